@@ -0,0 +1,120 @@
+// Command migrate runs one-off data migrations against the configured
+// database:
+//
+//	go run ./cmd/migrate read-state
+//	go run ./cmd/migrate [-dry-run] repair-read-by
+//	go run ./cmd/migrate [-dry-run] default-groups
+//	go run ./cmd/migrate [-dry-run] default-posting-policies
+//	go run ./cmd/migrate [-dry-run] dedupe-group-members
+package main
+
+import (
+	"chatterbloom/backend/config"
+	"chatterbloom/backend/db"
+	"chatterbloom/backend/migrations"
+	"chatterbloom/backend/readstate"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+var dryRun = flag.Bool("dry-run", false, "report what would change without changing anything (all but read-state)")
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "usage: migrate [flags] <migration>\n\nmigrations:\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "  read-state                build read_state positions from read_by arrays\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "  repair-read-by            store read_by user IDs as ObjectIDs instead of strings\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "  default-groups            move default groups from groups into chat_groups\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "  default-posting-policies  give existing default groups their posting policy (after default-groups)\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "  dedupe-group-members      remove duplicate group memberships so they can be uniquely indexed\n\nflags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	// Initialize configuration
+	cfg := config.LoadConfig()
+
+	// Connect to MongoDB
+	client, err := db.ConnectDB(cfg.MongoURI)
+	if err != nil {
+		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	defer client.Disconnect(context.Background())
+
+	ctx := context.Background()
+	database := client.Database(cfg.DatabaseName)
+
+	// Migrations rely on the same indexes as the server, except the one that
+	// removes what stops the group_members index being created
+	if flag.Arg(0) != "dedupe-group-members" {
+		if err := db.EnsureIndexes(client, cfg.DatabaseName); err != nil {
+			log.Fatalf("Failed to create indexes: %v", err)
+		}
+	}
+
+	switch flag.Arg(0) {
+	case "read-state":
+		migrated, err := migrations.MigrateReadState(ctx, database)
+		if err != nil {
+			log.Fatalf("read-state: %v (after %d positions)", err, migrated)
+		}
+		log.Printf("read-state: created or advanced %d read positions", migrated)
+
+	case "repair-read-by":
+		repair, err := readstate.NewMongoStore(client, cfg.DatabaseName).RepairReadBy(ctx, *dryRun)
+		if err != nil {
+			log.Fatalf("repair-read-by: %v", err)
+		}
+		verb := "repaired"
+		if *dryRun {
+			verb = "would repair"
+		}
+		log.Printf("repair-read-by: %s %d messages with string IDs and %d with a missing read_by", verb, repair.StringIDs, repair.Missing)
+
+	case "default-groups":
+		moved, err := migrations.DefaultGroupsToChatGroups(ctx, database, *dryRun)
+		if err != nil {
+			log.Fatalf("default-groups: %v (after %d groups)", err, moved)
+		}
+		verb := "moved"
+		if *dryRun {
+			verb = "would move"
+		}
+		log.Printf("default-groups: %s %d default groups into chat_groups", verb, moved)
+
+	case "default-posting-policies":
+		updated, err := migrations.DefaultPostingPolicies(ctx, database, *dryRun)
+		if err != nil {
+			log.Fatalf("default-posting-policies: %v (after %d groups)", err, updated)
+		}
+		verb := "set"
+		if *dryRun {
+			verb = "would set"
+		}
+		log.Printf("default-posting-policies: %s the posting policy of %d default groups", verb, updated)
+
+	case "dedupe-group-members":
+		removed, err := migrations.DedupeGroupMembers(ctx, database, *dryRun)
+		if err != nil {
+			log.Fatalf("dedupe-group-members: %v (after %d memberships)", err, removed)
+		}
+		verb := "removed"
+		if *dryRun {
+			verb = "would remove"
+		}
+		log.Printf("dedupe-group-members: %s %d duplicate memberships", verb, removed)
+
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
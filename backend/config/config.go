@@ -2,28 +2,53 @@ package config
 
 import (
 	"os"
+	"time"
 )
 
 // Config holds all configuration for the application
 type Config struct {
-	Port            string
-	MongoURI        string
-	DatabaseName    string
-	JWTSecret       string
-	AllowedOrigins  []string
-	Environment     string
+	Port              string
+	MongoURI          string
+	DatabaseName      string
+	JWTSecret         string
+	AllowedOrigins    []string
+	Environment       string
+	Backplane         string        // memory, mongo
+	MessageEditWindow time.Duration // How long after sending a message its sender may edit it
+	BlobStore         string        // local, s3
+	UploadDir         string        // Root directory of the local blob store
+	S3Endpoint        string
+	S3Region          string
+	S3Bucket          string
+	S3AccessKey       string
+	S3SecretKey       string
+	UserCacheTTL      time.Duration // How long hydrated senders are cached in process; 0 disables the cache
+	AckReminderAfter  time.Duration // Default delay before reminding recipients to acknowledge an announcement
+	ContactPolicyFile string        // JSON file overriding the default contact policy; empty uses the default
 }
 
 // LoadConfig loads configuration from environment variables
 func LoadConfig() *Config {
 	// Set default values
 	config := &Config{
-		Port:           getEnv("PORT", "8090"),
-		MongoURI:       getEnv("MONGO_URI", "mongodb://localhost:27017"),
-		DatabaseName:   getEnv("DB_NAME", "chatterbloom"),
-		JWTSecret:      getEnv("JWT_SECRET", "your-secret-key"),
-		Environment:    getEnv("ENVIRONMENT", "development"),
-		AllowedOrigins: []string{"http://localhost:8090", "http://localhost:3000", "http://localhost:8084"},
+		Port:              getEnv("PORT", "8090"),
+		MongoURI:          getEnv("MONGO_URI", "mongodb://localhost:27017"),
+		DatabaseName:      getEnv("DB_NAME", "chatterbloom"),
+		JWTSecret:         getEnv("JWT_SECRET", "your-secret-key"),
+		Environment:       getEnv("ENVIRONMENT", "development"),
+		Backplane:         getEnv("WS_BACKPLANE", "memory"),
+		MessageEditWindow: getEnvDuration("MESSAGE_EDIT_WINDOW", 15*time.Minute),
+		BlobStore:         getEnv("BLOB_STORE", "local"),
+		UploadDir:         getEnv("UPLOAD_DIR", "./uploads"),
+		S3Endpoint:        getEnv("S3_ENDPOINT", "https://s3.amazonaws.com"),
+		S3Region:          getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:          getEnv("S3_BUCKET", ""),
+		S3AccessKey:       getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey:       getEnv("S3_SECRET_KEY", ""),
+		UserCacheTTL:      getEnvDuration("USER_CACHE_TTL", 0),
+		AckReminderAfter:  getEnvDuration("ACK_REMINDER_AFTER", 24*time.Hour),
+		ContactPolicyFile: getEnv("CONTACT_POLICY_FILE", ""),
+		AllowedOrigins:    []string{"http://localhost:8090", "http://localhost:3000", "http://localhost:8084"},
 	}
 
 	return config
@@ -36,3 +61,13 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+// Helper function to get duration environment variables (e.g. "15m") with fallback
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, exists := os.LookupEnv(key); exists {
+		if duration, err := time.ParseDuration(value); err == nil {
+			return duration
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,45 @@
+package constants
+
+// Maximum number of files attached to one message
+const MaxAttachmentsPerMessage = 5
+
+// Attachment MIME types
+var (
+	ImageAttachmentTypes = []string{
+		"image/jpeg",
+		"image/png",
+		"image/gif",
+		"image/webp",
+	}
+
+	DocumentAttachmentTypes = []string{
+		"application/pdf",
+		"text/plain",
+		"application/msword",
+		"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		"application/vnd.ms-excel",
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		"application/vnd.ms-powerpoint",
+		"application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	}
+)
+
+// Role-based maximum attachment size, in bytes
+var MaxAttachmentSizeByRole = map[string]int64{
+	RoleAdmin:     50 << 20,
+	RolePrincipal: 50 << 20,
+	RoleTeacher:   25 << 20,
+	RoleStaff:     25 << 20,
+	RoleStudent:   10 << 20,
+	RoleParent:    10 << 20,
+}
+
+// Role-based attachment MIME types
+var AllowedAttachmentTypesByRole = map[string][]string{
+	RoleAdmin:     append(append([]string{}, ImageAttachmentTypes...), DocumentAttachmentTypes...),
+	RolePrincipal: append(append([]string{}, ImageAttachmentTypes...), DocumentAttachmentTypes...),
+	RoleTeacher:   append(append([]string{}, ImageAttachmentTypes...), DocumentAttachmentTypes...),
+	RoleStaff:     append(append([]string{}, ImageAttachmentTypes...), DocumentAttachmentTypes...),
+	RoleStudent:   append(append([]string{}, ImageAttachmentTypes...), "application/pdf", "text/plain", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"),
+	RoleParent:    append(append([]string{}, ImageAttachmentTypes...), "application/pdf"),
+}
@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"chatterbloom/backend/config"
+	"chatterbloom/backend/db"
+	"chatterbloom/backend/models"
+	"chatterbloom/backend/notifications"
+	"chatterbloom/backend/protocol"
+	"chatterbloom/backend/websocket"
+	"context"
+	"encoding/csv"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxReminderAfterHours is the longest an announcement can wait before
+// reminding recipients to acknowledge it (30 days)
+const maxReminderAfterHours = 720
+
+// AcknowledgementController handles acknowledgements of announcements
+type AcknowledgementController struct {
+	DB       *mongo.Client
+	Config   *config.Config
+	Hub      *websocket.Hub
+	Messages *MessageController
+}
+
+// Acknowledge records that the current user has seen an announcement that
+// requires acknowledgement. Acknowledging twice is not an error.
+func (ac *AcknowledgementController) Acknowledge(c echo.Context) error {
+	// Get user ID from token
+	userID := c.Get("user_id").(string)
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	// Get message ID from URL
+	messageObjID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid message ID")
+	}
+
+	message, err := ac.Messages.findMessage(messageObjID)
+	if err != nil {
+		return err
+	}
+	if message.DeletedAt != nil {
+		return echo.NewHTTPError(http.StatusGone, "Message has been deleted")
+	}
+	if !message.RequiresAck {
+		return echo.NewHTTPError(http.StatusBadRequest, "This message does not require acknowledgement")
+	}
+
+	// Only group members can acknowledge
+	membership, err := findMembership(ac.DB, ac.Config.DatabaseName, message.GroupID, userObjID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if membership == nil {
+		return echo.NewHTTPError(http.StatusForbidden, "You are not a member of this group")
+	}
+
+	// Record acknowledgement
+	acksColl := db.GetCollection(ac.DB, ac.Config.DatabaseName, "acknowledgements")
+	ack := models.Acknowledgement{
+		ID:             primitive.NewObjectID(),
+		MessageID:      message.ID,
+		GroupID:        message.GroupID,
+		UserID:         userObjID,
+		AcknowledgedAt: time.Now(),
+	}
+	_, err = acksColl.InsertOne(context.Background(), ack)
+	if mongo.IsDuplicateKeyError(err) {
+		err = acksColl.FindOne(
+			context.Background(),
+			bson.M{"message_id": message.ID, "user_id": userObjID},
+		).Decode(&ack)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		return c.JSON(http.StatusOK, ack)
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to acknowledge message")
+	}
+
+	// Update the announcement's count
+	messagesColl := db.GetCollection(ac.DB, ac.Config.DatabaseName, "messages")
+	err = messagesColl.FindOneAndUpdate(
+		context.Background(),
+		bson.M{"_id": message.ID},
+		bson.M{"$inc": bson.M{"ack_count": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(message)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update acknowledgement count")
+	}
+
+	// Acknowledging an announcement also reads it
+	if err := ac.Messages.Reads.Advance(context.Background(), userObjID, message); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to mark message as read")
+	}
+
+	// Let the group see the count go up
+	if ac.Hub != nil {
+		ac.Hub.SendToGroup(message.GroupID.Hex(), protocol.TypeAcknowledged, protocol.AcknowledgedPayload{
+			MessageID: message.ID.Hex(),
+			UserID:    userID,
+			AckCount:  message.AckCount,
+		})
+	}
+
+	return c.JSON(http.StatusCreated, ack)
+}
+
+// GetAcknowledgements reports which recipients of an announcement have
+// acknowledged it. Only its sender, group admins, school admins and
+// principals can see the report. With ?format=csv it is sent as a CSV file.
+func (ac *AcknowledgementController) GetAcknowledgements(c echo.Context) error {
+	// Get user ID and role from context
+	userID := c.Get("user_id").(string)
+	userRole := c.Get("user_role").(string)
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	// Get message ID from URL
+	messageObjID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid message ID")
+	}
+
+	message, err := ac.Messages.findMessage(messageObjID)
+	if err != nil {
+		return err
+	}
+	if !message.RequiresAck {
+		return echo.NewHTTPError(http.StatusBadRequest, "This message does not require acknowledgement")
+	}
+
+	// Check permission
+	if message.SenderID != userObjID {
+		if _, err := authorizeGroup(ac.DB, ac.Config.DatabaseName, message.GroupID, userObjID, userRole, capViewAcknowledgements); err != nil {
+			return err
+		}
+	}
+
+	// Build report
+	recipients, acknowledged, err := notifications.Recipients(context.Background(), ac.DB, ac.Config.DatabaseName, message)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	users, err := loadUsers(ac.DB, ac.Config.DatabaseName, ac.Messages.Users, recipients)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	report := models.AcknowledgementReport{
+		MessageID:    message.ID.Hex(),
+		Acknowledged: []models.AcknowledgementStatus{},
+		Pending:      []models.AcknowledgementStatus{},
+	}
+	for _, recipientID := range recipients {
+		user, ok := users[recipientID]
+		if !ok {
+			continue // Member whose account was deleted
+		}
+		status := models.AcknowledgementStatus{User: user.ToResponse()}
+		if at, ok := acknowledged[recipientID]; ok {
+			status.AcknowledgedAt = &at
+			report.Acknowledged = append(report.Acknowledged, status)
+		} else {
+			report.Pending = append(report.Pending, status)
+		}
+	}
+	sortByName := func(statuses []models.AcknowledgementStatus) {
+		sort.Slice(statuses, func(i, j int) bool {
+			return strings.ToLower(statuses[i].User.FullName) < strings.ToLower(statuses[j].User.FullName)
+		})
+	}
+	sortByName(report.Acknowledged)
+	sortByName(report.Pending)
+
+	if c.QueryParam("format") == "csv" {
+		return writeAcknowledgementCSV(c, report)
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// writeAcknowledgementCSV sends a report as a CSV download, pending
+// recipients first
+func writeAcknowledgementCSV(c echo.Context, report models.AcknowledgementReport) error {
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	header.Set(echo.HeaderContentDisposition, `attachment; filename="acknowledgements-`+report.MessageID+`.csv"`)
+	c.Response().WriteHeader(http.StatusOK)
+
+	w := csv.NewWriter(c.Response())
+	w.Write([]string{"name", "email", "role", "status", "acknowledged_at"})
+	for _, status := range report.Pending {
+		w.Write([]string{csvSafe(status.User.FullName), csvSafe(status.User.Email), status.User.Role, "pending", ""})
+	}
+	for _, status := range report.Acknowledged {
+		w.Write([]string{
+			csvSafe(status.User.FullName),
+			csvSafe(status.User.Email),
+			status.User.Role,
+			"acknowledged",
+			status.AcknowledgedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// csvSafe stops spreadsheet apps from treating a user-supplied cell as a formula
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,28 @@
+package controllers_test
+
+import (
+	"testing"
+
+	"chatterbloom/backend/controllers"
+)
+
+func TestCSVSafe(t *testing.T) {
+	cases := map[string]string{
+		"":                        "",
+		"Ada Lovelace":            "Ada Lovelace",
+		"ada@school.example":      "ada@school.example",
+		"O'Brien":                 "O'Brien",
+		"=HYPERLINK(\"x\",\"y\")": "'=HYPERLINK(\"x\",\"y\")",
+		"+1 555":                  "'+1 555",
+		"-2+3":                    "'-2+3",
+		"@SUM(A1)":                "'@SUM(A1)",
+		"\t=1":                    "'\t=1",
+		"\r=1":                    "'\r=1",
+		"a=1":                     "a=1",
+	}
+	for value, want := range cases {
+		if got := controllers.CSVSafe(value); got != want {
+			t.Errorf("csvSafe(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package controllers
+
+import (
+	"bytes"
+	"chatterbloom/backend/config"
+	"chatterbloom/backend/constants"
+	"chatterbloom/backend/media"
+	"chatterbloom/backend/models"
+	"chatterbloom/backend/storage"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxAttachmentNameLength bounds stored file names
+const maxAttachmentNameLength = 255
+
+// sniffLength is how much of a file detectMimeType looks at
+const sniffLength = 512
+
+// Office formats can't be told apart by sniffing (they look like zip or OLE
+// containers), so their type comes from the file extension
+var officeTypesByExtension = map[string]string{
+	".doc":  "application/msword",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xls":  "application/vnd.ms-excel",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".ppt":  "application/vnd.ms-powerpoint",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+}
+
+// AttachmentController handles uploading and downloading message attachments
+type AttachmentController struct {
+	DB       *mongo.Client
+	Config   *config.Config
+	Blobs    storage.BlobStore
+	Messages *MessageController
+}
+
+// SendMessageWithAttachments sends a message with files attached. The
+// request is a multipart form with group_id, content, optional parent_id and
+// one or more files fields.
+func (ac *AttachmentController) SendMessageWithAttachments(c echo.Context) error {
+	// Get user ID and role from context
+	userID := c.Get("user_id").(string)
+	userRole := c.Get("user_role").(string)
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	maxSize, ok := constants.MaxAttachmentSizeByRole[userRole]
+	if !ok {
+		return echo.NewHTTPError(http.StatusForbidden, "You are not allowed to upload attachments")
+	}
+
+	// Parse the form, refusing bodies that can't be within the limits
+	maxBody := maxSize*constants.MaxAttachmentsPerMessage + 1<<20
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, maxBody)
+	form, err := c.MultipartForm()
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "Upload is too large")
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid multipart form")
+	}
+	defer form.RemoveAll()
+
+	files := form.File["files"]
+	if len(files) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "At least one file is required")
+	}
+	if len(files) > constants.MaxAttachmentsPerMessage {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("At most %d files can be attached to a message", constants.MaxAttachmentsPerMessage))
+	}
+
+	req := models.MessageRequest{
+		GroupID:  c.FormValue("group_id"),
+		Content:  c.FormValue("content"),
+		ParentID: c.FormValue("parent_id"),
+	}
+	groupObjID, err := primitive.ObjectIDFromHex(req.GroupID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid group ID")
+	}
+
+	// Check membership before storing anything
+	membership, err := findMembership(ac.DB, ac.Config.DatabaseName, groupObjID, userObjID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if membership == nil {
+		return echo.NewHTTPError(http.StatusForbidden, "You are not a member of this group")
+	}
+
+	// Store files
+	attachments := make([]models.Attachment, 0, len(files))
+	for _, file := range files {
+		attachment, err := ac.storeAttachment(groupObjID, file, userRole, maxSize)
+		if err != nil {
+			ac.deleteAttachments(attachments)
+			return err
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	// Create message, cleaning up the stored files if that fails
+	messageResponse, err := ac.Messages.createMessage(userObjID, req, attachments)
+	if err != nil {
+		ac.deleteAttachments(attachments)
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, messageResponse)
+}
+
+// storeAttachment checks an uploaded file against the role's limits and
+// streams it to the blob store, hashing it on the way, along with a
+// thumbnail for images
+func (ac *AttachmentController) storeAttachment(groupObjID primitive.ObjectID, file *multipart.FileHeader, userRole string, maxSize int64) (models.Attachment, error) {
+	name := attachmentName(file.Filename)
+	if file.Size > maxSize {
+		return models.Attachment{}, echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("%s is larger than the %d MB limit", name, maxSize>>20))
+	}
+	if file.Size == 0 {
+		return models.Attachment{}, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s is empty", name))
+	}
+
+	// Open file
+	src, err := file.Open()
+	if err != nil {
+		return models.Attachment{}, echo.NewHTTPError(http.StatusBadRequest, "Failed to read upload")
+	}
+	defer src.Close()
+
+	// Check type, going by content rather than what the client claims
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return models.Attachment{}, echo.NewHTTPError(http.StatusBadRequest, "Failed to read upload")
+	}
+	head = head[:n]
+	mimeType := detectMimeType(name, head)
+	if !containsString(constants.AllowedAttachmentTypesByRole[userRole], mimeType) {
+		return models.Attachment{}, echo.NewHTTPError(http.StatusUnsupportedMediaType, fmt.Sprintf("Files of type %s are not allowed", mimeType))
+	}
+
+	attachment := models.Attachment{
+		ID:       primitive.NewObjectID(),
+		Name:     name,
+		MimeType: mimeType,
+		Size:     file.Size,
+	}
+	attachment.StorageKey = "attachments/" + groupObjID.Hex() + "/" + attachment.ID.Hex()
+
+	// Store file
+	ctx := context.Background()
+	checksum := sha256.New()
+	body := io.TeeReader(io.MultiReader(bytes.NewReader(head), src), checksum)
+	err = ac.Blobs.Put(ctx, attachment.StorageKey, body, attachment.Size, mimeType)
+	if err != nil {
+		log.Printf("error: storing attachment %s: %v", attachment.StorageKey, err)
+		return models.Attachment{}, echo.NewHTTPError(http.StatusInternalServerError, "Failed to store attachment")
+	}
+	attachment.Checksum = hex.EncodeToString(checksum.Sum(nil))
+
+	// Generate thumbnail for images we can decode; the upload still
+	// succeeds without one
+	if containsString(constants.ImageAttachmentTypes, mimeType) && mimeType != "image/webp" {
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			log.Printf("error: rereading %s for a thumbnail: %v", attachment.StorageKey, err)
+			return attachment, nil
+		}
+		thumb, width, height, err := media.Thumbnail(src)
+		if err != nil {
+			log.Printf("error: generating thumbnail for %s: %v", attachment.StorageKey, err)
+			return attachment, nil
+		}
+
+		thumbnailKey := attachment.StorageKey + "_thumb.jpg"
+		err = ac.Blobs.Put(ctx, thumbnailKey, bytes.NewReader(thumb), int64(len(thumb)), "image/jpeg")
+		if err != nil {
+			log.Printf("error: storing thumbnail %s: %v", thumbnailKey, err)
+			return attachment, nil
+		}
+		attachment.ThumbnailKey = thumbnailKey
+		attachment.Width = width
+		attachment.Height = height
+	}
+
+	return attachment, nil
+}
+
+// deleteAttachments removes stored files of a message that was not created
+func (ac *AttachmentController) deleteAttachments(attachments []models.Attachment) {
+	for _, attachment := range attachments {
+		keys := []string{attachment.StorageKey}
+		if attachment.ThumbnailKey != "" {
+			keys = append(keys, attachment.ThumbnailKey)
+		}
+		for _, key := range keys {
+			if err := ac.Blobs.Delete(context.Background(), key); err != nil {
+				log.Printf("error: deleting attachment %s: %v", key, err)
+			}
+		}
+	}
+}
+
+// DownloadAttachment sends an attachment to a member of its message's group
+func (ac *AttachmentController) DownloadAttachment(c echo.Context) error {
+	return ac.serveAttachment(c, false)
+}
+
+// DownloadThumbnail sends an image attachment's thumbnail to a member of its
+// message's group
+func (ac *AttachmentController) DownloadThumbnail(c echo.Context) error {
+	return ac.serveAttachment(c, true)
+}
+
+// serveAttachment streams an attachment or its thumbnail from the blob store
+func (ac *AttachmentController) serveAttachment(c echo.Context, thumbnail bool) error {
+	// Get user ID from token
+	userID := c.Get("user_id").(string)
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	// Get message and attachment IDs from URL
+	messageObjID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid message ID")
+	}
+	attachmentObjID, err := primitive.ObjectIDFromHex(c.Param("attachmentId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid attachment ID")
+	}
+
+	message, err := ac.Messages.findMessage(messageObjID)
+	if err != nil {
+		return err
+	}
+
+	// Only group members can download
+	membership, err := findMembership(ac.DB, ac.Config.DatabaseName, message.GroupID, userObjID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if membership == nil {
+		return echo.NewHTTPError(http.StatusForbidden, "You are not a member of this group")
+	}
+
+	if message.DeletedAt != nil {
+		return echo.NewHTTPError(http.StatusGone, "Message has been deleted")
+	}
+
+	// Find attachment
+	var attachment *models.Attachment
+	for i := range message.Attachments {
+		if message.Attachments[i].ID == attachmentObjID {
+			attachment = &message.Attachments[i]
+			break
+		}
+	}
+	if attachment == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Attachment not found")
+	}
+
+	key, contentType := attachment.StorageKey, attachment.MimeType
+	if thumbnail {
+		if attachment.ThumbnailKey == "" {
+			return echo.NewHTTPError(http.StatusNotFound, "Attachment has no thumbnail")
+		}
+		key, contentType = attachment.ThumbnailKey, "image/jpeg"
+	}
+
+	blob, err := ac.Blobs.Get(c.Request().Context(), key)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, "Attachment not found")
+		}
+		log.Printf("error: reading attachment %s: %v", key, err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to read attachment")
+	}
+	defer blob.Close()
+
+	// Images are shown inline; everything else is downloaded
+	disposition := "attachment"
+	if strings.HasPrefix(contentType, "image/") {
+		disposition = "inline"
+	}
+
+	header := c.Response().Header()
+	header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Name}))
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Cache-Control", "private, max-age=86400")
+	if !thumbnail {
+		header.Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+		header.Set("ETag", `"`+attachment.Checksum+`"`)
+	}
+
+	return c.Stream(http.StatusOK, contentType, blob)
+}
+
+// attachmentName cleans up a client-supplied file name for storage and
+// Content-Disposition headers
+func attachmentName(filename string) string {
+	name := strings.TrimSpace(filepath.Base(strings.ReplaceAll(filename, "\\", "/")))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == "/" {
+		return "attachment"
+	}
+	if len(name) > maxAttachmentNameLength {
+		ext := filepath.Ext(name)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		name = strings.ToValidUTF8(name[:maxAttachmentNameLength-len(ext)], "") + ext
+	}
+	return name
+}
+
+// detectMimeType sniffs a file's type from its content, falling back to the
+// extension for office documents
+func detectMimeType(name string, data []byte) string {
+	mimeType, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil {
+		return "application/octet-stream"
+	}
+
+	if mimeType == "application/zip" || mimeType == "application/octet-stream" {
+		if officeType, ok := officeTypesByExtension[strings.ToLower(filepath.Ext(name))]; ok {
+			return officeType
+		}
+	}
+	return mimeType
+}
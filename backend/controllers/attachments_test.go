@@ -0,0 +1,272 @@
+package controllers_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"chatterbloom/backend/constants"
+	"chatterbloom/backend/controllers"
+	"chatterbloom/backend/media"
+	"chatterbloom/backend/models"
+	"chatterbloom/backend/storage"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestAttachmentName(t *testing.T) {
+	long := strings.Repeat("a", 300)
+	cases := []struct {
+		in, want string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"  report.pdf  ", "report.pdf"},
+		{"../../etc/passwd", "passwd"},
+		{`C:\Users\ada\notes.txt`, "notes.txt"},
+		{"line\nbreak\x7f.txt", "linebreak.txt"},
+		{"", "attachment"},
+		{"/", "attachment"},
+		{".", "attachment"},
+		{"dir/", "dir"},
+		{long + ".pdf", long[:251] + ".pdf"},
+		{long + "." + long, long[:255]},
+		{strings.Repeat("é", 200), strings.Repeat("é", 127)},
+	}
+	for _, c := range cases {
+		if got := controllers.AttachmentName(c.in); got != c.want {
+			t.Errorf("attachmentName(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestDetectMimeType(t *testing.T) {
+	zip := []byte("PK\x03\x04\x14\x00\x06\x00")
+	cases := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"photo.png", pngImage(t, 2, 2), "image/png"},
+		{"photo.jpg", []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"), "image/jpeg"},
+		{"notes.pdf", []byte("%PDF-1.7\n"), "application/pdf"},
+		{"notes.txt", []byte("Field trip on Friday"), "text/plain"},
+		{"report.docx", zip, "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{"REPORT.XLSX", zip, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+		{"archive.zip", zip, "application/zip"},
+		{"sheet.xls", []byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1\x00"), "application/vnd.ms-excel"},
+		{"binary.bin", []byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1\x00"), "application/octet-stream"},
+		// The content wins over a misleading name
+		{"photo.png", []byte("%PDF-1.7\n"), "application/pdf"},
+		{"script.docx", []byte("<html><script>alert(1)</script>"), "text/html"},
+	}
+	for _, c := range cases {
+		if got := controllers.DetectMimeType(c.name, c.data); got != c.want {
+			t.Errorf("detectMimeType(%q, %q...) = %s, want %s", c.name, c.data[:min(len(c.data), 8)], got, c.want)
+		}
+	}
+}
+
+// TestUploadLimits uploads files at and past each role's limits
+func TestUploadLimits(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("ENVIRONMENT", "production")
+
+	studentLimit := constants.MaxAttachmentSizeByRole[constants.RoleStudent]
+	cases := []struct {
+		name, role, filename string
+		data                 []byte
+		want                 int
+	}{
+		{"student at the limit", constants.RoleStudent, "notes.txt", bytes.Repeat([]byte("a"), int(studentLimit)), http.StatusCreated},
+		{"student past the limit", constants.RoleStudent, "notes.txt", bytes.Repeat([]byte("a"), int(studentLimit)+1), http.StatusRequestEntityTooLarge},
+		{"teacher past the student limit", constants.RoleTeacher, "notes.txt", bytes.Repeat([]byte("a"), int(studentLimit)+1), http.StatusCreated},
+		{"student text", constants.RoleStudent, "notes.txt", []byte("Homework"), http.StatusCreated},
+		{"parent text", constants.RoleParent, "notes.txt", []byte("Homework"), http.StatusUnsupportedMediaType},
+		{"parent pdf", constants.RoleParent, "form.pdf", []byte("%PDF-1.7\n"), http.StatusCreated},
+		{"parent spreadsheet", constants.RoleParent, "sheet.xlsx", []byte("PK\x03\x04\x14\x00"), http.StatusUnsupportedMediaType},
+		{"staff spreadsheet", constants.RoleStaff, "sheet.xlsx", []byte("PK\x03\x04\x14\x00"), http.StatusCreated},
+		{"student html", constants.RoleStudent, "page.txt", []byte("<html><body>hi</body></html>"), http.StatusUnsupportedMediaType},
+		{"empty file", constants.RoleStudent, "notes.txt", nil, http.StatusBadRequest},
+	}
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for _, c := range cases {
+		mt.Run(c.name, func(mt *mtest.T) {
+			ids := testIDs{group: primitive.NewObjectID(), caller: primitive.NewObjectID()}
+			blobs := storage.NewLocalBlobStore(mt.TempDir())
+			mt.AddMockResponses(uploadReplies(ids, c.role)...)
+
+			rec := upload(mt, newServerWithBlobs(mt, blobs), ids, c.role, c.filename, c.data)
+			if rec.Code != c.want {
+				mt.Fatalf("got status %d, want %d: %s", rec.Code, c.want, rec.Body)
+			}
+			if c.want != http.StatusCreated {
+				return
+			}
+
+			// The file is stored whole and described by its metadata
+			attachment := insertedAttachment(mt)
+			sum := sha256.Sum256(c.data)
+			if attachment.Size != int64(len(c.data)) || attachment.Checksum != hex.EncodeToString(sum[:]) {
+				mt.Errorf("got size %d and checksum %s", attachment.Size, attachment.Checksum)
+			}
+			if attachment.Name != c.filename {
+				mt.Errorf("got name %q, want %q", attachment.Name, c.filename)
+			}
+			if !strings.HasPrefix(attachment.StorageKey, "attachments/"+ids.group.Hex()+"/") {
+				mt.Errorf("got storage key %s", attachment.StorageKey)
+			}
+			if stored := readStored(mt, blobs, attachment.StorageKey); !bytes.Equal(stored, c.data) {
+				mt.Errorf("stored %d bytes, want the %d uploaded", len(stored), len(c.data))
+			}
+			if attachment.ThumbnailKey != "" {
+				mt.Errorf("got a thumbnail for %s", attachment.MimeType)
+			}
+		})
+	}
+}
+
+// TestUploadThumbnail checks that images get a scaled down JPEG thumbnail,
+// and that images that can't be decoded are still stored
+func TestUploadThumbnail(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("ENVIRONMENT", "production")
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("image", func(mt *mtest.T) {
+		ids := testIDs{group: primitive.NewObjectID(), caller: primitive.NewObjectID()}
+		blobs := storage.NewLocalBlobStore(mt.TempDir())
+		mt.AddMockResponses(uploadReplies(ids, constants.RoleStudent)...)
+
+		rec := upload(mt, newServerWithBlobs(mt, blobs), ids, constants.RoleStudent, "photo.png", pngImage(t, 640, 480))
+		if rec.Code != http.StatusCreated {
+			mt.Fatalf("got status %d: %s", rec.Code, rec.Body)
+		}
+
+		attachment := insertedAttachment(mt)
+		if attachment.MimeType != "image/png" || attachment.Width != 640 || attachment.Height != 480 {
+			mt.Errorf("got %s of %dx%d", attachment.MimeType, attachment.Width, attachment.Height)
+		}
+		if attachment.ThumbnailKey != attachment.StorageKey+"_thumb.jpg" {
+			mt.Fatalf("got thumbnail key %q", attachment.ThumbnailKey)
+		}
+		thumb, err := jpeg.Decode(bytes.NewReader(readStored(mt, blobs, attachment.ThumbnailKey)))
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if size := thumb.Bounds().Size(); size.X != media.ThumbnailSize || size.Y != media.ThumbnailSize*480/640 {
+			mt.Errorf("got a %v thumbnail", size)
+		}
+	})
+
+	mt.Run("undecodable image", func(mt *mtest.T) {
+		ids := testIDs{group: primitive.NewObjectID(), caller: primitive.NewObjectID()}
+		blobs := storage.NewLocalBlobStore(mt.TempDir())
+		mt.AddMockResponses(uploadReplies(ids, constants.RoleStudent)...)
+
+		// A PNG signature followed by garbage
+		data := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0xff}, 64)...)
+		rec := upload(mt, newServerWithBlobs(mt, blobs), ids, constants.RoleStudent, "photo.png", data)
+		if rec.Code != http.StatusCreated {
+			mt.Fatalf("got status %d: %s", rec.Code, rec.Body)
+		}
+		attachment := insertedAttachment(mt)
+		if attachment.ThumbnailKey != "" || attachment.Width != 0 {
+			mt.Errorf("got thumbnail %q of width %d", attachment.ThumbnailKey, attachment.Width)
+		}
+		if stored := readStored(mt, blobs, attachment.StorageKey); !bytes.Equal(stored, data) {
+			mt.Error("the image was not stored")
+		}
+	})
+}
+
+// uploadReplies are the replies to the queries of a successful upload
+func uploadReplies(ids testIDs, role string) []bson.D {
+	return []bson.D{
+		cursor("group_members", memberDoc(ids.group, ids.caller, models.GroupRoleMember)),
+		cursor("group_members", memberDoc(ids.group, ids.caller, models.GroupRoleMember)),
+		cursor("chat_groups", groupDoc(ids)),
+		mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		cursor("users", userDoc(ids.caller, role)),
+	}
+}
+
+// upload sends a file to the attachments endpoint
+func upload(mt *mtest.T, e http.Handler, ids testIDs, role, filename string, data []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("group_id", ids.group.Hex())
+	part, err := form.CreateFormFile("files", filename)
+	if err != nil {
+		mt.Fatal(err)
+	}
+	part.Write(data)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/messages/attachments", &body)
+	req.Header.Set("Authorization", bearer(mt, ids.caller, role))
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+// insertedAttachment returns the only attachment of the message inserted
+func insertedAttachment(mt *mtest.T) models.Attachment {
+	for _, started := range mt.GetAllStartedEvents() {
+		if started.CommandName != "insert" || started.Command.Lookup("insert").StringValue() != "messages" {
+			continue
+		}
+		var message models.Message
+		if err := bson.Unmarshal(started.Command.Lookup("documents", "0").Document(), &message); err != nil {
+			mt.Fatal(err)
+		}
+		if len(message.Attachments) != 1 {
+			mt.Fatalf("got %d attachments", len(message.Attachments))
+		}
+		return message.Attachments[0]
+	}
+	mt.Fatal("no message was inserted")
+	return models.Attachment{}
+}
+
+func readStored(mt *mtest.T, blobs storage.BlobStore, key string) []byte {
+	blob, err := blobs.Get(context.Background(), key)
+	if err != nil {
+		mt.Fatal(err)
+	}
+	defer blob.Close()
+	data, err := io.ReadAll(blob)
+	if err != nil {
+		mt.Fatal(err)
+	}
+	return data
+}
+
+// pngImage encodes a gradient of the given size as a PNG
+func pngImage(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
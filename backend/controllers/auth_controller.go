@@ -5,7 +5,9 @@ import (
 	"chatterbloom/backend/constants"
 	"chatterbloom/backend/db"
 	"chatterbloom/backend/models"
+	"chatterbloom/backend/websocket"
 	"context"
+	"log"
 	"net/http"
 	"time"
 
@@ -22,6 +24,7 @@ import (
 type AuthController struct {
 	DB     *mongo.Client
 	Config *config.Config
+	Hub    *websocket.Hub
 }
 
 // LoginRequest represents the login request body
@@ -141,7 +144,7 @@ func (ac *AuthController) Register(c echo.Context) error {
 	}
 
 	// Add user to default groups based on role and organizational unit
-	groupsColl := db.GetCollection(ac.DB, ac.Config.DatabaseName, "groups")
+	groupsColl := db.GetCollection(ac.DB, ac.Config.DatabaseName, "chat_groups")
 	membersColl := db.GetCollection(ac.DB, ac.Config.DatabaseName, "group_members")
 
 	// Add to role-based default groups
@@ -149,18 +152,20 @@ func (ac *AuthController) Register(c echo.Context) error {
 		for _, groupName := range defaultGroups {
 			// Check if group exists, if not create it
 			var group models.ChatGroup
-			err := groupsColl.FindOne(context.Background(), bson.M{"name": groupName}).Decode(&group)
+			err := groupsColl.FindOne(context.Background(), bson.M{"name": groupName, "created_by": "system"}).Decode(&group)
 			if err != nil {
 				// Create the group
 				group = models.ChatGroup{
-					ID:          primitive.NewObjectID(),
-					Name:        groupName,
-					Description: "Default group for " + groupName,
-					GroupType:   "system",
-					ChatType:    "group",
-					CreatedAt:   now,
-					UpdatedAt:   now,
-					CreatedBy:   "system",
+					ID:            primitive.NewObjectID(),
+					Name:          groupName,
+					Description:   "Default group for " + groupName,
+					GroupType:     "system",
+					ChatType:      "group",
+					Members:       []string{},
+					CreatedAt:     now,
+					UpdatedAt:     now,
+					CreatedBy:     "system",
+					PostingPolicy: models.DefaultGroupPostingPolicies[groupName],
 				}
 				_, err = groupsColl.InsertOne(context.Background(), group)
 				if err != nil {
@@ -170,23 +175,15 @@ func (ac *AuthController) Register(c echo.Context) error {
 			}
 
 			// Add user to group
-			groupMember := models.GroupMember{
-				ID:        primitive.NewObjectID(),
-				GroupID:   group.ID,
-				UserID:    newUser.ID,
-				Role:      "member",
-				JoinedAt:  now,
-				CreatedAt: now,
-				UpdatedAt: now,
+			if err := joinDefaultGroup(groupsColl, membersColl, group.ID, newUser.ID, now); err != nil {
+				log.Printf("error: adding user %s to group %s: %v", newUser.ID.Hex(), group.ID.Hex(), err)
 			}
-			_, err = membersColl.InsertOne(context.Background(), groupMember)
-			// Log error but continue if there's an issue
 		}
 	}
 
 	// Add to organizational unit group
 	var orgUnitGroup models.ChatGroup
-	err = groupsColl.FindOne(context.Background(), bson.M{"name": req.OrganizationalUnit}).Decode(&orgUnitGroup)
+	err = groupsColl.FindOne(context.Background(), bson.M{"name": req.OrganizationalUnit, "created_by": "system"}).Decode(&orgUnitGroup)
 	if err != nil {
 		// Create the group
 		orgUnitGroup = models.ChatGroup{
@@ -196,36 +193,18 @@ func (ac *AuthController) Register(c echo.Context) error {
 			GroupType:          "organizational_unit",
 			OrganizationalUnit: req.OrganizationalUnit,
 			ChatType:           "group",
+			Members:            []string{},
 			CreatedAt:          now,
 			UpdatedAt:          now,
 			CreatedBy:          "system",
 		}
 		_, err = groupsColl.InsertOne(context.Background(), orgUnitGroup)
-		if err == nil {
-			// Add user to group
-			groupMember := models.GroupMember{
-				ID:        primitive.NewObjectID(),
-				GroupID:   orgUnitGroup.ID,
-				UserID:    newUser.ID,
-				Role:      "member",
-				JoinedAt:  now,
-				CreatedAt: now,
-				UpdatedAt: now,
-			}
-			_, _ = membersColl.InsertOne(context.Background(), groupMember)
-		}
-	} else {
-		// Add user to existing org unit group
-		groupMember := models.GroupMember{
-			ID:        primitive.NewObjectID(),
-			GroupID:   orgUnitGroup.ID,
-			UserID:    newUser.ID,
-			Role:      "member",
-			JoinedAt:  now,
-			CreatedAt: now,
-			UpdatedAt: now,
+	}
+	if err == nil {
+		// Add user to group
+		if err := joinDefaultGroup(groupsColl, membersColl, orgUnitGroup.ID, newUser.ID, now); err != nil {
+			log.Printf("error: adding user %s to group %s: %v", newUser.ID.Hex(), orgUnitGroup.ID.Hex(), err)
 		}
-		_, _ = membersColl.InsertOne(context.Background(), groupMember)
 	}
 
 	// Generate JWT token
@@ -443,7 +422,7 @@ func (ac *AuthController) CreateUser(c echo.Context) error {
 	}
 
 	// Add user to default groups based on role and organizational unit
-	groupsColl := db.GetCollection(ac.DB, ac.Config.DatabaseName, "groups")
+	groupsColl := db.GetCollection(ac.DB, ac.Config.DatabaseName, "chat_groups")
 	membersColl := db.GetCollection(ac.DB, ac.Config.DatabaseName, "group_members")
 
 	// Add to role-based default groups
@@ -451,18 +430,20 @@ func (ac *AuthController) CreateUser(c echo.Context) error {
 		for _, groupName := range defaultGroups {
 			// Check if group exists, if not create it
 			var group models.ChatGroup
-			err := groupsColl.FindOne(context.Background(), bson.M{"name": groupName}).Decode(&group)
+			err := groupsColl.FindOne(context.Background(), bson.M{"name": groupName, "created_by": "system"}).Decode(&group)
 			if err != nil {
 				// Create the group
 				group = models.ChatGroup{
-					ID:          primitive.NewObjectID(),
-					Name:        groupName,
-					Description: "Default group for " + groupName,
-					GroupType:   "system",
-					ChatType:    "group",
-					CreatedAt:   now,
-					UpdatedAt:   now,
-					CreatedBy:   "system",
+					ID:            primitive.NewObjectID(),
+					Name:          groupName,
+					Description:   "Default group for " + groupName,
+					GroupType:     "system",
+					ChatType:      "group",
+					Members:       []string{},
+					CreatedAt:     now,
+					UpdatedAt:     now,
+					CreatedBy:     "system",
+					PostingPolicy: models.DefaultGroupPostingPolicies[groupName],
 				}
 				_, err = groupsColl.InsertOne(context.Background(), group)
 				if err != nil {
@@ -472,23 +453,15 @@ func (ac *AuthController) CreateUser(c echo.Context) error {
 			}
 
 			// Add user to group
-			groupMember := models.GroupMember{
-				ID:        primitive.NewObjectID(),
-				GroupID:   group.ID,
-				UserID:    newUser.ID,
-				Role:      "member",
-				JoinedAt:  now,
-				CreatedAt: now,
-				UpdatedAt: now,
+			if err := joinDefaultGroup(groupsColl, membersColl, group.ID, newUser.ID, now); err != nil {
+				log.Printf("error: adding user %s to group %s: %v", newUser.ID.Hex(), group.ID.Hex(), err)
 			}
-			_, err = membersColl.InsertOne(context.Background(), groupMember)
-			// Log error but continue if there's an issue
 		}
 	}
 
 	// Add to organizational unit group
 	var orgUnitGroup models.ChatGroup
-	err = groupsColl.FindOne(context.Background(), bson.M{"name": req.OrganizationalUnit}).Decode(&orgUnitGroup)
+	err = groupsColl.FindOne(context.Background(), bson.M{"name": req.OrganizationalUnit, "created_by": "system"}).Decode(&orgUnitGroup)
 	if err != nil {
 		// Create the group
 		orgUnitGroup = models.ChatGroup{
@@ -498,36 +471,18 @@ func (ac *AuthController) CreateUser(c echo.Context) error {
 			GroupType:          "organizational_unit",
 			OrganizationalUnit: req.OrganizationalUnit,
 			ChatType:           "group",
+			Members:            []string{},
 			CreatedAt:          now,
 			UpdatedAt:          now,
 			CreatedBy:          "system",
 		}
 		_, err = groupsColl.InsertOne(context.Background(), orgUnitGroup)
-		if err == nil {
-			// Add user to group
-			groupMember := models.GroupMember{
-				ID:        primitive.NewObjectID(),
-				GroupID:   orgUnitGroup.ID,
-				UserID:    newUser.ID,
-				Role:      "member",
-				JoinedAt:  now,
-				CreatedAt: now,
-				UpdatedAt: now,
-			}
-			_, _ = membersColl.InsertOne(context.Background(), groupMember)
-		}
-	} else {
-		// Add user to existing org unit group
-		groupMember := models.GroupMember{
-			ID:        primitive.NewObjectID(),
-			GroupID:   orgUnitGroup.ID,
-			UserID:    newUser.ID,
-			Role:      "member",
-			JoinedAt:  now,
-			CreatedAt: now,
-			UpdatedAt: now,
+	}
+	if err == nil {
+		// Add user to group
+		if err := joinDefaultGroup(groupsColl, membersColl, orgUnitGroup.ID, newUser.ID, now); err != nil {
+			log.Printf("error: adding user %s to group %s: %v", newUser.ID.Hex(), orgUnitGroup.ID.Hex(), err)
 		}
-		_, _ = membersColl.InsertOne(context.Background(), groupMember)
 	}
 
 	return c.JSON(http.StatusCreated, newUser.ToResponse())
@@ -627,15 +582,15 @@ func (ac *AuthController) UpdateUser(c echo.Context) error {
 		update["organizational_unit"] = req.OrganizationalUnit
 
 		// Add user to new organizational unit group
-		groupsColl := db.GetCollection(ac.DB, ac.Config.DatabaseName, "groups")
+		groupsColl := db.GetCollection(ac.DB, ac.Config.DatabaseName, "chat_groups")
 		membersColl := db.GetCollection(ac.DB, ac.Config.DatabaseName, "group_members")
 
 		// Check if org unit group exists, if not create it
+		now := time.Now()
 		var orgUnitGroup models.ChatGroup
-		err = groupsColl.FindOne(context.Background(), bson.M{"name": req.OrganizationalUnit}).Decode(&orgUnitGroup)
+		err = groupsColl.FindOne(context.Background(), bson.M{"name": req.OrganizationalUnit, "created_by": "system"}).Decode(&orgUnitGroup)
 		if err != nil {
 			// Create the group
-			now := time.Now()
 			orgUnitGroup = models.ChatGroup{
 				ID:                 primitive.NewObjectID(),
 				Name:               req.OrganizationalUnit,
@@ -643,36 +598,18 @@ func (ac *AuthController) UpdateUser(c echo.Context) error {
 				GroupType:          "organizational_unit",
 				OrganizationalUnit: req.OrganizationalUnit,
 				ChatType:           "group",
+				Members:            []string{},
 				CreatedAt:          now,
 				UpdatedAt:          now,
 				CreatedBy:          "system",
 			}
 			_, err = groupsColl.InsertOne(context.Background(), orgUnitGroup)
-			if err == nil {
-				// Add user to group
-				groupMember := models.GroupMember{
-					ID:        primitive.NewObjectID(),
-					GroupID:   orgUnitGroup.ID,
-					UserID:    userObjID,
-					Role:      "member",
-					JoinedAt:  time.Now(),
-					CreatedAt: time.Now(),
-					UpdatedAt: time.Now(),
-				}
-				_, _ = membersColl.InsertOne(context.Background(), groupMember)
-			}
-		} else {
-			// Add user to existing org unit group
-			groupMember := models.GroupMember{
-				ID:        primitive.NewObjectID(),
-				GroupID:   orgUnitGroup.ID,
-				UserID:    userObjID,
-				Role:      "member",
-				JoinedAt:  time.Now(),
-				CreatedAt: time.Now(),
-				UpdatedAt: time.Now(),
+		}
+		if err == nil {
+			// Add user to group
+			if err := joinDefaultGroup(groupsColl, membersColl, orgUnitGroup.ID, userObjID, now); err != nil {
+				log.Printf("error: adding user %s to group %s: %v", userID, orgUnitGroup.ID.Hex(), err)
 			}
-			_, _ = membersColl.InsertOne(context.Background(), groupMember)
 		}
 	}
 
@@ -686,6 +623,13 @@ func (ac *AuthController) UpdateUser(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update user")
 	}
 
+	// Move the user's guardians to the parent channel of their new unit
+	if update["organizational_unit"] != nil || update["role"] != nil {
+		if err := syncGuardiansOf(ac.DB, ac.Config.DatabaseName, ac.Hub, userObjID); err != nil {
+			log.Printf("error: syncing guardians of user %s: %v", userID, err)
+		}
+	}
+
 	// Get updated user
 	err = usersColl.FindOne(context.Background(), bson.M{"_id": userObjID}).Decode(&user)
 	if err != nil {
@@ -732,7 +676,31 @@ func (ac *AuthController) DeleteUser(c echo.Context) error {
 	membersColl := db.GetCollection(ac.DB, ac.Config.DatabaseName, "group_members")
 	_, err = membersColl.DeleteMany(context.Background(), bson.M{"user_id": userObjID})
 	if err != nil {
-		// Log error but continue
+		log.Printf("error: deleting group memberships of user %s: %v", userID, err)
+	}
+	groupsColl := db.GetCollection(ac.DB, ac.Config.DatabaseName, "chat_groups")
+	_, err = groupsColl.UpdateMany(context.Background(), bson.M{"members": userID}, bson.M{"$pull": bson.M{"members": userID}})
+	if err != nil {
+		log.Printf("error: removing user %s from group member lists: %v", userID, err)
+	}
+
+	// Unlink the user's guardians and students, then update the guardians' parent channels
+	guardianshipsColl := db.GetCollection(ac.DB, ac.Config.DatabaseName, "guardianships")
+	guardianIDs, err := guardianshipsColl.Distinct(context.Background(), "guardian_id", bson.M{"student_id": userObjID})
+	if err != nil {
+		log.Printf("error: loading guardians of user %s: %v", userID, err)
+	}
+	_, err = guardianshipsColl.DeleteMany(context.Background(), bson.M{"$or": bson.A{
+		bson.M{"guardian_id": userObjID},
+		bson.M{"student_id": userObjID},
+	}})
+	if err != nil {
+		log.Printf("error: deleting guardianships of user %s: %v", userID, err)
+	}
+	for _, guardianID := range guardianIDs {
+		if err := syncGuardianChannels(ac.DB, ac.Config.DatabaseName, ac.Hub, guardianID.(primitive.ObjectID)); err != nil {
+			log.Printf("error: syncing parent channels of guardian %s: %v", guardianID.(primitive.ObjectID).Hex(), err)
+		}
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{"message": "User deleted successfully"})
@@ -757,3 +725,25 @@ func (ac *AuthController) generateToken(userID string, role string) (string, err
 
 	return tokenString, nil
 }
+
+// joinDefaultGroup makes a user a member of a default or organizational unit
+// group, keeping the group's members list in step with group_members
+func joinDefaultGroup(groupsColl, membersColl *mongo.Collection, groupID, userObjID primitive.ObjectID, now time.Time) error {
+	_, err := membersColl.UpdateOne(
+		context.Background(),
+		bson.M{"group_id": groupID, "user_id": userObjID},
+		bson.M{"$setOnInsert": bson.M{
+			"role":       models.GroupRoleMember,
+			"joined_at":  now,
+			"created_at": now,
+			"updated_at": now,
+		}},
+		options.Update().SetUpsert(true),
+	)
+	// A duplicate key means a concurrent request added the membership
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	_, err = groupsColl.UpdateOne(context.Background(), bson.M{"_id": groupID}, bson.M{"$addToSet": bson.M{"members": userObjID.Hex()}})
+	return err
+}
@@ -0,0 +1,456 @@
+package controllers
+
+import (
+	"chatterbloom/backend/config"
+	"chatterbloom/backend/constants"
+	"chatterbloom/backend/db"
+	"chatterbloom/backend/models"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxCampaignGroups is the most groups one campaign can be sent to
+const maxCampaignGroups = 200
+
+// CampaignController handles announcements sent to many groups at once
+type CampaignController struct {
+	DB       *mongo.Client
+	Config   *config.Config
+	Messages *MessageController
+}
+
+// SendCampaign sends one announcement to every targeted group, as linked
+// per-group messages under a new campaign. Nothing is sent unless the user
+// may announce in every targeted group.
+func (cc *CampaignController) SendCampaign(c echo.Context) error {
+	// Get user ID and role from context
+	userID := c.Get("user_id").(string)
+	userRole := c.Get("user_role").(string)
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	// Parse request body
+	var req models.CampaignRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Message content is required")
+	}
+	if _, err := cc.Messages.announcementReminderAfter(req.ReminderAfterHours); err != nil {
+		return err
+	}
+
+	targets, err := campaignTargets(req)
+	if err != nil {
+		return err
+	}
+	groups, err := cc.targetedGroups(targets)
+	if err != nil {
+		return err
+	}
+
+	// Teachers may only announce in their own groups
+	if userRole != constants.RoleAdmin && userRole != constants.RolePrincipal {
+		groupIDs := make([]primitive.ObjectID, len(groups))
+		for i, group := range groups {
+			groupIDs[i] = group.ID
+		}
+		membersColl := db.GetCollection(cc.DB, cc.Config.DatabaseName, "group_members")
+		count, err := membersColl.CountDocuments(context.Background(), bson.M{
+			"group_id": bson.M{"$in": groupIDs},
+			"user_id":  userObjID,
+		})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		if int(count) != len(groups) {
+			return echo.NewHTTPError(http.StatusForbidden, "You are not a member of every targeted group")
+		}
+	}
+
+	campaign := models.Campaign{
+		ID:          primitive.NewObjectID(),
+		SenderID:    userObjID,
+		Title:       req.Title,
+		Content:     req.Content,
+		RequiresAck: req.RequireAcknowledgement,
+		Targets:     targets,
+		CreatedAt:   time.Now(),
+	}
+
+	// Post the announcement to each group, carrying on past failures
+	for _, group := range groups {
+		delivery := models.CampaignDelivery{GroupID: group.ID}
+		messageResp, err := cc.Messages.createAnnouncement(userObjID, userRole, group.ID, models.AnnouncementRequest{
+			Content:                req.Content,
+			Title:                  req.Title,
+			AllowReplies:           req.AllowReplies,
+			RequireAcknowledgement: req.RequireAcknowledgement,
+			ReminderAfterHours:     req.ReminderAfterHours,
+			CampaignID:             &campaign.ID,
+		})
+		if err != nil {
+			delivery.Error = "Failed to create message"
+			if httpErr, ok := err.(*echo.HTTPError); ok {
+				if message, ok := httpErr.Message.(string); ok {
+					delivery.Error = message
+				}
+			}
+		} else {
+			messageObjID, _ := primitive.ObjectIDFromHex(messageResp.ID)
+			delivery.MessageID = &messageObjID
+		}
+		campaign.Deliveries = append(campaign.Deliveries, delivery)
+	}
+
+	// Store the campaign
+	campaignsColl := db.GetCollection(cc.DB, cc.Config.DatabaseName, "campaigns")
+	if _, err := campaignsColl.InsertOne(context.Background(), campaign); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save campaign")
+	}
+
+	return c.JSON(http.StatusCreated, campaign)
+}
+
+// campaignTargets validates and normalizes the targets of a campaign request
+func campaignTargets(req models.CampaignRequest) (models.CampaignTargets, error) {
+	var targets models.CampaignTargets
+
+	for _, groupID := range req.GroupIDs {
+		groupObjID, err := primitive.ObjectIDFromHex(groupID)
+		if err != nil {
+			return targets, echo.NewHTTPError(http.StatusBadRequest, "Invalid group ID")
+		}
+		targets.GroupIDs = append(targets.GroupIDs, groupObjID)
+	}
+
+	for _, unit := range req.OrganizationalUnits {
+		if !containsString(constants.OrganizationalUnits, unit) {
+			return targets, echo.NewHTTPError(http.StatusBadRequest, "Unknown organizational unit: "+unit)
+		}
+		if !containsString(targets.OrganizationalUnits, unit) {
+			targets.OrganizationalUnits = append(targets.OrganizationalUnits, unit)
+		}
+	}
+
+	for _, role := range req.Roles {
+		if _, ok := constants.DefaultGroupsByRole[role]; !ok {
+			return targets, echo.NewHTTPError(http.StatusBadRequest, "Unknown role: "+role)
+		}
+		if !containsString(targets.Roles, role) {
+			targets.Roles = append(targets.Roles, role)
+		}
+	}
+
+	if len(targets.GroupIDs) == 0 && len(targets.OrganizationalUnits) == 0 && len(targets.Roles) == 0 {
+		return targets, echo.NewHTTPError(http.StatusBadRequest, "Choose at least one group, organizational unit or role")
+	}
+	return targets, nil
+}
+
+// roleGroupNames returns the default groups only the given roles are put in,
+// e.g. "Parent Community" for parents but not the "School Announcements"
+// group parents share with students
+func roleGroupNames(roles []string) []string {
+	var names []string
+	for _, role := range roles {
+		for _, name := range constants.DefaultGroupsByRole[role] {
+			shared := false
+			for otherRole, otherNames := range constants.DefaultGroupsByRole {
+				if !containsString(roles, otherRole) && containsString(otherNames, name) {
+					shared = true
+					break
+				}
+			}
+			if !shared && !containsString(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// targetedGroups loads every group a campaign is addressed to
+func (cc *CampaignController) targetedGroups(targets models.CampaignTargets) ([]models.ChatGroup, error) {
+	filter, err := targetFilter(targets)
+	if err != nil {
+		return nil, err
+	}
+
+	groupsColl := db.GetCollection(cc.DB, cc.Config.DatabaseName, "chat_groups")
+	cursor, err := groupsColl.Find(
+		context.Background(),
+		filter,
+		options.Find().SetSort(bson.M{"name": 1}).SetLimit(maxCampaignGroups+1),
+	)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer cursor.Close(context.Background())
+
+	var groups []models.ChatGroup
+	if err := cursor.All(context.Background(), &groups); err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to decode groups")
+	}
+
+	if len(groups) == 0 {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "No groups match the campaign's targets")
+	}
+	if len(groups) > maxCampaignGroups {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "A campaign can target at most "+strconv.Itoa(maxCampaignGroups)+" groups")
+	}
+
+	// Every listed group must exist
+	found := make(map[primitive.ObjectID]bool, len(groups))
+	for _, group := range groups {
+		found[group.ID] = true
+	}
+	for _, groupObjID := range targets.GroupIDs {
+		if !found[groupObjID] {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "Group not found: "+groupObjID.Hex())
+		}
+	}
+
+	return groups, nil
+}
+
+// targetFilter returns the filter matching the groups a campaign is
+// addressed to. Organizational units and roles only match the default groups
+// created for them, not groups users named the same way, and an organizational
+// unit's parent channel only gets campaigns that also target parents.
+func targetFilter(targets models.CampaignTargets) (bson.M, error) {
+	var anyOf bson.A
+	if len(targets.GroupIDs) > 0 {
+		anyOf = append(anyOf, bson.M{"_id": bson.M{"$in": targets.GroupIDs}})
+	}
+	if len(targets.OrganizationalUnits) > 0 {
+		unitGroups := bson.M{
+			"organizational_unit": bson.M{"$in": targets.OrganizationalUnits},
+			"created_by":          "system",
+		}
+		if !containsString(targets.Roles, constants.RoleParent) {
+			unitGroups["audience"] = bson.M{"$ne": models.AudienceParents}
+		}
+		anyOf = append(anyOf, unitGroups)
+	}
+	if names := roleGroupNames(targets.Roles); len(names) > 0 {
+		anyOf = append(anyOf, bson.M{
+			"name":       bson.M{"$in": names},
+			"created_by": "system",
+		})
+	}
+	if len(anyOf) == 0 {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "No groups match the campaign's targets")
+	}
+
+	return bson.M{"$or": anyOf, "chat_type": bson.M{"$ne": "individual"}}, nil
+}
+
+// GetCampaigns returns the campaigns the current user has sent, newest first
+func (cc *CampaignController) GetCampaigns(c echo.Context) error {
+	// Get user ID from token
+	userID := c.Get("user_id").(string)
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	// Get limit from query parameters
+	limit := 50 // Default limit
+	if limitParam := c.QueryParam("limit"); limitParam != "" {
+		if parsedLimit, err := strconv.Atoi(limitParam); err == nil && parsedLimit > 0 && parsedLimit <= 100 {
+			limit = parsedLimit
+		}
+	}
+
+	campaignsColl := db.GetCollection(cc.DB, cc.Config.DatabaseName, "campaigns")
+	cursor, err := campaignsColl.Find(
+		context.Background(),
+		bson.M{"sender_id": userObjID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer cursor.Close(context.Background())
+
+	campaigns := []models.Campaign{}
+	if err := cursor.All(context.Background(), &campaigns); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to decode campaigns")
+	}
+
+	return c.JSON(http.StatusOK, campaigns)
+}
+
+// GetCampaignStats reports how a campaign was delivered, read and
+// acknowledged, per group and in total. Only its sender, principals and
+// admins can see it.
+func (cc *CampaignController) GetCampaignStats(c echo.Context) error {
+	// Get user ID and role from context
+	userID := c.Get("user_id").(string)
+	userRole := c.Get("user_role").(string)
+
+	campaignObjID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid campaign ID")
+	}
+
+	// Find the campaign
+	campaignsColl := db.GetCollection(cc.DB, cc.Config.DatabaseName, "campaigns")
+	var campaign models.Campaign
+	err = campaignsColl.FindOne(context.Background(), bson.M{"_id": campaignObjID}).Decode(&campaign)
+	if err == mongo.ErrNoDocuments {
+		return echo.NewHTTPError(http.StatusNotFound, "Campaign not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	if campaign.SenderID.Hex() != userID && userRole != constants.RoleAdmin && userRole != constants.RolePrincipal {
+		return echo.NewHTTPError(http.StatusForbidden, "You can't view this campaign")
+	}
+
+	stats, err := cc.campaignStats(&campaign)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load campaign stats")
+	}
+
+	return c.JSON(http.StatusOK, stats)
+}
+
+// campaignStats counts the recipients, readers and acknowledgers of a
+// campaign's messages. Recipients are the current members of each group but
+// the sender; the totals only count groups the campaign was delivered to.
+func (cc *CampaignController) campaignStats(campaign *models.Campaign) (models.CampaignStats, error) {
+	ctx := context.Background()
+	stats := models.CampaignStats{Campaign: *campaign, Groups: []models.CampaignGroupStats{}}
+
+	groupIDs := make([]primitive.ObjectID, len(campaign.Deliveries))
+	for i, delivery := range campaign.Deliveries {
+		groupIDs[i] = delivery.GroupID
+	}
+
+	// Get group names
+	groupsColl := db.GetCollection(cc.DB, cc.Config.DatabaseName, "chat_groups")
+	groupCursor, err := groupsColl.Find(ctx, bson.M{"_id": bson.M{"$in": groupIDs}}, options.Find().SetProjection(bson.M{"name": 1}))
+	if err != nil {
+		return stats, err
+	}
+	var groups []models.ChatGroup
+	if err := groupCursor.All(ctx, &groups); err != nil {
+		return stats, err
+	}
+	groupNames := make(map[primitive.ObjectID]string, len(groups))
+	for _, group := range groups {
+		groupNames[group.ID] = group.Name
+	}
+
+	// Get recipients per group
+	membersColl := db.GetCollection(cc.DB, cc.Config.DatabaseName, "group_members")
+	memberCursor, err := membersColl.Find(ctx, bson.M{
+		"group_id": bson.M{"$in": groupIDs},
+		"user_id":  bson.M{"$ne": campaign.SenderID},
+	}, options.Find().SetProjection(bson.M{"group_id": 1, "user_id": 1}))
+	if err != nil {
+		return stats, err
+	}
+	var members []models.GroupMember
+	if err := memberCursor.All(ctx, &members); err != nil {
+		return stats, err
+	}
+	recipients := make(map[primitive.ObjectID]map[primitive.ObjectID]bool)
+	for _, member := range members {
+		if recipients[member.GroupID] == nil {
+			recipients[member.GroupID] = make(map[primitive.ObjectID]bool)
+		}
+		recipients[member.GroupID][member.UserID] = true
+	}
+
+	// Get the campaign's messages
+	messagesColl := db.GetCollection(cc.DB, cc.Config.DatabaseName, "messages")
+	messageCursor, err := messagesColl.Find(ctx, bson.M{"campaign_id": campaign.ID}, options.Find().SetProjection(bson.M{"group_id": 1, "read_by": 1}))
+	if err != nil {
+		return stats, err
+	}
+	var messages []models.Message
+	if err := messageCursor.All(ctx, &messages); err != nil {
+		return stats, err
+	}
+	messagesByID := make(map[primitive.ObjectID]models.Message, len(messages))
+	messageIDs := make([]primitive.ObjectID, len(messages))
+	for i, message := range messages {
+		messagesByID[message.ID] = message
+		messageIDs[i] = message.ID
+	}
+
+	// Get acknowledgements
+	acksColl := db.GetCollection(cc.DB, cc.Config.DatabaseName, "acknowledgements")
+	ackCursor, err := acksColl.Find(ctx, bson.M{"message_id": bson.M{"$in": messageIDs}})
+	if err != nil {
+		return stats, err
+	}
+	var acks []models.Acknowledgement
+	if err := ackCursor.All(ctx, &acks); err != nil {
+		return stats, err
+	}
+	acksByMessage := make(map[primitive.ObjectID][]primitive.ObjectID)
+	for _, ack := range acks {
+		acksByMessage[ack.MessageID] = append(acksByMessage[ack.MessageID], ack.UserID)
+	}
+
+	// Count per group, and people once across groups
+	reached := make(map[primitive.ObjectID]bool)
+	readers := make(map[primitive.ObjectID]bool)
+	acknowledgers := make(map[primitive.ObjectID]bool)
+	for _, delivery := range campaign.Deliveries {
+		groupRecipients := recipients[delivery.GroupID]
+		groupStats := models.CampaignGroupStats{
+			GroupID:    delivery.GroupID.Hex(),
+			GroupName:  groupNames[delivery.GroupID],
+			Error:      delivery.Error,
+			Recipients: len(groupRecipients),
+		}
+
+		if delivery.MessageID == nil {
+			stats.Failed++
+			stats.Groups = append(stats.Groups, groupStats)
+			continue
+		}
+		stats.Delivered++
+		groupStats.MessageID = delivery.MessageID.Hex()
+		for recipientID := range groupRecipients {
+			reached[recipientID] = true
+		}
+
+		for _, readerID := range messagesByID[*delivery.MessageID].ReadBy {
+			if groupRecipients[readerID] {
+				groupStats.Read++
+				readers[readerID] = true
+			}
+		}
+		for _, ackUserID := range acksByMessage[*delivery.MessageID] {
+			if groupRecipients[ackUserID] {
+				groupStats.Acknowledged++
+				acknowledgers[ackUserID] = true
+			}
+		}
+		stats.Groups = append(stats.Groups, groupStats)
+	}
+
+	stats.Recipients = len(reached)
+	stats.Read = len(readers)
+	stats.Acknowledged = len(acknowledgers)
+	return stats, nil
+}
@@ -0,0 +1,121 @@
+package controllers_test
+
+import (
+	"reflect"
+	"testing"
+
+	"chatterbloom/backend/constants"
+	"chatterbloom/backend/controllers"
+	"chatterbloom/backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestCampaignTargetFilter checks which groups each kind of campaign target
+// reaches
+func TestCampaignTargetFilter(t *testing.T) {
+	groupID := primitive.NewObjectID()
+	notParentChannel := bson.M{"$ne": models.AudienceParents}
+
+	cases := []struct {
+		name    string
+		targets models.CampaignTargets
+		want    bson.A
+	}{
+		{
+			name:    "groups",
+			targets: models.CampaignTargets{GroupIDs: []primitive.ObjectID{groupID}},
+			want:    bson.A{bson.M{"_id": bson.M{"$in": []primitive.ObjectID{groupID}}}},
+		},
+		{
+			name:    "units leave out parent channels",
+			targets: models.CampaignTargets{OrganizationalUnits: []string{"Grade 1", "Grade 2"}},
+			want: bson.A{bson.M{
+				"organizational_unit": bson.M{"$in": []string{"Grade 1", "Grade 2"}},
+				"created_by":          "system",
+				"audience":            notParentChannel,
+			}},
+		},
+		{
+			name:    "units with parents include parent channels",
+			targets: models.CampaignTargets{OrganizationalUnits: []string{"Grade 1"}, Roles: []string{constants.RoleParent}},
+			want: bson.A{
+				bson.M{
+					"organizational_unit": bson.M{"$in": []string{"Grade 1"}},
+					"created_by":          "system",
+				},
+				bson.M{"name": bson.M{"$in": []string{"Parent Community"}}, "created_by": "system"},
+			},
+		},
+		{
+			name:    "teachers get the groups only they are in",
+			targets: models.CampaignTargets{Roles: []string{constants.RoleTeacher}},
+			want:    bson.A{bson.M{"name": bson.M{"$in": []string{"Teacher Lounge"}}, "created_by": "system"}},
+		},
+		{
+			name:    "groups shared by every targeted role",
+			targets: models.CampaignTargets{Roles: []string{constants.RoleTeacher, constants.RolePrincipal}},
+			want:    bson.A{bson.M{"name": bson.M{"$in": []string{"Faculty", "Teacher Lounge"}}, "created_by": "system"}},
+		},
+		{
+			name: "every kind",
+			targets: models.CampaignTargets{
+				GroupIDs:            []primitive.ObjectID{groupID},
+				OrganizationalUnits: []string{"Grade 3"},
+				Roles:               []string{constants.RoleStaff},
+			},
+			want: bson.A{
+				bson.M{"_id": bson.M{"$in": []primitive.ObjectID{groupID}}},
+				bson.M{
+					"organizational_unit": bson.M{"$in": []string{"Grade 3"}},
+					"created_by":          "system",
+					"audience":            notParentChannel,
+				},
+				bson.M{"name": bson.M{"$in": []string{"Support Staff"}}, "created_by": "system"},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			filter, err := controllers.TargetFilter(c.targets)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := bson.M{"$or": c.want, "chat_type": bson.M{"$ne": "individual"}}
+			if !reflect.DeepEqual(filter, want) {
+				t.Errorf("got filter\n%v\nwant\n%v", filter, want)
+			}
+		})
+	}
+
+	// Admins share every default group with other roles, so targeting them
+	// alone reaches nothing
+	if _, err := controllers.TargetFilter(models.CampaignTargets{Roles: []string{constants.RoleAdmin}}); err == nil {
+		t.Error("targeting admins alone: got a filter, want an error")
+	}
+}
+
+// TestCampaignTargetsValidation checks that campaign requests only name
+// known units and roles, and name at least one target
+func TestCampaignTargetsValidation(t *testing.T) {
+	cases := []struct {
+		name string
+		req  models.CampaignRequest
+		ok   bool
+	}{
+		{"group", models.CampaignRequest{GroupIDs: []string{primitive.NewObjectID().Hex()}}, true},
+		{"invalid group", models.CampaignRequest{GroupIDs: []string{"nope"}}, false},
+		{"unit", models.CampaignRequest{OrganizationalUnits: []string{"Grade 4"}}, true},
+		{"unknown unit", models.CampaignRequest{OrganizationalUnits: []string{"Grade 13"}}, false},
+		{"role", models.CampaignRequest{Roles: []string{constants.RoleParent}}, true},
+		{"unknown role", models.CampaignRequest{Roles: []string{"janitor"}}, false},
+		{"nothing", models.CampaignRequest{}, false},
+	}
+	for _, c := range cases {
+		_, err := controllers.CampaignTargets(c.req)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: got error %v, want ok %v", c.name, err, c.ok)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"chatterbloom/backend/db"
+	"chatterbloom/backend/models"
+	"chatterbloom/backend/policy"
+	"context"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// groupFacts answers contact policy questions from group memberships
+type groupFacts struct {
+	client *mongo.Client
+	dbName string
+}
+
+// ShareGroup implements policy.Facts. Direct conversations don't count, so
+// an existing conversation can't justify itself.
+func (f groupFacts) ShareGroup(a, b primitive.ObjectID) (bool, error) {
+	return f.inGroupsOf(a, bson.M{"user_id": b})
+}
+
+// IsGuardian implements policy.Facts
+func (f groupFacts) IsGuardian(guardian, student primitive.ObjectID) (bool, error) {
+	guardianshipsColl := db.GetCollection(f.client, f.dbName, "guardianships")
+	count, err := guardianshipsColl.CountDocuments(context.Background(), bson.M{
+		"guardian_id": guardian,
+		"student_id":  student,
+		"status":      models.GuardianshipVerified,
+	})
+	return count > 0, err
+}
+
+// SharesStudent implements policy.Facts
+func (f groupFacts) SharesStudent(guardian, other primitive.ObjectID) (bool, error) {
+	guardianshipsColl := db.GetCollection(f.client, f.dbName, "guardianships")
+	studentIDs, err := guardianshipsColl.Distinct(context.Background(), "student_id", bson.M{
+		"guardian_id": guardian,
+		"status":      models.GuardianshipVerified,
+	})
+	if err != nil || len(studentIDs) == 0 {
+		return false, err
+	}
+
+	return f.inGroupsOf(other, bson.M{"user_id": bson.M{"$in": studentIDs}})
+}
+
+// inGroupsOf reports whether a membership matching filter exists in one of
+// the user's groups, leaving out direct conversations
+func (f groupFacts) inGroupsOf(userObjID primitive.ObjectID, filter bson.M) (bool, error) {
+	membersColl := db.GetCollection(f.client, f.dbName, "group_members")
+	groupIDs, err := membersColl.Distinct(context.Background(), "group_id", bson.M{"user_id": userObjID})
+	if err != nil || len(groupIDs) == 0 {
+		return false, err
+	}
+
+	// Leave out direct conversations
+	groupsColl := db.GetCollection(f.client, f.dbName, "chat_groups")
+	groupIDs, err = groupsColl.Distinct(context.Background(), "_id", bson.M{
+		"_id":       bson.M{"$in": groupIDs},
+		"chat_type": bson.M{"$ne": "individual"},
+	})
+	if err != nil || len(groupIDs) == 0 {
+		return false, err
+	}
+
+	filter["group_id"] = bson.M{"$in": groupIDs}
+	count, err := membersColl.CountDocuments(context.Background(), filter)
+	return count > 0, err
+}
+
+// checkContact returns a 403 error carrying the policy's reason code when
+// the sender may not reach the recipient by action
+func checkContact(client *mongo.Client, dbName string, contacts *policy.Policy, action string, senderObjID, recipientObjID primitive.ObjectID) error {
+	if contacts == nil {
+		contacts = policy.Default()
+	}
+
+	users, err := loadUsers(client, dbName, nil, []primitive.ObjectID{senderObjID, recipientObjID})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	sender, ok := users[senderObjID]
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "User not found")
+	}
+	recipient, ok := users[recipientObjID]
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "User not found")
+	}
+
+	denial, err := contacts.Check(action, &sender, &recipient, groupFacts{client: client, dbName: dbName})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if denial != nil {
+		return echo.NewHTTPError(http.StatusForbidden, denial)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"chatterbloom/backend/constants"
+	"chatterbloom/backend/models"
+	"chatterbloom/backend/policy"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// TestContactPolicyDenials checks that every way of reaching another user
+// is refused with the policy's reason code when the policy forbids it
+func TestContactPolicyDenials(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("ENVIRONMENT", "production")
+
+	cases := []struct {
+		name, method, path, body string
+		// Replies to the queries made before the policy is checked
+		replies func(ids testIDs) []bson.D
+	}{
+		{
+			name:   "start direct conversation",
+			method: http.MethodPost,
+			path:   "/api/conversations/direct",
+			body:   `{"user_id":":target"}`,
+		},
+		{
+			name:   "add member",
+			method: http.MethodPost,
+			path:   "/api/groups/:group/members",
+			body:   `{"user_id":":target","role":"member"}`,
+			replies: func(ids testIDs) []bson.D {
+				return []bson.D{
+					cursor("chat_groups", groupDoc(ids)),
+					cursor("group_members", memberDoc(ids.group, ids.caller, models.GroupRoleAdmin)),
+				}
+			},
+		},
+		{
+			name:   "send direct message",
+			method: http.MethodPost,
+			path:   "/api/messages",
+			body:   `{"group_id":":group","content":"Hi"}`,
+			replies: func(ids testIDs) []bson.D {
+				return []bson.D{
+					cursor("group_members", memberDoc(ids.group, ids.caller, models.GroupRoleMember)),
+					cursor("chat_groups", bson.D{
+						{Key: "_id", Value: ids.group},
+						{Key: "chat_type", Value: "individual"},
+						{Key: "members", Value: bson.A{ids.caller.Hex(), ids.target.Hex()}},
+					}),
+				}
+			},
+		},
+	}
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for _, c := range cases {
+		mt.Run(c.name, func(mt *mtest.T) {
+			ids := testIDs{group: primitive.NewObjectID(), caller: primitive.NewObjectID(), target: primitive.NewObjectID()}
+			var replies []bson.D
+			if c.replies != nil {
+				replies = c.replies(ids)
+			}
+			// Students may only reach students of their own unit
+			replies = append(replies, cursor("users",
+				unitUserDoc(ids.caller, constants.RoleStudent, "Grade 5"),
+				unitUserDoc(ids.target, constants.RoleStudent, "Grade 6"),
+			))
+			mt.AddMockResponses(replies...)
+
+			replacer := strings.NewReplacer(":group", ids.group.Hex(), ":target", ids.target.Hex())
+			req := httptest.NewRequest(c.method, replacer.Replace(c.path), strings.NewReader(replacer.Replace(c.body)))
+			req.Header.Set("Authorization", bearer(mt, ids.caller, constants.RoleStudent))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			newServer(mt).ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusForbidden {
+				mt.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusForbidden, rec.Body)
+			}
+			var denial policy.Denial
+			if err := json.Unmarshal(rec.Body.Bytes(), &denial); err != nil {
+				mt.Fatalf("decoding %s: %v", rec.Body, err)
+			}
+			if denial.Reason != policy.ReasonDifferentUnit || denial.Message == "" {
+				mt.Errorf("got %s, want a denial with reason %q", rec.Body, policy.ReasonDifferentUnit)
+			}
+			for _, started := range mt.GetAllStartedEvents() {
+				if started.CommandName == "insert" || started.CommandName == "update" {
+					mt.Errorf("wrote to %s after the policy refused", started.Command.Lookup(started.CommandName).StringValue())
+				}
+			}
+		})
+	}
+}
+
+// TestSharedUnitGroup checks that the organizational unit group registration
+// puts users in counts as a shared group, and direct conversations don't
+func TestSharedUnitGroup(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("ENVIRONMENT", "production")
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for _, shared := range []bool{true, false} {
+		name := "shared unit group"
+		if !shared {
+			name = "only a direct conversation"
+		}
+		mt.Run(name, func(mt *mtest.T) {
+			ids := testIDs{group: primitive.NewObjectID(), caller: primitive.NewObjectID(), target: primitive.NewObjectID()}
+			conversationID := primitive.NewObjectID()
+			sharedGroups := bson.A{}
+			if shared {
+				sharedGroups = append(sharedGroups, ids.group)
+			}
+			success := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1})
+
+			mt.AddMockResponses(
+				cursor("users",
+					unitUserDoc(ids.caller, constants.RoleStudent, "Grade 5"),
+					unitUserDoc(ids.target, constants.RoleTeacher, "Grade 5"),
+				),
+				// The student's groups: their unit group and a conversation
+				mtest.CreateSuccessResponse(bson.E{Key: "values", Value: bson.A{ids.group, conversationID}}),
+				// Of which the unit group isn't a direct conversation
+				mtest.CreateSuccessResponse(bson.E{Key: "values", Value: sharedGroups}),
+				cursor("group_members", bson.D{{Key: "n", Value: 1}}),
+				// Starting the conversation
+				cursor("users",
+					unitUserDoc(ids.caller, constants.RoleStudent, "Grade 5"),
+					unitUserDoc(ids.target, constants.RoleTeacher, "Grade 5"),
+				),
+				success, success, success,
+			)
+
+			body := `{"user_id":"` + ids.target.Hex() + `"}`
+			req := httptest.NewRequest(http.MethodPost, "/api/conversations/direct", strings.NewReader(body))
+			req.Header.Set("Authorization", bearer(mt, ids.caller, constants.RoleStudent))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			newServer(mt).ServeHTTP(rec, req)
+
+			want := http.StatusCreated
+			if !shared {
+				want = http.StatusForbidden
+			}
+			if rec.Code != want {
+				mt.Fatalf("got status %d, want %d: %s", rec.Code, want, rec.Body)
+			}
+
+			// Groups are looked up where registration creates them
+			for _, started := range mt.GetAllStartedEvents() {
+				if started.CommandName == "distinct" && started.Command.Lookup("key").StringValue() == "_id" {
+					if coll := started.Command.Lookup("distinct").StringValue(); coll != "chat_groups" {
+						mt.Errorf("looked groups up in %s, want chat_groups", coll)
+					}
+				}
+			}
+		})
+	}
+}
+
+func unitUserDoc(userID primitive.ObjectID, role, unit string) bson.D {
+	return append(userDoc(userID, role), bson.E{Key: "organizational_unit", Value: unit})
+}
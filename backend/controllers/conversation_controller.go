@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"chatterbloom/backend/config"
+	"chatterbloom/backend/db"
+	"chatterbloom/backend/models"
+	"chatterbloom/backend/policy"
+	"chatterbloom/backend/protocol"
+	"chatterbloom/backend/websocket"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ConversationController handles direct conversations between two users
+type ConversationController struct {
+	DB       *mongo.Client
+	Config   *config.Config
+	Hub      *websocket.Hub
+	Contacts *policy.Policy
+}
+
+// CreateDirectConversation returns the current user's direct conversation
+// with another user, creating it if there is none yet
+func (cc *ConversationController) CreateDirectConversation(c echo.Context) error {
+	// Get user ID from token
+	userID := c.Get("user_id").(string)
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	// Bind request body
+	var req models.DirectConversationRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	otherObjID, err := primitive.ObjectIDFromHex(req.UserID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	conversation, created, err := startDirectConversation(cc.DB, cc.Config.DatabaseName, cc.Hub, cc.Contacts, userObjID, otherObjID)
+	if err != nil {
+		return err
+	}
+
+	if created {
+		return c.JSON(http.StatusCreated, conversation)
+	}
+	return c.JSON(http.StatusOK, conversation)
+}
+
+// startDirectConversation finds or creates the direct conversation between
+// two users and returns it as the first user sees it, if the contact policy
+// lets the first user reach the other. The other user is told about
+// conversations that are new.
+func startDirectConversation(client *mongo.Client, dbName string, hub *websocket.Hub, contacts *policy.Policy, userObjID, otherObjID primitive.ObjectID) (models.ChatGroupResponse, bool, error) {
+	if userObjID == otherObjID {
+		return models.ChatGroupResponse{}, false, echo.NewHTTPError(http.StatusBadRequest, "You can't start a conversation with yourself")
+	}
+	if err := checkContact(client, dbName, contacts, policy.ActionContact, userObjID, otherObjID); err != nil {
+		return models.ChatGroupResponse{}, false, err
+	}
+
+	// Load both participants to name the conversation for each
+	users, err := loadUsers(client, dbName, nil, []primitive.ObjectID{userObjID, otherObjID})
+	if err != nil {
+		return models.ChatGroupResponse{}, false, echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	conversation, created, err := findOrCreateDirect(client, dbName, userObjID, otherObjID)
+	if err != nil {
+		return models.ChatGroupResponse{}, false, echo.NewHTTPError(http.StatusInternalServerError, "Failed to start conversation")
+	}
+
+	if created && hub != nil {
+		hub.SendToUser(otherObjID.Hex(), protocol.TypeConversationStarted, protocol.ConversationPayload{
+			Conversation: directResponse(conversation, users[userObjID]),
+		})
+	}
+
+	return directResponse(conversation, users[otherObjID]), created, nil
+}
+
+// findOrCreateDirect returns the direct conversation between two users,
+// creating it if needed. The unique pair key makes concurrent calls agree on
+// one conversation.
+func findOrCreateDirect(client *mongo.Client, dbName string, userObjID, otherObjID primitive.ObjectID) (*models.ChatGroup, bool, error) {
+	groupsColl := db.GetCollection(client, dbName, "chat_groups")
+	pairKey := directPairKey(userObjID, otherObjID)
+
+	// Create the conversation, or find the one that already exists
+	now := time.Now()
+	conversation := models.ChatGroup{
+		ID:        primitive.NewObjectID(),
+		GroupType: "custom",
+		ChatType:  "individual",
+		CreatedAt: now,
+		UpdatedAt: now,
+		CreatedBy: userObjID.Hex(),
+		Members:   []string{userObjID.Hex(), otherObjID.Hex()},
+		PairKey:   pairKey,
+	}
+	created := true
+	if _, err := groupsColl.InsertOne(context.Background(), conversation); err != nil {
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, false, err
+		}
+		created = false
+		if err := groupsColl.FindOne(context.Background(), bson.M{"pair_key": pairKey}).Decode(&conversation); err != nil {
+			return nil, false, err
+		}
+	}
+
+	// Make sure both participants are members, also repairing a conversation
+	// whose creator failed half-way
+	membersColl := db.GetCollection(client, dbName, "group_members")
+	for _, memberObjID := range []primitive.ObjectID{userObjID, otherObjID} {
+		_, err := membersColl.UpdateOne(
+			context.Background(),
+			bson.M{"group_id": conversation.ID, "user_id": memberObjID},
+			bson.M{"$setOnInsert": bson.M{
+				"role":       models.GroupRoleMember,
+				"joined_at":  conversation.CreatedAt,
+				"created_at": now,
+				"updated_at": now,
+			}},
+			options.Update().SetUpsert(true),
+		)
+		// Two upserts racing on the unique membership index insert once; the
+		// other fails with a duplicate key error, and the member exists
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return nil, false, err
+		}
+	}
+
+	return &conversation, created, nil
+}
+
+// directPairKey identifies the conversation between two users, whichever
+// of them starts it
+func directPairKey(a, b primitive.ObjectID) string {
+	if a.Hex() > b.Hex() {
+		a, b = b, a
+	}
+	return a.Hex() + ":" + b.Hex()
+}
+
+// directResponse shows a direct conversation under the other participant's
+// name and avatar
+func directResponse(conversation *models.ChatGroup, other models.User) models.ChatGroupResponse {
+	response := conversation.ToResponse(len(conversation.Members))
+	participant := other.ToResponse()
+	response.Participant = &participant
+	response.Name = other.FullName
+	response.AvatarURL = other.AvatarURL
+	return response
+}
+
+// directResponses shows a user's direct conversations, loading every other
+// participant in one query
+func directResponses(client *mongo.Client, dbName string, userObjID primitive.ObjectID, conversations []models.ChatGroup) ([]models.ChatGroupResponse, error) {
+	others := make([]primitive.ObjectID, len(conversations))
+	for i := range conversations {
+		others[i] = otherParticipant(&conversations[i], userObjID)
+	}
+
+	users, err := loadUsers(client, dbName, nil, others)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]models.ChatGroupResponse, 0, len(conversations))
+	for i := range conversations {
+		other, ok := users[others[i]]
+		if !ok {
+			other = models.User{ID: others[i], FullName: "Unknown User"}
+		}
+		responses = append(responses, directResponse(&conversations[i], other))
+	}
+	return responses, nil
+}
+
+// otherParticipant returns the participant of a direct conversation who
+// isn't userObjID
+func otherParticipant(conversation *models.ChatGroup, userObjID primitive.ObjectID) primitive.ObjectID {
+	for _, memberID := range conversation.Members {
+		if memberObjID, err := primitive.ObjectIDFromHex(memberID); err == nil && memberObjID != userObjID {
+			return memberObjID
+		}
+	}
+	return userObjID
+}
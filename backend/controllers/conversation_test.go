@@ -0,0 +1,57 @@
+package controllers_test
+
+import (
+	"testing"
+
+	"chatterbloom/backend/controllers"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// TestFindOrCreateDirectRace checks that a conversation started by two
+// requests at once is found rather than duplicated, and that a membership
+// the other request inserted first counts as added
+func TestFindOrCreateDirectRace(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("lost both races", func(mt *mtest.T) {
+		userID, otherID := primitive.NewObjectID(), primitive.NewObjectID()
+		existingID := primitive.NewObjectID()
+		duplicateKey := mtest.CreateWriteErrorsResponse(mtest.WriteError{Code: 11000, Message: "E11000 duplicate key error"})
+
+		mt.AddMockResponses(
+			duplicateKey,
+			cursor("chat_groups", bson.D{
+				{Key: "_id", Value: existingID},
+				{Key: "chat_type", Value: "individual"},
+				{Key: "members", Value: bson.A{userID.Hex(), otherID.Hex()}},
+			}),
+			duplicateKey,
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+
+		conversation, created, err := controllers.FindOrCreateDirect(mt.Client, "chatterbloom", userID, otherID)
+		if err != nil {
+			mt.Fatalf("got error %v", err)
+		}
+		if created {
+			mt.Error("got created, want the existing conversation")
+		}
+		if conversation.ID != existingID {
+			mt.Errorf("got conversation %s, want %s", conversation.ID.Hex(), existingID.Hex())
+		}
+	})
+
+	mt.Run("other write errors fail", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Code: 121, Message: "Document failed validation"}),
+		)
+
+		_, _, err := controllers.FindOrCreateDirect(mt.Client, "chatterbloom", primitive.NewObjectID(), primitive.NewObjectID())
+		if err == nil {
+			mt.Error("got no error, want the membership write error")
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"chatterbloom/backend/constants"
+	"chatterbloom/backend/controllers"
+	"chatterbloom/backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// TestRegisteredStudentCannotPostAnnouncements registers a student, who
+// joins School Announcements, then checks that the group's announcement-only
+// policy stops them posting in it
+func TestRegisteredStudentCannotPostAnnouncements(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("ENVIRONMENT", "production")
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("register then post", func(mt *mtest.T) {
+		success := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1})
+		replies := []bson.D{cursor("users"), success}
+		// Each default group and the unit group is looked up, created and joined
+		for range len(constants.DefaultGroupsByRole[constants.RoleStudent]) + 1 {
+			replies = append(replies, cursor("chat_groups"), success, success, success)
+		}
+		mt.AddMockResponses(replies...)
+
+		body := `{"email":"ada@school.example","password":"secret1","full_name":"Ada","role":"student","organizational_unit":"Grade 5"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/register", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		e := newServer(mt)
+		e.ServeHTTP(rec, req)
+		if rec.Code != http.StatusCreated {
+			mt.Fatalf("register: got status %d: %s", rec.Code, rec.Body)
+		}
+		var auth controllers.AuthResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &auth); err != nil {
+			mt.Fatal(err)
+		}
+		userID, _ := primitive.ObjectIDFromHex(auth.User.ID)
+
+		// Find the announcements group registration created
+		var group bson.D
+		var groupID primitive.ObjectID
+		for _, started := range mt.GetAllStartedEvents() {
+			if coll := started.Command.Lookup(started.CommandName).StringValue(); coll == "groups" {
+				mt.Errorf("registration used the groups collection (%s)", started.CommandName)
+			}
+			if started.CommandName != "insert" || started.Command.Lookup("insert").StringValue() != "chat_groups" {
+				continue
+			}
+			doc := started.Command.Lookup("documents", "0").Document()
+			if doc.Lookup("name").StringValue() == "School Announcements" {
+				groupID = doc.Lookup("_id").ObjectID()
+				if err := bson.Unmarshal(doc, &group); err != nil {
+					mt.Fatal(err)
+				}
+			}
+		}
+		if group == nil {
+			mt.Fatal("School Announcements was not created in chat_groups")
+		}
+
+		mt.ClearEvents()
+		mt.AddMockResponses(
+			cursor("group_members", memberDoc(groupID, userID, models.GroupRoleMember)),
+			cursor("chat_groups", group),
+			cursor("users", userDoc(userID, constants.RoleStudent)),
+		)
+		body = `{"group_id":"` + groupID.Hex() + `","content":"Hello"}`
+		req = httptest.NewRequest(http.MethodPost, "/api/messages", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+auth.Token)
+		req.Header.Set("Content-Type", "application/json")
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			mt.Errorf("post: got status %d, want %d: %s", rec.Code, http.StatusForbidden, rec.Body)
+		}
+	})
+}
@@ -0,0 +1,23 @@
+package controllers
+
+// Internals used by the controllers_test package
+var (
+	GroupRoleCapabilities = groupRoleCapabilities
+	HasGroupCapability    = hasGroupCapability
+	CampaignTargets       = campaignTargets
+	TargetFilter          = targetFilter
+	FindOrCreateDirect    = findOrCreateDirect
+	AttachmentName        = attachmentName
+	DetectMimeType        = detectMimeType
+	ValidEmoji            = validEmoji
+	CSVSafe               = csvSafe
+	SyncGuardianChannels  = syncGuardianChannels
+
+	CapAddMembers           = capAddMembers
+	CapRemoveMembers        = capRemoveMembers
+	CapAssignRoles          = capAssignRoles
+	CapChangeSettings       = capChangeSettings
+	CapModerateMessages     = capModerateMessages
+	CapViewAcknowledgements = capViewAcknowledgements
+	CapBypassPostingPolicy  = capBypassPostingPolicy
+)
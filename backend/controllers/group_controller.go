@@ -5,6 +5,9 @@ import (
 	"chatterbloom/backend/constants"
 	"chatterbloom/backend/db"
 	"chatterbloom/backend/models"
+	"chatterbloom/backend/policy"
+	"chatterbloom/backend/protocol"
+	"chatterbloom/backend/websocket"
 	"context"
 	"net/http"
 	"time"
@@ -18,8 +21,10 @@ import (
 
 // GroupController handles group-related requests
 type GroupController struct {
-	DB     *mongo.Client
-	Config *config.Config
+	DB       *mongo.Client
+	Config   *config.Config
+	Hub      *websocket.Hub
+	Contacts *policy.Policy
 }
 
 // CreateGroupRequest represents the request to create a new group
@@ -32,21 +37,46 @@ type CreateGroupRequest struct {
 	Members            []string `json:"members"`
 }
 
-// GetGroups returns all groups for the current user
+// findMembership returns the user's membership in a group, or nil if the
+// user is not a member
+func findMembership(client *mongo.Client, dbName string, groupID, userID primitive.ObjectID) (*models.GroupMember, error) {
+	membersColl := db.GetCollection(client, dbName, "group_members")
+
+	var membership models.GroupMember
+	err := membersColl.FindOne(
+		context.Background(),
+		bson.M{
+			"group_id": groupID,
+			"user_id":  userID,
+		},
+	).Decode(&membership)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &membership, nil
+}
+
+// GetGroups returns all groups for the current user, with direct
+// conversations listed apart under the other participant's name
 func (gc *GroupController) GetGroups(c echo.Context) error {
 	// Get user ID from token - handle case when no user is authenticated
-	userIDInterface := c.Get("user")
+	userIDInterface := c.Get("user_id")
 	
 	// Get collections
 	groupsColl := db.GetCollection(gc.DB, gc.Config.DatabaseName, "chat_groups")
 	membersColl := db.GetCollection(gc.DB, gc.Config.DatabaseName, "group_members")
 	
-	// If no user is authenticated, return all groups (for development mode)
+	// If no user is authenticated, return all groups (for development mode).
+	// Direct conversations are left out, as they have no viewer to name them for.
 	if userIDInterface == nil {
 		// Find all groups
 		cursor, err := groupsColl.Find(
 			context.Background(),
-			bson.M{},
+			bson.M{"chat_type": bson.M{"$ne": "individual"}},
 		)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch groups")
@@ -58,8 +88,13 @@ func (gc *GroupController) GetGroups(c echo.Context) error {
 		if err := cursor.All(context.Background(), &groups); err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to decode groups")
 		}
+
+		response, err := gc.groupsWithMemberCounts(groups)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get member counts")
+		}
 		
-		return c.JSON(http.StatusOK, groups)
+		return c.JSON(http.StatusOK, models.GroupList{Groups: response, DirectConversations: []models.ChatGroupResponse{}})
 	}
 	
 	// Process authenticated user request
@@ -86,7 +121,10 @@ func (gc *GroupController) GetGroups(c echo.Context) error {
 	}
 
 	if len(memberships) == 0 {
-		return c.JSON(http.StatusOK, []models.ChatGroupResponse{})
+		return c.JSON(http.StatusOK, models.GroupList{
+			Groups:              []models.ChatGroupResponse{},
+			DirectConversations: []models.ChatGroupResponse{},
+		})
 	}
 
 	// Create slice of group IDs
@@ -111,18 +149,25 @@ func (gc *GroupController) GetGroups(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to decode groups")
 	}
 
-	// Convert to response format
-	var response []models.ChatGroupResponse
+	// Separate direct conversations from groups
+	var chatGroups, conversations []models.ChatGroup
 	for _, group := range groups {
-		// Get member count for each group
-		count, err := membersColl.CountDocuments(
-			context.Background(),
-			bson.M{"group_id": group.ID},
-		)
-		if err != nil {
-			count = 0 // Default to 0 if error
+		if group.ChatType == "individual" {
+			conversations = append(conversations, group)
+		} else {
+			chatGroups = append(chatGroups, group)
 		}
-		response = append(response, group.ToResponse(int(count)))
+	}
+
+	// Convert to response format with member counts
+	var response models.GroupList
+	response.Groups, err = gc.groupsWithMemberCounts(chatGroups)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get member counts")
+	}
+	response.DirectConversations, err = directResponses(gc.DB, gc.Config.DatabaseName, userObjID, conversations)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get conversation participants")
 	}
 
 	return c.JSON(http.StatusOK, response)
@@ -131,7 +176,11 @@ func (gc *GroupController) GetGroups(c echo.Context) error {
 // CreateGroup creates a new chat group
 func (gc *GroupController) CreateGroup(c echo.Context) error {
 	// Get user ID from token
-	userID := c.Get("user").(string)
+	userID, _ := c.Get("user_id").(string)
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
 	
 	// Bind request body
 	var req CreateGroupRequest
@@ -139,6 +188,37 @@ func (gc *GroupController) CreateGroup(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
 	}
 
+	// Individual chats are direct conversations, one per pair of users
+	if req.ChatType == "individual" {
+		if len(req.Members) != 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, "A direct conversation needs exactly one other member")
+		}
+		otherObjID, err := primitive.ObjectIDFromHex(req.Members[0])
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		conversation, created, err := startDirectConversation(gc.DB, gc.Config.DatabaseName, gc.Hub, gc.Contacts, userObjID, otherObjID)
+		if err != nil {
+			return err
+		}
+		if created {
+			return c.JSON(http.StatusCreated, conversation)
+		}
+		return c.JSON(http.StatusOK, conversation)
+	}
+
+	// The creator must be allowed to add every member, checked before any
+	// of them is added
+	for _, memberID := range req.Members {
+		memberObjID, err := primitive.ObjectIDFromHex(memberID)
+		if err != nil || memberObjID == userObjID {
+			continue
+		}
+		if err := checkContact(gc.DB, gc.Config.DatabaseName, gc.Contacts, policy.ActionAddMember, userObjID, memberObjID); err != nil {
+			return err
+		}
+	}
+
 	// Create new group
 	now := time.Now()
 	newGroup := models.ChatGroup{
@@ -158,7 +238,7 @@ func (gc *GroupController) CreateGroup(c echo.Context) error {
 	groupsColl := db.GetCollection(gc.DB, gc.Config.DatabaseName, "chat_groups")
 
 	// Insert group into database
-	_, err := groupsColl.InsertOne(context.Background(), newGroup)
+	_, err = groupsColl.InsertOne(context.Background(), newGroup)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create group")
 	}
@@ -180,12 +260,12 @@ func (gc *GroupController) CreateGroup(c echo.Context) error {
 			JoinedAt: now,
 			CreatedAt: now,
 			UpdatedAt: now,
-			Role:     "member",
+			Role:     models.GroupRoleMember,
 		}
 		
 		// Make creator an admin
 		if memberID == userID {
-			membership.Role = "admin"
+			membership.Role = models.GroupRoleAdmin
 		}
 		
 		_, err = membersColl.InsertOne(context.Background(), membership)
@@ -201,7 +281,8 @@ func (gc *GroupController) CreateGroup(c echo.Context) error {
 	return c.JSON(http.StatusCreated, newGroup.ToResponse(count))
 }
 
-// GetGroupDetails returns details of a specific group
+// GetGroupDetails returns details of a specific group to its members, school
+// admins and principals
 func (gc *GroupController) GetGroupDetails(c echo.Context) error {
 	// Get group ID from URL
 	groupID := c.Param("id")
@@ -223,6 +304,23 @@ func (gc *GroupController) GetGroupDetails(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
 
+	// Only members, school admins and principals can see a group
+	userRole, _ := c.Get("user_role").(string)
+	if userRole != constants.RoleAdmin && userRole != constants.RolePrincipal {
+		userID, _ := c.Get("user_id").(string)
+		userObjID, err := primitive.ObjectIDFromHex(userID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+		}
+		membership, err := findMembership(gc.DB, gc.Config.DatabaseName, groupObjID, userObjID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		if membership == nil {
+			return echo.NewHTTPError(http.StatusForbidden, "You are not a member of this group")
+		}
+	}
+
 	// Get group members
 	membersColl := db.GetCollection(gc.DB, gc.Config.DatabaseName, "group_members")
 	
@@ -248,8 +346,12 @@ func (gc *GroupController) GetGroupDetails(c echo.Context) error {
 	return c.JSON(http.StatusOK, group.ToResponse(memberCount))
 }
 
-// AddMemberToGroup adds a user to a group
+// AddMemberToGroup adds a user to a group. Group admins and moderators can
+// add members, only group admins can add them as admins or moderators.
 func (gc *GroupController) AddMemberToGroup(c echo.Context) error {
+	// Get user role from context
+	userRole := c.Get("user_role").(string)
+
 	// Get group ID from URL
 	groupID := c.Param("id")
 	groupObjID, err := primitive.ObjectIDFromHex(groupID)
@@ -260,30 +362,61 @@ func (gc *GroupController) AddMemberToGroup(c echo.Context) error {
 	// Bind request body
 	var req struct {
 		UserID string `json:"user_id" validate:"required"`
-		Role   string `json:"role" validate:"required,oneof=admin member"`
+		Role   string `json:"role" validate:"oneof=admin moderator member"`
 	}
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
 	}
+	if req.Role == "" {
+		req.Role = models.GroupRoleMember
+	}
+	if _, ok := groupRoleRank[req.Role]; !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid role")
+	}
 
 	userObjID, err := primitive.ObjectIDFromHex(req.UserID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
 	}
 
+	// Get the adding user from token
+	actorObjID, err := primitive.ObjectIDFromHex(c.Get("user_id").(string))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
 	// Check if group exists
 	groupsColl := db.GetCollection(gc.DB, gc.Config.DatabaseName, "chat_groups")
-	count, err := groupsColl.CountDocuments(context.Background(), bson.M{"_id": groupObjID})
+	var existing models.ChatGroup
+	err = groupsColl.FindOne(context.Background(), bson.M{"_id": groupObjID}).Decode(&existing)
+	if err == mongo.ErrNoDocuments {
+		return echo.NewHTTPError(http.StatusNotFound, "Group not found")
+	}
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
-	if count == 0 {
-		return echo.NewHTTPError(http.StatusNotFound, "Group not found")
+	if existing.ChatType == "individual" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Members can't be added to a direct conversation")
+	}
+
+	// Check the adding user's group role allows it
+	if _, err := authorizeGroup(gc.DB, gc.Config.DatabaseName, groupObjID, actorObjID, userRole, capAddMembers); err != nil {
+		return err
+	}
+	if req.Role != models.GroupRoleMember {
+		if _, err := authorizeGroup(gc.DB, gc.Config.DatabaseName, groupObjID, actorObjID, userRole, capAssignRoles); err != nil {
+			return err
+		}
+	}
+
+	// Check the contact policy lets the adding user bring this user in
+	if err := checkContact(gc.DB, gc.Config.DatabaseName, gc.Contacts, policy.ActionAddMember, actorObjID, userObjID); err != nil {
+		return err
 	}
 
 	// Check if user is already a member
 	membersColl := db.GetCollection(gc.DB, gc.Config.DatabaseName, "group_members")
-	count, err = membersColl.CountDocuments(
+	count, err := membersColl.CountDocuments(
 		context.Background(),
 		bson.M{
 			"group_id": groupObjID,
@@ -307,6 +440,10 @@ func (gc *GroupController) AddMemberToGroup(c echo.Context) error {
 	}
 
 	_, err = membersColl.InsertOne(context.Background(), membership)
+	if mongo.IsDuplicateKeyError(err) {
+		// Added by a concurrent request since the check above
+		return echo.NewHTTPError(http.StatusConflict, "User is already a member of this group")
+	}
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add member to group")
 	}
@@ -340,8 +477,12 @@ func (gc *GroupController) AddMemberToGroup(c echo.Context) error {
 	return c.JSON(http.StatusOK, group.ToResponse(int(count)))
 }
 
-// RemoveMemberFromGroup removes a user from a group
+// RemoveMemberFromGroup removes a user from a group. Members can leave,
+// group admins can remove anyone and moderators can remove members.
 func (gc *GroupController) RemoveMemberFromGroup(c echo.Context) error {
+	// Get user role from context
+	userRole := c.Get("user_role").(string)
+
 	// Get group ID and user ID from URL
 	groupID := c.Param("id")
 	userID := c.Param("userId")
@@ -356,6 +497,54 @@ func (gc *GroupController) RemoveMemberFromGroup(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
 	}
 
+	// Get the removing user from token
+	actorObjID, err := primitive.ObjectIDFromHex(c.Get("user_id").(string))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	// Check if group exists
+	groupsColl := db.GetCollection(gc.DB, gc.Config.DatabaseName, "chat_groups")
+	var existing models.ChatGroup
+	err = groupsColl.FindOne(context.Background(), bson.M{"_id": groupObjID}).Decode(&existing)
+	if err == mongo.ErrNoDocuments {
+		return echo.NewHTTPError(http.StatusNotFound, "Group not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if existing.ChatType == "individual" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Members can't be removed from a direct conversation")
+	}
+
+	// Check the removed user is a member
+	target, err := findMembership(gc.DB, gc.Config.DatabaseName, groupObjID, userObjID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if target == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "User is not a member of this group")
+	}
+
+	// Anyone can leave, removing others depends on the removing user's group role
+	if actorObjID != userObjID {
+		membership, err := authorizeGroup(gc.DB, gc.Config.DatabaseName, groupObjID, actorObjID, userRole, capRemoveMembers)
+		if err != nil {
+			return err
+		}
+		// Teachers rank as moderators whatever their stored group role
+		targets, err := loadUsers(gc.DB, gc.Config.DatabaseName, nil, []primitive.ObjectID{userObjID})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		if !canActOnRole(membership, userRole, effectiveGroupRole(target, targets[userObjID].Role)) {
+			return echo.NewHTTPError(http.StatusForbidden, "Moderators can only remove members")
+		}
+	}
+	if err := gc.checkNotLastAdmin(groupObjID, target); err != nil {
+		return err
+	}
+
 	// Remove membership
 	membersColl := db.GetCollection(gc.DB, gc.Config.DatabaseName, "group_members")
 	_, err = membersColl.DeleteOne(
@@ -370,7 +559,6 @@ func (gc *GroupController) RemoveMemberFromGroup(c echo.Context) error {
 	}
 
 	// Update members array in group document
-	groupsColl := db.GetCollection(gc.DB, gc.Config.DatabaseName, "chat_groups")
 	_, err = groupsColl.UpdateOne(
 		context.Background(),
 		bson.M{"_id": groupObjID},
@@ -380,6 +568,11 @@ func (gc *GroupController) RemoveMemberFromGroup(c echo.Context) error {
 		// Log error but continue
 	}
 
+	// Stop sending the group's events to the removed user's open connections
+	if gc.Hub != nil {
+		gc.Hub.RemoveUserFromRoom(userID, groupID)
+	}
+
 	// Get group details
 	var group models.ChatGroup
 	err = groupsColl.FindOne(context.Background(), bson.M{"_id": groupObjID}).Decode(&group)
@@ -399,6 +592,92 @@ func (gc *GroupController) RemoveMemberFromGroup(c echo.Context) error {
 	return c.JSON(http.StatusOK, group.ToResponse(int(count)))
 }
 
+// UpdateMemberRole changes a member's role in a group (group admins only)
+func (gc *GroupController) UpdateMemberRole(c echo.Context) error {
+	// Get user ID and role from context
+	userID := c.Get("user_id").(string)
+	userRole := c.Get("user_role").(string)
+	actorObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	// Get group ID and user ID from URL
+	groupObjID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid group ID")
+	}
+	userObjID, err := primitive.ObjectIDFromHex(c.Param("userId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	// Bind request body
+	var req models.MemberRoleRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	if _, ok := groupRoleRank[req.Role]; !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid role")
+	}
+
+	// Check permission
+	if _, err := authorizeGroup(gc.DB, gc.Config.DatabaseName, groupObjID, actorObjID, userRole, capAssignRoles); err != nil {
+		return err
+	}
+
+	// Check the user is a member and the group keeps an admin
+	target, err := findMembership(gc.DB, gc.Config.DatabaseName, groupObjID, userObjID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if target == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "User is not a member of this group")
+	}
+	if req.Role != models.GroupRoleAdmin {
+		if err := gc.checkNotLastAdmin(groupObjID, target); err != nil {
+			return err
+		}
+	}
+
+	// Update membership
+	membersColl := db.GetCollection(gc.DB, gc.Config.DatabaseName, "group_members")
+	now := time.Now()
+	_, err = membersColl.UpdateOne(
+		context.Background(),
+		bson.M{"_id": target.ID},
+		bson.M{"$set": bson.M{"role": req.Role, "updated_at": now}},
+	)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update member role")
+	}
+
+	target.Role = req.Role
+	target.UpdatedAt = now
+	return c.JSON(http.StatusOK, target)
+}
+
+// checkNotLastAdmin returns a 409 error when the membership is the group's
+// only admin, so a group always keeps someone to manage it
+func (gc *GroupController) checkNotLastAdmin(groupObjID primitive.ObjectID, membership *models.GroupMember) error {
+	if membership.Role != models.GroupRoleAdmin {
+		return nil
+	}
+
+	membersColl := db.GetCollection(gc.DB, gc.Config.DatabaseName, "group_members")
+	admins, err := membersColl.CountDocuments(context.Background(), bson.M{
+		"group_id": groupObjID,
+		"role":     models.GroupRoleAdmin,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if admins <= 1 {
+		return echo.NewHTTPError(http.StatusConflict, "A group needs at least one admin")
+	}
+	return nil
+}
+
 // GetAllGroups returns all groups in the system (admin only)
 func (gc *GroupController) GetAllGroups(c echo.Context) error {
 	// Get user role from context
@@ -424,7 +703,6 @@ func (gc *GroupController) GetAllGroups(c echo.Context) error {
 	
 	// Get groups collection
 	groupsColl := db.GetCollection(gc.DB, gc.Config.DatabaseName, "chat_groups")
-	membersColl := db.GetCollection(gc.DB, gc.Config.DatabaseName, "group_members")
 	
 	// Find all groups
 	opts := options.Find().SetSort(bson.M{"name": 1})
@@ -441,18 +719,178 @@ func (gc *GroupController) GetAllGroups(c echo.Context) error {
 	}
 	
 	// Convert to response format with member counts
-	var response []models.ChatGroupResponse
-	for _, group := range groups {
-		// Get member count for each group
-		count, err := membersColl.CountDocuments(
-			context.Background(),
-			bson.M{"group_id": group.ID},
-		)
-		if err != nil {
-			count = 0 // Default to 0 if error
+	response, err := gc.groupsWithMemberCounts(groups)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get member counts")
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// GetGroupPresence returns the online status of every member of a group
+func (gc *GroupController) GetGroupPresence(c echo.Context) error {
+	// Get user ID and role from context
+	userID := c.Get("user_id").(string)
+	userRole := c.Get("user_role").(string)
+
+	// Get group ID from URL
+	groupID := c.Param("id")
+	groupObjID, err := primitive.ObjectIDFromHex(groupID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid group ID")
+	}
+
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	// Get group members
+	membersColl := db.GetCollection(gc.DB, gc.Config.DatabaseName, "group_members")
+	cursor, err := membersColl.Find(
+		context.Background(),
+		bson.M{"group_id": groupObjID},
+	)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer cursor.Close(context.Background())
+
+	var members []models.GroupMember
+	if err := cursor.All(context.Background(), &members); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to decode members")
+	}
+
+	// Only members (or admins and principals) can see who is online
+	isMember := false
+	userIDs := make([]string, 0, len(members))
+	userObjIDs := make([]primitive.ObjectID, 0, len(members))
+	for _, member := range members {
+		if member.UserID == userObjID {
+			isMember = true
 		}
-		response = append(response, group.ToResponse(int(count)))
+		userIDs = append(userIDs, member.UserID.Hex())
+		userObjIDs = append(userObjIDs, member.UserID)
 	}
-	
+	if !isMember && userRole != constants.RoleAdmin && userRole != constants.RolePrincipal {
+		return echo.NewHTTPError(http.StatusForbidden, "You are not a member of this group")
+	}
+
+	// Get last seen times
+	usersColl := db.GetCollection(gc.DB, gc.Config.DatabaseName, "users")
+	usersCursor, err := usersColl.Find(
+		context.Background(),
+		bson.M{"_id": bson.M{"$in": userObjIDs}},
+		options.Find().SetProjection(bson.M{"last_seen_at": 1}),
+	)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer usersCursor.Close(context.Background())
+
+	var users []models.User
+	if err := usersCursor.All(context.Background(), &users); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to decode users")
+	}
+
+	lastSeen := make(map[string]*time.Time, len(users))
+	for _, user := range users {
+		lastSeen[user.ID.Hex()] = user.LastSeenAt
+	}
+
+	// Live status comes from the WebSocket hub
+	statuses := map[string]string{}
+	if gc.Hub != nil {
+		statuses = gc.Hub.Presence(userIDs)
+	}
+
+	response := make([]models.MemberPresence, 0, len(userIDs))
+	for _, memberID := range userIDs {
+		status, ok := statuses[memberID]
+		if !ok {
+			status = protocol.PresenceOffline
+		}
+		response = append(response, models.MemberPresence{
+			UserID:     memberID,
+			Status:     status,
+			LastSeenAt: lastSeen[memberID],
+		})
+	}
+
 	return c.JSON(http.StatusOK, response)
 }
+
+// UpdateGroupSettings changes a group's settings. Only school admins,
+// principals and group admins can change them.
+func (gc *GroupController) UpdateGroupSettings(c echo.Context) error {
+	// Get user ID and role from context
+	userID := c.Get("user_id").(string)
+	userRole := c.Get("user_role").(string)
+
+	// Get group ID from URL
+	groupObjID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid group ID")
+	}
+
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	var req models.GroupSettingsRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	// Check permission
+	if _, err := authorizeGroup(gc.DB, gc.Config.DatabaseName, groupObjID, userObjID, userRole, capChangeSettings); err != nil {
+		return err
+	}
+
+	// Build update from the fields that were sent
+	set := bson.M{"updated_at": time.Now()}
+	if req.AllowedReactions != nil {
+		allowed := []string{}
+		for _, emoji := range *req.AllowedReactions {
+			if !validEmoji(emoji) {
+				return echo.NewHTTPError(http.StatusBadRequest, "Invalid emoji in allowed_reactions")
+			}
+			if !containsString(allowed, emoji) {
+				allowed = append(allowed, emoji)
+			}
+		}
+		set["allowed_reactions"] = allowed
+	}
+	if err := postingSettings(&req, set); err != nil {
+		return err
+	}
+
+	// Update group
+	groupsColl := db.GetCollection(gc.DB, gc.Config.DatabaseName, "chat_groups")
+	var group models.ChatGroup
+	err = groupsColl.FindOneAndUpdate(
+		context.Background(),
+		bson.M{"_id": groupObjID},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&group)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return echo.NewHTTPError(http.StatusNotFound, "Group not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update group settings")
+	}
+
+	// Get member count
+	membersColl := db.GetCollection(gc.DB, gc.Config.DatabaseName, "group_members")
+	count, err := membersColl.CountDocuments(
+		context.Background(),
+		bson.M{"group_id": groupObjID},
+	)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get member count")
+	}
+
+	return c.JSON(http.StatusOK, group.ToResponse(int(count)))
+}
@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"chatterbloom/backend/constants"
+	"chatterbloom/backend/models"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Group capabilities, checked by authorizeGroup
+const (
+	capAddMembers           = "add members"
+	capRemoveMembers        = "remove members"
+	capAssignRoles          = "assign member roles"
+	capChangeSettings       = "change group settings"
+	capModerateMessages     = "delete other members' messages"
+	capViewAcknowledgements = "see who acknowledged announcements"
+	capBypassPostingPolicy  = "post past announcement-only and slow mode limits"
+)
+
+// groupRoleCapabilities lists what each group role may do. Moderators look
+// after members and messages, only admins shape the group itself.
+var groupRoleCapabilities = map[string][]string{
+	models.GroupRoleAdmin: {
+		capAddMembers,
+		capRemoveMembers,
+		capAssignRoles,
+		capChangeSettings,
+		capModerateMessages,
+		capViewAcknowledgements,
+		capBypassPostingPolicy,
+	},
+	models.GroupRoleModerator: {
+		capAddMembers,
+		capRemoveMembers,
+		capModerateMessages,
+		capViewAcknowledgements,
+	},
+	models.GroupRoleMember: {},
+}
+
+// teacherCapabilities are what teachers may do in every group they are in,
+// on top of their group role
+var teacherCapabilities = []string{capBypassPostingPolicy}
+
+// groupRoleRank orders group roles so moderators can only act on members
+var groupRoleRank = map[string]int{
+	models.GroupRoleMember:    0,
+	models.GroupRoleModerator: 1,
+	models.GroupRoleAdmin:     2,
+}
+
+// effectiveGroupRole returns the group role a member acts with. Teachers
+// moderate the groups they are in.
+func effectiveGroupRole(membership *models.GroupMember, userRole string) string {
+	if membership.Role == models.GroupRoleMember && userRole == constants.RoleTeacher {
+		return models.GroupRoleModerator
+	}
+	return membership.Role
+}
+
+// authorizeGroup checks that a user may use a capability in a group and
+// returns their membership, which is nil for school admins and principals
+// who aren't members. They may do anything in any group.
+func authorizeGroup(client *mongo.Client, dbName string, groupObjID, userObjID primitive.ObjectID, userRole, capability string) (*models.GroupMember, error) {
+	membership, err := findMembership(client, dbName, groupObjID, userObjID)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if userRole == constants.RoleAdmin || userRole == constants.RolePrincipal {
+		return membership, nil
+	}
+	if membership == nil {
+		return nil, echo.NewHTTPError(http.StatusForbidden, "You are not a member of this group")
+	}
+
+	if !hasGroupCapability(membership, userRole, capability) {
+		return nil, echo.NewHTTPError(http.StatusForbidden, "Your role in this group doesn't let you "+capability)
+	}
+	return membership, nil
+}
+
+// hasGroupCapability reports whether a member may use a capability, for
+// callers that already loaded the membership
+func hasGroupCapability(membership *models.GroupMember, userRole, capability string) bool {
+	if userRole == constants.RoleAdmin || userRole == constants.RolePrincipal {
+		return true
+	}
+	if membership == nil {
+		return false
+	}
+	if userRole == constants.RoleTeacher && containsString(teacherCapabilities, capability) {
+		return true
+	}
+	return containsString(groupRoleCapabilities[effectiveGroupRole(membership, userRole)], capability)
+}
+
+// canActOnRole reports whether the holder of a membership may add, remove or
+// assign a member with the given group role. School admins and principals
+// (nil membership) and group admins may act on any role, moderators only on
+// members.
+func canActOnRole(membership *models.GroupMember, userRole, role string) bool {
+	if membership == nil || userRole == constants.RoleAdmin || userRole == constants.RolePrincipal {
+		return true
+	}
+	actorRole := effectiveGroupRole(membership, userRole)
+	return actorRole == models.GroupRoleAdmin || groupRoleRank[role] < groupRoleRank[actorRole]
+}
@@ -0,0 +1,523 @@
+package controllers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chatterbloom/backend/config"
+	"chatterbloom/backend/constants"
+	"chatterbloom/backend/controllers"
+	"chatterbloom/backend/models"
+	"chatterbloom/backend/policy"
+	"chatterbloom/backend/protocol"
+	"chatterbloom/backend/routes"
+	"chatterbloom/backend/storage"
+	"chatterbloom/backend/websocket"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+const testJWTSecret = "test-secret"
+
+// groupRoute is a route that reads or changes a group, or acts on it on
+// behalf of the group, and who may use it
+type groupRoute struct {
+	// Group capability the route needs; empty for routes any member may use
+	capability string
+
+	// Whether school admins and principals may use a route that needs no
+	// capability without being members
+	schoolAdmins bool
+
+	// School roles the route is open to at all; nil for every role
+	schoolRoles []string
+
+	// Query string and request body, with :group and :target replaced by the
+	// IDs of the group and the user acted on
+	query, body string
+
+	// Content type of the body, JSON if empty
+	contentType string
+
+	// Documents the handler loads before it looks up the caller's
+	// membership, in order
+	before func(ids testIDs) []bson.D
+
+	// Reply to the membership lookup, for handlers that don't look up a
+	// single membership; nil for the caller's membership or none
+	membership func(ids testIDs, groupRole string) bson.D
+}
+
+// testIDs are the documents a matrix case is about
+type testIDs struct {
+	group, message, caller, target primitive.ObjectID
+}
+
+// teacherRoutes are the school roles allowed past the /api/teacher routes'
+// role check
+var teacherRoutes = []string{constants.RoleAdmin, constants.RolePrincipal, constants.RoleTeacher}
+
+// uploadBody is a multipart form attaching a PDF to a message
+const uploadBody = "--boundary\r\n" +
+	"Content-Disposition: form-data; name=\"group_id\"\r\n\r\n:group\r\n" +
+	"--boundary\r\n" +
+	"Content-Disposition: form-data; name=\"files\"; filename=\"notes.pdf\"\r\n" +
+	"Content-Type: application/pdf\r\n\r\n%PDF-1.4\n\r\n" +
+	"--boundary--\r\n"
+
+func groupFirst(ids testIDs) []bson.D {
+	return []bson.D{cursor("chat_groups", groupDoc(ids))}
+}
+
+func messageFirst(ids testIDs) []bson.D {
+	return []bson.D{cursor("messages", messageDoc(ids))}
+}
+
+// groupRoutes classifies every route registered by RegisterRoutes that acts
+// on one group. Every other route must be in otherRoutes.
+var groupRoutes = map[string]groupRoute{
+	"GET /api/groups/:id": {
+		schoolAdmins: true,
+		before:       groupFirst,
+	},
+	"GET /api/groups/:id/presence": {
+		schoolAdmins: true,
+	},
+	"GET /api/groups/:groupId/messages": {
+		schoolAdmins: true,
+	},
+	"POST /api/messages": {
+		body: `{"group_id":":group","content":"Hi"}`,
+	},
+	"POST /api/messages/attachments": {
+		body:        uploadBody,
+		contentType: "multipart/form-data; boundary=boundary",
+	},
+	"POST /api/teacher/groups/:id/announcement": {
+		schoolAdmins: true,
+		schoolRoles:  teacherRoutes,
+		body:         `{"title":"Trip","content":"Field trip on Friday"}`,
+		before:       groupFirst,
+	},
+	"POST /api/teacher/campaigns": {
+		schoolAdmins: true,
+		schoolRoles:  teacherRoutes,
+		body:         `{"title":"Trip","content":"Field trip on Friday","group_ids":[":group"]}`,
+		before:       groupFirst,
+		membership: func(ids testIDs, groupRole string) bson.D {
+			if groupRole == "" {
+				return cursor("group_members")
+			}
+			return cursor("group_members", bson.D{{Key: "n", Value: 1}})
+		},
+	},
+	"GET /api/messages/unread": {
+		query: "?groupId=:group",
+	},
+	"GET /api/search/messages": {
+		query: "?q=trip&group_id=:group",
+	},
+	"POST /api/groups/:id/members": {
+		capability: controllers.CapAddMembers,
+		body:       `{"user_id":":target","role":"member"}`,
+		before:     groupFirst,
+	},
+	"DELETE /api/groups/:id/members/:userId": {
+		capability: controllers.CapRemoveMembers,
+		before: func(ids testIDs) []bson.D {
+			return []bson.D{
+				cursor("chat_groups", groupDoc(ids)),
+				cursor("group_members", memberDoc(ids.group, ids.target, models.GroupRoleMember)),
+			}
+		},
+	},
+	"PUT /api/groups/:id/members/:userId/role": {
+		capability: controllers.CapAssignRoles,
+		body:       `{"role":"member"}`,
+	},
+	"PUT /api/groups/:id/settings": {
+		capability: controllers.CapChangeSettings,
+		body:       `{}`,
+	},
+	"PUT /api/groups/:id/read": {
+		body: `{}`,
+	},
+	"PUT /api/messages/:id": {
+		body: `{"content":"Field trip on Monday"}`,
+		before: func(ids testIDs) []bson.D {
+			return []bson.D{cursor("messages", ownMessageDoc(ids))}
+		},
+	},
+	"DELETE /api/messages/:id": {
+		capability: controllers.CapModerateMessages,
+		before:     messageFirst,
+	},
+	"PUT /api/messages/:id/read": {
+		before: messageFirst,
+	},
+	"GET /api/messages/:id/thread": {
+		before: messageFirst,
+	},
+	"POST /api/messages/:id/reactions": {
+		body:   `{"emoji":"👍"}`,
+		before: messageFirst,
+	},
+	"DELETE /api/messages/:id/reactions": {
+		query:  "?emoji=%F0%9F%91%8D",
+		before: messageFirst,
+	},
+	"GET /api/messages/:id/attachments/:attachmentId": {
+		before: messageFirst,
+	},
+	"GET /api/messages/:id/attachments/:attachmentId/thumbnail": {
+		before: messageFirst,
+	},
+	"POST /api/messages/:id/acknowledge": {
+		before: messageFirst,
+	},
+	"GET /api/messages/:id/acknowledgements": {
+		capability: controllers.CapViewAcknowledgements,
+		before:     messageFirst,
+	},
+}
+
+// otherRoutes are the routes that don't act on one group, and why
+var otherRoutes = map[string]string{
+	"POST /api/auth/login":                "public",
+	"POST /api/auth/register":             "public",
+	"GET /ws":                             "subscriptions are authorized per group by the hub",
+	"GET /api/user/profile":               "the caller's own profile",
+	"PUT /api/user/profile":               "the caller's own profile",
+	"POST /api/ws/ticket":                 "the caller's own ticket",
+	"GET /api/groups":                     "lists the caller's groups",
+	"POST /api/groups":                    "the creator becomes the new group's admin",
+	"POST /api/conversations/direct":      "checked by the contact policy",
+	"GET /api/admin/users":                "school admins and principals only",
+	"POST /api/admin/users":               "school admins and principals only",
+	"PUT /api/admin/users/:id":            "school admins and principals only",
+	"DELETE /api/admin/users/:id":         "school admins and principals only",
+	"GET /api/admin/groups/all":           "school admins and principals only",
+	"GET /api/admin/guardianships":        "school admins and principals only",
+	"POST /api/admin/guardianships":       "school admins and principals only",
+	"PUT /api/admin/guardianships/:id":    "school admins and principals only",
+	"DELETE /api/admin/guardianships/:id": "school admins and principals only",
+	"GET /api/teacher/campaigns":          "the caller's own campaigns",
+	"GET /api/teacher/campaigns/:id":      "the sender's campaign, or any for school admins and principals",
+	"GET /api/notifications":              "the caller's own notifications",
+	"PUT /api/notifications/:id/read":     "the caller's own notifications",
+	"GET /api/scheduled-messages":         "the caller's own scheduled messages",
+	"PUT /api/scheduled-messages/:id":     "the caller's own scheduled messages",
+	"DELETE /api/scheduled-messages/:id":  "the caller's own scheduled messages",
+}
+
+// TestGroupRouteMatrix calls every group route as every school role holding
+// every group role, and checks the result against the capability map
+func TestGroupRouteMatrix(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("ENVIRONMENT", "production")
+
+	// Every route must be classified, and every classified route must still
+	// exist
+	registered := map[string]bool{}
+	for _, route := range newServer(nil).Routes() {
+		if route.Method == echo.RouteNotFound {
+			continue
+		}
+		key := route.Method + " " + route.Path
+		registered[key] = true
+		_, isGroupRoute := groupRoutes[key]
+		_, isOtherRoute := otherRoutes[key]
+		if !isGroupRoute && !isOtherRoute {
+			t.Errorf("%s is in neither groupRoutes nor otherRoutes", key)
+		}
+	}
+	for key := range groupRoutes {
+		if !registered[key] {
+			t.Errorf("%s is in groupRoutes but not registered", key)
+		}
+	}
+	for key := range otherRoutes {
+		if !registered[key] {
+			t.Errorf("%s is in otherRoutes but not registered", key)
+		}
+	}
+
+	schoolRoles := []string{
+		constants.RoleAdmin, constants.RolePrincipal, constants.RoleTeacher,
+		constants.RoleStaff, constants.RoleStudent, constants.RoleParent,
+	}
+	groupRoles := []string{models.GroupRoleAdmin, models.GroupRoleModerator, models.GroupRoleMember, ""}
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for key, route := range groupRoutes {
+		for _, schoolRole := range schoolRoles {
+			for _, groupRole := range groupRoles {
+				name := key + "/" + schoolRole + "/" + groupRole
+				if groupRole == "" {
+					name = key + "/" + schoolRole + "/non-member"
+				}
+
+				mt.Run(name, func(mt *mtest.T) {
+					ids := testIDs{
+						group:   primitive.NewObjectID(),
+						message: primitive.NewObjectID(),
+						caller:  primitive.NewObjectID(),
+						target:  primitive.NewObjectID(),
+					}
+
+					// The handler gets the documents it loads first and the
+					// caller's membership; every later query fails
+					if route.before != nil {
+						mt.AddMockResponses(route.before(ids)...)
+					}
+					switch {
+					case route.membership != nil:
+						mt.AddMockResponses(route.membership(ids, groupRole))
+					case groupRole == "":
+						mt.AddMockResponses(cursor("group_members"))
+					default:
+						mt.AddMockResponses(cursor("group_members", memberDoc(ids.group, ids.caller, groupRole)))
+					}
+
+					code := call(mt, newServer(mt), key, route, ids, schoolRole)
+
+					// Where the group role decides, it must have been looked up
+					want := allowed(route, schoolRole, groupRole)
+					if allowed(route, schoolRole, "") != allowed(route, schoolRole, models.GroupRoleAdmin) && !membershipChecked(mt, ids) {
+						mt.Fatalf("the caller's membership was never looked up (status %d)", code)
+					}
+					if got := code != http.StatusForbidden; got != want {
+						mt.Errorf("got status %d, want allowed=%v", code, want)
+					}
+				})
+			}
+		}
+	}
+}
+
+// allowed is what the route's classification and the capability map say
+// about a caller
+func allowed(route groupRoute, schoolRole, groupRole string) bool {
+	if route.schoolRoles != nil && !containsRole(route.schoolRoles, schoolRole) {
+		return false
+	}
+	schoolAdmin := schoolRole == constants.RoleAdmin || schoolRole == constants.RolePrincipal
+	if route.capability == "" {
+		return groupRole != "" || (route.schoolAdmins && schoolAdmin)
+	}
+	if schoolAdmin {
+		return true
+	}
+	if groupRole == "" {
+		return false
+	}
+
+	// Teachers moderate the groups they are in
+	if schoolRole == constants.RoleTeacher && groupRole == models.GroupRoleMember {
+		groupRole = models.GroupRoleModerator
+	}
+	return containsRole(controllers.GroupRoleCapabilities[groupRole], route.capability)
+}
+
+func containsRole(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// TestRemoveMemberByEffectiveRole checks that moderators can't remove a
+// teacher, who moderates whatever group role they were given
+func TestRemoveMemberByEffectiveRole(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("ENVIRONMENT", "production")
+
+	route := groupRoutes["DELETE /api/groups/:id/members/:userId"]
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for _, targetRole := range []string{constants.RoleStudent, constants.RoleTeacher} {
+		mt.Run(targetRole, func(mt *mtest.T) {
+			ids := testIDs{group: primitive.NewObjectID(), caller: primitive.NewObjectID(), target: primitive.NewObjectID()}
+			mt.AddMockResponses(route.before(ids)...)
+			mt.AddMockResponses(
+				cursor("group_members", memberDoc(ids.group, ids.caller, models.GroupRoleModerator)),
+				cursor("users", userDoc(ids.target, targetRole)),
+			)
+
+			code := call(mt, newServer(mt), "DELETE /api/groups/:id/members/:userId", route, ids, constants.RoleStaff)
+			if forbidden := code == http.StatusForbidden; forbidden != (targetRole == constants.RoleTeacher) {
+				mt.Errorf("removing a %s: got status %d", targetRole, code)
+			}
+		})
+	}
+}
+
+// newServer registers the application's routes on a fresh server, storing
+// attachments in a temporary directory
+func newServer(mt *mtest.T) *echo.Echo {
+	if mt == nil {
+		return newServerWithBlobs(nil, nil)
+	}
+	return newServerWithBlobs(mt, storage.NewLocalBlobStore(mt.TempDir()))
+}
+
+// newServerWithBlobs registers the application's routes on a fresh server
+// that stores attachments in blobs
+func newServerWithBlobs(mt *mtest.T, blobs storage.BlobStore) *echo.Echo {
+	return newServerWithBackplane(mt, blobs, websocket.NewMemoryBackplane())
+}
+
+// newServerWithEvents registers the application's routes on a fresh server
+// and returns the room events it publishes
+func newServerWithEvents(mt *mtest.T) (*echo.Echo, <-chan websocket.RoomEvent) {
+	backplane := websocket.NewMemoryBackplane()
+	events := subscribe(mt, backplane)
+	return newServerWithBackplane(mt, storage.NewLocalBlobStore(mt.TempDir()), backplane), events
+}
+
+// subscribe returns the room events published on a backplane
+func subscribe(mt *mtest.T, backplane websocket.Backplane) <-chan websocket.RoomEvent {
+	events := make(chan websocket.RoomEvent, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	mt.Cleanup(cancel)
+	if err := backplane.Subscribe(ctx, func(event websocket.RoomEvent) { events <- event }); err != nil {
+		mt.Fatal(err)
+	}
+	return events
+}
+
+// nextRoomEvent waits for the next room event published
+func nextRoomEvent(mt *mtest.T, events <-chan websocket.RoomEvent) websocket.RoomEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		mt.Fatal("no event was published")
+		return websocket.RoomEvent{}
+	}
+}
+
+// nextEvent waits for the next room event a server published and decodes it
+func nextEvent(mt *mtest.T, events <-chan websocket.RoomEvent) (string, protocol.Envelope) {
+	event := nextRoomEvent(mt, events)
+	var envelope protocol.Envelope
+	if err := json.Unmarshal(event.Message, &envelope); err != nil {
+		mt.Fatalf("decoding %s: %v", event.Message, err)
+	}
+	return event.RoomID, envelope
+}
+
+func newServerWithBackplane(mt *mtest.T, blobs storage.BlobStore, backplane websocket.Backplane) *echo.Echo {
+	var client *mongo.Client
+	if mt != nil {
+		client = mt.Client
+	}
+	e := echo.New()
+	hub := websocket.NewHub(backplane, nil)
+	contacts := policy.Default()
+	messages := controllers.NewMessageController(client, config.LoadConfig(), hub, contacts)
+	routes.RegisterRoutes(e, client, hub, blobs, contacts, messages)
+	return e
+}
+
+// call sends a request to a route as a user with the given school role
+func call(mt *mtest.T, e *echo.Echo, key string, route groupRoute, ids testIDs, schoolRole string) int {
+	method, path, _ := strings.Cut(key, " ")
+	id := ids.group
+	if strings.HasPrefix(path, "/api/messages/") {
+		id = ids.message
+	}
+	path = strings.NewReplacer(
+		":id", id.Hex(),
+		":groupId", ids.group.Hex(),
+		":userId", ids.target.Hex(),
+		":attachmentId", primitive.NewObjectID().Hex(),
+	).Replace(path)
+	values := strings.NewReplacer(":group", ids.group.Hex(), ":target", ids.target.Hex())
+	contentType := route.contentType
+	if contentType == "" {
+		contentType = echo.MIMEApplicationJSON
+	}
+
+	req := httptest.NewRequest(method, path+values.Replace(route.query), strings.NewReader(values.Replace(route.body)))
+	req.Header.Set(echo.HeaderAuthorization, bearer(mt, ids.caller, schoolRole))
+	req.Header.Set(echo.HeaderContentType, contentType)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+// membershipChecked reports whether the caller's membership was looked up,
+// on its own or among the group's members
+func membershipChecked(mt *mtest.T, ids testIDs) bool {
+	for _, started := range mt.GetAllStartedEvents() {
+		if started.Command.Lookup(started.CommandName).StringValue() != "group_members" {
+			continue
+		}
+		if bytes.Contains(started.Command, ids.caller[:]) || bytes.Contains(started.Command, ids.group[:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// cursor is a mock reply to a find on coll
+func cursor(coll string, docs ...bson.D) bson.D {
+	return mtest.CreateCursorResponse(0, "chatterbloom."+coll, mtest.FirstBatch, docs...)
+}
+
+func groupDoc(ids testIDs) bson.D {
+	return bson.D{
+		{Key: "_id", Value: ids.group},
+		{Key: "name", Value: "Class 4B"},
+		{Key: "group_type", Value: "class"},
+		{Key: "chat_type", Value: "group"},
+	}
+}
+
+func memberDoc(groupID, userID primitive.ObjectID, role string) bson.D {
+	return bson.D{
+		{Key: "_id", Value: primitive.NewObjectID()},
+		{Key: "group_id", Value: groupID},
+		{Key: "user_id", Value: userID},
+		{Key: "role", Value: role},
+		{Key: "joined_at", Value: time.Now()},
+	}
+}
+
+// ownMessageDoc is a message in the group sent by the caller
+func ownMessageDoc(ids testIDs) bson.D {
+	return bson.D{
+		{Key: "_id", Value: ids.message},
+		{Key: "group_id", Value: ids.group},
+		{Key: "sender_id", Value: ids.caller},
+		{Key: "type", Value: "regular"},
+		{Key: "content", Value: "Field trip on Friday"},
+		{Key: "created_at", Value: time.Now()},
+	}
+}
+
+// messageDoc is an announcement in the group sent by the target, so the
+// caller never acts on their own message
+func messageDoc(ids testIDs) bson.D {
+	return bson.D{
+		{Key: "_id", Value: ids.message},
+		{Key: "group_id", Value: ids.group},
+		{Key: "sender_id", Value: ids.target},
+		{Key: "type", Value: "announcement"},
+		{Key: "content", Value: "Field trip on Friday"},
+		{Key: "requires_ack", Value: true},
+		{Key: "created_at", Value: time.Now()},
+	}
+}
@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"chatterbloom/backend/constants"
+	"chatterbloom/backend/db"
+	"chatterbloom/backend/models"
+	"chatterbloom/backend/websocket"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// syncGuardianChannels puts a guardian in the parent channel of every
+// organizational unit one of their verified students is in, and takes them
+// out of the other parent channels, unsubscribing their open connections
+func syncGuardianChannels(client *mongo.Client, dbName string, hub *websocket.Hub, guardianObjID primitive.ObjectID) error {
+	ctx := context.Background()
+
+	// Get the guardian's verified students
+	guardianshipsColl := db.GetCollection(client, dbName, "guardianships")
+	studentIDs, err := guardianshipsColl.Distinct(ctx, "student_id", bson.M{
+		"guardian_id": guardianObjID,
+		"status":      models.GuardianshipVerified,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Get the units they are in
+	var units []interface{}
+	if len(studentIDs) > 0 {
+		usersColl := db.GetCollection(client, dbName, "users")
+		units, err = usersColl.Distinct(ctx, "organizational_unit", bson.M{
+			"_id":                 bson.M{"$in": studentIDs},
+			"role":                constants.RoleStudent,
+			"organizational_unit": bson.M{"$nin": bson.A{"", nil}},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Join each unit's parent channel
+	groupsColl := db.GetCollection(client, dbName, "chat_groups")
+	membersColl := db.GetCollection(client, dbName, "group_members")
+	channelIDs := make([]primitive.ObjectID, 0, len(units))
+	for _, unit := range units {
+		channel, err := parentChannel(client, dbName, unit.(string))
+		if err != nil {
+			return err
+		}
+		channelIDs = append(channelIDs, channel.ID)
+
+		now := time.Now()
+		_, err = membersColl.UpdateOne(
+			ctx,
+			bson.M{"group_id": channel.ID, "user_id": guardianObjID},
+			bson.M{"$setOnInsert": bson.M{
+				"role":       models.GroupRoleMember,
+				"joined_at":  now,
+				"created_at": now,
+				"updated_at": now,
+			}},
+			options.Update().SetUpsert(true),
+		)
+		// A duplicate key means a concurrent sync added the membership
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+		_, err = groupsColl.UpdateOne(ctx, bson.M{"_id": channel.ID}, bson.M{"$addToSet": bson.M{"members": guardianObjID.Hex()}})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Leave the parent channels of units none of their students are in
+	parentChannelIDs, err := groupsColl.Distinct(ctx, "_id", bson.M{
+		"audience": models.AudienceParents,
+		"_id":      bson.M{"$nin": channelIDs},
+	})
+	if err != nil || len(parentChannelIDs) == 0 {
+		return err
+	}
+	staleIDs, err := membersColl.Distinct(ctx, "group_id", bson.M{
+		"group_id": bson.M{"$in": parentChannelIDs},
+		"user_id":  guardianObjID,
+	})
+	if err != nil || len(staleIDs) == 0 {
+		return err
+	}
+	_, err = membersColl.DeleteMany(ctx, bson.M{
+		"group_id": bson.M{"$in": staleIDs},
+		"user_id":  guardianObjID,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = groupsColl.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": staleIDs}}, bson.M{"$pull": bson.M{"members": guardianObjID.Hex()}})
+	if err != nil {
+		return err
+	}
+
+	if hub != nil {
+		for _, staleID := range staleIDs {
+			hub.RemoveUserFromRoom(guardianObjID.Hex(), staleID.(primitive.ObjectID).Hex())
+		}
+	}
+	return nil
+}
+
+// syncGuardiansOf updates the parent channels of every guardian of a student,
+// e.g. after the student moved to another organizational unit
+func syncGuardiansOf(client *mongo.Client, dbName string, hub *websocket.Hub, studentObjID primitive.ObjectID) error {
+	guardianshipsColl := db.GetCollection(client, dbName, "guardianships")
+	guardianIDs, err := guardianshipsColl.Distinct(context.Background(), "guardian_id", bson.M{"student_id": studentObjID})
+	if err != nil {
+		return err
+	}
+
+	for _, guardianID := range guardianIDs {
+		if err := syncGuardianChannels(client, dbName, hub, guardianID.(primitive.ObjectID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parentChannel returns the parent channel of an organizational unit,
+// creating it if needed
+func parentChannel(client *mongo.Client, dbName, unit string) (*models.ChatGroup, error) {
+	groupsColl := db.GetCollection(client, dbName, "chat_groups")
+
+	now := time.Now()
+	var channel models.ChatGroup
+	err := groupsColl.FindOneAndUpdate(
+		context.Background(),
+		bson.M{"organizational_unit": unit, "audience": models.AudienceParents},
+		bson.M{"$setOnInsert": bson.M{
+			"name":        unit + " Parents",
+			"description": "Parents and guardians of " + unit + " students",
+			"group_type":  "organizational_unit",
+			"chat_type":   "group",
+			"created_at":  now,
+			"updated_at":  now,
+			"created_by":  "system",
+			"members":     []string{},
+		}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&channel)
+	if mongo.IsDuplicateKeyError(err) {
+		// Another request created it first
+		err = groupsColl.FindOne(context.Background(), bson.M{"organizational_unit": unit, "audience": models.AudienceParents}).Decode(&channel)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
@@ -0,0 +1,306 @@
+package controllers
+
+import (
+	"chatterbloom/backend/config"
+	"chatterbloom/backend/constants"
+	"chatterbloom/backend/db"
+	"chatterbloom/backend/models"
+	"chatterbloom/backend/websocket"
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GuardianshipController handles the links between guardians and students
+type GuardianshipController struct {
+	DB     *mongo.Client
+	Config *config.Config
+	Hub    *websocket.Hub
+}
+
+// GetGuardianships returns guardianships, optionally filtered by student,
+// guardian or status (admin only)
+func (gc *GuardianshipController) GetGuardianships(c echo.Context) error {
+	// Build query from the optional filters
+	query := bson.M{}
+	for _, param := range []string{"student_id", "guardian_id"} {
+		if value := c.QueryParam(param); value != "" {
+			objID, err := primitive.ObjectIDFromHex(value)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "Invalid "+param)
+			}
+			query[param] = objID
+		}
+	}
+	if status := c.QueryParam("status"); status != "" {
+		if !validGuardianshipStatus(status) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid status")
+		}
+		query["status"] = status
+	}
+
+	// Find guardianships
+	guardianshipsColl := db.GetCollection(gc.DB, gc.Config.DatabaseName, "guardianships")
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+	cursor, err := guardianshipsColl.Find(context.Background(), query, opts)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer cursor.Close(context.Background())
+
+	var guardianships []models.Guardianship
+	if err := cursor.All(context.Background(), &guardianships); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to decode guardianships")
+	}
+
+	response, err := gc.guardianshipResponses(guardianships)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load users")
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// CreateGuardianship links a guardian to a student (admin only)
+func (gc *GuardianshipController) CreateGuardianship(c echo.Context) error {
+	// Get user ID from token
+	userID := c.Get("user_id").(string)
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	// Bind request body
+	var req models.GuardianshipRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	guardianObjID, err := primitive.ObjectIDFromHex(req.GuardianID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid guardian ID")
+	}
+	studentObjID, err := primitive.ObjectIDFromHex(req.StudentID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid student ID")
+	}
+	if !containsString(models.GuardianRelationships, req.Relationship) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid relationship")
+	}
+	if req.Status == "" {
+		req.Status = models.GuardianshipPending
+	}
+	if !validGuardianshipStatus(req.Status) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid status")
+	}
+
+	// Check that both users exist and have the right roles
+	users, err := loadUsers(gc.DB, gc.Config.DatabaseName, nil, []primitive.ObjectID{guardianObjID, studentObjID})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	guardian, ok := users[guardianObjID]
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "Guardian not found")
+	}
+	if guardian.Role != constants.RoleParent {
+		return echo.NewHTTPError(http.StatusBadRequest, "Guardians must have the parent role")
+	}
+	student, ok := users[studentObjID]
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "Student not found")
+	}
+	if student.Role != constants.RoleStudent {
+		return echo.NewHTTPError(http.StatusBadRequest, "Guardians can only be linked to students")
+	}
+
+	// Create guardianship
+	now := time.Now()
+	guardianship := models.Guardianship{
+		ID:           primitive.NewObjectID(),
+		GuardianID:   guardianObjID,
+		StudentID:    studentObjID,
+		Relationship: req.Relationship,
+		Status:       req.Status,
+		CreatedBy:    userObjID,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if guardianship.Status == models.GuardianshipVerified {
+		guardianship.VerifiedBy = &userObjID
+		guardianship.VerifiedAt = &now
+	}
+
+	guardianshipsColl := db.GetCollection(gc.DB, gc.Config.DatabaseName, "guardianships")
+	if _, err := guardianshipsColl.InsertOne(context.Background(), guardianship); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return echo.NewHTTPError(http.StatusConflict, "Guardian is already linked to this student")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create guardianship")
+	}
+
+	gc.syncChannels(guardianObjID)
+
+	return c.JSON(http.StatusCreated, models.GuardianshipResponse{
+		Guardianship: guardianship,
+		Guardian:     userResponse(users, guardianObjID),
+		Student:      userResponse(users, studentObjID),
+	})
+}
+
+// UpdateGuardianship changes the relationship or verification status of a
+// guardianship (admin only)
+func (gc *GuardianshipController) UpdateGuardianship(c echo.Context) error {
+	// Get user ID from token
+	userID := c.Get("user_id").(string)
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	// Get guardianship ID from URL
+	guardianshipObjID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid guardianship ID")
+	}
+
+	// Bind request body
+	var req models.UpdateGuardianshipRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	// Prepare update
+	now := time.Now()
+	set := bson.M{"updated_at": now}
+	unset := bson.M{}
+	if req.Relationship != nil {
+		if !containsString(models.GuardianRelationships, *req.Relationship) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid relationship")
+		}
+		set["relationship"] = *req.Relationship
+	}
+	if req.Status != nil {
+		if !validGuardianshipStatus(*req.Status) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid status")
+		}
+		set["status"] = *req.Status
+		if *req.Status == models.GuardianshipVerified {
+			set["verified_by"] = userObjID
+			set["verified_at"] = now
+		} else {
+			unset["verified_by"] = ""
+			unset["verified_at"] = ""
+		}
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	// Update guardianship
+	guardianshipsColl := db.GetCollection(gc.DB, gc.Config.DatabaseName, "guardianships")
+	var guardianship models.Guardianship
+	err = guardianshipsColl.FindOneAndUpdate(
+		context.Background(),
+		bson.M{"_id": guardianshipObjID},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&guardianship)
+	if err == mongo.ErrNoDocuments {
+		return echo.NewHTTPError(http.StatusNotFound, "Guardianship not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update guardianship")
+	}
+
+	gc.syncChannels(guardianship.GuardianID)
+
+	response, err := gc.guardianshipResponses([]models.Guardianship{guardianship})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load users")
+	}
+
+	return c.JSON(http.StatusOK, response[0])
+}
+
+// DeleteGuardianship unlinks a guardian from a student (admin only)
+func (gc *GuardianshipController) DeleteGuardianship(c echo.Context) error {
+	// Get guardianship ID from URL
+	guardianshipObjID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid guardianship ID")
+	}
+
+	// Delete guardianship
+	guardianshipsColl := db.GetCollection(gc.DB, gc.Config.DatabaseName, "guardianships")
+	var guardianship models.Guardianship
+	err = guardianshipsColl.FindOneAndDelete(context.Background(), bson.M{"_id": guardianshipObjID}).Decode(&guardianship)
+	if err == mongo.ErrNoDocuments {
+		return echo.NewHTTPError(http.StatusNotFound, "Guardianship not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete guardianship")
+	}
+
+	gc.syncChannels(guardianship.GuardianID)
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Guardianship deleted successfully"})
+}
+
+// syncChannels updates a guardian's parent channels after a change to their
+// guardianships. The change itself is already saved, so failures are logged.
+func (gc *GuardianshipController) syncChannels(guardianObjID primitive.ObjectID) {
+	if err := syncGuardianChannels(gc.DB, gc.Config.DatabaseName, gc.Hub, guardianObjID); err != nil {
+		log.Printf("error: syncing parent channels of guardian %s: %v", guardianObjID.Hex(), err)
+	}
+}
+
+// guardianshipResponses adds both users to each guardianship, loading them in
+// one query
+func (gc *GuardianshipController) guardianshipResponses(guardianships []models.Guardianship) ([]models.GuardianshipResponse, error) {
+	userIDs := make([]primitive.ObjectID, 0, 2*len(guardianships))
+	for _, g := range guardianships {
+		userIDs = append(userIDs, g.GuardianID, g.StudentID)
+	}
+
+	users, err := loadUsers(gc.DB, gc.Config.DatabaseName, nil, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]models.GuardianshipResponse, 0, len(guardianships))
+	for _, g := range guardianships {
+		response = append(response, models.GuardianshipResponse{
+			Guardianship: g,
+			Guardian:     userResponse(users, g.GuardianID),
+			Student:      userResponse(users, g.StudentID),
+		})
+	}
+	return response, nil
+}
+
+// userResponse returns a loaded user's response, or nil if the user is gone
+func userResponse(users map[primitive.ObjectID]models.User, userObjID primitive.ObjectID) *models.UserResponse {
+	user, ok := users[userObjID]
+	if !ok {
+		return nil
+	}
+	response := user.ToResponse()
+	return &response
+}
+
+// validGuardianshipStatus reports whether a guardianship status is known
+func validGuardianshipStatus(status string) bool {
+	switch status {
+	case models.GuardianshipPending, models.GuardianshipVerified, models.GuardianshipRejected:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,646 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"chatterbloom/backend/constants"
+	"chatterbloom/backend/controllers"
+	"chatterbloom/backend/models"
+	"chatterbloom/backend/websocket"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// TestSyncGuardianChannels checks that a guardian joins the parent channels of
+// their verified students' units and leaves the others
+func TestSyncGuardianChannels(t *testing.T) {
+	ok := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1})
+
+	cases := []struct {
+		name string
+		// Replies to the sync, given the parent channel of the student's unit
+		// and one of another unit the guardian is in
+		replies func(student, channel, stale primitive.ObjectID) []bson.D
+		// Whether the guardian joins the channel and leaves the stale one
+		wantJoin, wantLeave bool
+	}{
+		{
+			name: "student in a new unit",
+			replies: func(student, channel, stale primitive.ObjectID) []bson.D {
+				return []bson.D{
+					values(student),
+					values("Grade 5"),
+					mtest.CreateSuccessResponse(bson.E{Key: "value", Value: parentChannelDoc(channel, "Grade 5")}),
+					ok,
+					ok,
+					values(stale, primitive.NewObjectID()),
+					values(stale),
+					ok,
+					ok,
+				}
+			},
+			wantJoin:  true,
+			wantLeave: true,
+		},
+		{
+			name: "no verified students",
+			replies: func(student, channel, stale primitive.ObjectID) []bson.D {
+				return []bson.D{values(), values(stale), values(stale), ok, ok}
+			},
+			wantLeave: true,
+		},
+		{
+			// A concurrent sync already added the membership
+			name: "already a member",
+			replies: func(student, channel, stale primitive.ObjectID) []bson.D {
+				return []bson.D{
+					values(student),
+					values("Grade 5"),
+					mtest.CreateSuccessResponse(bson.E{Key: "value", Value: parentChannelDoc(channel, "Grade 5")}),
+					mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "E11000 duplicate key error"}),
+					ok,
+					values(),
+				}
+			},
+			wantJoin: true,
+		},
+	}
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for _, c := range cases {
+		mt.Run(c.name, func(mt *mtest.T) {
+			guardian, student := primitive.NewObjectID(), primitive.NewObjectID()
+			channel, stale := primitive.NewObjectID(), primitive.NewObjectID()
+			mt.AddMockResponses(c.replies(student, channel, stale)...)
+
+			backplane := websocket.NewMemoryBackplane()
+			events := subscribe(mt, backplane)
+			hub := websocket.NewHub(backplane, nil)
+			if err := controllers.SyncGuardianChannels(mt.Client, "chatterbloom", hub, guardian); err != nil {
+				mt.Fatal(err)
+			}
+
+			students := commandsOn(mt, "distinct", "guardianships")
+			if len(students) != 1 {
+				mt.Fatalf("got %d guardianship lookups, want 1", len(students))
+			}
+			if status := students[0].Lookup("query", "status").StringValue(); status != models.GuardianshipVerified {
+				mt.Errorf("got students with status %s, want only verified ones", status)
+			}
+			if len(commandsOn(mt, "distinct", "users")) != 0 != c.wantJoin {
+				mt.Errorf("looked up units %d times", len(commandsOn(mt, "distinct", "users")))
+			}
+
+			checkJoined(mt, guardian, channel, "Grade 5", c.wantJoin)
+			checkLeft(mt, events, guardian, stale, c.wantLeave)
+		})
+	}
+}
+
+// TestCreateGuardianship checks that only parents can be linked to students,
+// and that a guardianship created verified records who verified it
+func TestCreateGuardianship(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("ENVIRONMENT", "production")
+
+	cases := []struct {
+		name, relationship, status string
+		// Roles of the users found, "" if one isn't
+		guardianRole, studentRole string
+		duplicate                 bool
+		want                      int
+		wantStatus                string
+	}{
+		{
+			name:         "verified",
+			relationship: "legal_guardian",
+			status:       models.GuardianshipVerified,
+			guardianRole: constants.RoleParent,
+			studentRole:  constants.RoleStudent,
+			want:         http.StatusCreated,
+			wantStatus:   models.GuardianshipVerified,
+		},
+		{
+			name:         "pending by default",
+			relationship: "parent",
+			guardianRole: constants.RoleParent,
+			studentRole:  constants.RoleStudent,
+			want:         http.StatusCreated,
+			wantStatus:   models.GuardianshipPending,
+		},
+		{name: "guardian not a parent", relationship: "parent", guardianRole: constants.RoleTeacher, studentRole: constants.RoleStudent, want: http.StatusBadRequest},
+		{name: "student not a student", relationship: "parent", guardianRole: constants.RoleParent, studentRole: constants.RoleParent, want: http.StatusBadRequest},
+		{name: "missing guardian", relationship: "parent", studentRole: constants.RoleStudent, want: http.StatusNotFound},
+		{name: "missing student", relationship: "parent", guardianRole: constants.RoleParent, want: http.StatusNotFound},
+		{name: "invalid relationship", relationship: "uncle", want: http.StatusBadRequest},
+		{name: "invalid status", relationship: "parent", status: "approved", want: http.StatusBadRequest},
+		{
+			name:         "already linked",
+			relationship: "parent",
+			guardianRole: constants.RoleParent,
+			studentRole:  constants.RoleStudent,
+			duplicate:    true,
+			want:         http.StatusConflict,
+		},
+	}
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for _, c := range cases {
+		mt.Run(c.name, func(mt *mtest.T) {
+			admin, guardian, student := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+			var users []bson.D
+			if c.guardianRole != "" {
+				users = append(users, userDoc(guardian, c.guardianRole))
+			}
+			if c.studentRole != "" {
+				users = append(users, userDoc(student, c.studentRole))
+			}
+			mt.AddMockResponses(cursor("users", users...))
+			if c.duplicate {
+				mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "E11000 duplicate key error"}))
+			} else {
+				// The sync finds nothing to join or leave
+				mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}), values(), values())
+			}
+
+			body := `{"guardian_id":"` + guardian.Hex() + `","student_id":"` + student.Hex() + `","relationship":"` + c.relationship + `","status":"` + c.status + `"}`
+			rec := sendAs(mt, newServer(mt), http.MethodPost, "/api/admin/guardianships", body, admin, constants.RoleAdmin)
+			if rec.Code != c.want {
+				mt.Fatalf("got status %d, want %d: %s", rec.Code, c.want, rec.Body)
+			}
+
+			inserts := commandsOn(mt, "insert", "guardianships")
+			if c.want != http.StatusCreated {
+				if len(inserts) != 0 && !c.duplicate {
+					mt.Error("stored a guardianship that was refused")
+				}
+				return
+			}
+
+			if len(inserts) != 1 {
+				mt.Fatalf("got %d inserts, want 1", len(inserts))
+			}
+			inserted := inserts[0].Lookup("documents", "0").Document()
+			checkFields(mt, "guardianship", inserted, bson.M{
+				"guardian_id":  guardian,
+				"student_id":   student,
+				"relationship": c.relationship,
+				"status":       c.wantStatus,
+				"created_by":   admin,
+			})
+			verifiedBy, err := inserted.LookupErr("verified_by")
+			if verified := c.wantStatus == models.GuardianshipVerified; verified != (err == nil) {
+				mt.Errorf("got verified_by %s for a %s guardianship", verifiedBy, c.wantStatus)
+			} else if verified && verifiedBy.ObjectID() != admin {
+				mt.Errorf("got verified_by %s, want the admin", verifiedBy)
+			}
+
+			// The guardian's parent channels are updated
+			if len(commandsOn(mt, "distinct", "guardianships")) != 1 {
+				mt.Error("didn't sync the guardian's parent channels")
+			}
+
+			var response models.GuardianshipResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				mt.Fatal(err)
+			}
+			if response.Guardian == nil || response.Guardian.ID != guardian.Hex() || response.Student == nil || response.Student.ID != student.Hex() {
+				mt.Errorf("got response %s", rec.Body)
+			}
+		})
+	}
+}
+
+// TestUpdateGuardianship checks that verifying a guardianship records who
+// verified it and that any other status clears it
+func TestUpdateGuardianship(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("ENVIRONMENT", "production")
+
+	cases := []struct {
+		name, body string
+		found      bool
+		want       int
+		// Expected fields set and unset by the update
+		wantSet   bson.M
+		wantUnset []string
+	}{
+		{
+			name:    "verify",
+			body:    `{"status":"verified"}`,
+			found:   true,
+			want:    http.StatusOK,
+			wantSet: bson.M{"status": models.GuardianshipVerified, "verified_by": ":admin"},
+		},
+		{
+			name:      "reject",
+			body:      `{"status":"rejected"}`,
+			found:     true,
+			want:      http.StatusOK,
+			wantSet:   bson.M{"status": models.GuardianshipRejected},
+			wantUnset: []string{"verified_by", "verified_at"},
+		},
+		{
+			name:    "relationship",
+			body:    `{"relationship":"grandparent"}`,
+			found:   true,
+			want:    http.StatusOK,
+			wantSet: bson.M{"relationship": "grandparent"},
+		},
+		{name: "invalid status", body: `{"status":"approved"}`, want: http.StatusBadRequest},
+		{name: "invalid relationship", body: `{"relationship":"uncle"}`, want: http.StatusBadRequest},
+		{name: "not found", body: `{"status":"verified"}`, want: http.StatusNotFound},
+	}
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for _, c := range cases {
+		mt.Run(c.name, func(mt *mtest.T) {
+			admin, guardian, student := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+			guardianship := guardianshipDoc(guardian, student, models.GuardianshipVerified)
+			if c.found {
+				mt.AddMockResponses(
+					mtest.CreateSuccessResponse(bson.E{Key: "value", Value: guardianship}),
+					values(),
+					values(),
+					cursor("users", userDoc(guardian, constants.RoleParent), userDoc(student, constants.RoleStudent)),
+				)
+			} else {
+				mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}))
+			}
+
+			path := "/api/admin/guardianships/" + idOf(guardianship).Hex()
+			rec := sendAs(mt, newServer(mt), http.MethodPut, path, c.body, admin, constants.RoleAdmin)
+			if rec.Code != c.want {
+				mt.Fatalf("got status %d, want %d: %s", rec.Code, c.want, rec.Body)
+			}
+
+			updates := commandsOn(mt, "findAndModify", "guardianships")
+			if c.wantSet == nil {
+				if c.want == http.StatusBadRequest && len(updates) != 0 {
+					mt.Error("updated a guardianship with an invalid change")
+				}
+				return
+			}
+
+			update := updates[0].Lookup("update").Document()
+			if c.wantSet["verified_by"] == ":admin" {
+				c.wantSet["verified_by"] = admin
+			}
+			checkFields(mt, "$set", update.Lookup("$set").Document(), c.wantSet)
+			unset, err := update.LookupErr("$unset")
+			if len(c.wantUnset) == 0 && err == nil {
+				mt.Errorf("unset %s", unset)
+			}
+			for _, field := range c.wantUnset {
+				if _, err := unset.Document().LookupErr(field); err != nil {
+					mt.Errorf("kept %s", field)
+				}
+			}
+
+			if len(commandsOn(mt, "distinct", "guardianships")) != 1 {
+				mt.Error("didn't sync the guardian's parent channels")
+			}
+			var response models.GuardianshipResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				mt.Fatal(err)
+			}
+			if response.Guardian == nil || response.Student == nil {
+				mt.Errorf("got response without its users %s", rec.Body)
+			}
+		})
+	}
+}
+
+// TestDeleteGuardianship checks that a guardian unlinked from a student leaves
+// the parent channel of the student's unit
+func TestDeleteGuardianship(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("ENVIRONMENT", "production")
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for _, found := range []bool{true, false} {
+		name := "delete"
+		if !found {
+			name = "not found"
+		}
+		mt.Run(name, func(mt *mtest.T) {
+			admin, guardian, student := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+			channel := primitive.NewObjectID()
+			guardianship := guardianshipDoc(guardian, student, models.GuardianshipVerified)
+			if found {
+				ok := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1})
+				mt.AddMockResponses(
+					mtest.CreateSuccessResponse(bson.E{Key: "value", Value: guardianship}),
+					values(),
+					values(channel),
+					values(channel),
+					ok,
+					ok,
+				)
+			} else {
+				mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}))
+			}
+
+			e, events := newServerWithEvents(mt)
+			rec := sendAs(mt, e, http.MethodDelete, "/api/admin/guardianships/"+idOf(guardianship).Hex(), "", admin, constants.RoleAdmin)
+			want := http.StatusOK
+			if !found {
+				want = http.StatusNotFound
+			}
+			if rec.Code != want {
+				mt.Fatalf("got status %d, want %d: %s", rec.Code, want, rec.Body)
+			}
+
+			deletes := commandsOn(mt, "findAndModify", "guardianships")
+			if id := deletes[0].Lookup("query", "_id").ObjectID(); id != idOf(guardianship) {
+				mt.Errorf("deleted %s, want %s", id.Hex(), idOf(guardianship).Hex())
+			}
+			checkLeft(mt, events, guardian, channel, found)
+		})
+	}
+}
+
+// TestUpdateUserUnit checks that moving a student to another unit moves their
+// guardians to the parent channel of the new unit
+func TestUpdateUserUnit(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("ENVIRONMENT", "production")
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("new unit", func(mt *mtest.T) {
+		admin, student, guardian := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+		unitGroup, channel, stale := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+		ok := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1})
+		mt.AddMockResponses(
+			cursor("users", unitUserDoc(student, constants.RoleStudent, "Grade 4")),
+			cursor("chat_groups", bson.D{
+				{Key: "_id", Value: unitGroup},
+				{Key: "name", Value: "Grade 5"},
+				{Key: "created_by", Value: "system"},
+			}),
+			ok,
+			ok,
+			ok,
+
+			// The student's guardians and their sync
+			values(guardian),
+			values(student),
+			values("Grade 5"),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: parentChannelDoc(channel, "Grade 5")}),
+			ok,
+			ok,
+			values(stale),
+			values(stale),
+			ok,
+			ok,
+
+			cursor("users", unitUserDoc(student, constants.RoleStudent, "Grade 5")),
+		)
+
+		e, events := newServerWithEvents(mt)
+		rec := sendAs(mt, e, http.MethodPut, "/api/admin/users/"+student.Hex(), `{"organizational_unit":"Grade 5"}`, admin, constants.RoleAdmin)
+		if rec.Code != http.StatusOK {
+			mt.Fatalf("got status %d: %s", rec.Code, rec.Body)
+		}
+
+		guardians := commandsOn(mt, "distinct", "guardianships")
+		if len(guardians) != 2 || guardians[0].Lookup("query", "student_id").ObjectID() != student {
+			mt.Fatalf("didn't sync the student's guardians")
+		}
+		checkJoined(mt, guardian, channel, "Grade 5", true)
+		checkLeft(mt, events, guardian, stale, true)
+	})
+}
+
+// TestDeleteUser checks that a deleted user is removed from their groups and
+// guardianships, and that their guardians leave the parent channels they were
+// in for them
+func TestDeleteUser(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("ENVIRONMENT", "production")
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("student", func(mt *mtest.T) {
+		admin, student, guardian, channel := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+		ok := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1})
+		mt.AddMockResponses(
+			cursor("users", unitUserDoc(student, constants.RoleStudent, "Grade 5")),
+			ok,
+			ok,
+			ok,
+			values(guardian),
+			ok,
+
+			// The guardian has no other verified students
+			values(),
+			values(channel),
+			values(channel),
+			ok,
+			ok,
+		)
+
+		e, events := newServerWithEvents(mt)
+		rec := sendAs(mt, e, http.MethodDelete, "/api/admin/users/"+student.Hex(), "", admin, constants.RoleAdmin)
+		if rec.Code != http.StatusOK {
+			mt.Fatalf("got status %d: %s", rec.Code, rec.Body)
+		}
+
+		if deletes := commandsOn(mt, "delete", "users"); len(deletes) != 1 || deletes[0].Lookup("deletes", "0", "q", "_id").ObjectID() != student {
+			mt.Error("didn't delete the user")
+		}
+		memberships := commandsOn(mt, "delete", "group_members")
+		if len(memberships) == 0 || memberships[0].Lookup("deletes", "0", "q", "user_id").ObjectID() != student {
+			mt.Error("didn't delete the user's group memberships")
+		}
+
+		// The user is pulled from every member list they are in
+		pulls := commandsOn(mt, "update", "chat_groups")
+		if len(pulls) == 0 {
+			mt.Fatal("didn't remove the user from member lists")
+		}
+		pull := pulls[0].Lookup("updates", "0").Document()
+		if member, _ := pull.Lookup("q", "members").StringValueOK(); member != student.Hex() {
+			mt.Errorf("removed the user from groups with member %q", member)
+		}
+		if member, _ := pull.Lookup("u", "$pull", "members").StringValueOK(); member != student.Hex() {
+			mt.Errorf("got update %s, want the user pulled from members", pull.Lookup("u"))
+		}
+		if multi, _ := pull.Lookup("multi").BooleanOK(); !multi {
+			mt.Error("removed the user from one group only")
+		}
+
+		// Guardianships on either side go
+		unlinks := commandsOn(mt, "delete", "guardianships")
+		if len(unlinks) != 1 {
+			mt.Fatalf("got %d guardianship deletes, want 1", len(unlinks))
+		}
+		sides, _ := unlinks[0].Lookup("deletes", "0", "q", "$or").Array().Values()
+		if len(sides) != 2 || sides[0].Document().Lookup("guardian_id").ObjectID() != student || sides[1].Document().Lookup("student_id").ObjectID() != student {
+			mt.Errorf("deleted guardianships %s", unlinks[0].Lookup("deletes", "0", "q"))
+		}
+
+		checkLeft(mt, events, guardian, channel, true)
+	})
+
+	mt.Run("not found", func(mt *mtest.T) {
+		mt.AddMockResponses(cursor("users"))
+		rec := sendAs(mt, newServer(mt), http.MethodDelete, "/api/admin/users/"+primitive.NewObjectID().Hex(), "", primitive.NewObjectID(), constants.RoleAdmin)
+		if rec.Code != http.StatusNotFound {
+			mt.Fatalf("got status %d, want %d", rec.Code, http.StatusNotFound)
+		}
+		for _, coll := range []string{"users", "group_members", "guardianships"} {
+			if len(commandsOn(mt, "delete", coll)) != 0 {
+				mt.Errorf("deleted from %s", coll)
+			}
+		}
+	})
+}
+
+// checkJoined checks whether a guardian joined the parent channel of a unit
+func checkJoined(mt *mtest.T, guardian, channel primitive.ObjectID, unit string, want bool) {
+	channels := commandsOn(mt, "findAndModify", "chat_groups")
+	if !want {
+		if len(channels) != 0 {
+			mt.Errorf("joined the parent channel of %s", channels[0].Lookup("query", "organizational_unit"))
+		}
+		return
+	}
+	if len(channels) != 1 {
+		mt.Fatalf("got %d parent channel lookups, want 1", len(channels))
+	}
+	checkFields(mt, "parent channel", channels[0].Lookup("query").Document(), bson.M{
+		"organizational_unit": unit,
+		"audience":            models.AudienceParents,
+	})
+	if upsert, _ := channels[0].Lookup("upsert").BooleanOK(); !upsert {
+		mt.Error("didn't create a missing parent channel")
+	}
+
+	var membership, members bson.Raw
+	for _, update := range commandsOn(mt, "update", "group_members") {
+		membership = update.Lookup("updates", "0").Document()
+	}
+	for _, update := range commandsOn(mt, "update", "chat_groups") {
+		if _, err := update.LookupErr("updates", "0", "u", "$addToSet"); err == nil {
+			members = update.Lookup("updates", "0").Document()
+		}
+	}
+	if membership == nil || members == nil {
+		mt.Fatal("didn't add the guardian to the channel")
+	}
+	checkFields(mt, "membership", membership.Lookup("q").Document(), bson.M{"group_id": channel, "user_id": guardian})
+	if upsert, _ := membership.Lookup("upsert").BooleanOK(); !upsert {
+		mt.Error("didn't upsert the membership")
+	}
+	if id := members.Lookup("q", "_id").ObjectID(); id != channel {
+		mt.Errorf("added the guardian to the members of %s, want %s", id.Hex(), channel.Hex())
+	}
+	if member := members.Lookup("u", "$addToSet", "members").StringValue(); member != guardian.Hex() {
+		mt.Errorf("added member %s, want the guardian", member)
+	}
+
+	// The channel joined isn't left
+	var others []bson.Raw
+	for _, distinct := range commandsOn(mt, "distinct", "chat_groups") {
+		others = append(others, distinct)
+	}
+	if len(others) != 1 {
+		mt.Fatalf("got %d parent channel lists, want 1", len(others))
+	}
+	kept, _ := others[0].Lookup("query", "_id", "$nin").Array().Values()
+	if len(kept) != 1 || kept[0].ObjectID() != channel {
+		mt.Errorf("left every parent channel but %v, want all but %s", kept, channel.Hex())
+	}
+}
+
+// checkLeft checks whether a guardian left a parent channel, and was
+// unsubscribed from it
+func checkLeft(mt *mtest.T, events <-chan websocket.RoomEvent, guardian, channel primitive.ObjectID, want bool) {
+	deletes := commandsOn(mt, "delete", "group_members")
+	var left bson.Raw
+	for _, d := range deletes {
+		if _, err := d.LookupErr("deletes", "0", "q", "group_id"); err == nil {
+			left = d.Lookup("deletes", "0", "q").Document()
+		}
+	}
+	if !want {
+		if left != nil {
+			mt.Errorf("left parent channels %s", left)
+		}
+		select {
+		case event := <-events:
+			mt.Errorf("got event %+v", event)
+		default:
+		}
+		return
+	}
+	if left == nil {
+		mt.Fatal("didn't leave the parent channel")
+	}
+	channels, _ := left.Lookup("group_id", "$in").Array().Values()
+	if len(channels) != 1 || channels[0].ObjectID() != channel || left.Lookup("user_id").ObjectID() != guardian {
+		mt.Errorf("left %s, want the guardian out of %s", left, channel.Hex())
+	}
+
+	var pull bson.Raw
+	for _, update := range commandsOn(mt, "update", "chat_groups") {
+		if _, err := update.LookupErr("updates", "0", "u", "$pull"); err == nil {
+			pull = update.Lookup("updates", "0").Document()
+		}
+	}
+	if pull == nil {
+		mt.Fatal("didn't remove the guardian from the channel's members")
+	}
+	if member := pull.Lookup("u", "$pull", "members").StringValue(); member != guardian.Hex() {
+		mt.Errorf("pulled member %s, want the guardian", member)
+	}
+
+	// Their open connections leave the room
+	event := nextRoomEvent(mt, events)
+	if event.RoomID != channel.Hex() || event.EvictUserID != guardian.Hex() {
+		mt.Errorf("got event %+v, want the guardian evicted from %s", event, channel.Hex())
+	}
+}
+
+// commandsOn returns the commands of a kind sent for a collection
+func commandsOn(mt *mtest.T, name, coll string) []bson.Raw {
+	var commands []bson.Raw
+	for _, started := range mt.GetAllStartedEvents() {
+		if started.CommandName == name && started.Command.Lookup(name).StringValue() == coll {
+			commands = append(commands, started.Command)
+		}
+	}
+	return commands
+}
+
+// values is a mock reply to a distinct
+func values(values ...interface{}) bson.D {
+	return mtest.CreateSuccessResponse(bson.E{Key: "values", Value: append(bson.A{}, values...)})
+}
+
+func parentChannelDoc(channelID primitive.ObjectID, unit string) bson.D {
+	return bson.D{
+		{Key: "_id", Value: channelID},
+		{Key: "name", Value: unit + " Parents"},
+		{Key: "organizational_unit", Value: unit},
+		{Key: "audience", Value: models.AudienceParents},
+		{Key: "created_by", Value: "system"},
+	}
+}
+
+func guardianshipDoc(guardianID, studentID primitive.ObjectID, status string) bson.D {
+	return bson.D{
+		{Key: "_id", Value: primitive.NewObjectID()},
+		{Key: "guardian_id", Value: guardianID},
+		{Key: "student_id", Value: studentID},
+		{Key: "relationship", Value: "parent"},
+		{Key: "status", Value: status},
+		{Key: "created_by", Value: primitive.NewObjectID()},
+		{Key: "created_at", Value: time.Now()},
+	}
+}
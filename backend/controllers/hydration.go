@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"chatterbloom/backend/db"
+	"chatterbloom/backend/models"
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxUserCacheEntries bounds the memory used by a UserCache
+const maxUserCacheEntries = 10000
+
+// UserCache keeps recently loaded users for a short time, so senders of busy
+// groups aren't fetched again on every request. Entries are not invalidated
+// when a user changes, which is why the TTL should stay short. A nil
+// *UserCache caches nothing.
+type UserCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[primitive.ObjectID]userCacheEntry
+}
+
+// userCacheEntry is a cached user and when it stops being valid
+type userCacheEntry struct {
+	user      models.User
+	expiresAt time.Time
+}
+
+// NewUserCache creates a cache that keeps users for ttl. It returns nil,
+// which disables caching, if ttl is not positive.
+func NewUserCache(ttl time.Duration) *UserCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &UserCache{
+		ttl:     ttl,
+		entries: make(map[primitive.ObjectID]userCacheEntry),
+	}
+}
+
+// get returns a cached user if it hasn't expired
+func (uc *UserCache) get(id primitive.ObjectID) (models.User, bool) {
+	if uc == nil {
+		return models.User{}, false
+	}
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	entry, ok := uc.entries[id]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return models.User{}, false
+	}
+	return entry.user, true
+}
+
+// put caches users, making room by dropping expired entries (or, failing
+// that, everything) once the cache is full
+func (uc *UserCache) put(users []models.User) {
+	if uc == nil || len(users) == 0 {
+		return
+	}
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	now := time.Now()
+	if len(uc.entries)+len(users) > maxUserCacheEntries {
+		for id, entry := range uc.entries {
+			if now.After(entry.expiresAt) {
+				delete(uc.entries, id)
+			}
+		}
+		if len(uc.entries)+len(users) > maxUserCacheEntries {
+			uc.entries = make(map[primitive.ObjectID]userCacheEntry)
+		}
+	}
+
+	expiresAt := now.Add(uc.ttl)
+	for _, user := range users {
+		uc.entries[user.ID] = userCacheEntry{user: user, expiresAt: expiresAt}
+	}
+}
+
+// loadUsers returns the users with the given IDs, keyed by ID, using one
+// query for all users that aren't cached. Unknown IDs are left out.
+func loadUsers(client *mongo.Client, dbName string, cache *UserCache, userIDs []primitive.ObjectID) (map[primitive.ObjectID]models.User, error) {
+	users := make(map[primitive.ObjectID]models.User, len(userIDs))
+
+	// Take what we can from the cache
+	var missing []primitive.ObjectID
+	requested := make(map[primitive.ObjectID]bool, len(userIDs))
+	for _, id := range userIDs {
+		if requested[id] {
+			continue
+		}
+		requested[id] = true
+
+		if user, ok := cache.get(id); ok {
+			users[id] = user
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) == 0 {
+		return users, nil
+	}
+
+	// Fetch the rest in one query
+	usersColl := db.GetCollection(client, dbName, "users")
+	cursor, err := usersColl.Find(context.Background(), bson.M{"_id": bson.M{"$in": missing}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var fetched []models.User
+	if err := cursor.All(context.Background(), &fetched); err != nil {
+		return nil, err
+	}
+	cache.put(fetched)
+
+	for _, user := range fetched {
+		users[user.ID] = user
+	}
+	return users, nil
+}
+
+// countMembers returns the member count of each group in one aggregation.
+// Groups without members are left out.
+func countMembers(client *mongo.Client, dbName string, groupIDs []primitive.ObjectID) (map[primitive.ObjectID]int, error) {
+	counts := make(map[primitive.ObjectID]int, len(groupIDs))
+	if len(groupIDs) == 0 {
+		return counts, nil
+	}
+
+	membersColl := db.GetCollection(client, dbName, "group_members")
+	cursor, err := membersColl.Aggregate(context.Background(), mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"group_id": bson.M{"$in": groupIDs}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$group_id", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var results []struct {
+		GroupID primitive.ObjectID `bson:"_id"`
+		Count   int                `bson:"count"`
+	}
+	if err := cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		counts[result.GroupID] = result.Count
+	}
+	return counts, nil
+}
+
+// messagesWithSenders converts messages to responses with their senders
+// attached, loading all senders at once
+func (mc *MessageController) messagesWithSenders(messages []models.Message) []models.MessageResponse {
+	senderIDs := make([]primitive.ObjectID, len(messages))
+	for i := range messages {
+		senderIDs[i] = messages[i].SenderID
+	}
+
+	// Messages are still returned, without senders, if the lookup fails
+	senders, err := loadUsers(mc.DB, mc.Config.DatabaseName, mc.Users, senderIDs)
+	if err != nil {
+		senders = map[primitive.ObjectID]models.User{}
+	}
+
+	responses := make([]models.MessageResponse, 0, len(messages))
+	for i := range messages {
+		messageResponse := messages[i].ToResponse()
+		if sender, ok := senders[messages[i].SenderID]; ok {
+			senderResponse := sender.ToResponse()
+			messageResponse.Sender = &senderResponse
+		}
+		responses = append(responses, messageResponse)
+	}
+	return responses
+}
+
+// groupsWithMemberCounts converts groups to responses with their member
+// counts, counting all groups at once
+func (gc *GroupController) groupsWithMemberCounts(groups []models.ChatGroup) ([]models.ChatGroupResponse, error) {
+	groupIDs := make([]primitive.ObjectID, len(groups))
+	for i := range groups {
+		groupIDs[i] = groups[i].ID
+	}
+
+	counts, err := countMembers(gc.DB, gc.Config.DatabaseName, groupIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]models.ChatGroupResponse, 0, len(groups))
+	for i := range groups {
+		responses = append(responses, groups[i].ToResponse(counts[groups[i].ID]))
+	}
+	return responses, nil
+}
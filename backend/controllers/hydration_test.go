@@ -0,0 +1,181 @@
+package controllers_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"chatterbloom/backend/constants"
+	"chatterbloom/backend/models"
+
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// pageSizes are the page sizes the query count must not depend on
+var pageSizes = []int{10, 50, 200}
+
+// listEndpoint is a listing whose query count is checked
+type listEndpoint struct {
+	path       func(ids testIDs) string
+	schoolRole string
+
+	// Replies to every query the endpoint makes for a page of size n, in order
+	replies func(ids testIDs, n int) []bson.D
+}
+
+var listEndpoints = map[string]listEndpoint{
+	"GetMessages": {
+		path: func(ids testIDs) string {
+			return "/api/groups/" + ids.group.Hex() + "/messages"
+		},
+		schoolRole: constants.RoleStudent,
+		replies: func(ids testIDs, n int) []bson.D {
+			// Every message is from a different sender
+			messages := make([]bson.D, n)
+			senders := make([]bson.D, n)
+			for i := range messages {
+				senderID := primitive.NewObjectID()
+				messages[i] = bson.D{
+					{Key: "_id", Value: primitive.NewObjectID()},
+					{Key: "group_id", Value: ids.group},
+					{Key: "sender_id", Value: senderID},
+					{Key: "type", Value: "regular"},
+					{Key: "content", Value: fmt.Sprintf("Message %d", i)},
+					{Key: "created_at", Value: time.Now().Add(-time.Duration(i) * time.Minute)},
+				}
+				senders[i] = userDoc(senderID, constants.RoleStudent)
+			}
+			return []bson.D{
+				cursor("group_members", memberDoc(ids.group, ids.caller, models.GroupRoleMember)),
+				cursor("messages", messages...),
+				cursor("users", senders...),
+			}
+		},
+	},
+	"GetGroups": {
+		path: func(ids testIDs) string {
+			return "/api/groups"
+		},
+		schoolRole: constants.RoleTeacher,
+		replies: func(ids testIDs, n int) []bson.D {
+			// Half the memberships are direct conversations, each with a
+			// different user
+			memberships := make([]bson.D, n)
+			groups := make([]bson.D, n)
+			var counts, others []bson.D
+			for i := range groups {
+				groupID := primitive.NewObjectID()
+				memberships[i] = memberDoc(groupID, ids.caller, models.GroupRoleMember)
+				if i%2 == 0 {
+					groups[i] = bson.D{
+						{Key: "_id", Value: groupID},
+						{Key: "name", Value: fmt.Sprintf("Group %d", i)},
+						{Key: "chat_type", Value: "group"},
+					}
+					counts = append(counts, bson.D{{Key: "_id", Value: groupID}, {Key: "count", Value: 25}})
+					continue
+				}
+				otherID := primitive.NewObjectID()
+				groups[i] = bson.D{
+					{Key: "_id", Value: groupID},
+					{Key: "chat_type", Value: "individual"},
+					{Key: "members", Value: bson.A{ids.caller.Hex(), otherID.Hex()}},
+				}
+				others = append(others, userDoc(otherID, constants.RoleParent))
+			}
+			return []bson.D{
+				cursor("group_members", memberships...),
+				cursor("chat_groups", groups...),
+				cursor("group_members", counts...),
+				cursor("users", others...),
+			}
+		},
+	},
+	"GetAllGroups": {
+		path: func(ids testIDs) string {
+			return "/api/admin/groups/all"
+		},
+		schoolRole: constants.RoleAdmin,
+		replies: func(ids testIDs, n int) []bson.D {
+			groups := make([]bson.D, n)
+			counts := make([]bson.D, n)
+			for i := range groups {
+				groupID := primitive.NewObjectID()
+				groups[i] = bson.D{
+					{Key: "_id", Value: groupID},
+					{Key: "name", Value: fmt.Sprintf("Group %d", i)},
+					{Key: "chat_type", Value: "group"},
+				}
+				counts[i] = bson.D{{Key: "_id", Value: groupID}, {Key: "count", Value: 25}}
+			}
+			return []bson.D{cursor("chat_groups", groups...), cursor("group_members", counts...)}
+		},
+	},
+}
+
+// TestListQueryCount checks that listing endpoints make the same number of
+// queries whatever the size of the page, so senders and member counts are
+// loaded in batches rather than per item
+func TestListQueryCount(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("ENVIRONMENT", "production")
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for name, endpoint := range listEndpoints {
+		queries := make(map[int]int, len(pageSizes))
+		for _, size := range pageSizes {
+			mt.Run(fmt.Sprintf("%s/%d", name, size), func(mt *mtest.T) {
+				ids := testIDs{group: primitive.NewObjectID(), caller: primitive.NewObjectID()}
+				replies := endpoint.replies(ids, size)
+				mt.AddMockResponses(replies...)
+
+				req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s?limit=%d", endpoint.path(ids), size), nil)
+				req.Header.Set("Authorization", bearer(mt, ids.caller, endpoint.schoolRole))
+				rec := httptest.NewRecorder()
+				newServer(mt).ServeHTTP(rec, req)
+
+				if rec.Code != http.StatusOK {
+					mt.Fatalf("got status %d: %s", rec.Code, rec.Body)
+				}
+				queries[size] = len(mt.GetAllStartedEvents())
+				if queries[size] != len(replies) {
+					mt.Errorf("made %d queries, want %d", queries[size], len(replies))
+				}
+			})
+		}
+
+		for _, size := range pageSizes[1:] {
+			if queries[size] != queries[pageSizes[0]] {
+				t.Errorf("%s made %d queries for a page of %d but %d for a page of %d",
+					name, queries[pageSizes[0]], pageSizes[0], queries[size], size)
+			}
+		}
+	}
+}
+
+func userDoc(userID primitive.ObjectID, role string) bson.D {
+	return bson.D{
+		{Key: "_id", Value: userID},
+		{Key: "email", Value: userID.Hex() + "@school.example"},
+		{Key: "full_name", Value: "User " + userID.Hex()[18:]},
+		{Key: "role", Value: role},
+	}
+}
+
+// bearer returns an Authorization header for a user with a school role
+func bearer(mt *mtest.T, userID primitive.ObjectID, schoolRole string) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": userID.Hex(),
+		"role":    schoolRole,
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(testJWTSecret))
+	if err != nil {
+		mt.Fatal(err)
+	}
+	return "Bearer " + token
+}
@@ -5,11 +5,15 @@ import (
 	"chatterbloom/backend/constants"
 	"chatterbloom/backend/db"
 	"chatterbloom/backend/models"
+	"chatterbloom/backend/policy"
+	"chatterbloom/backend/protocol"
+	"chatterbloom/backend/readstate"
 	"chatterbloom/backend/websocket"
 	"context"
-	"encoding/json"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -21,13 +25,41 @@ import (
 
 // MessageController handles message-related requests
 type MessageController struct {
-	DB     *mongo.Client
-	Config *config.Config
-	Hub    *websocket.Hub
+	DB       *mongo.Client
+	Config   *config.Config
+	Hub      *websocket.Hub
+	Users    *UserCache
+	Contacts *policy.Policy
+	Reads    readstate.Store
 }
 
-// GetMessages returns messages for a specific group
+// NewMessageController creates a MessageController that caches senders and
+// keeps read positions in the database. The routes and the scheduler share
+// one, so they share its cache.
+func NewMessageController(client *mongo.Client, cfg *config.Config, hub *websocket.Hub, contacts *policy.Policy) *MessageController {
+	return &MessageController{
+		DB:       client,
+		Config:   cfg,
+		Hub:      hub,
+		Users:    NewUserCache(cfg.UserCacheTTL),
+		Contacts: contacts,
+		Reads:    readstate.NewMongoStore(client, cfg.DatabaseName),
+	}
+}
+
+// GetMessages returns messages for a specific group, newest first. Pages are
+// chosen with the before and after cursors of a previous page, or around a
+// message ID to open the conversation at that message. Clients that still
+// send offset get the old unpaged array.
 func (mc *MessageController) GetMessages(c echo.Context) error {
+	// Get user ID and role from token
+	userID := c.Get("user_id").(string)
+	userRole := c.Get("user_role").(string)
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
 	// Get group ID from URL
 	groupID := c.Param("groupId")
 	groupObjID, err := primitive.ObjectIDFromHex(groupID)
@@ -35,16 +67,137 @@ func (mc *MessageController) GetMessages(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid group ID")
 	}
 
-	// Get limit and offset from query parameters
-	limit := 50 // Default limit
-	offset := 0 // Default offset
+	// Only group members (or admins and principals) can read a group
+	if userRole != constants.RoleAdmin && userRole != constants.RolePrincipal {
+		membership, err := findMembership(mc.DB, mc.Config.DatabaseName, groupObjID, userObjID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		if membership == nil {
+			return echo.NewHTTPError(http.StatusForbidden, "You are not a member of this group")
+		}
+	}
 
+	// Get limit from query parameters
+	limit := 50 // Default limit
 	if limitParam := c.QueryParam("limit"); limitParam != "" {
 		if parsedLimit, err := strconv.Atoi(limitParam); err == nil && parsedLimit > 0 {
 			limit = parsedLimit
 		}
 	}
+	if limit > maxMessagePageSize {
+		limit = maxMessagePageSize
+	}
+
+	before := c.QueryParam("before")
+	after := c.QueryParam("after")
+	around := c.QueryParam("around")
+	if before == "" && after == "" && around == "" && c.QueryParam("offset") != "" {
+		return mc.getMessagesByOffset(c, groupObjID, limit)
+	}
+
+	// Thread replies are fetched with their thread
+	filter := bson.M{
+		"group_id":       groupObjID,
+		"thread_root_id": bson.M{"$exists": false},
+	}
+
+	var (
+		messages []models.Message
+		page     models.MessagePage
+	)
+	switch {
+	case around != "":
+		messages, page, err = mc.messagesAround(groupObjID, filter, around, limit)
+
+	case after != "":
+		key, keyErr := decodeCursor(after)
+		if keyErr != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid after cursor")
+		}
+		messages, page.HasNewer, err = mc.findMessagesFrom(filter, &key, false, limit)
+		page.HasOlder = true
+
+	default:
+		var key *messageKey
+		if before != "" {
+			decoded, keyErr := decodeCursor(before)
+			if keyErr != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "Invalid before cursor")
+			}
+			key = &decoded
+		}
+		messages, page.HasOlder, err = mc.findMessagesFrom(filter, key, true, limit)
+		page.HasNewer = key != nil
+	}
+	if err != nil {
+		if httpErr, ok := err.(*echo.HTTPError); ok {
+			return httpErr
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	// Prepare response with sender information
+	page.Messages = mc.messagesWithSenders(messages)
+	if len(messages) > 0 {
+		page.PrevCursor = encodeCursor(keyOf(&messages[0]))
+		page.NextCursor = encodeCursor(keyOf(&messages[len(messages)-1]))
+	}
+
+	return c.JSON(http.StatusOK, page)
+}
+
+// messagesAround returns a page centred on a message, newest first. Thread
+// replies are replaced by their root, since the page only holds top-level
+// messages.
+func (mc *MessageController) messagesAround(groupObjID primitive.ObjectID, filter bson.M, messageID string, limit int) ([]models.Message, models.MessagePage, error) {
+	var page models.MessagePage
+
+	messageObjID, err := primitive.ObjectIDFromHex(messageID)
+	if err != nil {
+		return nil, page, echo.NewHTTPError(http.StatusBadRequest, "Invalid around message ID")
+	}
+
+	target, err := mc.findMessage(messageObjID)
+	if err != nil {
+		return nil, page, err
+	}
+	if target.ThreadRootID != nil {
+		target, err = mc.findMessage(*target.ThreadRootID)
+		if err != nil {
+			return nil, page, err
+		}
+	}
+	if target.GroupID != groupObjID {
+		return nil, page, echo.NewHTTPError(http.StatusNotFound, "Message not found")
+	}
+
+	// Split the rest of the page between both sides of the target
+	key := keyOf(target)
+	olderLimit := (limit - 1) / 2
+	newer, hasNewer, err := mc.findMessagesFrom(filter, &key, false, limit-1-olderLimit)
+	if err != nil {
+		return nil, page, err
+	}
+	older, hasOlder, err := mc.findMessagesFrom(filter, &key, true, olderLimit)
+	if err != nil {
+		return nil, page, err
+	}
+
+	messages := make([]models.Message, 0, len(newer)+1+len(older))
+	messages = append(messages, newer...)
+	messages = append(messages, *target)
+	messages = append(messages, older...)
+
+	page.HasNewer = hasNewer
+	page.HasOlder = hasOlder
+	return messages, page, nil
+}
 
+// getMessagesByOffset returns messages for a group the way GetMessages did
+// before cursors, for clients that still page with offset
+func (mc *MessageController) getMessagesByOffset(c echo.Context, groupObjID primitive.ObjectID, limit int) error {
+	offset := 0 // Default offset
 	if offsetParam := c.QueryParam("offset"); offsetParam != "" {
 		if parsedOffset, err := strconv.Atoi(offsetParam); err == nil && parsedOffset >= 0 {
 			offset = parsedOffset
@@ -56,14 +209,17 @@ func (mc *MessageController) GetMessages(c echo.Context) error {
 
 	// Set options for pagination and sorting (newest messages first)
 	findOptions := options.Find().
-		SetSort(bson.M{"created_at": -1}).
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
 		SetSkip(int64(offset)).
 		SetLimit(int64(limit))
 
-	// Find messages for the group
+	// Find messages for the group; thread replies are fetched with their thread
 	cursor, err := messagesColl.Find(
 		context.Background(),
-		bson.M{"group_id": groupObjID},
+		bson.M{
+			"group_id":       groupObjID,
+			"thread_root_id": bson.M{"$exists": false},
+		},
 		findOptions,
 	)
 	if err != nil {
@@ -77,36 +233,15 @@ func (mc *MessageController) GetMessages(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to decode messages")
 	}
 
-	// Get user collection for sender information
-	usersColl := db.GetCollection(mc.DB, mc.Config.DatabaseName, "users")
-
 	// Prepare response with sender information
-	var response []models.MessageResponse
-	for _, msg := range messages {
-		messageResponse := msg.ToResponse()
-
-		// Get sender information
-		var sender models.User
-		err := usersColl.FindOne(
-			context.Background(),
-			bson.M{"_id": msg.SenderID},
-		).Decode(&sender)
-		
-		if err == nil {
-			senderResponse := sender.ToResponse()
-			messageResponse.Sender = &senderResponse
-		}
-
-		response = append(response, messageResponse)
-	}
-
-	return c.JSON(http.StatusOK, response)
+	return c.JSON(http.StatusOK, mc.messagesWithSenders(messages))
 }
 
-// SendMessage sends a new message to a group
+// SendMessage sends a new message to a group, or schedules it when send_at
+// is set
 func (mc *MessageController) SendMessage(c echo.Context) error {
 	// Get user ID from token
-	userID := c.Get("user").(string)
+	userID := c.Get("user_id").(string)
 	userObjID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
@@ -118,232 +253,742 @@ func (mc *MessageController) SendMessage(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
 	}
 
+	if req.SendAt != nil || req.Recurrence != "" {
+		schedule, err := mc.scheduleMessage(userObjID, req)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusAccepted, schedule)
+	}
+
+	messageResponse, err := mc.createMessage(userObjID, req, nil)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, messageResponse)
+}
+
+// createMessage validates, stores and broadcasts a regular message. It backs
+// the REST endpoints and send_message frames from WebSocket clients.
+// Attachments must already be in the blob store.
+func (mc *MessageController) createMessage(userObjID primitive.ObjectID, req models.MessageRequest, attachments []models.Attachment) (models.MessageResponse, error) {
+	if strings.TrimSpace(req.Content) == "" && len(attachments) == 0 {
+		return models.MessageResponse{}, echo.NewHTTPError(http.StatusBadRequest, "Message content is required")
+	}
+
 	groupObjID, err := primitive.ObjectIDFromHex(req.GroupID)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid group ID")
+		return models.MessageResponse{}, echo.NewHTTPError(http.StatusBadRequest, "Invalid group ID")
 	}
 
 	// Check if user is a member of the group
-	membersColl := db.GetCollection(mc.DB, mc.Config.DatabaseName, "group_members")
-	count, err := membersColl.CountDocuments(
-		context.Background(),
-		bson.M{
-			"group_id": groupObjID,
-			"user_id":  userObjID,
-		},
-	)
+	membership, err := findMembership(mc.DB, mc.Config.DatabaseName, groupObjID, userObjID)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		return models.MessageResponse{}, echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
-	if count == 0 {
-		return echo.NewHTTPError(http.StatusForbidden, "You are not a member of this group")
+	if membership == nil {
+		return models.MessageResponse{}, echo.NewHTTPError(http.StatusForbidden, "You are not a member of this group")
+	}
+
+	// Get the group
+	groupsColl := db.GetCollection(mc.DB, mc.Config.DatabaseName, "chat_groups")
+	var group models.ChatGroup
+	if err := groupsColl.FindOne(context.Background(), bson.M{"_id": groupObjID}).Decode(&group); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return models.MessageResponse{}, echo.NewHTTPError(http.StatusNotFound, "Group not found")
+		}
+		return models.MessageResponse{}, echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	// Messages in direct conversations follow the contact policy, which may
+	// have changed since the conversation started
+	if group.ChatType == "individual" {
+		if err := checkContact(mc.DB, mc.Config.DatabaseName, mc.Contacts, policy.ActionContact, userObjID, otherParticipant(&group, userObjID)); err != nil {
+			return models.MessageResponse{}, err
+		}
 	}
 
 	// Create new message
 	now := time.Now()
 	newMessage := models.Message{
-		ID:        primitive.NewObjectID(),
+		ID:        req.ID,
 		Content:   req.Content,
 		GroupID:   groupObjID,
 		SenderID:  userObjID,
+		Type:      "regular",
 		CreatedAt: now,
 		UpdatedAt: now,
-		ReadBy:    []primitive.ObjectID{userObjID}, // Mark as read by sender
+		ReadBy:    []primitive.ObjectID{},
+
+		Attachments: attachments,
+	}
+	if newMessage.ID.IsZero() {
+		newMessage.ID = primitive.NewObjectID()
+	}
+
+	// Attach replies to their thread
+	if req.ParentID != "" {
+		if err := mc.attachToThread(&newMessage, req.ParentID); err != nil {
+			return models.MessageResponse{}, err
+		}
+	}
+
+	// Check the group's posting policy
+	if err := mc.checkPostingPolicy(&group, membership, &newMessage); err != nil {
+		return models.MessageResponse{}, err
 	}
 
 	// Get messages collection
 	messagesColl := db.GetCollection(mc.DB, mc.Config.DatabaseName, "messages")
 
-	// Insert message into database
+	// Insert message into database; a message that wasn't sent doesn't
+	// count against slow mode
 	_, err = messagesColl.InsertOne(context.Background(), newMessage)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to send message")
+		if err := mc.cancelCooldown(&group, membership, newMessage.CreatedAt); err != nil {
+			log.Printf("error: cancelling slow mode cooldown of member %s: %v", membership.ID.Hex(), err)
+		}
+		return models.MessageResponse{}, echo.NewHTTPError(http.StatusInternalServerError, "Failed to send message")
 	}
 
-	// Get user information for response
-	usersColl := db.GetCollection(mc.DB, mc.Config.DatabaseName, "users")
-	var sender models.User
-	err = usersColl.FindOne(context.Background(), bson.M{"_id": userObjID}).Decode(&sender)
-	
-	// Prepare response
-	messageResponse := newMessage.ToResponse()
-	if err == nil {
-		senderResponse := sender.ToResponse()
-		messageResponse.Sender = &senderResponse
+	if newMessage.ThreadRootID != nil {
+		return mc.publishThreadReply(&newMessage)
 	}
 
+	// Prepare response with sender information
+	messageResponse := mc.messageWithSender(&newMessage)
+
 	// Broadcast message to WebSocket clients in the group
 	if mc.Hub != nil {
-		messageJSON, _ := json.Marshal(map[string]interface{}{
-			"type":    "new_message",
-			"message": messageResponse,
+		mc.Hub.SendToGroup(req.GroupID, protocol.TypeNewMessage, protocol.MessagePayload{Message: messageResponse})
+	}
+
+	return messageResponse, nil
+}
+
+// attachToThread validates the parent of a reply, which must be the root of
+// its thread, and points the reply at it
+func (mc *MessageController) attachToThread(reply *models.Message, parentID string) error {
+	parentObjID, err := primitive.ObjectIDFromHex(parentID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid parent message ID")
+	}
+
+	parent, err := mc.findMessage(parentObjID)
+	if err != nil {
+		return err
+	}
+	if parent.GroupID != reply.GroupID {
+		return echo.NewHTTPError(http.StatusBadRequest, "Parent message is in another group")
+	}
+	if parent.DeletedAt != nil {
+		return echo.NewHTTPError(http.StatusGone, "Parent message has been deleted")
+	}
+
+	// Threads are one level deep, so replies can't be replied to
+	if parent.ThreadRootID != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Replies can't be replied to, reply to the thread instead")
+	}
+	if parent.RepliesDisabled {
+		return echo.NewHTTPError(http.StatusForbidden, "Replies are disabled for this message")
+	}
+
+	reply.ParentID = &parent.ID
+	reply.ThreadRootID = &parent.ID
+	return nil
+}
+
+// publishThreadReply updates the thread summary on the root of a stored
+// reply and broadcasts the reply to the group
+func (mc *MessageController) publishThreadReply(reply *models.Message) (models.MessageResponse, error) {
+	messagesColl := db.GetCollection(mc.DB, mc.Config.DatabaseName, "messages")
+
+	var root models.Message
+	err := messagesColl.FindOneAndUpdate(
+		context.Background(),
+		bson.M{"_id": *reply.ThreadRootID},
+		bson.M{
+			"$inc": bson.M{"reply_count": 1},
+			"$max": bson.M{"last_reply_at": reply.CreatedAt},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&root)
+	if err != nil {
+		return models.MessageResponse{}, echo.NewHTTPError(http.StatusInternalServerError, "Failed to update thread")
+	}
+
+	messageResponse := mc.messageWithSender(reply)
+
+	// Broadcast reply to WebSocket clients in the group
+	if mc.Hub != nil {
+		mc.Hub.SendToGroup(reply.GroupID.Hex(), protocol.TypeThreadReply, protocol.ThreadReplyPayload{
+			Message:      messageResponse,
+			ThreadRootID: root.ID.Hex(),
+			ReplyCount:   root.ReplyCount,
+			LastReplyAt:  *root.LastReplyAt,
 		})
-		mc.Hub.BroadcastToRoom(req.GroupID, messageJSON)
 	}
 
-	return c.JSON(http.StatusCreated, messageResponse)
+	return messageResponse, nil
 }
 
-// MarkMessageAsRead marks a message as read by the current user
-func (mc *MessageController) MarkMessageAsRead(c echo.Context) error {
+// GetThread returns the root of a thread and a page of its replies, oldest first
+func (mc *MessageController) GetThread(c echo.Context) error {
 	// Get user ID from token
-	userID := c.Get("user").(string)
+	userID := c.Get("user_id").(string)
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
 
 	// Get message ID from URL
-	messageID := c.Param("id")
-	messageObjID, err := primitive.ObjectIDFromHex(messageID)
+	messageObjID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid message ID")
 	}
 
-	// Get messages collection
+	// Get limit and offset from query parameters
+	limit := 50 // Default limit
+	offset := 0 // Default offset
+
+	if limitParam := c.QueryParam("limit"); limitParam != "" {
+		if parsedLimit, err := strconv.Atoi(limitParam); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+
+	if offsetParam := c.QueryParam("offset"); offsetParam != "" {
+		if parsedOffset, err := strconv.Atoi(offsetParam); err == nil && parsedOffset >= 0 {
+			offset = parsedOffset
+		}
+	}
+
+	// Resolve the thread root, even when given the ID of a reply
+	root, err := mc.findMessage(messageObjID)
+	if err != nil {
+		return err
+	}
+	if root.ThreadRootID != nil {
+		root, err = mc.findMessage(*root.ThreadRootID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Only group members can read a thread
+	membership, err := findMembership(mc.DB, mc.Config.DatabaseName, root.GroupID, userObjID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if membership == nil {
+		return echo.NewHTTPError(http.StatusForbidden, "You are not a member of this group")
+	}
+
+	// Find replies
 	messagesColl := db.GetCollection(mc.DB, mc.Config.DatabaseName, "messages")
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit))
 
-	// Update message to add user to read_by array
-	_, err = messagesColl.UpdateOne(
+	cursor, err := messagesColl.Find(
 		context.Background(),
-		bson.M{"_id": messageObjID},
-		bson.M{"$addToSet": bson.M{"read_by": userID}},
+		bson.M{"thread_root_id": root.ID},
+		findOptions,
 	)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to mark message as read")
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
+	defer cursor.Close(context.Background())
 
-	return c.JSON(http.StatusOK, map[string]string{"message": "Message marked as read"})
+	var replies []models.Message
+	if err := cursor.All(context.Background(), &replies); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to decode messages")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"root":    mc.messageWithSender(root),
+		"replies": mc.messagesWithSenders(replies),
+	})
 }
 
-// GetUnreadCount returns the count of unread messages for the current user
-func (mc *MessageController) GetUnreadCount(c echo.Context) error {
+// UpdateMessage edits a message. Only the sender may edit, while they are
+// still a member of the group and within the configured edit window. The
+// previous version is kept in revisions.
+func (mc *MessageController) UpdateMessage(c echo.Context) error {
 	// Get user ID from token
-	userID := c.Get("user").(string)
+	userID := c.Get("user_id").(string)
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
 
-	// Get group ID from query parameter (optional)
-	groupID := c.QueryParam("groupId")
+	// Get message ID from URL
+	messageObjID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid message ID")
+	}
 
-	// Get messages collection
+	// Bind request body
+	var req models.UpdateMessageRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Message content is required")
+	}
+
+	message, err := mc.findMessage(messageObjID)
+	if err != nil {
+		return err
+	}
+
+	// Check edit permissions
+	if message.SenderID != userObjID {
+		return echo.NewHTTPError(http.StatusForbidden, "Only the sender can edit this message")
+	}
+	membership, err := findMembership(mc.DB, mc.Config.DatabaseName, message.GroupID, userObjID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if membership == nil {
+		return echo.NewHTTPError(http.StatusForbidden, "You are no longer a member of this group")
+	}
+	if message.DeletedAt != nil {
+		return echo.NewHTTPError(http.StatusGone, "Message has been deleted")
+	}
+	if time.Since(message.CreatedAt) > mc.Config.MessageEditWindow {
+		return echo.NewHTTPError(http.StatusForbidden, "The edit window for this message has passed")
+	}
+
+	// Titles and reply settings only apply to announcements
+	title := message.Title
+	repliesDisabled := message.RepliesDisabled
+	if message.Type == "announcement" {
+		if req.Title != "" {
+			title = req.Title
+		}
+		if req.AllowReplies != nil {
+			repliesDisabled = !*req.AllowReplies
+		}
+	}
+
+	now := time.Now()
+	revision := models.MessageRevision{
+		Content:   message.Content,
+		Title:     message.Title,
+		ChangedAt: now,
+		ChangedBy: userObjID,
+	}
+
+	// Only update if the message is still in the state we read, so concurrent
+	// edits can't drop a revision
 	messagesColl := db.GetCollection(mc.DB, mc.Config.DatabaseName, "messages")
+	result, err := messagesColl.UpdateOne(
+		context.Background(),
+		bson.M{
+			"_id":        messageObjID,
+			"updated_at": message.UpdatedAt,
+			"deleted_at": bson.M{"$exists": false},
+		},
+		bson.M{
+			"$set": bson.M{
+				"content":          req.Content,
+				"title":            title,
+				"replies_disabled": repliesDisabled,
+				"edited_at":        now,
+				"updated_at":       now,
+			},
+			"$push": bson.M{"revisions": revision},
+		},
+	)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update message")
+	}
+	if result.MatchedCount == 0 {
+		return echo.NewHTTPError(http.StatusConflict, "Message was changed concurrently, please retry")
+	}
 
-	// Prepare filter
-	filter := bson.M{"read_by": bson.M{"$ne": userID}}
-	
-	// Add group filter if provided
-	if groupID != "" {
-		groupObjID, err := primitive.ObjectIDFromHex(groupID)
-		if err == nil {
-			filter["group_id"] = groupObjID
+	message.Content = req.Content
+	message.Title = title
+	message.RepliesDisabled = repliesDisabled
+	message.EditedAt = &now
+	message.UpdatedAt = now
+
+	messageResponse := mc.messageWithSender(message)
+
+	// Broadcast the edit to WebSocket clients in the group
+	if mc.Hub != nil {
+		mc.Hub.SendToGroup(message.GroupID.Hex(), protocol.TypeMessageUpdated, protocol.MessagePayload{Message: messageResponse})
+	}
+
+	return c.JSON(http.StatusOK, messageResponse)
+}
+
+// DeleteMessage soft-deletes a message, leaving a tombstone. The sender,
+// group admins and moderators (including teachers in the group), and school
+// admins and principals may delete.
+func (mc *MessageController) DeleteMessage(c echo.Context) error {
+	// Get user ID and role from context
+	userID := c.Get("user_id").(string)
+	userRole := c.Get("user_role").(string)
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	// Get message ID from URL
+	messageObjID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid message ID")
+	}
+
+	message, err := mc.findMessage(messageObjID)
+	if err != nil {
+		return err
+	}
+	if message.DeletedAt != nil {
+		return echo.NewHTTPError(http.StatusGone, "Message has already been deleted")
+	}
+
+	// Check delete permissions
+	if message.SenderID != userObjID {
+		if _, err := authorizeGroup(mc.DB, mc.Config.DatabaseName, message.GroupID, userObjID, userRole, capModerateMessages); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	revision := models.MessageRevision{
+		Content:   message.Content,
+		Title:     message.Title,
+		ChangedAt: now,
+		ChangedBy: userObjID,
+	}
+
+	messagesColl := db.GetCollection(mc.DB, mc.Config.DatabaseName, "messages")
+	result, err := messagesColl.UpdateOne(
+		context.Background(),
+		bson.M{
+			"_id":        messageObjID,
+			"deleted_at": bson.M{"$exists": false},
+		},
+		bson.M{
+			"$set": bson.M{
+				"content":    "",
+				"title":      "",
+				"deleted_at": now,
+				"deleted_by": userObjID,
+				"updated_at": now,
+			},
+			"$push": bson.M{"revisions": revision},
+		},
+	)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete message")
+	}
+	if result.MatchedCount == 0 {
+		return echo.NewHTTPError(http.StatusGone, "Message has already been deleted")
+	}
+
+	message.DeletedAt = &now
+	message.DeletedBy = &userObjID
+	message.UpdatedAt = now
+
+	messageResponse := mc.messageWithSender(message)
+
+	// Broadcast the deletion to WebSocket clients in the group
+	if mc.Hub != nil {
+		mc.Hub.SendToGroup(message.GroupID.Hex(), protocol.TypeMessageDeleted, protocol.MessagePayload{Message: messageResponse})
+	}
+
+	return c.JSON(http.StatusOK, messageResponse)
+}
+
+// findMessage loads a message by ID
+func (mc *MessageController) findMessage(messageObjID primitive.ObjectID) (*models.Message, error) {
+	messagesColl := db.GetCollection(mc.DB, mc.Config.DatabaseName, "messages")
+
+	var message models.Message
+	err := messagesColl.FindOne(context.Background(), bson.M{"_id": messageObjID}).Decode(&message)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "Message not found")
+		}
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	return &message, nil
+}
+
+// messageWithSender converts a message to a response with sender information
+func (mc *MessageController) messageWithSender(message *models.Message) models.MessageResponse {
+	return mc.messagesWithSenders([]models.Message{*message})[0]
+}
+
+// MarkMessageAsRead marks a message as read by the current user
+func (mc *MessageController) MarkMessageAsRead(c echo.Context) error {
+	// Get user ID from token
+	userID := c.Get("user_id").(string)
+
+	if err := mc.markRead(userID, c.Param("id")); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Message marked as read"})
+}
+
+// markRead marks a group read up to a message. It backs both the REST
+// endpoint and mark_read frames from WebSocket clients.
+func (mc *MessageController) markRead(userID, messageID string) error {
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+	messageObjID, err := primitive.ObjectIDFromHex(messageID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid message ID")
+	}
+
+	message, err := mc.findMessage(messageObjID)
+	if err != nil {
+		return err
+	}
+
+	// Only group members can read a group's messages
+	membership, err := findMembership(mc.DB, mc.Config.DatabaseName, message.GroupID, userObjID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if membership == nil {
+		return echo.NewHTTPError(http.StatusForbidden, "You are not a member of this group")
+	}
+
+	// Read positions only track top-level messages, so a reply marks its root
+	if message.ThreadRootID != nil {
+		message, err = mc.findMessage(*message.ThreadRootID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := mc.Reads.Advance(context.Background(), userObjID, message); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to mark message as read")
+	}
+
+	return nil
+}
+
+// MarkGroupRead marks a group read up to the message in the request body, or
+// up to its latest message if none is given
+func (mc *MessageController) MarkGroupRead(c echo.Context) error {
+	// Get user ID from token
+	userID := c.Get("user_id").(string)
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	// Get group ID from URL
+	groupObjID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid group ID")
+	}
+
+	var req models.MarkGroupReadRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	// Check if user is a member of the group
+	membership, err := findMembership(mc.DB, mc.Config.DatabaseName, groupObjID, userObjID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if membership == nil {
+		return echo.NewHTTPError(http.StatusForbidden, "You are not a member of this group")
+	}
+
+	// Find the message to mark read up to
+	var message *models.Message
+	if req.MessageID != "" {
+		messageObjID, err := primitive.ObjectIDFromHex(req.MessageID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid message ID")
+		}
+		message, err = mc.findMessage(messageObjID)
+		if err != nil {
+			return err
+		}
+		if message.GroupID != groupObjID {
+			return echo.NewHTTPError(http.StatusNotFound, "Message not found")
+		}
+		if message.ThreadRootID != nil {
+			message, err = mc.findMessage(*message.ThreadRootID)
+			if err != nil {
+				return err
+			}
 		}
 	} else {
-		// If no group specified, we need to find only groups the user is a member of
-		membersColl := db.GetCollection(mc.DB, mc.Config.DatabaseName, "group_members")
-		userObjID, _ := primitive.ObjectIDFromHex(userID)
-		
-		cursor, err := membersColl.Find(
-			context.Background(),
-			bson.M{"user_id": userObjID},
+		latest, _, err := mc.findMessagesFrom(
+			bson.M{"group_id": groupObjID, "thread_root_id": bson.M{"$exists": false}},
+			nil, true, 1,
 		)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 		}
-		defer cursor.Close(context.Background())
-
-		var memberships []models.GroupMember
-		if err := cursor.All(context.Background(), &memberships); err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to decode group memberships")
+		if len(latest) > 0 {
+			message = &latest[0]
 		}
+	}
 
-		var groupIDs []primitive.ObjectID
-		for _, membership := range memberships {
-			groupIDs = append(groupIDs, membership.GroupID)
+	if message != nil {
+		if err := mc.Reads.Advance(context.Background(), userObjID, message); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to mark group as read")
 		}
+	}
 
-		if len(groupIDs) > 0 {
-			filter["group_id"] = bson.M{"$in": groupIDs}
-		} else {
-			// User is not in any groups, so there can't be any unread messages
-			return c.JSON(http.StatusOK, map[string]int{"count": 0})
+	// Return the resulting read state
+	positions, err := mc.Reads.Positions(context.Background(), userObjID, []models.GroupMember{*membership})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	counts, err := mc.Reads.UnreadCounts(context.Background(), userObjID, positions)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	position := positions[groupObjID]
+	response := models.ReadStateResponse{
+		GroupID:     groupObjID.Hex(),
+		LastReadAt:  position.CreatedAt,
+		UnreadCount: counts[groupObjID],
+	}
+	if !position.ID.IsZero() {
+		response.LastReadMessageID = position.ID.Hex()
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// GetUnreadCount returns the count of unread messages for the current user,
+// in total and per group
+func (mc *MessageController) GetUnreadCount(c echo.Context) error {
+	// Get user ID from token
+	userID := c.Get("user_id").(string)
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	// Get group ID from query parameter (optional)
+	groupID := c.QueryParam("groupId")
+	if groupID == "" {
+		groupID = c.QueryParam("group_id")
+	}
+
+	// Find the user's memberships
+	filter := bson.M{"user_id": userObjID}
+	if groupID != "" {
+		groupObjID, err := primitive.ObjectIDFromHex(groupID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid group ID")
 		}
+		filter["group_id"] = groupObjID
 	}
 
-	// Count unread messages
-	count, err := messagesColl.CountDocuments(context.Background(), filter)
+	membersColl := db.GetCollection(mc.DB, mc.Config.DatabaseName, "group_members")
+	cursor, err := membersColl.Find(context.Background(), filter)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
+	defer cursor.Close(context.Background())
 
-	return c.JSON(http.StatusOK, map[string]int{"count": int(count)})
+	var memberships []models.GroupMember
+	if err := cursor.All(context.Background(), &memberships); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to decode group memberships")
+	}
+	if groupID != "" && len(memberships) == 0 {
+		return echo.NewHTTPError(http.StatusForbidden, "You are not a member of this group")
+	}
+
+	// Count messages past each read position
+	positions, err := mc.Reads.Positions(context.Background(), userObjID, memberships)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	counts, err := mc.Reads.UnreadCounts(context.Background(), userObjID, positions)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	response := models.UnreadCountResponse{Groups: make(map[string]int, len(counts))}
+	for groupObjID, count := range counts {
+		response.Count += count
+		response.Groups[groupObjID.Hex()] = count
+	}
+
+	return c.JSON(http.StatusOK, response)
 }
 
-// SendAnnouncement sends an announcement message to a group (teacher/admin only)
+// SendAnnouncement sends an announcement message to a group (teacher/admin
+// only), or schedules it when send_at is set
 func (mc *MessageController) SendAnnouncement(c echo.Context) error {
 	// Get user ID and role from context
 	userID := c.Get("user_id").(string)
 	userRole := c.Get("user_role").(string)
-	
-	// Only teachers, principals and admins can send announcements
-	if userRole != constants.RoleTeacher && userRole != constants.RolePrincipal && userRole != constants.RoleAdmin {
-		return echo.NewHTTPError(http.StatusForbidden, "Insufficient permissions")
-	}
-	
+
 	// Get group ID from URL
-	groupID := c.Param("id")
-	groupObjID, err := primitive.ObjectIDFromHex(groupID)
+	groupObjID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid group ID")
 	}
-	
+
 	// Parse request body
-	var req struct {
-		Content string `json:"content" validate:"required"`
-		Title   string `json:"title" validate:"required"`
-	}
-	
+	var req models.AnnouncementRequest
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
 	}
-	
+
 	// Get user object ID
 	userObjID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
 	}
-	
-	// Get groups collection
-	groupsColl := db.GetCollection(mc.DB, mc.Config.DatabaseName, "chat_groups")
-	
-	// Find group by ID
-	var group models.ChatGroup
-	err = groupsColl.FindOne(context.Background(), bson.M{"_id": groupObjID}).Decode(&group)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return echo.NewHTTPError(http.StatusNotFound, "Group not found")
-		}
-		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
-	}
-	
-	// Check if user is a member of the group or has admin/principal role
-	if userRole != constants.RoleAdmin && userRole != constants.RolePrincipal {
-		membersColl := db.GetCollection(mc.DB, mc.Config.DatabaseName, "group_members")
-		var membership models.GroupMember
-		err = membersColl.FindOne(
-			context.Background(),
-			bson.M{
-				"group_id": groupObjID,
-				"user_id":  userObjID,
-			},
-		).Decode(&membership)
-		
+
+	if req.SendAt != nil || req.Recurrence != "" {
+		schedule, err := mc.scheduleAnnouncement(userObjID, userRole, groupObjID, req)
 		if err != nil {
-			if err == mongo.ErrNoDocuments {
-				return echo.NewHTTPError(http.StatusForbidden, "You are not a member of this group")
-			}
-			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+			return err
 		}
+		return c.JSON(http.StatusAccepted, schedule)
+	}
+
+	messageResp, err := mc.createAnnouncement(userObjID, userRole, groupObjID, req)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, messageResp)
+}
+
+// createAnnouncement validates, stores and broadcasts an announcement
+func (mc *MessageController) createAnnouncement(userObjID primitive.ObjectID, userRole string, groupObjID primitive.ObjectID, req models.AnnouncementRequest) (models.MessageResponse, error) {
+	if err := mc.checkAnnouncementAccess(userObjID, userRole, groupObjID); err != nil {
+		return models.MessageResponse{}, err
 	}
-	
+
+	// Validate acknowledgement settings
+	reminderAfter, err := mc.announcementReminderAfter(req.ReminderAfterHours)
+	if err != nil {
+		return models.MessageResponse{}, err
+	}
+
 	// Create announcement message
 	now := time.Now()
 	message := models.Message{
-		ID:        primitive.NewObjectID(),
+		ID:        req.ID,
 		GroupID:   groupObjID,
 		SenderID:  userObjID,
 		Content:   req.Content,
@@ -352,47 +997,85 @@ func (mc *MessageController) SendAnnouncement(c echo.Context) error {
 		CreatedAt: now,
 		UpdatedAt: now,
 		ReadBy:    []primitive.ObjectID{},
+
+		RepliesDisabled: req.AllowReplies != nil && !*req.AllowReplies,
+		RequiresAck:     req.RequireAcknowledgement,
+		CampaignID:      req.CampaignID,
 	}
-	
+	if message.ID.IsZero() {
+		message.ID = primitive.NewObjectID()
+	}
+	if req.RequireAcknowledgement && reminderAfter > 0 {
+		reminderAt := now.Add(reminderAfter)
+		message.ReminderAt = &reminderAt
+	}
+
 	// Get messages collection
 	messagesColl := db.GetCollection(mc.DB, mc.Config.DatabaseName, "messages")
-	
+
 	// Insert message into database
 	_, err = messagesColl.InsertOne(context.Background(), message)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create message")
+		return models.MessageResponse{}, echo.NewHTTPError(http.StatusInternalServerError, "Failed to create message")
 	}
-	
-	// Get user info for response
-	usersColl := db.GetCollection(mc.DB, mc.Config.DatabaseName, "users")
-	var sender models.User
-	err = usersColl.FindOne(context.Background(), bson.M{"_id": userObjID}).Decode(&sender)
-	if err != nil {
-		// Continue even if user info can't be retrieved
-		sender = models.User{
-			ID:       userObjID,
-			FullName: "Unknown User",
+
+	// Prepare message response with sender information
+	messageResp := mc.messageWithSender(&message)
+
+	// Broadcast message to group members via WebSocket
+	if mc.Hub != nil {
+		mc.Hub.SendToGroup(groupObjID.Hex(), protocol.TypeAnnouncement, protocol.MessagePayload{Message: messageResp})
+	}
+
+	return messageResp, nil
+}
+
+// checkAnnouncementAccess checks that a user may send announcements to a
+// group that isn't read-only: teachers who are members of it, and any
+// principal or admin
+func (mc *MessageController) checkAnnouncementAccess(userObjID primitive.ObjectID, userRole string, groupObjID primitive.ObjectID) error {
+	// Only teachers, principals and admins can send announcements
+	if userRole != constants.RoleTeacher && userRole != constants.RolePrincipal && userRole != constants.RoleAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "Insufficient permissions")
+	}
+
+	// Get groups collection
+	groupsColl := db.GetCollection(mc.DB, mc.Config.DatabaseName, "chat_groups")
+
+	// Check the group exists and takes posts
+	var group models.ChatGroup
+	if err := groupsColl.FindOne(context.Background(), bson.M{"_id": groupObjID}).Decode(&group); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return echo.NewHTTPError(http.StatusNotFound, "Group not found")
 		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
-	
-	// Prepare message response
-	messageResp := message.ToResponse()
-	messageResp.Sender = &models.UserResponse{
-		ID:                sender.ID.Hex(),
-		Email:             sender.Email,
-		FullName:          sender.FullName,
-		AvatarURL:         sender.AvatarURL,
-		Role:              sender.Role,
-		OrganizationalUnit: sender.OrganizationalUnit,
+	if group.PostingPolicy == models.PostingReadOnly {
+		return echo.NewHTTPError(http.StatusForbidden, "This group is read-only")
 	}
-	
-	// Broadcast message to group members via WebSocket
-	if mc.Hub != nil {
-		mc.Hub.SendToGroup(groupID, map[string]interface{}{
-			"type":    "announcement",
-			"message": messageResp,
-		})
+
+	// Check if user is a member of the group or has admin/principal role
+	if userRole != constants.RoleAdmin && userRole != constants.RolePrincipal {
+		membership, err := findMembership(mc.DB, mc.Config.DatabaseName, groupObjID, userObjID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		if membership == nil {
+			return echo.NewHTTPError(http.StatusForbidden, "You are not a member of this group")
+		}
 	}
-	
-	return c.JSON(http.StatusCreated, messageResp)
+
+	return nil
+}
+
+// announcementReminderAfter returns how long an announcement waits before
+// reminding recipients to acknowledge it
+func (mc *MessageController) announcementReminderAfter(hours *int) (time.Duration, error) {
+	if hours == nil {
+		return mc.Config.AckReminderAfter, nil
+	}
+	if *hours < 0 || *hours > maxReminderAfterHours {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "reminder_after_hours must be between 0 and 720")
+	}
+	return time.Duration(*hours) * time.Hour, nil
 }
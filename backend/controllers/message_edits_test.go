@@ -0,0 +1,377 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chatterbloom/backend/constants"
+	"chatterbloom/backend/models"
+	"chatterbloom/backend/protocol"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// TestUpdateMessage checks that senders can edit their messages within the
+// edit window, keeping the previous version as a revision
+func TestUpdateMessage(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("ENVIRONMENT", "production")
+	t.Setenv("MESSAGE_EDIT_WINDOW", "15m")
+
+	sentAt := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+	updatedAt := sentAt.Add(time.Second)
+
+	cases := []struct {
+		name, body string
+		// The message, as stored
+		message func(ids testIDs) bson.D
+		// Replies to the queries made after loading the message
+		replies func(ids testIDs) []bson.D
+		want    int
+
+		// Expected fields of a successful update
+		wantSet      bson.M
+		wantRevision bson.M
+	}{
+		{
+			name:    "edit",
+			body:    `{"content":"Field trip on Monday","title":"Ignored"}`,
+			message: editableDoc(sentAt, updatedAt),
+			replies: edited,
+			want:    http.StatusOK,
+			// Titles only apply to announcements
+			wantSet:      bson.M{"content": "Field trip on Monday", "title": "", "replies_disabled": false},
+			wantRevision: bson.M{"content": "Field trip on Friday"},
+		},
+		{
+			name: "edit announcement",
+			body: `{"content":"Field trip on Monday","title":"Moved","allow_replies":false}`,
+			message: func(ids testIDs) bson.D {
+				doc := editableDoc(sentAt, updatedAt)(ids)
+				for i := range doc {
+					if doc[i].Key == "type" {
+						doc[i].Value = "announcement"
+					}
+				}
+				return append(doc, bson.E{Key: "title", Value: "Field trip"})
+			},
+			replies:      edited,
+			want:         http.StatusOK,
+			wantSet:      bson.M{"content": "Field trip on Monday", "title": "Moved", "replies_disabled": true},
+			wantRevision: bson.M{"content": "Field trip on Friday", "title": "Field trip"},
+		},
+		{
+			name:    "edit window passed",
+			body:    `{"content":"Field trip on Monday"}`,
+			message: editableDoc(time.Now().Add(-16*time.Minute), updatedAt),
+			replies: member,
+			want:    http.StatusForbidden,
+		},
+		{
+			name:    "someone else's message",
+			body:    `{"content":"Field trip on Monday"}`,
+			message: messageDoc,
+			want:    http.StatusForbidden,
+		},
+		{
+			name:    "no longer a member",
+			body:    `{"content":"Field trip on Monday"}`,
+			message: editableDoc(sentAt, updatedAt),
+			replies: func(ids testIDs) []bson.D { return []bson.D{cursor("group_members")} },
+			want:    http.StatusForbidden,
+		},
+		{
+			name:    "deleted",
+			body:    `{"content":"Field trip on Monday"}`,
+			message: tombstoneDoc(editableDoc(sentAt, updatedAt)),
+			replies: member,
+			want:    http.StatusGone,
+		},
+		{
+			// Another edit or a deletion changed the message since it was read
+			name:    "changed concurrently",
+			body:    `{"content":"Field trip on Monday"}`,
+			message: editableDoc(sentAt, updatedAt),
+			replies: func(ids testIDs) []bson.D {
+				return append(member(ids), mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}))
+			},
+			want: http.StatusConflict,
+		},
+		{
+			name:    "empty content",
+			body:    `{"content":"  "}`,
+			message: editableDoc(sentAt, updatedAt),
+			want:    http.StatusBadRequest,
+		},
+	}
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for _, c := range cases {
+		mt.Run(c.name, func(mt *mtest.T) {
+			ids := testIDs{group: primitive.NewObjectID(), message: primitive.NewObjectID(), caller: primitive.NewObjectID(), target: primitive.NewObjectID()}
+			if c.want != http.StatusBadRequest {
+				mt.AddMockResponses(cursor("messages", c.message(ids)))
+			}
+			if c.replies != nil {
+				mt.AddMockResponses(c.replies(ids)...)
+			}
+
+			e, events := newServerWithEvents(mt)
+			rec := sendAs(mt, e, http.MethodPut, "/api/messages/"+ids.message.Hex(), c.body, ids.caller, constants.RoleTeacher)
+			if rec.Code != c.want {
+				mt.Fatalf("got status %d, want %d: %s", rec.Code, c.want, rec.Body)
+			}
+
+			update := messageUpdate(mt)
+			if c.wantSet == nil {
+				if update != nil && c.want != http.StatusConflict {
+					mt.Errorf("updated the message: %s", update)
+				}
+				return
+			}
+			if update == nil {
+				mt.Fatal("didn't update the message")
+			}
+
+			// Only the version that was read is updated, so no revision is lost
+			if got := update.Lookup("q", "updated_at").Time(); !got.Equal(updatedAt) {
+				mt.Errorf("updated the message as of %s, want %s", got, updatedAt)
+			}
+			checkFields(mt, "set", update.Lookup("u", "$set").Document(), c.wantSet)
+			if _, err := update.LookupErr("u", "$set", "edited_at"); err != nil {
+				mt.Error("didn't set edited_at")
+			}
+			revision := update.Lookup("u", "$push", "revisions").Document()
+			checkFields(mt, "revision", revision, c.wantRevision)
+			if by := revision.Lookup("changed_by").ObjectID(); by != ids.caller {
+				mt.Errorf("revision changed by %s, want the caller", by.Hex())
+			}
+
+			var response models.MessageResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				mt.Fatal(err)
+			}
+			if response.Content != c.wantSet["content"] || response.EditedAt == nil {
+				mt.Errorf("got %s", rec.Body)
+			}
+
+			room, event := nextEvent(mt, events)
+			if room != ids.group.Hex() || event.Type != protocol.TypeMessageUpdated {
+				mt.Fatalf("got %s event in room %s, want %s in the group", event.Type, room, protocol.TypeMessageUpdated)
+			}
+			var payload protocol.MessagePayload
+			if err := json.Unmarshal(event.Payload, &payload); err != nil {
+				mt.Fatal(err)
+			}
+			if payload.Message.Content != c.wantSet["content"] || payload.Message.Title != c.wantSet["title"] {
+				mt.Errorf("got event %s", event.Payload)
+			}
+		})
+	}
+}
+
+// TestDeleteMessage checks that deleting a message leaves a tombstone
+// without its content, which is kept as a revision
+func TestDeleteMessage(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("ENVIRONMENT", "production")
+
+	deleted := func(ids testIDs) []bson.D {
+		return []bson.D{mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}), cursor("users")}
+	}
+
+	cases := []struct {
+		name, schoolRole string
+		message          func(ids testIDs) bson.D
+		replies          func(ids testIDs) []bson.D
+		want             int
+	}{
+		{
+			name:       "own message",
+			schoolRole: constants.RoleStudent,
+			message:    ownMessageDoc,
+			replies:    deleted,
+			want:       http.StatusOK,
+		},
+		{
+			name:       "moderator",
+			schoolRole: constants.RoleStudent,
+			message:    messageDoc,
+			replies: func(ids testIDs) []bson.D {
+				return append([]bson.D{cursor("group_members", memberDoc(ids.group, ids.caller, models.GroupRoleModerator))}, deleted(ids)...)
+			},
+			want: http.StatusOK,
+		},
+		{
+			name:       "school admin",
+			schoolRole: constants.RoleAdmin,
+			message:    messageDoc,
+			replies: func(ids testIDs) []bson.D {
+				return append([]bson.D{cursor("group_members")}, deleted(ids)...)
+			},
+			want: http.StatusOK,
+		},
+		{
+			name:       "member",
+			schoolRole: constants.RoleStudent,
+			message:    messageDoc,
+			replies: func(ids testIDs) []bson.D {
+				return []bson.D{cursor("group_members", memberDoc(ids.group, ids.caller, models.GroupRoleMember))}
+			},
+			want: http.StatusForbidden,
+		},
+		{
+			name:       "already deleted",
+			schoolRole: constants.RoleStudent,
+			message:    tombstoneDoc(ownMessageDoc),
+			want:       http.StatusGone,
+		},
+		{
+			name:       "deleted concurrently",
+			schoolRole: constants.RoleStudent,
+			message:    ownMessageDoc,
+			replies: func(ids testIDs) []bson.D {
+				return []bson.D{mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0})}
+			},
+			want: http.StatusGone,
+		},
+	}
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for _, c := range cases {
+		mt.Run(c.name, func(mt *mtest.T) {
+			ids := testIDs{group: primitive.NewObjectID(), message: primitive.NewObjectID(), caller: primitive.NewObjectID(), target: primitive.NewObjectID()}
+			mt.AddMockResponses(cursor("messages", c.message(ids)))
+			if c.replies != nil {
+				mt.AddMockResponses(c.replies(ids)...)
+			}
+
+			e, events := newServerWithEvents(mt)
+			rec := sendAs(mt, e, http.MethodDelete, "/api/messages/"+ids.message.Hex(), "", ids.caller, c.schoolRole)
+			if rec.Code != c.want {
+				mt.Fatalf("got status %d, want %d: %s", rec.Code, c.want, rec.Body)
+			}
+			update := messageUpdate(mt)
+			if c.want != http.StatusOK {
+				if update != nil && c.name != "deleted concurrently" {
+					mt.Errorf("updated the message: %s", update)
+				}
+				return
+			}
+
+			// The content is cleared and kept as a revision
+			if update == nil {
+				mt.Fatal("didn't update the message")
+			}
+			if _, err := update.LookupErr("q", "deleted_at", "$exists"); err != nil {
+				mt.Errorf("deleted without checking the message is still there: %s", update.Lookup("q"))
+			}
+			checkFields(mt, "set", update.Lookup("u", "$set").Document(), bson.M{"content": "", "title": ""})
+			if by := update.Lookup("u", "$set", "deleted_by").ObjectID(); by != ids.caller {
+				mt.Errorf("deleted by %s, want the caller", by.Hex())
+			}
+			revision := update.Lookup("u", "$push", "revisions").Document()
+			checkFields(mt, "revision", revision, bson.M{"content": "Field trip on Friday"})
+
+			// Clients get a tombstone
+			var response models.MessageResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				mt.Fatal(err)
+			}
+			if !response.Deleted || response.Content != "" {
+				mt.Errorf("got %s, want a tombstone", rec.Body)
+			}
+
+			room, event := nextEvent(mt, events)
+			if room != ids.group.Hex() || event.Type != protocol.TypeMessageDeleted {
+				mt.Fatalf("got %s event in room %s, want %s in the group", event.Type, room, protocol.TypeMessageDeleted)
+			}
+			var payload protocol.MessagePayload
+			if err := json.Unmarshal(event.Payload, &payload); err != nil {
+				mt.Fatal(err)
+			}
+			if !payload.Message.Deleted || payload.Message.Content != "" || payload.Message.ID != ids.message.Hex() {
+				mt.Errorf("got event %s, want a tombstone", event.Payload)
+			}
+		})
+	}
+}
+
+// editableDoc is a message the caller sent at sentAt and last changed at
+// updatedAt
+func editableDoc(sentAt, updatedAt time.Time) func(ids testIDs) bson.D {
+	return func(ids testIDs) bson.D {
+		doc := ownMessageDoc(ids)
+		for i := range doc {
+			if doc[i].Key == "created_at" {
+				doc[i].Value = sentAt
+			}
+		}
+		return append(doc, bson.E{Key: "updated_at", Value: updatedAt})
+	}
+}
+
+// tombstoneDoc is a deleted version of a message
+func tombstoneDoc(message func(ids testIDs) bson.D) func(ids testIDs) bson.D {
+	return func(ids testIDs) bson.D {
+		return append(message(ids), bson.E{Key: "deleted_at", Value: time.Now()})
+	}
+}
+
+// member replies to the caller's membership lookup
+func member(ids testIDs) []bson.D {
+	return []bson.D{cursor("group_members", memberDoc(ids.group, ids.caller, models.GroupRoleMember))}
+}
+
+// edited replies to the queries of a successful edit
+func edited(ids testIDs) []bson.D {
+	return append(member(ids), mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}), cursor("users"))
+}
+
+// sendAs sends a JSON request as a user with the given school role
+func sendAs(mt *mtest.T, h http.Handler, method, path, body string, userID primitive.ObjectID, schoolRole string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", bearer(mt, userID, schoolRole))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// messageUpdate returns the update statement sent to the messages
+// collection, if any
+func messageUpdate(mt *mtest.T) bson.Raw {
+	for _, started := range mt.GetAllStartedEvents() {
+		if started.CommandName == "update" && started.Command.Lookup("update").StringValue() == "messages" {
+			return started.Command.Lookup("updates", "0").Document()
+		}
+	}
+	return nil
+}
+
+// checkFields compares string and boolean fields of a document
+func checkFields(mt *mtest.T, name string, doc bson.Raw, want bson.M) {
+	for field, value := range want {
+		got := doc.Lookup(field)
+		switch value := value.(type) {
+		case string:
+			if s, ok := got.StringValueOK(); !ok || s != value {
+				mt.Errorf("%s %s to %s, want %q", name, field, got, value)
+			}
+		case bool:
+			if b, ok := got.BooleanOK(); !ok || b != value {
+				mt.Errorf("%s %s to %s, want %v", name, field, got, value)
+			}
+		case primitive.ObjectID:
+			if id, ok := got.ObjectIDOK(); !ok || id != value {
+				mt.Errorf("%s %s to %s, want %s", name, field, got, value.Hex())
+			}
+		}
+	}
+}
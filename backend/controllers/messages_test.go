@@ -0,0 +1,62 @@
+package controllers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"chatterbloom/backend/constants"
+	"chatterbloom/backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// TestGetMessagesMembership checks that only members of a group, and school
+// admins and principals, can list its messages, whichever way they page
+func TestGetMessagesMembership(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("ENVIRONMENT", "production")
+
+	cases := []struct {
+		name, schoolRole string
+		member           bool
+		want             int
+	}{
+		{"member", constants.RoleStudent, true, http.StatusOK},
+		{"non-member", constants.RoleStudent, false, http.StatusForbidden},
+		{"teacher non-member", constants.RoleTeacher, false, http.StatusForbidden},
+		{"admin non-member", constants.RoleAdmin, false, http.StatusOK},
+		{"principal non-member", constants.RolePrincipal, false, http.StatusOK},
+	}
+	queries := []string{"", "?offset=0"}
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for _, c := range cases {
+		for _, query := range queries {
+			mt.Run(c.name+query, func(mt *mtest.T) {
+				ids := testIDs{group: primitive.NewObjectID(), caller: primitive.NewObjectID()}
+				var replies []bson.D
+				if c.schoolRole != constants.RoleAdmin && c.schoolRole != constants.RolePrincipal {
+					if c.member {
+						replies = append(replies, cursor("group_members", memberDoc(ids.group, ids.caller, models.GroupRoleMember)))
+					} else {
+						replies = append(replies, cursor("group_members"))
+					}
+				}
+				replies = append(replies, cursor("messages", messageDoc(ids)), cursor("users"))
+				mt.AddMockResponses(replies...)
+
+				req := httptest.NewRequest(http.MethodGet, "/api/groups/"+ids.group.Hex()+"/messages"+query, nil)
+				req.Header.Set("Authorization", bearer(mt, ids.caller, c.schoolRole))
+				rec := httptest.NewRecorder()
+				newServer(mt).ServeHTTP(rec, req)
+
+				if rec.Code != c.want {
+					mt.Errorf("got status %d, want %d: %s", rec.Code, c.want, rec.Body)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"chatterbloom/backend/config"
+	"chatterbloom/backend/db"
+	"chatterbloom/backend/models"
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NotificationController handles the current user's notifications
+type NotificationController struct {
+	DB     *mongo.Client
+	Config *config.Config
+}
+
+// GetNotifications returns the current user's notifications, newest first.
+// With ?unread=true only unread ones are returned.
+func (nc *NotificationController) GetNotifications(c echo.Context) error {
+	// Get user ID from token
+	userID := c.Get("user_id").(string)
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	// Get limit from query parameters
+	limit := 50 // Default limit
+	if limitParam := c.QueryParam("limit"); limitParam != "" {
+		if parsedLimit, err := strconv.Atoi(limitParam); err == nil && parsedLimit > 0 && parsedLimit <= 100 {
+			limit = parsedLimit
+		}
+	}
+
+	filter := bson.M{"user_id": userObjID}
+	if c.QueryParam("unread") == "true" {
+		filter["read_at"] = bson.M{"$exists": false}
+	}
+
+	notificationsColl := db.GetCollection(nc.DB, nc.Config.DatabaseName, "notifications")
+	cursor, err := notificationsColl.Find(
+		context.Background(),
+		filter,
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer cursor.Close(context.Background())
+
+	notifications := []models.Notification{}
+	if err := cursor.All(context.Background(), &notifications); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to decode notifications")
+	}
+
+	return c.JSON(http.StatusOK, notifications)
+}
+
+// MarkNotificationRead marks one of the current user's notifications as read
+func (nc *NotificationController) MarkNotificationRead(c echo.Context) error {
+	// Get user ID from token
+	userID := c.Get("user_id").(string)
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	// Get notification ID from URL
+	notificationObjID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid notification ID")
+	}
+
+	notificationsColl := db.GetCollection(nc.DB, nc.Config.DatabaseName, "notifications")
+	result, err := notificationsColl.UpdateOne(
+		context.Background(),
+		bson.M{"_id": notificationObjID, "user_id": userObjID, "read_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"read_at": time.Now()}},
+	)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to mark notification as read")
+	}
+	if result.MatchedCount == 0 {
+		count, err := notificationsColl.CountDocuments(context.Background(), bson.M{"_id": notificationObjID, "user_id": userObjID})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		if count == 0 {
+			return echo.NewHTTPError(http.StatusNotFound, "Notification not found")
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Notification marked as read"})
+}
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"chatterbloom/backend/db"
+	"chatterbloom/backend/models"
+	"context"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxMessagePageSize caps the limit clients can ask for
+const maxMessagePageSize = 100
+
+// messageKey is a position in a group's history. Messages are ordered by
+// creation time, with the ID breaking ties between messages created in the
+// same millisecond.
+type messageKey struct {
+	CreatedAt time.Time
+	ID        primitive.ObjectID
+}
+
+// keyOf returns the position of a message
+func keyOf(message *models.Message) messageKey {
+	return messageKey{CreatedAt: message.CreatedAt, ID: message.ID}
+}
+
+// encodeCursor turns a position into an opaque cursor
+func encodeCursor(key messageKey) string {
+	raw := strconv.FormatInt(key.CreatedAt.UnixMilli(), 10) + ":" + key.ID.Hex()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor parses a cursor made by encodeCursor
+func decodeCursor(cursor string) (messageKey, error) {
+	invalid := errors.New("invalid cursor")
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return messageKey{}, invalid
+	}
+	millis, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return messageKey{}, invalid
+	}
+	ms, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return messageKey{}, invalid
+	}
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return messageKey{}, invalid
+	}
+
+	return messageKey{CreatedAt: time.UnixMilli(ms).UTC(), ID: objID}, nil
+}
+
+// beyondKey returns $or clauses matching messages older or newer than key
+func beyondKey(key messageKey, older bool) bson.A {
+	op := "$gt"
+	if older {
+		op = "$lt"
+	}
+	return bson.A{
+		bson.M{"created_at": bson.M{op: key.CreatedAt}},
+		bson.M{"created_at": key.CreatedAt, "_id": bson.M{op: key.ID}},
+	}
+}
+
+// findMessagesFrom returns up to limit messages matching filter on one side
+// of key, newest first, and whether more messages lie beyond them. A nil key
+// starts from the newest message.
+func (mc *MessageController) findMessagesFrom(filter bson.M, key *messageKey, older bool, limit int) ([]models.Message, bool, error) {
+	if limit <= 0 {
+		return nil, false, nil
+	}
+
+	query := bson.M{}
+	for field, value := range filter {
+		query[field] = value
+	}
+
+	direction := 1
+	if older {
+		direction = -1
+	}
+	if key != nil {
+		query["$or"] = beyondKey(*key, older)
+	}
+
+	// Fetch one extra message to learn whether there are more
+	messagesColl := db.GetCollection(mc.DB, mc.Config.DatabaseName, "messages")
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(int64(limit + 1))
+
+	cursor, err := messagesColl.Find(context.Background(), query, findOptions)
+	if err != nil {
+		return nil, false, err
+	}
+	defer cursor.Close(context.Background())
+
+	var messages []models.Message
+	if err := cursor.All(context.Background(), &messages); err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+
+	// Newer messages were fetched oldest first
+	if !older {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+
+	return messages, hasMore, nil
+}
@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"chatterbloom/backend/db"
+	"chatterbloom/backend/models"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxSlowModeSeconds is the longest cooldown a slow mode group can set
+const maxSlowModeSeconds = 24 * 60 * 60
+
+// checkPostingPolicy checks that a member may post a regular message or
+// thread reply in a group. In slow mode it also starts the member's
+// cooldown, so it runs last, right before the message is stored, and the
+// caller must cancelCooldown if storing fails.
+func (mc *MessageController) checkPostingPolicy(group *models.ChatGroup, membership *models.GroupMember, message *models.Message) error {
+	switch group.PostingPolicy {
+	case models.PostingReadOnly:
+		return echo.NewHTTPError(http.StatusForbidden, "This group is read-only")
+
+	case models.PostingAnnouncementOnly:
+		privileged, err := mc.bypassesPostingPolicy(membership)
+		if err != nil || privileged {
+			return err
+		}
+
+		// Members may only reply in the threads of announcements
+		if message.ThreadRootID != nil {
+			root, err := mc.findMessage(*message.ThreadRootID)
+			if err != nil {
+				return err
+			}
+			if root.Type == "announcement" {
+				return nil
+			}
+		}
+		return echo.NewHTTPError(http.StatusForbidden, "Only group admins and teachers can post in this group")
+
+	case models.PostingSlowMode:
+		privileged, err := mc.bypassesPostingPolicy(membership)
+		if err != nil || privileged || group.SlowModeSeconds <= 0 {
+			return err
+		}
+		return mc.startCooldown(group, membership, message.CreatedAt)
+	}
+
+	return nil
+}
+
+// bypassesPostingPolicy reports whether a member may post past
+// announcement-only and slow mode limits
+func (mc *MessageController) bypassesPostingPolicy(membership *models.GroupMember) (bool, error) {
+	users, err := loadUsers(mc.DB, mc.Config.DatabaseName, mc.Users, []primitive.ObjectID{membership.UserID})
+	if err != nil {
+		return false, echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return hasGroupCapability(membership, users[membership.UserID].Role, capBypassPostingPolicy), nil
+}
+
+// startCooldown records a slow mode post, failing with 429 if the member's
+// previous post is more recent than the group's cooldown. The check and the
+// update are one operation so concurrent sends can't both pass.
+func (mc *MessageController) startCooldown(group *models.ChatGroup, membership *models.GroupMember, now time.Time) error {
+	cooldown := time.Duration(group.SlowModeSeconds) * time.Second
+
+	membersColl := db.GetCollection(mc.DB, mc.Config.DatabaseName, "group_members")
+	result, err := membersColl.UpdateOne(
+		context.Background(),
+		bson.M{
+			"_id": membership.ID,
+			"$or": bson.A{
+				bson.M{"last_posted_at": bson.M{"$exists": false}},
+				bson.M{"last_posted_at": bson.M{"$lte": now.Add(-cooldown)}},
+			},
+		},
+		bson.M{"$set": bson.M{"last_posted_at": now}},
+	)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if result.MatchedCount == 0 {
+		wait := cooldown
+		if membership.LastPostedAt != nil && membership.LastPostedAt.Add(cooldown).After(now) {
+			wait = membership.LastPostedAt.Add(cooldown).Sub(now)
+		}
+		return echo.NewHTTPError(http.StatusTooManyRequests, fmt.Sprintf("Slow mode is on, wait %d seconds before posting again", int(math.Ceil(wait.Seconds()))))
+	}
+	return nil
+}
+
+// cancelCooldown undoes the cooldown checkPostingPolicy started for a
+// message that couldn't be stored, restoring the member's previous post time.
+// Cooldowns started since by another post are left alone.
+func (mc *MessageController) cancelCooldown(group *models.ChatGroup, membership *models.GroupMember, startedAt time.Time) error {
+	if group.PostingPolicy != models.PostingSlowMode {
+		return nil
+	}
+
+	restore := bson.M{"$unset": bson.M{"last_posted_at": ""}}
+	if membership.LastPostedAt != nil {
+		restore = bson.M{"$set": bson.M{"last_posted_at": *membership.LastPostedAt}}
+	}
+
+	membersColl := db.GetCollection(mc.DB, mc.Config.DatabaseName, "group_members")
+	_, err := membersColl.UpdateOne(
+		context.Background(),
+		bson.M{"_id": membership.ID, "last_posted_at": startedAt},
+		restore,
+	)
+	return err
+}
+
+// postingSettings validates posting policy changes and adds them to a group
+// settings update
+func postingSettings(req *models.GroupSettingsRequest, set bson.M) error {
+	if req.PostingPolicy != nil {
+		switch *req.PostingPolicy {
+		case models.PostingOpen, models.PostingAnnouncementOnly, models.PostingReadOnly:
+		case models.PostingSlowMode:
+			if req.SlowModeSeconds == nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "slow_mode_seconds is required for slow mode")
+			}
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid posting_policy")
+		}
+		set["posting_policy"] = *req.PostingPolicy
+	}
+
+	if req.SlowModeSeconds != nil {
+		if *req.SlowModeSeconds < 1 || *req.SlowModeSeconds > maxSlowModeSeconds {
+			return echo.NewHTTPError(http.StatusBadRequest, "slow_mode_seconds must be between 1 and 86400")
+		}
+		set["slow_mode_seconds"] = *req.SlowModeSeconds
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package controllers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chatterbloom/backend/constants"
+	"chatterbloom/backend/controllers"
+	"chatterbloom/backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// TestSlowModeCooldownOnlyCountsStoredMessages checks that a slow mode post
+// that fails to be stored gives the member their previous post time back
+func TestSlowModeCooldownOnlyCountsStoredMessages(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("ENVIRONMENT", "production")
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("insert fails", func(mt *mtest.T) {
+		ids := testIDs{group: primitive.NewObjectID(), caller: primitive.NewObjectID()}
+		lastPostedAt := time.Now().Add(-time.Hour).Truncate(time.Millisecond).UTC()
+
+		membership := append(memberDoc(ids.group, ids.caller, models.GroupRoleMember),
+			bson.E{Key: "last_posted_at", Value: lastPostedAt})
+		group := append(groupDoc(ids),
+			bson.E{Key: "posting_policy", Value: models.PostingSlowMode},
+			bson.E{Key: "slow_mode_seconds", Value: 60})
+		mt.AddMockResponses(
+			cursor("group_members", membership),
+			cursor("chat_groups", group),
+			cursor("users", userDoc(ids.caller, constants.RoleStudent)),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 1, Message: "insert failed"}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+		)
+
+		body := `{"group_id":"` + ids.group.Hex() + `","content":"Hello"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/messages", strings.NewReader(body))
+		req.Header.Set("Authorization", bearer(mt, ids.caller, constants.RoleStudent))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		newServer(mt).ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusInternalServerError {
+			mt.Fatalf("got status %d, want %d", rec.Code, http.StatusInternalServerError)
+		}
+
+		// The last command puts the previous post time back, but only if no
+		// other post started a cooldown since
+		started := mt.GetAllStartedEvents()
+		claim, restore := started[len(started)-3], started[len(started)-1]
+		if restore.CommandName != "update" || restore.Command.Lookup("update").StringValue() != "group_members" {
+			mt.Fatalf("last command was %s, want an update of group_members", restore.CommandName)
+		}
+		startedAt := claim.Command.Lookup("updates", "0", "u", "$set", "last_posted_at").Time()
+		if got := restore.Command.Lookup("updates", "0", "q", "last_posted_at").Time(); !got.Equal(startedAt) {
+			mt.Errorf("restore matches last_posted_at %v, want the cooldown it started at %v", got, startedAt)
+		}
+		if got := restore.Command.Lookup("updates", "0", "u", "$set", "last_posted_at").Time(); !got.Equal(lastPostedAt) {
+			mt.Errorf("restore sets last_posted_at to %v, want %v", got, lastPostedAt)
+		}
+	})
+}
+
+// TestPostingPolicyBypass checks who may post past announcement-only and
+// slow mode limits: group admins and teachers, but not moderators
+func TestPostingPolicyBypass(t *testing.T) {
+	cases := []struct {
+		schoolRole, groupRole string
+		want                  bool
+	}{
+		{constants.RoleStudent, models.GroupRoleAdmin, true},
+		{constants.RoleStudent, models.GroupRoleModerator, false},
+		{constants.RoleStudent, models.GroupRoleMember, false},
+		{constants.RoleParent, models.GroupRoleModerator, false},
+		{constants.RoleStaff, models.GroupRoleModerator, false},
+		{constants.RoleTeacher, models.GroupRoleMember, true},
+		{constants.RoleTeacher, models.GroupRoleModerator, true},
+		{constants.RoleAdmin, models.GroupRoleMember, true},
+		{constants.RolePrincipal, models.GroupRoleMember, true},
+	}
+	for _, c := range cases {
+		membership := &models.GroupMember{Role: c.groupRole}
+		if got := controllers.HasGroupCapability(membership, c.schoolRole, controllers.CapBypassPostingPolicy); got != c.want {
+			t.Errorf("%s with group role %s: got bypass %v, want %v", c.schoolRole, c.groupRole, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"chatterbloom/backend/config"
+	"chatterbloom/backend/db"
+	"chatterbloom/backend/models"
+	"chatterbloom/backend/protocol"
+	"chatterbloom/backend/websocket"
+	"context"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxEmojiBytes bounds the size of a reaction key
+const maxEmojiBytes = 32
+
+// maxReactionsPerMessage bounds how many different emoji a message can have
+const maxReactionsPerMessage = 20
+
+// emojiPictographs are the code points that can start an emoji: pictographs,
+// symbols with an emoji presentation, skin tone modifiers and regional
+// indicators
+var emojiPictographs = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x00a9, Hi: 0x00ae, Stride: 5},
+		{Lo: 0x203c, Hi: 0x2049, Stride: 13},
+		{Lo: 0x2122, Hi: 0x2139, Stride: 23},
+		{Lo: 0x2194, Hi: 0x2199, Stride: 1},
+		{Lo: 0x21a9, Hi: 0x21aa, Stride: 1},
+		{Lo: 0x231a, Hi: 0x23ff, Stride: 1},
+		{Lo: 0x24c2, Hi: 0x24c2, Stride: 1},
+		{Lo: 0x25aa, Hi: 0x25fe, Stride: 1},
+		{Lo: 0x2600, Hi: 0x27bf, Stride: 1},
+		{Lo: 0x2934, Hi: 0x2935, Stride: 1},
+		{Lo: 0x2b05, Hi: 0x2b55, Stride: 1},
+		{Lo: 0x3030, Hi: 0x3030, Stride: 1},
+		{Lo: 0x303d, Hi: 0x303d, Stride: 1},
+		{Lo: 0x3297, Hi: 0x3299, Stride: 2},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x1f000, Hi: 0x1faff, Stride: 1},
+	},
+	LatinOffset: 1,
+}
+
+// emojiJoiners are the code points that may follow the first one in an emoji
+// sequence besides pictographs: the zero width joiner, variation selectors
+// and the tags of subdivision flags
+var emojiJoiners = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x200d, Hi: 0x200d, Stride: 1},
+		{Lo: 0xfe0e, Hi: 0xfe0f, Stride: 1},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0xe0020, Hi: 0xe007f, Stride: 1},
+	},
+}
+
+// ReactionController handles emoji reactions on messages
+type ReactionController struct {
+	DB     *mongo.Client
+	Config *config.Config
+	Hub    *websocket.Hub
+}
+
+// AddReaction adds the current user's reaction to a message
+func (rc *ReactionController) AddReaction(c echo.Context) error {
+	var req models.ReactionRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	return rc.updateReaction(c, req.Emoji, true)
+}
+
+// RemoveReaction removes the current user's reaction, given as the `emoji`
+// query parameter, from a message
+func (rc *ReactionController) RemoveReaction(c echo.Context) error {
+	return rc.updateReaction(c, c.QueryParam("emoji"), false)
+}
+
+// updateReaction adds or removes a reaction and broadcasts the new counts
+func (rc *ReactionController) updateReaction(c echo.Context, emoji string, add bool) error {
+	// Get user ID from token
+	userID := c.Get("user_id").(string)
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	// Get message ID from URL
+	messageObjID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid message ID")
+	}
+
+	// Reactions are added as emoji, but any reaction already stored can be
+	// taken back
+	if !safeReactionKey(emoji) || add && !validEmoji(emoji) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid emoji")
+	}
+
+	// Get message
+	messagesColl := db.GetCollection(rc.DB, rc.Config.DatabaseName, "messages")
+	var message models.Message
+	err = messagesColl.FindOne(context.Background(), bson.M{"_id": messageObjID}).Decode(&message)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return echo.NewHTTPError(http.StatusNotFound, "Message not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if message.DeletedAt != nil {
+		return echo.NewHTTPError(http.StatusGone, "Message has been deleted")
+	}
+
+	// Only group members can react
+	membership, err := findMembership(rc.DB, rc.Config.DatabaseName, message.GroupID, userObjID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if membership == nil {
+		return echo.NewHTTPError(http.StatusForbidden, "You are not a member of this group")
+	}
+
+	// The group may restrict which emoji can be added
+	if add {
+		groupsColl := db.GetCollection(rc.DB, rc.Config.DatabaseName, "chat_groups")
+		var group models.ChatGroup
+		err = groupsColl.FindOne(context.Background(), bson.M{"_id": message.GroupID}).Decode(&group)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		if len(group.AllowedReactions) > 0 && !containsString(group.AllowedReactions, emoji) {
+			return echo.NewHTTPError(http.StatusForbidden, "This emoji is not allowed in this group")
+		}
+	}
+
+	// Update the reaction set for the emoji
+	filter := bson.M{"_id": messageObjID}
+	update := bson.M{"$pull": bson.M{"reactions." + emoji: userObjID}}
+	if add {
+		// A new emoji is only added while the message has room for it;
+		// emoji everyone took back don't count
+		filter["$or"] = bson.A{
+			bson.M{"reactions." + emoji + ".0": bson.M{"$exists": true}},
+			bson.M{"$expr": bson.M{"$lt": bson.A{
+				bson.M{"$size": bson.M{"$filter": bson.M{
+					"input": bson.M{"$objectToArray": bson.M{"$ifNull": bson.A{"$reactions", bson.M{}}}},
+					"cond":  bson.M{"$gt": bson.A{bson.M{"$size": "$$this.v"}, 0}},
+				}}},
+				maxReactionsPerMessage,
+			}}},
+		}
+		update = bson.M{"$addToSet": bson.M{"reactions." + emoji: userObjID}}
+	}
+	err = messagesColl.FindOneAndUpdate(
+		context.Background(),
+		filter,
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&message)
+	if err == mongo.ErrNoDocuments && add {
+		return echo.NewHTTPError(http.StatusConflict, "This message already has the maximum number of different reactions")
+	}
+	if err == mongo.ErrNoDocuments {
+		return echo.NewHTTPError(http.StatusNotFound, "Message not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update reaction")
+	}
+
+	messageResponse := message.ToResponse()
+
+	// Broadcast the new counts to WebSocket clients in the group
+	if rc.Hub != nil {
+		rc.Hub.SendToGroup(message.GroupID.Hex(), protocol.TypeReactionUpdated, protocol.ReactionPayload{
+			MessageID: message.ID.Hex(),
+			UserID:    userID,
+			Emoji:     emoji,
+			Added:     add,
+			Reactions: messageResponse.Reactions,
+		})
+	}
+
+	return c.JSON(http.StatusOK, messageResponse)
+}
+
+// validEmoji accepts a single emoji: a pictograph, optionally followed by
+// modifiers and further pictographs joined to it, or a keycap
+func validEmoji(emoji string) bool {
+	if !safeReactionKey(emoji) {
+		return false
+	}
+
+	runes := []rune(emoji)
+	if n := len(runes); n > 1 && runes[n-1] == 0x20e3 {
+		// A digit, # or * in a keycap, optionally with an emoji presentation
+		return strings.ContainsRune("0123456789#*", runes[0]) && (n == 2 || n == 3 && runes[1] == 0xfe0f)
+	}
+	if !unicode.Is(emojiPictographs, runes[0]) {
+		return false
+	}
+	for _, r := range runes[1:] {
+		if !unicode.Is(emojiPictographs, r) && !unicode.Is(emojiJoiners, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// safeReactionKey rejects reaction keys that are empty, too long, contain
+// whitespace or are unsafe as MongoDB field names
+func safeReactionKey(emoji string) bool {
+	if emoji == "" || len(emoji) > maxEmojiBytes {
+		return false
+	}
+	if strings.ContainsAny(emoji, ".$") {
+		return false
+	}
+	for _, r := range emoji {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
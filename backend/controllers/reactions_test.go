@@ -0,0 +1,176 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"chatterbloom/backend/constants"
+	"chatterbloom/backend/controllers"
+	"chatterbloom/backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestValidEmoji(t *testing.T) {
+	valid := []string{
+		"👍",
+		"👍🏽",
+		"❤️",
+		"✅",
+		"⭐",
+		"🇳🇱",
+		"👩‍👩‍👧",
+		"🏳️‍🌈",
+		"🏴󠁧󠁢󠁳󠁣󠁴󠁿",
+		"1️⃣",
+		"#⃣",
+		"©️",
+	}
+	for _, emoji := range valid {
+		if !controllers.ValidEmoji(emoji) {
+			t.Errorf("ValidEmoji(%q) = false", emoji)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"ok",
+		"+1",
+		"a👍",
+		"👍a",
+		"👍 ",
+		"1",
+		"a⃣",
+		"‍👍",
+		"️",
+		"👍.",
+		"$👍",
+		"é",
+		"中",
+		strings.Repeat("😀", 9),
+	}
+	for _, emoji := range invalid {
+		if controllers.ValidEmoji(emoji) {
+			t.Errorf("ValidEmoji(%q) = true", emoji)
+		}
+	}
+}
+
+// TestReactions checks adding and removing reactions against the group's
+// allowed emoji and the limit of different reactions per message
+func TestReactions(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("ENVIRONMENT", "production")
+
+	cases := []struct {
+		name, method, emoji string
+		allowed             []string // The group's allowed reactions
+		full                bool     // The message has as many different reactions as it may
+		want                int
+	}{
+		{name: "add", method: http.MethodPost, emoji: "🎉", want: http.StatusOK},
+		{name: "add allowed", method: http.MethodPost, emoji: "👍", allowed: []string{"✅", "👍"}, want: http.StatusOK},
+		{name: "add not allowed", method: http.MethodPost, emoji: "🎉", allowed: []string{"✅", "👍"}, want: http.StatusForbidden},
+		{name: "add to a full message", method: http.MethodPost, emoji: "🎉", full: true, want: http.StatusConflict},
+		{name: "add text", method: http.MethodPost, emoji: "ok", want: http.StatusBadRequest},
+		{name: "add field path", method: http.MethodPost, emoji: "👍.count", want: http.StatusBadRequest},
+		{name: "remove", method: http.MethodDelete, emoji: "👍", allowed: []string{"✅"}, want: http.StatusOK},
+		// Reactions stored before emoji were checked can still be taken back
+		{name: "remove text", method: http.MethodDelete, emoji: "ok", want: http.StatusOK},
+		{name: "remove field path", method: http.MethodDelete, emoji: "a.b", want: http.StatusBadRequest},
+	}
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for _, c := range cases {
+		mt.Run(c.name, func(mt *mtest.T) {
+			ids := testIDs{group: primitive.NewObjectID(), message: primitive.NewObjectID(), caller: primitive.NewObjectID(), target: primitive.NewObjectID()}
+			add := c.method == http.MethodPost
+
+			if c.want != http.StatusBadRequest {
+				mt.AddMockResponses(
+					cursor("messages", messageDoc(ids)),
+					cursor("group_members", memberDoc(ids.group, ids.caller, models.GroupRoleMember)),
+				)
+				if add {
+					allowed := bson.A{}
+					for _, emoji := range c.allowed {
+						allowed = append(allowed, emoji)
+					}
+					mt.AddMockResponses(cursor("chat_groups", append(groupDoc(ids), bson.E{Key: "allowed_reactions", Value: allowed})))
+				}
+			}
+			if c.want == http.StatusOK {
+				reacted := append(messageDoc(ids), bson.E{Key: "reactions", Value: bson.D{
+					{Key: c.emoji, Value: bson.A{ids.target}},
+					{Key: "✅", Value: bson.A{}},
+				}})
+				mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: reacted}))
+			}
+			if c.full {
+				mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}))
+			}
+
+			path := "/api/messages/" + ids.message.Hex() + "/reactions"
+			body := ""
+			if add {
+				body = `{"emoji":"` + c.emoji + `"}`
+			} else {
+				path += "?emoji=" + url.QueryEscape(c.emoji)
+			}
+			req := httptest.NewRequest(c.method, path, strings.NewReader(body))
+			req.Header.Set("Authorization", bearer(mt, ids.caller, constants.RoleStudent))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			newServer(mt).ServeHTTP(rec, req)
+
+			if rec.Code != c.want {
+				mt.Fatalf("got status %d, want %d: %s", rec.Code, c.want, rec.Body)
+			}
+
+			var update bson.Raw
+			for _, started := range mt.GetAllStartedEvents() {
+				if started.CommandName == "findAndModify" {
+					update = started.Command
+				}
+			}
+			if c.want != http.StatusOK && !c.full {
+				if update != nil {
+					mt.Errorf("updated reactions: %s", update)
+				}
+				return
+			}
+			if update == nil {
+				mt.Fatal("didn't update reactions")
+			}
+
+			operator := "$pull"
+			if add {
+				operator = "$addToSet"
+			}
+			if id := update.Lookup("update", operator, "reactions."+c.emoji).ObjectID(); id != ids.caller {
+				mt.Errorf("got update %s, want the caller under %s", update.Lookup("update"), operator)
+			}
+			// Only adding is limited
+			if _, err := update.LookupErr("query", "$or"); (err == nil) != add {
+				mt.Errorf("got query %s", update.Lookup("query"))
+			}
+
+			if c.want == http.StatusOK {
+				var response models.MessageResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+					mt.Fatal(err)
+				}
+				// Emoji without reactions aren't counted
+				if len(response.Reactions) != 1 || response.Reactions[c.emoji] != 1 {
+					mt.Errorf("got reactions %v, want one %s", response.Reactions, c.emoji)
+				}
+			}
+		})
+	}
+}
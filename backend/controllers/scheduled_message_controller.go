@@ -0,0 +1,380 @@
+package controllers
+
+import (
+	"chatterbloom/backend/config"
+	"chatterbloom/backend/db"
+	"chatterbloom/backend/models"
+	"chatterbloom/backend/recurrence"
+	"chatterbloom/backend/scheduler"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxScheduleAhead is how far in the future a message can be scheduled
+const maxScheduleAhead = 365 * 24 * time.Hour
+
+// ScheduledMessageController lets authors manage their pending scheduled messages
+type ScheduledMessageController struct {
+	DB       *mongo.Client
+	Config   *config.Config
+	Messages *MessageController
+}
+
+// scheduleMessage stores a regular message to be published later
+func (mc *MessageController) scheduleMessage(userObjID primitive.ObjectID, req models.MessageRequest) (*models.ScheduledMessage, error) {
+	if strings.TrimSpace(req.Content) == "" {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Message content is required")
+	}
+	if req.ParentID != "" {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Thread replies can't be scheduled")
+	}
+
+	groupObjID, err := primitive.ObjectIDFromHex(req.GroupID)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid group ID")
+	}
+
+	// Check if user is a member of the group
+	membership, err := findMembership(mc.DB, mc.Config.DatabaseName, groupObjID, userObjID)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if membership == nil {
+		return nil, echo.NewHTTPError(http.StatusForbidden, "You are not a member of this group")
+	}
+
+	sendAt, timezone, err := scheduleTiming(req.SendAt, req.Recurrence, req.Timezone, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return mc.insertSchedule(models.ScheduledMessage{
+		GroupID:    groupObjID,
+		SenderID:   userObjID,
+		Type:       "regular",
+		Content:    req.Content,
+		Recurrence: req.Recurrence,
+		Timezone:   timezone,
+		SendAt:     sendAt,
+	})
+}
+
+// scheduleAnnouncement stores an announcement to be published later
+func (mc *MessageController) scheduleAnnouncement(userObjID primitive.ObjectID, userRole string, groupObjID primitive.ObjectID, req models.AnnouncementRequest) (*models.ScheduledMessage, error) {
+	if err := mc.checkAnnouncementAccess(userObjID, userRole, groupObjID); err != nil {
+		return nil, err
+	}
+	if _, err := mc.announcementReminderAfter(req.ReminderAfterHours); err != nil {
+		return nil, err
+	}
+
+	sendAt, timezone, err := scheduleTiming(req.SendAt, req.Recurrence, req.Timezone, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return mc.insertSchedule(models.ScheduledMessage{
+		GroupID:                groupObjID,
+		SenderID:               userObjID,
+		Type:                   "announcement",
+		Content:                req.Content,
+		Title:                  req.Title,
+		AllowReplies:           req.AllowReplies,
+		RequireAcknowledgement: req.RequireAcknowledgement,
+		ReminderAfterHours:     req.ReminderAfterHours,
+		Recurrence:             req.Recurrence,
+		Timezone:               timezone,
+		SendAt:                 sendAt,
+	})
+}
+
+// insertSchedule stores a new pending scheduled message starting at its SendAt
+func (mc *MessageController) insertSchedule(schedule models.ScheduledMessage) (*models.ScheduledMessage, error) {
+	now := time.Now()
+	schedule.ID = primitive.NewObjectID()
+	schedule.FirstSendAt = schedule.SendAt
+	schedule.Status = models.ScheduleStatusPending
+	schedule.CreatedAt = now
+	schedule.UpdatedAt = now
+
+	schedulesColl := db.GetCollection(mc.DB, mc.Config.DatabaseName, "scheduled_messages")
+	if _, err := schedulesColl.InsertOne(context.Background(), schedule); err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to schedule message")
+	}
+
+	return &schedule, nil
+}
+
+// scheduleTiming validates when a message is scheduled for and returns the
+// send time in the schedule's timezone, and the timezone's name
+func scheduleTiming(sendAt *time.Time, rule, timezone string, now time.Time) (time.Time, string, error) {
+	if sendAt == nil {
+		return time.Time{}, "", echo.NewHTTPError(http.StatusBadRequest, "send_at is required to schedule a message")
+	}
+	if !sendAt.After(now) {
+		return time.Time{}, "", echo.NewHTTPError(http.StatusBadRequest, "send_at must be in the future")
+	}
+	if sendAt.After(now.Add(maxScheduleAhead)) {
+		return time.Time{}, "", echo.NewHTTPError(http.StatusBadRequest, "send_at must be within a year")
+	}
+
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, "", echo.NewHTTPError(http.StatusBadRequest, "Invalid timezone")
+	}
+
+	if rule != "" {
+		if _, err := recurrence.Parse(rule); err != nil {
+			return time.Time{}, "", echo.NewHTTPError(http.StatusBadRequest, "Invalid recurrence: "+err.Error())
+		}
+	}
+
+	return sendAt.In(location), timezone, nil
+}
+
+// PublishScheduled publishes a due scheduled message as its author, with the
+// author's current role and memberships
+func (mc *MessageController) PublishScheduled(ctx context.Context, schedule *models.ScheduledMessage, messageID primitive.ObjectID) error {
+	// Get the author
+	usersColl := db.GetCollection(mc.DB, mc.Config.DatabaseName, "users")
+	var sender models.User
+	err := usersColl.FindOne(ctx, bson.M{"_id": schedule.SenderID}).Decode(&sender)
+	if err == mongo.ErrNoDocuments {
+		return fmt.Errorf("%w: author no longer exists", scheduler.ErrRejected)
+	}
+	if err != nil {
+		return err
+	}
+
+	if schedule.Type == "announcement" {
+		_, err = mc.createAnnouncement(schedule.SenderID, sender.Role, schedule.GroupID, models.AnnouncementRequest{
+			ID:                     messageID,
+			Content:                schedule.Content,
+			Title:                  schedule.Title,
+			AllowReplies:           schedule.AllowReplies,
+			RequireAcknowledgement: schedule.RequireAcknowledgement,
+			ReminderAfterHours:     schedule.ReminderAfterHours,
+		})
+	} else {
+		_, err = mc.createMessage(schedule.SenderID, models.MessageRequest{
+			ID:      messageID,
+			GroupID: schedule.GroupID.Hex(),
+			Content: schedule.Content,
+		}, nil)
+	}
+
+	// Client errors mean the author may no longer send this message, except
+	// for slow mode cooldowns, which pass, so the message is retried
+	if httpErr, ok := err.(*echo.HTTPError); ok && httpErr.Code < http.StatusInternalServerError && httpErr.Code != http.StatusTooManyRequests {
+		return fmt.Errorf("%w: %v", scheduler.ErrRejected, httpErr.Message)
+	}
+	return err
+}
+
+// GetScheduledMessages returns the current user's pending scheduled messages,
+// soonest first, optionally only those for ?group_id=
+func (sc *ScheduledMessageController) GetScheduledMessages(c echo.Context) error {
+	// Get user ID from token
+	userID := c.Get("user_id").(string)
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	filter := bson.M{
+		"sender_id": userObjID,
+		"status":    models.ScheduleStatusPending,
+	}
+	if groupID := c.QueryParam("group_id"); groupID != "" {
+		groupObjID, err := primitive.ObjectIDFromHex(groupID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid group ID")
+		}
+		filter["group_id"] = groupObjID
+	}
+
+	schedulesColl := db.GetCollection(sc.DB, sc.Config.DatabaseName, "scheduled_messages")
+	cursor, err := schedulesColl.Find(
+		context.Background(),
+		filter,
+		options.Find().SetSort(bson.D{{Key: "send_at", Value: 1}, {Key: "_id", Value: 1}}),
+	)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer cursor.Close(context.Background())
+
+	schedules := []models.ScheduledMessage{}
+	if err := cursor.All(context.Background(), &schedules); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to decode scheduled messages")
+	}
+
+	return c.JSON(http.StatusOK, schedules)
+}
+
+// UpdateScheduledMessage edits one of the current user's pending scheduled
+// messages. Messages being published at the time can't be edited.
+func (sc *ScheduledMessageController) UpdateScheduledMessage(c echo.Context) error {
+	schedule, err := sc.findOwnSchedule(c)
+	if err != nil {
+		return err
+	}
+
+	// Bind request body
+	var req models.UpdateScheduledMessageRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	// Apply content changes
+	if req.Content != nil {
+		if strings.TrimSpace(*req.Content) == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "Message content is required")
+		}
+		schedule.Content = *req.Content
+	}
+	if req.Title != nil || req.AllowReplies != nil || req.RequireAcknowledgement != nil || req.ReminderAfterHours != nil {
+		if schedule.Type != "announcement" {
+			return echo.NewHTTPError(http.StatusBadRequest, "Only announcements have a title and announcement options")
+		}
+		if req.Title != nil {
+			schedule.Title = *req.Title
+		}
+		if req.AllowReplies != nil {
+			schedule.AllowReplies = req.AllowReplies
+		}
+		if req.RequireAcknowledgement != nil {
+			schedule.RequireAcknowledgement = *req.RequireAcknowledgement
+		}
+		if req.ReminderAfterHours != nil {
+			if _, err := sc.Messages.announcementReminderAfter(req.ReminderAfterHours); err != nil {
+				return err
+			}
+			schedule.ReminderAfterHours = req.ReminderAfterHours
+		}
+	}
+
+	// Apply timing changes; a new send time restarts the recurrence there
+	if req.Recurrence != nil {
+		schedule.Recurrence = *req.Recurrence
+	}
+	if req.Timezone != nil {
+		schedule.Timezone = *req.Timezone
+	}
+	if req.SendAt != nil || req.Recurrence != nil || req.Timezone != nil {
+		sendAt := schedule.SendAt
+		if req.SendAt != nil {
+			sendAt = *req.SendAt
+		}
+		sendAt, timezone, err := scheduleTiming(&sendAt, schedule.Recurrence, schedule.Timezone, time.Now())
+		if err != nil {
+			return err
+		}
+		schedule.Timezone = timezone
+		if req.SendAt != nil {
+			schedule.SendAt = sendAt
+			schedule.FirstSendAt = sendAt
+			schedule.SentCount = 0
+		}
+	}
+	schedule.UpdatedAt = time.Now()
+
+	// Save, unless the scheduler has started publishing it meanwhile
+	schedulesColl := db.GetCollection(sc.DB, sc.Config.DatabaseName, "scheduled_messages")
+	result, err := schedulesColl.ReplaceOne(context.Background(), editableSchedule(schedule.ID), schedule)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update scheduled message")
+	}
+	if result.MatchedCount == 0 {
+		return echo.NewHTTPError(http.StatusConflict, "Scheduled message is being sent")
+	}
+
+	return c.JSON(http.StatusOK, schedule)
+}
+
+// CancelScheduledMessage cancels one of the current user's pending scheduled
+// messages, including any further recurrences
+func (sc *ScheduledMessageController) CancelScheduledMessage(c echo.Context) error {
+	schedule, err := sc.findOwnSchedule(c)
+	if err != nil {
+		return err
+	}
+
+	schedulesColl := db.GetCollection(sc.DB, sc.Config.DatabaseName, "scheduled_messages")
+	result, err := schedulesColl.UpdateOne(
+		context.Background(),
+		editableSchedule(schedule.ID),
+		bson.M{"$set": bson.M{
+			"status":     models.ScheduleStatusCancelled,
+			"updated_at": time.Now(),
+		}},
+	)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to cancel scheduled message")
+	}
+	if result.MatchedCount == 0 {
+		return echo.NewHTTPError(http.StatusConflict, "Scheduled message is being sent")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Scheduled message cancelled"})
+}
+
+// findOwnSchedule loads the pending scheduled message in the id parameter,
+// which must belong to the current user
+func (sc *ScheduledMessageController) findOwnSchedule(c echo.Context) (*models.ScheduledMessage, error) {
+	// Get user ID from token
+	userID := c.Get("user_id").(string)
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	scheduleObjID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid scheduled message ID")
+	}
+
+	schedulesColl := db.GetCollection(sc.DB, sc.Config.DatabaseName, "scheduled_messages")
+	var schedule models.ScheduledMessage
+	err = schedulesColl.FindOne(
+		context.Background(),
+		bson.M{"_id": scheduleObjID, "sender_id": userObjID},
+	).Decode(&schedule)
+	if err == mongo.ErrNoDocuments {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Scheduled message not found")
+	}
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	if schedule.Status != models.ScheduleStatusPending {
+		return nil, echo.NewHTTPError(http.StatusConflict, "Scheduled message is no longer pending")
+	}
+
+	return &schedule, nil
+}
+
+// editableSchedule matches a pending scheduled message no scheduler holds a
+// live lease on
+func editableSchedule(scheduleObjID primitive.ObjectID) bson.M {
+	return bson.M{
+		"_id":    scheduleObjID,
+		"status": models.ScheduleStatusPending,
+		"$or": bson.A{
+			bson.M{"lease_until": bson.M{"$exists": false}},
+			bson.M{"lease_until": bson.M{"$lt": time.Now()}},
+		},
+	}
+}
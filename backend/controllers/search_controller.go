@@ -0,0 +1,284 @@
+package controllers
+
+import (
+	"chatterbloom/backend/config"
+	"chatterbloom/backend/db"
+	"chatterbloom/backend/models"
+	"chatterbloom/backend/search"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// searchSnippetWidth is roughly how many characters a result snippet has
+	searchSnippetWidth = 160
+
+	// maxSearchScan bounds how many messages the in-process fallback looks
+	// at per request
+	maxSearchScan = 2000
+
+	// errCodeIndexNotFound is returned by MongoDB for $text queries on a
+	// collection without a text index
+	errCodeIndexNotFound = 27
+)
+
+// SearchController handles message search
+type SearchController struct {
+	DB       *mongo.Client
+	Config   *config.Config
+	Messages *MessageController
+}
+
+// SearchMessages finds messages containing every word of q in the groups the
+// user belongs to, newest first. Results can be narrowed with group_id,
+// sender_id, type, from and to, and are paged with cursor.
+func (sc *SearchController) SearchMessages(c echo.Context) error {
+	// Get user ID from token
+	userID := c.Get("user_id").(string)
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	terms := search.Terms(c.QueryParam("q"))
+	if len(terms) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Search query must contain a word of at least 2 characters")
+	}
+
+	// Get limit from query parameters
+	limit := 20 // Default limit
+	if limitParam := c.QueryParam("limit"); limitParam != "" {
+		if parsedLimit, err := strconv.Atoi(limitParam); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+	if limit > maxMessagePageSize {
+		limit = maxMessagePageSize
+	}
+
+	// Only search groups the user belongs to
+	groupIDs, err := sc.userGroupIDs(userObjID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	filter := bson.M{"deleted_at": bson.M{"$exists": false}}
+	if groupID := c.QueryParam("group_id"); groupID != "" {
+		groupObjID, err := primitive.ObjectIDFromHex(groupID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid group ID")
+		}
+		if !containsObjectID(groupIDs, groupObjID) {
+			return echo.NewHTTPError(http.StatusForbidden, "You are not a member of this group")
+		}
+		filter["group_id"] = groupObjID
+	} else {
+		filter["group_id"] = bson.M{"$in": groupIDs}
+	}
+
+	// Apply filters
+	if senderID := c.QueryParam("sender_id"); senderID != "" {
+		senderObjID, err := primitive.ObjectIDFromHex(senderID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid sender ID")
+		}
+		filter["sender_id"] = senderObjID
+	}
+
+	if messageType := c.QueryParam("type"); messageType != "" {
+		if messageType != "regular" && messageType != "announcement" {
+			return echo.NewHTTPError(http.StatusBadRequest, "Type must be regular or announcement")
+		}
+		filter["type"] = messageType
+	}
+
+	createdAt := bson.M{}
+	if from := c.QueryParam("from"); from != "" {
+		fromTime, _, err := parseDateParam(from)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid from date")
+		}
+		createdAt["$gte"] = fromTime
+	}
+	if to := c.QueryParam("to"); to != "" {
+		toTime, dateOnly, err := parseDateParam(to)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid to date")
+		}
+		if dateOnly {
+			// A date on its own includes the whole day
+			createdAt["$lt"] = toTime.AddDate(0, 0, 1)
+		} else {
+			createdAt["$lte"] = toTime
+		}
+	}
+	if len(createdAt) > 0 {
+		filter["created_at"] = createdAt
+	}
+
+	var key *messageKey
+	if cursorParam := c.QueryParam("cursor"); cursorParam != "" {
+		decoded, err := decodeCursor(cursorParam)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid cursor")
+		}
+		key = &decoded
+	}
+
+	page := models.MessageSearchPage{Results: []models.MessageSearchResult{}}
+	if len(groupIDs) == 0 {
+		return c.JSON(http.StatusOK, page)
+	}
+
+	// Search with the text index, scanning in process where there is none
+	messages, next, err := sc.textSearch(filter, terms, key, limit)
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(errCodeIndexNotFound) {
+		messages, next, err = sc.scanSearch(filter, terms, key, limit)
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Search failed")
+	}
+
+	// Prepare response with sender information and snippets
+	for i, messageResponse := range sc.Messages.messagesWithSenders(messages) {
+		page.Results = append(page.Results, models.MessageSearchResult{
+			Message: messageResponse,
+			Snippet: search.Snippet(searchableText(&messages[i]), terms, searchSnippetWidth),
+		})
+	}
+	if next != nil {
+		page.NextCursor = encodeCursor(*next)
+		page.HasMore = true
+	}
+
+	return c.JSON(http.StatusOK, page)
+}
+
+// textSearch finds matching messages with the MongoDB text index. It returns
+// the position to continue from if there are more.
+func (sc *SearchController) textSearch(filter bson.M, terms []string, key *messageKey, limit int) ([]models.Message, *messageKey, error) {
+	query := bson.M{"$text": bson.M{"$search": search.TextQuery(terms)}}
+	for field, value := range filter {
+		query[field] = value
+	}
+
+	messages, hasMore, err := sc.Messages.findMessagesFrom(query, key, true, limit)
+	if err != nil || !hasMore {
+		return messages, nil, err
+	}
+	next := keyOf(&messages[len(messages)-1])
+	return messages, &next, nil
+}
+
+// scanSearch finds matching messages by reading candidates newest first and
+// matching them in process. It stops after maxSearchScan messages, returning
+// the last one read as the position to continue from.
+func (sc *SearchController) scanSearch(filter bson.M, terms []string, key *messageKey, limit int) ([]models.Message, *messageKey, error) {
+	query := bson.M{}
+	for field, value := range filter {
+		query[field] = value
+	}
+	if key != nil {
+		query["$or"] = beyondKey(*key, true)
+	}
+
+	messagesColl := db.GetCollection(sc.DB, sc.Config.DatabaseName, "messages")
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(maxSearchScan)
+
+	cursor, err := messagesColl.Find(context.Background(), query, findOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var matches []models.Message
+	scanned := 0
+	for cursor.Next(context.Background()) {
+		var message models.Message
+		if err := cursor.Decode(&message); err != nil {
+			return nil, nil, err
+		}
+		scanned++
+
+		if search.Matches(searchableText(&message), terms) {
+			if len(matches) == limit {
+				next := keyOf(&matches[limit-1])
+				return matches, &next, nil
+			}
+			matches = append(matches, message)
+		}
+		if scanned == maxSearchScan {
+			next := keyOf(&message)
+			return matches, &next, nil
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return matches, nil, nil
+}
+
+// userGroupIDs returns the IDs of the groups a user belongs to
+func (sc *SearchController) userGroupIDs(userObjID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	membersColl := db.GetCollection(sc.DB, sc.Config.DatabaseName, "group_members")
+	cursor, err := membersColl.Find(
+		context.Background(),
+		bson.M{"user_id": userObjID},
+		options.Find().SetProjection(bson.M{"group_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var memberships []models.GroupMember
+	if err := cursor.All(context.Background(), &memberships); err != nil {
+		return nil, err
+	}
+
+	groupIDs := make([]primitive.ObjectID, 0, len(memberships))
+	for _, membership := range memberships {
+		groupIDs = append(groupIDs, membership.GroupID)
+	}
+	return groupIDs, nil
+}
+
+// searchableText is the text of a message that search matches against
+func searchableText(message *models.Message) string {
+	if message.Title == "" {
+		return message.Content
+	}
+	return message.Title + ": " + message.Content
+}
+
+// parseDateParam parses an RFC 3339 time or a plain date, reporting which
+func parseDateParam(value string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, false, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	return t, true, err
+}
+
+// containsObjectID reports whether ids contains id
+func containsObjectID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,271 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"chatterbloom/backend/constants"
+	"chatterbloom/backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// TestSearchMessages pages through the results of a search, with the text
+// index and with the in-process fallback used where there is none
+func TestSearchMessages(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("ENVIRONMENT", "production")
+
+	indexNotFound := mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 27, Name: "IndexNotFound", Message: "text index required for $text query"})
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for _, fallback := range []bool{false, true} {
+		name := "text index"
+		if fallback {
+			name = "fallback"
+		}
+		mt.Run(name, func(mt *mtest.T) {
+			caller := primitive.NewObjectID()
+			groups := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID()}
+			memberships := cursor("group_members",
+				memberDoc(groups[0], caller, models.GroupRoleMember),
+				memberDoc(groups[1], caller, models.GroupRoleMember),
+			)
+			newest := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+			first := searchDoc(groups[0], "Field trip on Friday", newest)
+			second := searchDoc(groups[1], "Permission slips for the field trip", newest.Add(-time.Hour))
+			third := searchDoc(groups[0], "Trip to the science field museum", newest.Add(-2*time.Hour))
+			unrelated := searchDoc(groups[1], "Field day", newest.Add(-90*time.Minute))
+
+			// The text index only returns matches, one more than asked for;
+			// the fallback reads every candidate
+			if fallback {
+				mt.AddMockResponses(memberships, indexNotFound, cursor("messages", first, unrelated, second, third), cursor("users"))
+			} else {
+				mt.AddMockResponses(memberships, cursor("messages", first, second, third), cursor("users"))
+			}
+
+			e := newServer(mt)
+			page := searchAs(mt, e, caller, "q=field+TRIP&limit=2", http.StatusOK)
+			if len(page.Results) != 2 || page.Results[0].Message.ID != idOf(first).Hex() || page.Results[1].Message.ID != idOf(second).Hex() {
+				mt.Fatalf("got results %+v", page.Results)
+			}
+			if !page.HasMore || page.NextCursor == "" {
+				mt.Errorf("got no next page")
+			}
+			if snippet := page.Results[0].Snippet; snippet != "<mark>Field</mark> <mark>trip</mark> on Friday" {
+				mt.Errorf("got snippet %s", snippet)
+			}
+
+			find := messageFinds(mt)[len(messageFinds(mt))-1]
+			filter := find.Lookup("filter").Document()
+			groupIDs, _ := filter.Lookup("group_id", "$in").Array().Values()
+			if len(groupIDs) != 2 || groupIDs[0].ObjectID() != groups[0] || groupIDs[1].ObjectID() != groups[1] {
+				mt.Errorf("searched groups %s, want the caller's", filter.Lookup("group_id"))
+			}
+			if _, err := filter.LookupErr("deleted_at", "$exists"); err != nil {
+				mt.Error("searched deleted messages")
+			}
+			if fallback {
+				if _, err := filter.LookupErr("$text"); err == nil {
+					mt.Error("scanned with a $text query")
+				}
+				if limit := find.Lookup("limit").Int64(); limit != 2000 {
+					mt.Errorf("scanned up to %d messages", limit)
+				}
+			} else {
+				if query := filter.Lookup("$text", "$search").StringValue(); query != `"field" "trip"` {
+					mt.Errorf("got text query %s", query)
+				}
+				if limit := find.Lookup("limit").Int64(); limit != 3 {
+					mt.Errorf("got limit %d, want one more than the page", limit)
+				}
+			}
+			if sort := find.Lookup("sort", "created_at").Int32(); sort != -1 {
+				mt.Errorf("got results in created_at order %d, want newest first", sort)
+			}
+
+			// The next page starts after the last result
+			mt.ClearEvents()
+			if fallback {
+				mt.AddMockResponses(memberships, indexNotFound, cursor("messages", third), cursor("users"))
+			} else {
+				mt.AddMockResponses(memberships, cursor("messages", third), cursor("users"))
+			}
+			page = searchAs(mt, e, caller, "q=field+trip&limit=2&cursor="+page.NextCursor, http.StatusOK)
+			if len(page.Results) != 1 || page.Results[0].Message.ID != idOf(third).Hex() || page.HasMore || page.NextCursor != "" {
+				mt.Errorf("got second page %+v", page)
+			}
+
+			finds := messageFinds(mt)
+			after := finds[len(finds)-1].Lookup("filter", "$or").Array()
+			if older := after.Index(0).Value().Document().Lookup("created_at", "$lt").Time(); !older.Equal(newest.Add(-time.Hour)) {
+				mt.Errorf("continued from %s, want after the last result", older)
+			}
+			if id := after.Index(1).Value().Document().Lookup("_id", "$lt").ObjectID(); id != idOf(second) {
+				mt.Errorf("continued from %s, want after the last result", id.Hex())
+			}
+		})
+	}
+}
+
+// TestSearchMessagesFilters checks that searches only cover the caller's
+// groups and apply the filters given
+func TestSearchMessagesFilters(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("ENVIRONMENT", "production")
+
+	sender := primitive.NewObjectID()
+	cases := []struct {
+		name, query string
+		member      bool // Whether the caller belongs to a group
+		want        int
+		// Expected fields of the search's filter, by path
+		wantFilter map[string]interface{}
+	}{
+		{
+			name:       "own group",
+			query:      "q=trip&group_id=:group",
+			member:     true,
+			want:       http.StatusOK,
+			wantFilter: map[string]interface{}{"group_id": ":group"},
+		},
+		{
+			name:   "another group",
+			query:  "q=trip&group_id=" + primitive.NewObjectID().Hex(),
+			member: true,
+			want:   http.StatusForbidden,
+		},
+		{
+			// Without groups there is nothing to search
+			name:  "no groups",
+			query: "q=trip",
+			want:  http.StatusOK,
+		},
+		{
+			name:   "filters",
+			query:  "q=trip&sender_id=" + sender.Hex() + "&type=announcement&from=2024-01-01&to=2024-01-31",
+			member: true,
+			want:   http.StatusOK,
+			wantFilter: map[string]interface{}{
+				"sender_id": sender,
+				"type":      "announcement",
+				// A date on its own includes the whole day
+				"created_at.$gte": time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				"created_at.$lt":  time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:   "time range",
+			query:  "q=trip&to=" + url.QueryEscape("2024-01-31T15:04:05Z"),
+			member: true,
+			want:   http.StatusOK,
+			wantFilter: map[string]interface{}{
+				"created_at.$lte": time.Date(2024, 1, 31, 15, 4, 5, 0, time.UTC),
+			},
+		},
+		{name: "short words", query: "q=a+b", want: http.StatusBadRequest},
+		{name: "invalid type", query: "q=trip&type=poll", member: true, want: http.StatusBadRequest},
+		{name: "invalid cursor", query: "q=trip&cursor=nope", member: true, want: http.StatusBadRequest},
+	}
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for _, c := range cases {
+		mt.Run(c.name, func(mt *mtest.T) {
+			caller, group := primitive.NewObjectID(), primitive.NewObjectID()
+			if c.member {
+				mt.AddMockResponses(cursor("group_members", memberDoc(group, caller, models.GroupRoleMember)))
+			} else {
+				mt.AddMockResponses(cursor("group_members"))
+			}
+			mt.AddMockResponses(cursor("messages", searchDoc(group, "Field trip", time.Now())), cursor("users"))
+
+			query := strings.ReplaceAll(c.query, ":group", group.Hex())
+			page := searchAs(mt, newServer(mt), caller, query, c.want)
+
+			finds := messageFinds(mt)
+			if c.wantFilter == nil {
+				if len(finds) != 0 {
+					mt.Errorf("searched messages: %s", finds[0].Lookup("filter"))
+				}
+				if c.want == http.StatusOK && len(page.Results) != 0 {
+					mt.Errorf("got results %+v", page.Results)
+				}
+				return
+			}
+			if len(finds) != 1 {
+				mt.Fatalf("searched %d times, want 1", len(finds))
+			}
+
+			filter := finds[0].Lookup("filter").Document()
+			for path, want := range c.wantFilter {
+				got := filter.Lookup(strings.Split(path, ".")...)
+				switch want := want.(type) {
+				case string:
+					if want == ":group" {
+						if got.ObjectID() != group {
+							mt.Errorf("got %s %s, want the group", path, got)
+						}
+					} else if got.StringValue() != want {
+						mt.Errorf("got %s %s, want %s", path, got, want)
+					}
+				case primitive.ObjectID:
+					if got.ObjectID() != want {
+						mt.Errorf("got %s %s, want %s", path, got, want.Hex())
+					}
+				case time.Time:
+					if !got.Time().Equal(want) {
+						mt.Errorf("got %s %s, want %s", path, got.Time(), want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// searchAs searches messages as a user and decodes the results
+func searchAs(mt *mtest.T, e http.Handler, userID primitive.ObjectID, query string, want int) models.MessageSearchPage {
+	rec := sendAs(mt, e, http.MethodGet, "/api/search/messages?"+query, "", userID, constants.RoleParent)
+	if rec.Code != want {
+		mt.Fatalf("got status %d, want %d: %s", rec.Code, want, rec.Body)
+	}
+	var page models.MessageSearchPage
+	if want == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			mt.Fatal(err)
+		}
+	}
+	return page
+}
+
+// messageFinds returns the finds on the messages collection
+func messageFinds(mt *mtest.T) []bson.Raw {
+	var finds []bson.Raw
+	for _, started := range mt.GetAllStartedEvents() {
+		if started.CommandName == "find" && started.Command.Lookup("find").StringValue() == "messages" {
+			finds = append(finds, started.Command)
+		}
+	}
+	return finds
+}
+
+func searchDoc(groupID primitive.ObjectID, content string, createdAt time.Time) bson.D {
+	return bson.D{
+		{Key: "_id", Value: primitive.NewObjectID()},
+		{Key: "group_id", Value: groupID},
+		{Key: "sender_id", Value: primitive.NewObjectID()},
+		{Key: "type", Value: "regular"},
+		{Key: "content", Value: content},
+		{Key: "created_at", Value: createdAt},
+	}
+}
+
+func idOf(doc bson.D) primitive.ObjectID {
+	return doc[0].Value.(primitive.ObjectID)
+}
@@ -0,0 +1,249 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"chatterbloom/backend/constants"
+	"chatterbloom/backend/models"
+	"chatterbloom/backend/protocol"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// TestReply checks which messages can be replied to, and that a reply
+// updates its thread's summary on the root
+func TestReply(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("ENVIRONMENT", "production")
+
+	lastReplyAt := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+
+	cases := []struct {
+		name string
+		// The message replied to
+		parent func(ids testIDs) bson.D
+		want   int
+	}{
+		{name: "reply", parent: messageDoc, want: http.StatusCreated},
+		{
+			name: "reply to a reply",
+			parent: func(ids testIDs) bson.D {
+				return append(ownMessageDoc(ids), bson.E{Key: "parent_id", Value: ids.target}, bson.E{Key: "thread_root_id", Value: ids.target})
+			},
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "replies disabled",
+			parent: func(ids testIDs) bson.D {
+				return append(messageDoc(ids), bson.E{Key: "replies_disabled", Value: true})
+			},
+			want: http.StatusForbidden,
+		},
+		{
+			name: "parent in another group",
+			parent: func(ids testIDs) bson.D {
+				doc := messageDoc(ids)
+				doc[1].Value = primitive.NewObjectID()
+				return doc
+			},
+			want: http.StatusBadRequest,
+		},
+		{name: "deleted parent", parent: tombstoneDoc(messageDoc), want: http.StatusGone},
+	}
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for _, c := range cases {
+		mt.Run(c.name, func(mt *mtest.T) {
+			ids := testIDs{group: primitive.NewObjectID(), message: primitive.NewObjectID(), caller: primitive.NewObjectID(), target: primitive.NewObjectID()}
+			mt.AddMockResponses(
+				cursor("group_members", memberDoc(ids.group, ids.caller, models.GroupRoleMember)),
+				cursor("chat_groups", groupDoc(ids)),
+				cursor("messages", c.parent(ids)),
+			)
+			if c.want == http.StatusCreated {
+				// The root with its updated summary
+				root := append(messageDoc(ids),
+					bson.E{Key: "reply_count", Value: 4},
+					bson.E{Key: "last_reply_at", Value: lastReplyAt},
+				)
+				mt.AddMockResponses(
+					mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+					mtest.CreateSuccessResponse(bson.E{Key: "value", Value: root}),
+					cursor("users"),
+				)
+			}
+
+			e, events := newServerWithEvents(mt)
+			body := `{"group_id":"` + ids.group.Hex() + `","content":"Can we bring snacks?","parent_id":"` + ids.message.Hex() + `"}`
+			rec := sendAs(mt, e, http.MethodPost, "/api/messages", body, ids.caller, constants.RoleParent)
+			if rec.Code != c.want {
+				mt.Fatalf("got status %d, want %d: %s", rec.Code, c.want, rec.Body)
+			}
+
+			var inserted, summary bson.Raw
+			for _, started := range mt.GetAllStartedEvents() {
+				switch started.CommandName {
+				case "insert":
+					inserted = started.Command.Lookup("documents", "0").Document()
+				case "findAndModify":
+					summary = started.Command
+				}
+			}
+			if c.want != http.StatusCreated {
+				if inserted != nil || summary != nil {
+					mt.Errorf("stored a reply that was refused")
+				}
+				return
+			}
+
+			// The reply points at the root, whose summary is updated
+			if inserted == nil || summary == nil {
+				mt.Fatal("didn't store the reply and update the thread")
+			}
+			if parent := inserted.Lookup("parent_id").ObjectID(); parent != ids.message {
+				mt.Errorf("got parent %s, want %s", parent.Hex(), ids.message.Hex())
+			}
+			if root := inserted.Lookup("thread_root_id").ObjectID(); root != ids.message {
+				mt.Errorf("got thread root %s, want %s", root.Hex(), ids.message.Hex())
+			}
+			if root := summary.Lookup("query", "_id").ObjectID(); root != ids.message {
+				mt.Errorf("updated the summary of %s, want %s", root.Hex(), ids.message.Hex())
+			}
+			if inc := summary.Lookup("update", "$inc", "reply_count").Int32(); inc != 1 {
+				mt.Errorf("incremented reply_count by %d", inc)
+			}
+			// Concurrent replies can't move last_reply_at back
+			sentAt := inserted.Lookup("created_at").Time()
+			if last := summary.Lookup("update", "$max", "last_reply_at").Time(); !last.Equal(sentAt) {
+				mt.Errorf("set last_reply_at to %s, want the reply's %s", last, sentAt)
+			}
+
+			room, event := nextEvent(mt, events)
+			if room != ids.group.Hex() || event.Type != protocol.TypeThreadReply {
+				mt.Fatalf("got %s event in room %s, want %s in the group", event.Type, room, protocol.TypeThreadReply)
+			}
+			var payload protocol.ThreadReplyPayload
+			if err := json.Unmarshal(event.Payload, &payload); err != nil {
+				mt.Fatal(err)
+			}
+			// The summary is the root's as updated, not the reply's view of it
+			if payload.ThreadRootID != ids.message.Hex() || payload.ReplyCount != 4 || !payload.LastReplyAt.Equal(lastReplyAt) {
+				mt.Errorf("got event %s", event.Payload)
+			}
+			if payload.Message.ThreadRootID != ids.message.Hex() || payload.Message.Content != "Can we bring snacks?" {
+				mt.Errorf("got reply %+v", payload.Message)
+			}
+		})
+	}
+}
+
+// TestGetThread checks that a thread is found from its root or any reply,
+// and that its replies are paged oldest first
+func TestGetThread(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("ENVIRONMENT", "production")
+
+	cases := []struct {
+		name, query string
+		fromReply   bool // Whether the thread is asked for by the ID of a reply
+		member      bool
+		want        int
+		// Expected paging
+		wantSkip, wantLimit int64
+	}{
+		{name: "first page", member: true, want: http.StatusOK, wantLimit: 50},
+		{name: "later page", query: "?limit=2&offset=2", member: true, want: http.StatusOK, wantSkip: 2, wantLimit: 2},
+		{name: "invalid paging", query: "?limit=0&offset=-1", member: true, want: http.StatusOK, wantLimit: 50},
+		{name: "from a reply", fromReply: true, member: true, want: http.StatusOK, wantLimit: 50},
+		{name: "non-member", want: http.StatusForbidden},
+	}
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for _, c := range cases {
+		mt.Run(c.name, func(mt *mtest.T) {
+			ids := testIDs{group: primitive.NewObjectID(), message: primitive.NewObjectID(), caller: primitive.NewObjectID(), target: primitive.NewObjectID()}
+			replies := []bson.D{replyDoc(ids, "See you there"), replyDoc(ids, "Thanks!")}
+
+			requested := ids.message
+			if c.fromReply {
+				requested = replies[1][0].Value.(primitive.ObjectID)
+				mt.AddMockResponses(cursor("messages", replies[1]))
+			}
+			mt.AddMockResponses(cursor("messages", append(messageDoc(ids), bson.E{Key: "reply_count", Value: 2})))
+			if c.member {
+				mt.AddMockResponses(
+					cursor("group_members", memberDoc(ids.group, ids.caller, models.GroupRoleMember)),
+					cursor("messages", replies...),
+					cursor("users"),
+				)
+			} else {
+				mt.AddMockResponses(cursor("group_members"))
+			}
+
+			rec := sendAs(mt, newServer(mt), http.MethodGet, "/api/messages/"+requested.Hex()+"/thread"+c.query, "", ids.caller, constants.RoleParent)
+			if rec.Code != c.want {
+				mt.Fatalf("got status %d, want %d: %s", rec.Code, c.want, rec.Body)
+			}
+			if c.want != http.StatusOK {
+				return
+			}
+
+			var thread struct {
+				Root    models.MessageResponse   `json:"root"`
+				Replies []models.MessageResponse `json:"replies"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &thread); err != nil {
+				mt.Fatal(err)
+			}
+			if thread.Root.ID != ids.message.Hex() || thread.Root.ReplyCount != 2 {
+				mt.Errorf("got root %+v", thread.Root)
+			}
+			if len(thread.Replies) != 2 || thread.Replies[0].Content != "See you there" {
+				mt.Errorf("got replies %+v", thread.Replies)
+			}
+
+			var find bson.Raw
+			for _, started := range mt.GetAllStartedEvents() {
+				if started.CommandName == "find" && started.Command.Lookup("find").StringValue() == "messages" {
+					if _, err := started.Command.LookupErr("filter", "thread_root_id"); err == nil {
+						find = started.Command
+					}
+				}
+			}
+			if find == nil {
+				mt.Fatal("didn't look up the replies")
+			}
+			if root := find.Lookup("filter", "thread_root_id").ObjectID(); root != ids.message {
+				mt.Errorf("got replies of %s, want %s", root.Hex(), ids.message.Hex())
+			}
+			sort := find.Lookup("sort").Document()
+			if elements, _ := sort.Elements(); len(elements) != 2 || elements[0].Key() != "created_at" || elements[0].Value().Int32() != 1 || elements[1].Key() != "_id" {
+				mt.Errorf("got sort %s, want oldest first", sort)
+			}
+			skip, _ := find.Lookup("skip").AsInt64OK()
+			limit, _ := find.Lookup("limit").AsInt64OK()
+			if skip != c.wantSkip || limit != c.wantLimit {
+				mt.Errorf("got skip %d and limit %d, want %d and %d", skip, limit, c.wantSkip, c.wantLimit)
+			}
+		})
+	}
+}
+
+// replyDoc is a reply by the target in the thread of the message
+func replyDoc(ids testIDs, content string) bson.D {
+	return bson.D{
+		{Key: "_id", Value: primitive.NewObjectID()},
+		{Key: "group_id", Value: ids.group},
+		{Key: "sender_id", Value: ids.target},
+		{Key: "type", Value: "regular"},
+		{Key: "content", Value: content},
+		{Key: "parent_id", Value: ids.message},
+		{Key: "thread_root_id", Value: ids.message},
+		{Key: "created_at", Value: time.Now()},
+	}
+}
@@ -0,0 +1,266 @@
+package controllers
+
+import (
+	"chatterbloom/backend/config"
+	"chatterbloom/backend/db"
+	"chatterbloom/backend/middleware"
+	"chatterbloom/backend/models"
+	"chatterbloom/backend/protocol"
+	"chatterbloom/backend/websocket"
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// wsTicketTTL is how long a WebSocket ticket stays valid after it is issued
+const wsTicketTTL = 30 * time.Second
+
+// wsBearerProtocol is the Sec-WebSocket-Protocol entry that precedes a token
+const wsBearerProtocol = "bearer"
+
+// WebSocketController handles WebSocket handshakes and tickets, and acts as
+// the websocket.Handler for frames sent over established connections
+type WebSocketController struct {
+	DB       *mongo.Client
+	Config   *config.Config
+	Hub      *websocket.Hub
+	Messages *MessageController
+}
+
+// IssueTicket returns a short-lived ticket that can be passed as the
+// `ticket` query parameter of /ws, for clients that can't set headers
+func (wc *WebSocketController) IssueTicket(c echo.Context) error {
+	userID := c.Get("user_id").(string)
+	userRole := c.Get("user_role").(string)
+
+	// Create token
+	token := jwt.New(jwt.SigningMethodHS256)
+
+	// Set claims
+	expiresAt := time.Now().Add(wsTicketTTL)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["user_id"] = userID
+	claims["role"] = userRole
+	claims["purpose"] = middleware.PurposeWSTicket
+	claims["exp"] = expiresAt.Unix()
+
+	// Generate encoded token
+	ticket, err := token.SignedString([]byte(wc.Config.JWTSecret))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to generate ticket")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"ticket":     ticket,
+		"expires_at": expiresAt,
+	})
+}
+
+// Connect authenticates the handshake and upgrades it to a WebSocket connection
+func (wc *WebSocketController) Connect(c echo.Context) error {
+	claims, subprotocol, err := wc.authenticate(c.Request())
+	if err != nil {
+		return err
+	}
+
+	// Subscribe the connection to every group the user belongs to
+	roomIDs, err := wc.userGroupIDs(claims.UserID)
+	if err != nil {
+		return err
+	}
+
+	// A requested room must be one of them
+	if roomID := c.QueryParam("roomId"); roomID != "" && !containsString(roomIDs, roomID) {
+		return echo.NewHTTPError(http.StatusForbidden, "You are not a member of this group")
+	}
+
+	// Browsers refuse the connection unless the chosen subprotocol is echoed back
+	responseHeader := http.Header{}
+	if subprotocol != "" {
+		responseHeader.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+
+	return websocket.ServeWs(wc.Hub, c, claims.UserID, roomIDs, wc, responseHeader)
+}
+
+// CanJoinRoom implements websocket.Handler for subscribe and resume frames:
+// a user may join a group's room only if group_members lists them in it
+func (wc *WebSocketController) CanJoinRoom(userID, roomID string) (bool, error) {
+	return wc.isGroupMember(userID, roomID)
+}
+
+// SendMessage implements websocket.Handler for send_message frames
+func (wc *WebSocketController) SendMessage(userID string, payload protocol.SendMessagePayload) (*models.MessageResponse, error) {
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	message, err := wc.Messages.createMessage(userObjID, models.MessageRequest{
+		GroupID:  payload.GroupID,
+		Content:  payload.Content,
+		ParentID: payload.ParentID,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// MarkRead implements websocket.Handler for mark_read frames
+func (wc *WebSocketController) MarkRead(userID string, payload protocol.MarkReadPayload) error {
+	return wc.Messages.markRead(userID, payload.MessageID)
+}
+
+// UpdateLastSeen implements websocket.Handler by persisting last_seen_at
+func (wc *WebSocketController) UpdateLastSeen(userID string, at time.Time) error {
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+
+	usersColl := db.GetCollection(wc.DB, wc.Config.DatabaseName, "users")
+	_, err = usersColl.UpdateOne(
+		context.Background(),
+		bson.M{"_id": userObjID},
+		bson.M{"$set": bson.M{"last_seen_at": at}},
+	)
+	return err
+}
+
+// authenticate extracts and validates the token from the handshake request.
+// It accepts, in order, an Authorization bearer header, a
+// "bearer, <token>" Sec-WebSocket-Protocol pair, or a `ticket` query parameter.
+func (wc *WebSocketController) authenticate(r *http.Request) (*middleware.TokenClaims, string, error) {
+	// Authorization header
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			return nil, "", echo.NewHTTPError(http.StatusUnauthorized, "Invalid authorization header format")
+		}
+		claims, err := wc.parseSessionToken(parts[1])
+		return claims, "", err
+	}
+
+	// Sec-WebSocket-Protocol header
+	protocols := websocketSubprotocols(r)
+	for i, protocol := range protocols {
+		if protocol == wsBearerProtocol && i+1 < len(protocols) {
+			claims, err := wc.parseSessionToken(protocols[i+1])
+			return claims, wsBearerProtocol, err
+		}
+	}
+
+	// Ticket query parameter
+	if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+		claims, err := middleware.ParseToken(wc.Config, ticket)
+		if err != nil {
+			return nil, "", err
+		}
+		if claims.Purpose != middleware.PurposeWSTicket {
+			return nil, "", echo.NewHTTPError(http.StatusUnauthorized, "Invalid ticket")
+		}
+		return claims, "", nil
+	}
+
+	return nil, "", echo.NewHTTPError(http.StatusUnauthorized, "Missing authorization")
+}
+
+// parseSessionToken validates a regular session token, rejecting tickets
+func (wc *WebSocketController) parseSessionToken(tokenString string) (*middleware.TokenClaims, error) {
+	claims, err := middleware.ParseToken(wc.Config, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Invalid token")
+	}
+	return claims, nil
+}
+
+// isGroupMember checks group_members for the given user and group
+func (wc *WebSocketController) isGroupMember(userID, groupID string) (bool, error) {
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return false, echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+	groupObjID, err := primitive.ObjectIDFromHex(groupID)
+	if err != nil {
+		return false, echo.NewHTTPError(http.StatusBadRequest, "Invalid group ID")
+	}
+
+	membersColl := db.GetCollection(wc.DB, wc.Config.DatabaseName, "group_members")
+	count, err := membersColl.CountDocuments(
+		context.Background(),
+		bson.M{
+			"group_id": groupObjID,
+			"user_id":  userObjID,
+		},
+	)
+	if err != nil {
+		return false, echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	return count > 0, nil
+}
+
+// userGroupIDs returns the IDs of every group the user is a member of
+func (wc *WebSocketController) userGroupIDs(userID string) ([]string, error) {
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	membersColl := db.GetCollection(wc.DB, wc.Config.DatabaseName, "group_members")
+	cursor, err := membersColl.Find(
+		context.Background(),
+		bson.M{"user_id": userObjID},
+	)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer cursor.Close(context.Background())
+
+	var memberships []models.GroupMember
+	if err := cursor.All(context.Background(), &memberships); err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to decode group memberships")
+	}
+
+	groupIDs := make([]string, 0, len(memberships))
+	for _, membership := range memberships {
+		groupIDs = append(groupIDs, membership.GroupID.Hex())
+	}
+
+	return groupIDs, nil
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// websocketSubprotocols splits the Sec-WebSocket-Protocol request header
+func websocketSubprotocols(r *http.Request) []string {
+	var protocols []string
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			if protocol = strings.TrimSpace(protocol); protocol != "" {
+				protocols = append(protocols, protocol)
+			}
+		}
+	}
+	return protocols
+}
@@ -0,0 +1,173 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes creates the indexes the application's queries rely on.
+// Creating an index that already exists is a no-op.
+func EnsureIndexes(client *mongo.Client, dbName string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Keyset pagination of a group's messages on (created_at, _id)
+	messagesColl := GetCollection(client, dbName, "messages")
+	_, err := messagesColl.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "group_id", Value: 1},
+				{Key: "created_at", Value: -1},
+				{Key: "_id", Value: -1},
+			},
+		},
+		// Full-text message search
+		{
+			Keys: bson.D{
+				{Key: "title", Value: "text"},
+				{Key: "content", Value: "text"},
+			},
+			Options: options.Index().SetName("messages_text"),
+		},
+		// Announcements waiting to send acknowledgement reminders
+		{
+			Keys:    bson.D{{Key: "reminder_at", Value: 1}},
+			Options: options.Index().SetSparse(true),
+		},
+		// A campaign's per-group announcements
+		{
+			Keys:    bson.D{{Key: "campaign_id", Value: 1}},
+			Options: options.Index().SetSparse(true),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	groupsColl := GetCollection(client, dbName, "chat_groups")
+	_, err = groupsColl.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		// One direct conversation per pair of users
+		{
+			Keys:    bson.D{{Key: "pair_key", Value: 1}},
+			Options: options.Index().SetUnique(true).SetSparse(true),
+		},
+		// One parent channel per organizational unit
+		{
+			Keys: bson.D{
+				{Key: "organizational_unit", Value: 1},
+				{Key: "audience", Value: 1},
+			},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"audience": bson.M{"$exists": true}}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// One membership per user and group. Run the dedupe-group-members
+	// migration first on databases that may hold duplicates.
+	membersColl := GetCollection(client, dbName, "group_members")
+	_, err = membersColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "group_id", Value: 1},
+			{Key: "user_id", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	guardianshipsColl := GetCollection(client, dbName, "guardianships")
+	_, err = guardianshipsColl.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		// One link per guardian and student
+		{
+			Keys: bson.D{
+				{Key: "guardian_id", Value: 1},
+				{Key: "student_id", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		},
+		// A student's guardians
+		{
+			Keys: bson.D{{Key: "student_id", Value: 1}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// One read position per user and group
+	readStateColl := GetCollection(client, dbName, "read_state")
+	_, err = readStateColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "user_id", Value: 1},
+			{Key: "group_id", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// One acknowledgement per user and announcement
+	acksColl := GetCollection(client, dbName, "acknowledgements")
+	_, err = acksColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "message_id", Value: 1},
+			{Key: "user_id", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// A user's notifications, newest first
+	notificationsColl := GetCollection(client, dbName, "notifications")
+	_, err = notificationsColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "user_id", Value: 1},
+			{Key: "created_at", Value: -1},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// A sender's campaigns, newest first
+	campaignsColl := GetCollection(client, dbName, "campaigns")
+	_, err = campaignsColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "sender_id", Value: 1},
+			{Key: "created_at", Value: -1},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	schedulesColl := GetCollection(client, dbName, "scheduled_messages")
+	_, err = schedulesColl.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		// Scheduled messages waiting to be published
+		{
+			Keys: bson.D{
+				{Key: "status", Value: 1},
+				{Key: "send_at", Value: 1},
+			},
+		},
+		// An author's scheduled messages, soonest first
+		{
+			Keys: bson.D{
+				{Key: "sender_id", Value: 1},
+				{Key: "status", Value: 1},
+				{Key: "send_at", Value: 1},
+			},
+		},
+	})
+	return err
+}
@@ -2,8 +2,13 @@ package main
 
 import (
 	"chatterbloom/backend/config"
+	"chatterbloom/backend/controllers"
 	"chatterbloom/backend/db"
+	"chatterbloom/backend/notifications"
+	"chatterbloom/backend/policy"
 	"chatterbloom/backend/routes"
+	"chatterbloom/backend/scheduler"
+	"chatterbloom/backend/storage"
 	"chatterbloom/backend/websocket"
 	"context"
 	"fmt"
@@ -32,6 +37,11 @@ func main() {
 		}
 	}()
 
+	// Create indexes
+	if err := db.EnsureIndexes(client, cfg.DatabaseName); err != nil {
+		log.Fatalf("Failed to create indexes: %v", err)
+	}
+
 	// Initialize Echo instance
 	e := echo.New()
 
@@ -40,12 +50,58 @@ func main() {
 	e.Use(middleware.Recover())
 	e.Use(middleware.CORS())
 
-	// Initialize WebSocket hub
-	hub := websocket.NewHub()
+	// Initialize WebSocket hub, sharing events across instances if configured
+	var backplane websocket.Backplane = websocket.NewMemoryBackplane()
+	if cfg.Backplane == "mongo" {
+		backplane, err = websocket.NewMongoBackplane(db.GetCollection(client, cfg.DatabaseName, "hub_events"))
+		if err != nil {
+			log.Fatalf("Failed to initialize MongoDB backplane: %v", err)
+		}
+	}
+	eventLog, err := websocket.NewMongoEventLog(
+		db.GetCollection(client, cfg.DatabaseName, "room_sequences"),
+		db.GetCollection(client, cfg.DatabaseName, "room_events"),
+	)
+	if err != nil {
+		log.Fatalf("Failed to initialize room event log: %v", err)
+	}
+	hub := websocket.NewHub(backplane, eventLog)
 	go hub.Run()
 
+	// Load who may message whom
+	contacts, err := policy.Load(cfg.ContactPolicyFile)
+	if err != nil {
+		log.Fatalf("Failed to load contact policy: %v", err)
+	}
+
+	// Start background jobs
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	notifier := &notifications.Notifier{DB: client, Config: cfg, Hub: hub}
+	ackReminders := &notifications.AckReminders{DB: client, Config: cfg, Notifier: notifier}
+	go ackReminders.Run(jobsCtx)
+	messageController := controllers.NewMessageController(client, cfg, hub, contacts)
+	scheduledMessages := &scheduler.Scheduler{
+		DB:        client,
+		Config:    cfg,
+		Publisher: messageController,
+	}
+	go scheduledMessages.Run(jobsCtx)
+
+	// Initialize attachment storage
+	var blobs storage.BlobStore = storage.NewLocalBlobStore(cfg.UploadDir)
+	if cfg.BlobStore == "s3" {
+		blobs = storage.NewS3BlobStore(storage.S3Config{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+		})
+	}
+
 	// Register routes
-	routes.RegisterRoutes(e, client, hub)
+	routes.RegisterRoutes(e, client, hub, blobs, contacts, messageController)
 
 	// Start server in a goroutine
 	go func() {
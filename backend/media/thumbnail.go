@@ -0,0 +1,122 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+
+	// Register decoders for the image formats we make thumbnails of
+	_ "image/gif"
+	_ "image/png"
+)
+
+const (
+	// ThumbnailSize is the longest side of a generated thumbnail, in pixels
+	ThumbnailSize = 320
+
+	// maxSourcePixels guards against images that are small on disk but
+	// enormous once decoded
+	maxSourcePixels = 40 * 1000 * 1000
+
+	// maxSamples is how many source pixels per axis are averaged into each
+	// thumbnail pixel
+	maxSamples = 4
+)
+
+// ErrImageTooLarge is returned for images with too many pixels to decode safely
+var ErrImageTooLarge = errors.New("image is too large")
+
+// Thumbnail decodes a JPEG, PNG or GIF image read from r and returns a JPEG
+// scaled down to fit within ThumbnailSize, along with the original image's
+// dimensions. The image's size is checked before it is decoded, so r is read
+// twice.
+func Thumbnail(r io.ReadSeeker) (thumb []byte, width, height int, err error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if config.Width*config.Height > maxSourcePixels {
+		return nil, 0, 0, ErrImageTooLarge
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, 0, err
+	}
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleDown(src, ThumbnailSize), &jpeg.Options{Quality: 80}); err != nil {
+		return nil, 0, 0, err
+	}
+	return buf.Bytes(), config.Width, config.Height, nil
+}
+
+// scaleDown resizes src to fit within size x size, keeping its aspect ratio.
+// Each output pixel averages a grid of source pixels from the area it covers.
+func scaleDown(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	dstW, dstH := srcW, srcH
+	if srcW > size || srcH > size {
+		if srcW >= srcH {
+			dstW, dstH = size, srcH*size/srcW
+		} else {
+			dstW, dstH = srcW*size/srcH, size
+		}
+	}
+	if dstW < 1 {
+		dstW = 1
+	}
+	if dstH < 1 {
+		dstH = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		y0, y1 := y*srcH/dstH, (y+1)*srcH/dstH
+		for x := 0; x < dstW; x++ {
+			x0, x1 := x*srcW/dstW, (x+1)*srcW/dstW
+			dst.Set(x, y, average(src, bounds.Min, x0, y0, x1, y1))
+		}
+	}
+	return dst
+}
+
+// average returns the mean color of up to maxSamples x maxSamples pixels
+// spread evenly over the source area [x0,x1) x [y0,y1)
+func average(src image.Image, origin image.Point, x0, y0, x1, y1 int) color.Color {
+	if x1 <= x0 {
+		x1 = x0 + 1
+	}
+	if y1 <= y0 {
+		y1 = y0 + 1
+	}
+	stepX := (x1 - x0 + maxSamples - 1) / maxSamples
+	stepY := (y1 - y0 + maxSamples - 1) / maxSamples
+
+	var r, g, b, a, n uint32
+	for y := y0; y < y1; y += stepY {
+		for x := x0; x < x1; x += stepX {
+			pr, pg, pb, pa := src.At(origin.X+x, origin.Y+y).RGBA()
+			r, g, b, a = r+pr, g+pg, b+pb, a+pa
+			n++
+		}
+	}
+
+	// Thumbnails are JPEGs, so composite onto white instead of keeping alpha
+	r, g, b, a = r/n, g/n, b/n, a/n
+	white := 0xffff - a
+	return color.RGBA64{
+		R: uint16(r + white),
+		G: uint16(g + white),
+		B: uint16(b + white),
+		A: 0xffff,
+	}
+}
@@ -10,6 +10,57 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
+// PurposeWSTicket marks a short-lived token that may only be used for the WebSocket handshake
+const PurposeWSTicket = "ws_ticket"
+
+// TokenClaims holds the identity carried by a validated token
+type TokenClaims struct {
+	UserID  string
+	Role    string
+	Purpose string
+}
+
+// ParseToken validates a JWT string and extracts its claims
+func ParseToken(config *config.Config, tokenString string) (*TokenClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		// Validate signing method
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, echo.NewHTTPError(http.StatusUnauthorized, "Invalid token signing method")
+		}
+		return []byte(config.JWTSecret), nil
+	})
+
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Invalid token: "+err.Error())
+	}
+
+	// Validate token
+	if !token.Valid {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Invalid token")
+	}
+
+	// Extract claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Invalid token claims")
+	}
+
+	userID, ok := claims["user_id"].(string)
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Invalid user ID in token")
+	}
+
+	role, ok := claims["role"].(string)
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Invalid role in token")
+	}
+
+	// Purpose is optional; regular session tokens don't carry one
+	purpose, _ := claims["purpose"].(string)
+
+	return &TokenClaims{UserID: userID, Role: role, Purpose: purpose}, nil
+}
+
 // JWTAuth returns a middleware that validates JWT tokens
 func JWTAuth(config *config.Config) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
@@ -27,43 +78,19 @@ func JWTAuth(config *config.Config) echo.MiddlewareFunc {
 			}
 
 			// Parse token
-			tokenString := parts[1]
-			token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-				// Validate signing method
-				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-					return nil, echo.NewHTTPError(http.StatusUnauthorized, "Invalid token signing method")
-				}
-				return []byte(config.JWTSecret), nil
-			})
-
+			claims, err := ParseToken(config, parts[1])
 			if err != nil {
-				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token: "+err.Error())
+				return err
 			}
 
-			// Validate token
-			if !token.Valid {
+			// Tickets are only good for the WebSocket handshake
+			if claims.Purpose != "" {
 				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token")
 			}
 
-			// Extract claims
-			claims, ok := token.Claims.(jwt.MapClaims)
-			if !ok {
-				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token claims")
-			}
-
-			// Set user ID in context
-			userID, ok := claims["user_id"].(string)
-			if !ok {
-				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid user ID in token")
-			}
-			c.Set("user_id", userID)
-
-			// Set user role in context
-			role, ok := claims["role"].(string)
-			if !ok {
-				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid role in token")
-			}
-			c.Set("user_role", role)
+			// Set user ID and role in context
+			c.Set("user_id", claims.UserID)
+			c.Set("user_role", claims.Role)
 
 			return next(c)
 		}
@@ -0,0 +1,110 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+
+	"chatterbloom/backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DefaultGroupsToChatGroups moves the default and organizational unit groups
+// that registration used to create in the groups collection into chat_groups,
+// where every other group lives. Memberships keep pointing at the same group
+// IDs. A group that a system group of the same name in chat_groups already
+// replaces is merged into it instead: its members join that group. With
+// dryRun set, it only counts what would change. It returns how many groups
+// were (or would be) moved or merged.
+func DefaultGroupsToChatGroups(ctx context.Context, database *mongo.Database, dryRun bool) (int64, error) {
+	oldGroupsColl := database.Collection("groups")
+	groupsColl := database.Collection("chat_groups")
+	membersColl := database.Collection("group_members")
+
+	if dryRun {
+		return oldGroupsColl.CountDocuments(ctx, bson.M{})
+	}
+
+	cursor, err := oldGroupsColl.Find(ctx, bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var moved int64
+	for cursor.Next(ctx) {
+		var group bson.M
+		if err := cursor.Decode(&group); err != nil {
+			return moved, err
+		}
+		groupID, _ := group["_id"].(primitive.ObjectID)
+
+		memberIDs, err := membersColl.Distinct(ctx, "user_id", bson.M{"group_id": groupID})
+		if err != nil {
+			return moved, err
+		}
+		members := make(bson.A, 0, len(memberIDs))
+		for _, memberID := range memberIDs {
+			if id, ok := memberID.(primitive.ObjectID); ok {
+				members = append(members, id.Hex())
+			}
+		}
+
+		var existing models.ChatGroup
+		err = groupsColl.FindOne(ctx, bson.M{
+			"_id":        bson.M{"$ne": groupID},
+			"name":       group["name"],
+			"created_by": "system",
+			"audience":   bson.M{"$ne": models.AudienceParents},
+		}).Decode(&existing)
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			group["members"] = members
+			_, err = groupsColl.InsertOne(ctx, group)
+			if mongo.IsDuplicateKeyError(err) {
+				// Moved by an earlier run that stopped before deleting it
+				err = nil
+			}
+			if err != nil {
+				return moved, err
+			}
+
+		case err != nil:
+			return moved, err
+
+		default:
+			if err := mergeMembers(ctx, groupsColl, membersColl, groupID, existing.ID, members); err != nil {
+				return moved, err
+			}
+		}
+
+		if _, err := oldGroupsColl.DeleteOne(ctx, bson.M{"_id": groupID}); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+	return moved, cursor.Err()
+}
+
+// mergeMembers moves the memberships of one group to another, dropping the
+// ones of users who are already members of both
+func mergeMembers(ctx context.Context, groupsColl, membersColl *mongo.Collection, fromID, toID primitive.ObjectID, members bson.A) error {
+	existingIDs, err := membersColl.Distinct(ctx, "user_id", bson.M{"group_id": toID})
+	if err != nil {
+		return err
+	}
+	if len(existingIDs) > 0 {
+		_, err = membersColl.DeleteMany(ctx, bson.M{"group_id": fromID, "user_id": bson.M{"$in": existingIDs}})
+		if err != nil {
+			return err
+		}
+	}
+	_, err = membersColl.UpdateMany(ctx, bson.M{"group_id": fromID}, bson.M{"$set": bson.M{"group_id": toID}})
+	if err != nil {
+		return err
+	}
+	_, err = groupsColl.UpdateOne(ctx, bson.M{"_id": toID}, bson.M{"$addToSet": bson.M{"members": bson.M{"$each": members}}})
+	return err
+}
@@ -0,0 +1,78 @@
+package migrations
+
+import (
+	"context"
+
+	"chatterbloom/backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// memberRoleRank orders group roles so the most privileged duplicate is kept
+var memberRoleRank = map[string]int{
+	models.GroupRoleMember:    0,
+	models.GroupRoleModerator: 1,
+	models.GroupRoleAdmin:     2,
+}
+
+// DedupeGroupMembers removes duplicate memberships of a user in a group, left
+// by concurrent joins before group_members had a unique index, so the index
+// can be created. Of each set of duplicates it keeps the one with the highest
+// group role, the oldest on a tie. With dryRun set, it only counts what would
+// change. It returns how many memberships were (or would be) removed.
+func DedupeGroupMembers(ctx context.Context, database *mongo.Database, dryRun bool) (int64, error) {
+	membersColl := database.Collection("group_members")
+
+	cursor, err := membersColl.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     bson.M{"group_id": "$group_id", "user_id": "$user_id"},
+			"members": bson.M{"$push": bson.M{"_id": "$_id", "role": "$role"}},
+			"count":   bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var removed int64
+	for cursor.Next(ctx) {
+		var duplicates struct {
+			Members []struct {
+				ID   primitive.ObjectID `bson:"_id"`
+				Role string             `bson:"role"`
+			} `bson:"members"`
+		}
+		if err := cursor.Decode(&duplicates); err != nil {
+			return removed, err
+		}
+
+		keep := 0
+		for i, member := range duplicates.Members {
+			if memberRoleRank[member.Role] > memberRoleRank[duplicates.Members[keep].Role] {
+				keep = i
+			}
+		}
+		var extraIDs []primitive.ObjectID
+		for i, member := range duplicates.Members {
+			if i != keep {
+				extraIDs = append(extraIDs, member.ID)
+			}
+		}
+
+		if dryRun {
+			removed += int64(len(extraIDs))
+			continue
+		}
+		result, err := membersColl.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": extraIDs}})
+		if err != nil {
+			return removed, err
+		}
+		removed += result.DeletedCount
+	}
+	return removed, cursor.Err()
+}
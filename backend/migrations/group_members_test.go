@@ -0,0 +1,67 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"chatterbloom/backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// TestDedupeGroupMembers checks that the most privileged of a user's
+// duplicate memberships in a group is kept, the oldest on a tie
+func TestDedupeGroupMembers(t *testing.T) {
+	ids := make([]primitive.ObjectID, 5)
+	for i := range ids {
+		ids[i] = primitive.NewObjectID()
+	}
+	member := func(id primitive.ObjectID, role string) bson.D {
+		return bson.D{{Key: "_id", Value: id}, {Key: "role", Value: role}}
+	}
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("dedupe", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "chatterbloom.group_members", mtest.FirstBatch,
+				// The moderator is kept over the older member
+				bson.D{{Key: "members", Value: bson.A{member(ids[0], models.GroupRoleMember), member(ids[1], models.GroupRoleModerator)}}},
+				// The oldest of equals is kept
+				bson.D{{Key: "members", Value: bson.A{member(ids[2], models.GroupRoleMember), member(ids[3], models.GroupRoleMember), member(ids[4], models.GroupRoleMember)}}},
+			),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}),
+		)
+
+		removed, err := DedupeGroupMembers(context.Background(), mt.DB, false)
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if removed != 3 {
+			mt.Errorf("removed %d memberships, want 3", removed)
+		}
+
+		var deleted []primitive.ObjectID
+		for _, started := range mt.GetAllStartedEvents() {
+			if started.CommandName != "delete" {
+				continue
+			}
+			values, _ := started.Command.Lookup("deletes", "0", "q", "_id", "$in").Array().Values()
+			for _, value := range values {
+				deleted = append(deleted, value.ObjectID())
+			}
+		}
+		want := []primitive.ObjectID{ids[0], ids[3], ids[4]}
+		if len(deleted) != len(want) {
+			mt.Fatalf("deleted %v, want %v", deleted, want)
+		}
+		for i := range want {
+			if deleted[i] != want[i] {
+				mt.Errorf("deleted %v, want %v", deleted, want)
+				break
+			}
+		}
+	})
+}
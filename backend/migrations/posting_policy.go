@@ -0,0 +1,46 @@
+package migrations
+
+import (
+	"context"
+
+	"chatterbloom/backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DefaultPostingPolicies gives default groups created before posting
+// policies existed the policy in models.DefaultGroupPostingPolicies.
+// Groups that already have a policy keep it. With dryRun set, it only counts
+// what would change. It returns how many groups were (or would be) updated.
+func DefaultPostingPolicies(ctx context.Context, database *mongo.Database, dryRun bool) (int64, error) {
+	// Run DefaultGroupsToChatGroups first, so groups created in the old
+	// groups collection are included
+	groupsColl := database.Collection("chat_groups")
+
+	var updated int64
+	for name, postingPolicy := range models.DefaultGroupPostingPolicies {
+		filter := bson.M{
+			"name":           name,
+			"created_by":     "system",
+			"posting_policy": bson.M{"$in": bson.A{nil, ""}},
+		}
+
+		if dryRun {
+			count, err := groupsColl.CountDocuments(ctx, filter)
+			if err != nil {
+				return updated, err
+			}
+			updated += count
+			continue
+		}
+
+		result, err := groupsColl.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"posting_policy": postingPolicy}})
+		if err != nil {
+			return updated, err
+		}
+		updated += result.ModifiedCount
+	}
+
+	return updated, nil
+}
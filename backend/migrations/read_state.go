@@ -0,0 +1,96 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MigrateReadState builds read_state positions from the read_by arrays of
+// existing messages: each user's position in a group becomes the newest
+// top-level message they are listed on. Positions that are already further
+// along are kept, so the migration can be run more than once. It returns how
+// many positions were created or moved.
+func MigrateReadState(ctx context.Context, database *mongo.Database) (int, error) {
+	messagesColl := database.Collection("messages")
+	readStateColl := database.Collection("read_state")
+
+	// Find the newest message each user has read in each group. Entries of
+	// read_by that aren't valid user IDs are skipped.
+	cursor, err := messagesColl.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"read_by.0":      bson.M{"$exists": true},
+			"thread_root_id": bson.M{"$exists": false},
+		}}},
+		{{Key: "$unwind", Value: "$read_by"}},
+		{{Key: "$project", Value: bson.M{
+			"group_id":   1,
+			"created_at": 1,
+			"reader": bson.M{"$convert": bson.M{
+				"input":   "$read_by",
+				"to":      "objectId",
+				"onError": nil,
+				"onNull":  nil,
+			}},
+		}}},
+		{{Key: "$match", Value: bson.M{"reader": bson.M{"$ne": nil}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":                  bson.M{"user_id": "$reader", "group_id": "$group_id"},
+			"last_read_message_id": bson.M{"$last": "$_id"},
+			"last_read_at":         bson.M{"$last": "$created_at"},
+		}}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		var position struct {
+			Key struct {
+				UserID  primitive.ObjectID `bson:"user_id"`
+				GroupID primitive.ObjectID `bson:"group_id"`
+			} `bson:"_id"`
+			LastReadMessageID primitive.ObjectID `bson:"last_read_message_id"`
+			LastReadAt        time.Time          `bson:"last_read_at"`
+		}
+		if err := cursor.Decode(&position); err != nil {
+			return migrated, err
+		}
+
+		// Only move positions forward; a duplicate key means the user's
+		// position is already ahead
+		_, err := readStateColl.UpdateOne(
+			ctx,
+			bson.M{
+				"user_id":  position.Key.UserID,
+				"group_id": position.Key.GroupID,
+				"$or": bson.A{
+					bson.M{"last_read_at": bson.M{"$lt": position.LastReadAt}},
+					bson.M{"last_read_at": position.LastReadAt, "last_read_message_id": bson.M{"$lt": position.LastReadMessageID}},
+				},
+			},
+			bson.M{"$set": bson.M{
+				"last_read_message_id": position.LastReadMessageID,
+				"last_read_at":         position.LastReadAt,
+				"updated_at":           time.Now(),
+			}},
+			options.Update().SetUpsert(true),
+		)
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		if err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+
+	return migrated, cursor.Err()
+}
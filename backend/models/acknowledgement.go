@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Acknowledgement records that a user confirmed seeing an announcement
+type Acknowledgement struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	MessageID      primitive.ObjectID `bson:"message_id" json:"message_id"`
+	GroupID        primitive.ObjectID `bson:"group_id" json:"group_id"`
+	UserID         primitive.ObjectID `bson:"user_id" json:"user_id"`
+	AcknowledgedAt time.Time          `bson:"acknowledged_at" json:"acknowledged_at"`
+}
+
+// AcknowledgementStatus is one recipient's line in an acknowledgement report
+type AcknowledgementStatus struct {
+	User           UserResponse `json:"user"`
+	AcknowledgedAt *time.Time   `json:"acknowledged_at,omitempty"`
+}
+
+// AcknowledgementReport lists who has and hasn't acknowledged an announcement
+type AcknowledgementReport struct {
+	MessageID    string                  `json:"message_id"`
+	Acknowledged []AcknowledgementStatus `json:"acknowledged"`
+	Pending      []AcknowledgementStatus `json:"pending"`
+}
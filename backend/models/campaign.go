@@ -0,0 +1,74 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Campaign is one announcement sent to many groups at once. Each targeted
+// group gets its own announcement message carrying the campaign's ID.
+type Campaign struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	SenderID    primitive.ObjectID `bson:"sender_id" json:"sender_id"`
+	Title       string             `bson:"title" json:"title"`
+	Content     string             `bson:"content" json:"content"`
+	RequiresAck bool               `bson:"requires_ack,omitempty" json:"requires_ack,omitempty"`
+	Targets     CampaignTargets    `bson:"targets" json:"targets"`
+	Deliveries  []CampaignDelivery `bson:"deliveries" json:"deliveries"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// CampaignTargets is what a campaign was addressed to. The campaign goes to
+// the union of the listed groups, the groups of the organizational units and
+// the groups only the listed roles are put in by default. Units' parent
+// channels are only included when parents are one of the roles.
+type CampaignTargets struct {
+	GroupIDs            []primitive.ObjectID `bson:"group_ids,omitempty" json:"group_ids,omitempty"`
+	OrganizationalUnits []string             `bson:"organizational_units,omitempty" json:"organizational_units,omitempty"`
+	Roles               []string             `bson:"roles,omitempty" json:"roles,omitempty"`
+}
+
+// CampaignDelivery is the outcome of sending a campaign to one group
+type CampaignDelivery struct {
+	GroupID   primitive.ObjectID  `bson:"group_id" json:"group_id"`
+	MessageID *primitive.ObjectID `bson:"message_id,omitempty" json:"message_id,omitempty"`
+	Error     string              `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// CampaignRequest represents the data needed to send a campaign
+type CampaignRequest struct {
+	Content      string `json:"content" validate:"required"`
+	Title        string `json:"title" validate:"required"`
+	AllowReplies *bool  `json:"allow_replies"` // Defaults to true
+
+	RequireAcknowledgement bool `json:"require_acknowledgement"`
+	ReminderAfterHours     *int `json:"reminder_after_hours"`
+
+	GroupIDs            []string `json:"group_ids"`
+	OrganizationalUnits []string `json:"organizational_units"`
+	Roles               []string `json:"roles"`
+}
+
+// CampaignGroupStats is how a campaign did in one group
+type CampaignGroupStats struct {
+	GroupID      string `json:"group_id"`
+	GroupName    string `json:"group_name"`
+	MessageID    string `json:"message_id,omitempty"`
+	Error        string `json:"error,omitempty"`
+	Recipients   int    `json:"recipients"`
+	Read         int    `json:"read"`
+	Acknowledged int    `json:"acknowledged"`
+}
+
+// CampaignStats reports delivery, reads and acknowledgements of a campaign.
+// The totals count people once, however many targeted groups they are in.
+type CampaignStats struct {
+	Campaign     Campaign             `json:"campaign"`
+	Groups       []CampaignGroupStats `json:"groups"`
+	Delivered    int                  `json:"delivered"` // Groups the announcement was posted to
+	Failed       int                  `json:"failed"`
+	Recipients   int                  `json:"recipients"`
+	Read         int                  `json:"read"`
+	Acknowledged int                  `json:"acknowledged"`
+}
@@ -19,8 +19,38 @@ type ChatGroup struct {
 	UpdatedAt          time.Time          `bson:"updated_at" json:"updated_at"`
 	CreatedBy          string             `bson:"created_by" json:"created_by"`
 	Members            []string           `bson:"members" json:"members"` // Array of user IDs
+	AllowedReactions   []string           `bson:"allowed_reactions,omitempty" json:"allowed_reactions,omitempty"` // Empty allows any emoji
+	PairKey            string             `bson:"pair_key,omitempty" json:"-"` // Both participants' IDs in order, set on direct conversations
+	Audience           string             `bson:"audience,omitempty" json:"audience,omitempty"` // Set on channels kept for one kind of member, e.g. parents
+	PostingPolicy      string             `bson:"posting_policy,omitempty" json:"posting_policy,omitempty"` // Empty is open
+	SlowModeSeconds    int                `bson:"slow_mode_seconds,omitempty" json:"slow_mode_seconds,omitempty"` // Cooldown between a member's messages in slow mode
 }
 
+// Group audiences
+const (
+	AudienceParents = "parents" // An organizational unit's channel for its students' guardians
+)
+
+// Group posting policies
+const (
+	PostingOpen             = "open"
+	PostingAnnouncementOnly = "announcement_only" // Only group admins and teachers post; members reply in announcement threads
+	PostingReadOnly         = "read_only"         // Nobody posts, e.g. archived groups
+	PostingSlowMode         = "slow_mode"         // Members wait SlowModeSeconds between messages
+)
+
+// Posting policies of default groups that aren't open, by group name
+var DefaultGroupPostingPolicies = map[string]string{
+	"School Announcements": PostingAnnouncementOnly,
+}
+
+// Group member roles
+const (
+	GroupRoleAdmin     = "admin"
+	GroupRoleModerator = "moderator"
+	GroupRoleMember    = "member"
+)
+
 // GroupMember represents a user's membership in a chat group
 type GroupMember struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
@@ -30,6 +60,8 @@ type GroupMember struct {
 	JoinedAt  time.Time          `bson:"joined_at" json:"joined_at"`
 	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
 	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+
+	LastPostedAt *time.Time `bson:"last_posted_at,omitempty" json:"-"` // Set in slow mode groups
 }
 
 // ChatGroupResponse is the group data returned to clients
@@ -46,6 +78,22 @@ type ChatGroupResponse struct {
 	CreatedBy          string    `json:"created_by"`
 	Members            []string  `json:"members"`
 	MemberCount        int       `json:"member_count"`
+	AllowedReactions   []string  `json:"allowed_reactions,omitempty"`
+	Audience           string    `json:"audience,omitempty"`
+	PostingPolicy      string    `json:"posting_policy"`
+	SlowModeSeconds    int       `json:"slow_mode_seconds,omitempty"`
+	Participant        *UserResponse `json:"participant,omitempty"` // The other user in a direct conversation
+}
+
+// GroupList is the current user's groups, with direct conversations apart
+type GroupList struct {
+	Groups              []ChatGroupResponse `json:"groups"`
+	DirectConversations []ChatGroupResponse `json:"direct_conversations"`
+}
+
+// DirectConversationRequest represents the user to start a direct conversation with
+type DirectConversationRequest struct {
+	UserID string `json:"user_id" validate:"required"`
 }
 
 // GroupWithMembers represents a group with its members
@@ -75,9 +123,29 @@ type GroupMemberWithUser struct {
 	UpdatedAt time.Time    `json:"updated_at"`
 }
 
+// GroupSettingsRequest represents the group settings admins can change.
+// Fields left out of the request are not changed.
+type GroupSettingsRequest struct {
+	AllowedReactions *[]string `json:"allowed_reactions"`
+	PostingPolicy    *string   `json:"posting_policy"`
+	SlowModeSeconds  *int      `json:"slow_mode_seconds"`
+}
+
+// MemberRoleRequest represents a member's new role in a group
+type MemberRoleRequest struct {
+	Role string `json:"role" validate:"required,oneof=admin moderator member"`
+}
+
+// MemberPresence is a group member's online status
+type MemberPresence struct {
+	UserID     string     `json:"user_id"`
+	Status     string     `json:"status"` // online, away, offline
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+}
+
 // ToResponse converts a ChatGroup to a ChatGroupResponse
 func (g *ChatGroup) ToResponse(memberCount int) ChatGroupResponse {
-	return ChatGroupResponse{
+	response := ChatGroupResponse{
 		ID:                 g.ID.Hex(),
 		Name:               g.Name,
 		Description:        g.Description,
@@ -90,5 +158,13 @@ func (g *ChatGroup) ToResponse(memberCount int) ChatGroupResponse {
 		CreatedBy:          g.CreatedBy,
 		Members:            g.Members,
 		MemberCount:        memberCount,
+		AllowedReactions:   g.AllowedReactions,
+		Audience:           g.Audience,
+		PostingPolicy:      g.PostingPolicy,
+		SlowModeSeconds:    g.SlowModeSeconds,
+	}
+	if response.PostingPolicy == "" {
+		response.PostingPolicy = PostingOpen
 	}
+	return response
 }
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Guardianship statuses. Only verified guardianships give a parent access
+// to their child's parent channel.
+const (
+	GuardianshipPending  = "pending"
+	GuardianshipVerified = "verified"
+	GuardianshipRejected = "rejected"
+)
+
+// Guardian relationships
+var GuardianRelationships = []string{"parent", "step_parent", "grandparent", "legal_guardian", "other"}
+
+// Guardianship links a parent user to a student user. A student can have
+// several guardians and a guardian several students.
+type Guardianship struct {
+	ID           primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	GuardianID   primitive.ObjectID  `bson:"guardian_id" json:"guardian_id"`
+	StudentID    primitive.ObjectID  `bson:"student_id" json:"student_id"`
+	Relationship string              `bson:"relationship" json:"relationship"`
+	Status       string              `bson:"status" json:"status"`
+	VerifiedBy   *primitive.ObjectID `bson:"verified_by,omitempty" json:"verified_by,omitempty"`
+	VerifiedAt   *time.Time          `bson:"verified_at,omitempty" json:"verified_at,omitempty"`
+	CreatedBy    primitive.ObjectID  `bson:"created_by" json:"created_by"`
+	CreatedAt    time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time           `bson:"updated_at" json:"updated_at"`
+}
+
+// GuardianshipResponse is a guardianship with both users
+type GuardianshipResponse struct {
+	Guardianship
+	Guardian *UserResponse `json:"guardian,omitempty"`
+	Student  *UserResponse `json:"student,omitempty"`
+}
+
+// GuardianshipRequest represents the data needed to link a guardian to a student
+type GuardianshipRequest struct {
+	GuardianID   string `json:"guardian_id" validate:"required"`
+	StudentID    string `json:"student_id" validate:"required"`
+	Relationship string `json:"relationship" validate:"required"`
+	Status       string `json:"status"` // Defaults to pending
+}
+
+// UpdateGuardianshipRequest represents the changes admins can make to a guardianship
+type UpdateGuardianshipRequest struct {
+	Relationship *string `json:"relationship"`
+	Status       *string `json:"status"`
+}
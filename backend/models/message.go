@@ -16,7 +16,71 @@ type Message struct {
 	Title     string               `bson:"title" json:"title"` // Used for announcements
 	CreatedAt time.Time            `bson:"created_at" json:"created_at"`
 	UpdatedAt time.Time            `bson:"updated_at" json:"updated_at"`
-	ReadBy    []primitive.ObjectID `bson:"read_by" json:"read_by"` // Array of user IDs who have read the message
+	ReadBy    ObjectIDs            `bson:"read_by" json:"read_by"` // Users who have read an announcement; other messages use ReadState
+	EditedAt  *time.Time           `bson:"edited_at,omitempty" json:"edited_at,omitempty"`
+	Revisions []MessageRevision    `bson:"revisions,omitempty" json:"revisions,omitempty"` // Previous versions, oldest first
+	DeletedAt *time.Time           `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy *primitive.ObjectID  `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
+
+	// Threads: replies point at their parent and at the root of the thread,
+	// and the root keeps a denormalized reply count and last reply time
+	ParentID        *primitive.ObjectID `bson:"parent_id,omitempty" json:"parent_id,omitempty"`
+	ThreadRootID    *primitive.ObjectID `bson:"thread_root_id,omitempty" json:"thread_root_id,omitempty"`
+	ReplyCount      int                 `bson:"reply_count,omitempty" json:"reply_count"`
+	LastReplyAt     *time.Time          `bson:"last_reply_at,omitempty" json:"last_reply_at,omitempty"`
+	RepliesDisabled bool                `bson:"replies_disabled,omitempty" json:"replies_disabled"` // Set by announcement authors
+
+	// Reactions maps each emoji to the IDs of users who reacted with it
+	Reactions map[string][]primitive.ObjectID `bson:"reactions,omitempty" json:"reactions,omitempty"`
+
+	Attachments []Attachment `bson:"attachments,omitempty" json:"attachments,omitempty"`
+
+	// Announcements can ask every recipient to acknowledge them, with a
+	// reminder to those who haven't by ReminderAt
+	RequiresAck    bool       `bson:"requires_ack,omitempty" json:"requires_ack"`
+	AckCount       int        `bson:"ack_count,omitempty" json:"ack_count"`
+	ReminderAt     *time.Time `bson:"reminder_at,omitempty" json:"reminder_at,omitempty"`
+	ReminderSentAt *time.Time `bson:"reminder_sent_at,omitempty" json:"reminder_sent_at,omitempty"`
+
+	// Set while an instance sends the reminder; it is retried once this passes
+	ReminderLeaseUntil *time.Time `bson:"reminder_lease_until,omitempty" json:"-"`
+
+	CampaignID *primitive.ObjectID `bson:"campaign_id,omitempty" json:"campaign_id,omitempty"` // Set on announcements sent to many groups at once
+}
+
+// Attachment is a file attached to a message. The file itself lives in the
+// blob store under StorageKey.
+type Attachment struct {
+	ID           primitive.ObjectID `bson:"_id" json:"id"`
+	Name         string             `bson:"name" json:"name"`
+	MimeType     string             `bson:"mime_type" json:"mime_type"`
+	Size         int64              `bson:"size" json:"size"`
+	Checksum     string             `bson:"checksum" json:"checksum"` // Hex-encoded SHA-256
+	StorageKey   string             `bson:"storage_key" json:"-"`
+	ThumbnailKey string             `bson:"thumbnail_key,omitempty" json:"-"` // Images only
+	Width        int                `bson:"width,omitempty" json:"width,omitempty"`
+	Height       int                `bson:"height,omitempty" json:"height,omitempty"`
+}
+
+// AttachmentResponse represents the attachment data sent to clients
+type AttachmentResponse struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	MimeType     string `json:"mime_type"`
+	Size         int64  `json:"size"`
+	Checksum     string `json:"checksum"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+	Width        int    `json:"width,omitempty"`
+	Height       int    `json:"height,omitempty"`
+}
+
+// MessageRevision is a previous version of an edited or deleted message
+type MessageRevision struct {
+	Content   string             `bson:"content" json:"content"`
+	Title     string             `bson:"title,omitempty" json:"title,omitempty"`
+	ChangedAt time.Time          `bson:"changed_at" json:"changed_at"`
+	ChangedBy primitive.ObjectID `bson:"changed_by" json:"changed_by"`
 }
 
 // MessageResponse is the message data returned to clients
@@ -31,6 +95,47 @@ type MessageResponse struct {
 	UpdatedAt time.Time    `json:"updated_at"`
 	ReadBy    []string     `json:"read_by"`
 	Sender    *UserResponse `json:"sender,omitempty"`
+	EditedAt  *time.Time   `json:"edited_at,omitempty"`
+	Deleted   bool         `json:"deleted"`
+
+	ParentID        string     `json:"parent_id,omitempty"`
+	ThreadRootID    string     `json:"thread_root_id,omitempty"`
+	ReplyCount      int        `json:"reply_count"`
+	LastReplyAt     *time.Time `json:"last_reply_at,omitempty"`
+	RepliesDisabled bool       `json:"replies_disabled"`
+
+	Reactions map[string]int `json:"reactions,omitempty"` // Count per emoji
+
+	Attachments []AttachmentResponse `json:"attachments,omitempty"`
+
+	RequiresAck bool       `json:"requires_ack"`
+	AckCount    int        `json:"ack_count"`
+	ReminderAt  *time.Time `json:"reminder_at,omitempty"`
+
+	CampaignID string `json:"campaign_id,omitempty"`
+}
+
+// MessagePage is one page of a group's messages, newest first
+type MessagePage struct {
+	Messages   []MessageResponse `json:"messages"`
+	NextCursor string            `json:"next_cursor,omitempty"` // Pass as before= to load older messages
+	PrevCursor string            `json:"prev_cursor,omitempty"` // Pass as after= to load newer messages
+	HasOlder   bool              `json:"has_older"`
+	HasNewer   bool              `json:"has_newer"`
+}
+
+// MessageSearchResult is a message matching a search, with an HTML excerpt
+// in which the matches are wrapped in <mark> tags
+type MessageSearchResult struct {
+	Message MessageResponse `json:"message"`
+	Snippet string          `json:"snippet"`
+}
+
+// MessageSearchPage is one page of search results, newest first
+type MessageSearchPage struct {
+	Results    []MessageSearchResult `json:"results"`
+	NextCursor string                `json:"next_cursor,omitempty"` // Pass as cursor= to load more results
+	HasMore    bool                  `json:"has_more"`
 }
 
 // ToResponse converts a Message to a MessageResponse
@@ -40,7 +145,7 @@ func (m *Message) ToResponse() MessageResponse {
 		readByStrings[i] = id.Hex()
 	}
 	
-	return MessageResponse{
+	response := MessageResponse{
 		ID:        m.ID.Hex(),
 		Content:   m.Content,
 		GroupID:   m.GroupID.Hex(),
@@ -50,15 +155,109 @@ func (m *Message) ToResponse() MessageResponse {
 		CreatedAt: m.CreatedAt,
 		UpdatedAt: m.UpdatedAt,
 		ReadBy:    readByStrings,
+		EditedAt:  m.EditedAt,
+
+		ReplyCount:      m.ReplyCount,
+		LastReplyAt:     m.LastReplyAt,
+		RepliesDisabled: m.RepliesDisabled,
+
+		RequiresAck: m.RequiresAck,
+		AckCount:    m.AckCount,
+		ReminderAt:  m.ReminderAt,
+	}
+	if m.CampaignID != nil {
+		response.CampaignID = m.CampaignID.Hex()
+	}
+
+	if len(m.Reactions) > 0 {
+		response.Reactions = make(map[string]int, len(m.Reactions))
+		for emoji, userIDs := range m.Reactions {
+			if len(userIDs) > 0 {
+				response.Reactions[emoji] = len(userIDs)
+			}
+		}
+	}
+
+	for _, attachment := range m.Attachments {
+		url := "/api/messages/" + m.ID.Hex() + "/attachments/" + attachment.ID.Hex()
+		attachmentResponse := AttachmentResponse{
+			ID:       attachment.ID.Hex(),
+			Name:     attachment.Name,
+			MimeType: attachment.MimeType,
+			Size:     attachment.Size,
+			Checksum: attachment.Checksum,
+			URL:      url,
+			Width:    attachment.Width,
+			Height:   attachment.Height,
+		}
+		if attachment.ThumbnailKey != "" {
+			attachmentResponse.ThumbnailURL = url + "/thumbnail"
+		}
+		response.Attachments = append(response.Attachments, attachmentResponse)
 	}
+
+	if m.ParentID != nil {
+		response.ParentID = m.ParentID.Hex()
+	}
+	if m.ThreadRootID != nil {
+		response.ThreadRootID = m.ThreadRootID.Hex()
+	}
+
+	// Deleted messages are tombstones; their content is never sent to clients
+	if m.DeletedAt != nil {
+		response.Content = ""
+		response.Title = ""
+		response.Attachments = nil
+		response.Deleted = true
+	}
+
+	return response
 }
 
 // MessageRequest represents the data needed to create a new message
 type MessageRequest struct {
-	Content string `json:"content" validate:"required"`
-	GroupID string `json:"group_id" validate:"required"`
-	Type    string `json:"type"`
-	Title   string `json:"title"`
+	Content  string `json:"content" validate:"required"`
+	GroupID  string `json:"group_id" validate:"required"`
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	ParentID string `json:"parent_id"` // Set to reply in a thread
+
+	// Set to schedule the message instead of sending it now
+	SendAt     *time.Time `json:"send_at"`
+	Recurrence string     `json:"recurrence"` // daily, weekly or an RRULE subset; needs send_at
+	Timezone   string     `json:"timezone"`   // IANA zone for recurrences, defaults to UTC
+
+	ID primitive.ObjectID `json:"-"` // Set when publishing a scheduled message
+}
+
+// AnnouncementRequest represents the data needed to create an announcement
+type AnnouncementRequest struct {
+	Content      string `json:"content" validate:"required"`
+	Title        string `json:"title" validate:"required"`
+	AllowReplies *bool  `json:"allow_replies"` // Defaults to true
+
+	RequireAcknowledgement bool `json:"require_acknowledgement"`
+	ReminderAfterHours     *int `json:"reminder_after_hours"` // Remind non-acknowledgers after this long; 0 disables, defaults to ACK_REMINDER_AFTER
+
+	// Set to schedule the announcement instead of sending it now
+	SendAt     *time.Time `json:"send_at"`
+	Recurrence string     `json:"recurrence"` // daily, weekly or an RRULE subset; needs send_at
+	Timezone   string     `json:"timezone"`   // IANA zone for recurrences, defaults to UTC
+
+	ID         primitive.ObjectID  `json:"-"` // Set when publishing a scheduled announcement
+	CampaignID *primitive.ObjectID `json:"-"` // Set when sending a campaign
+}
+
+// UpdateMessageRequest represents the data that can be changed when editing a message
+type UpdateMessageRequest struct {
+	Content      string `json:"content" validate:"required"`
+	Title        string `json:"title"`
+	AllowReplies *bool  `json:"allow_replies"` // Announcements only
+}
+
+// ReactionRequest represents an emoji reaction to add to a message
+type ReactionRequest struct {
+	Emoji string `json:"emoji" validate:"required"`
 }
 
 // ChatMessage is an alias for Message to maintain compatibility
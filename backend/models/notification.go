@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Notification types
+const (
+	NotificationAckReminder = "ack_reminder"
+)
+
+// Notification is something a user is told about outside the group timeline,
+// such as a reminder to acknowledge an announcement
+type Notification struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID  `bson:"user_id" json:"user_id"`
+	Type      string              `bson:"type" json:"type"`
+	GroupID   *primitive.ObjectID `bson:"group_id,omitempty" json:"group_id,omitempty"`
+	MessageID *primitive.ObjectID `bson:"message_id,omitempty" json:"message_id,omitempty"`
+	Title     string              `bson:"title" json:"title"`
+	Body      string              `bson:"body,omitempty" json:"body,omitempty"`
+	CreatedAt time.Time           `bson:"created_at" json:"created_at"`
+	ReadAt    *time.Time          `bson:"read_at,omitempty" json:"read_at,omitempty"`
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ObjectIDs is a list of user IDs stored as ObjectIDs. Older versions wrote
+// some IDs as hex strings, so decoding also accepts those, dropping any that
+// aren't valid IDs and IDs listed in both forms; `go run ./cmd/migrate
+// repair-read-by` rewrites them.
+type ObjectIDs []primitive.ObjectID
+
+// UnmarshalBSONValue implements bson.ValueUnmarshaler
+func (ids *ObjectIDs) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	switch t {
+	case bsontype.Null, bsontype.Undefined:
+		*ids = nil
+		return nil
+	case bsontype.Array:
+	default:
+		return fmt.Errorf("cannot decode BSON %s into ObjectIDs", t)
+	}
+
+	values, err := bson.Raw(data).Values()
+	if err != nil {
+		return err
+	}
+
+	decoded := make(ObjectIDs, 0, len(values))
+	seen := make(map[primitive.ObjectID]bool, len(values))
+	for _, value := range values {
+		var id primitive.ObjectID
+		switch value.Type {
+		case bsontype.ObjectID:
+			id = value.ObjectID()
+		case bsontype.String:
+			parsed, err := primitive.ObjectIDFromHex(value.StringValue())
+			if err != nil {
+				continue
+			}
+			id = parsed
+		default:
+			return fmt.Errorf("cannot decode BSON %s into an ObjectID", value.Type)
+		}
+		if !seen[id] {
+			seen[id] = true
+			decoded = append(decoded, id)
+		}
+	}
+
+	*ids = decoded
+	return nil
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ReadState is how far a user has read in a group. Every top-level message
+// up to and including LastReadMessageID counts as read.
+type ReadState struct {
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID            primitive.ObjectID `bson:"user_id" json:"user_id"`
+	GroupID           primitive.ObjectID `bson:"group_id" json:"group_id"`
+	LastReadMessageID primitive.ObjectID `bson:"last_read_message_id" json:"last_read_message_id"`
+	LastReadAt        time.Time          `bson:"last_read_at" json:"last_read_at"` // created_at of the last read message
+	UpdatedAt         time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// ReadStateResponse is a user's read position in a group with what's left unread
+type ReadStateResponse struct {
+	GroupID           string    `json:"group_id"`
+	LastReadMessageID string    `json:"last_read_message_id,omitempty"`
+	LastReadAt        time.Time `json:"last_read_at"`
+	UnreadCount       int       `json:"unread_count"`
+}
+
+// MarkGroupReadRequest represents a request to mark a group read up to a
+// message. Without a message ID the whole group is marked read.
+type MarkGroupReadRequest struct {
+	MessageID string `json:"message_id"`
+}
+
+// UnreadCountResponse is the number of unread messages, in total and per group
+type UnreadCountResponse struct {
+	Count  int            `json:"count"`
+	Groups map[string]int `json:"groups"`
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Scheduled message statuses
+const (
+	ScheduleStatusPending   = "pending"
+	ScheduleStatusCompleted = "completed"
+	ScheduleStatusCancelled = "cancelled"
+	ScheduleStatusFailed    = "failed"
+)
+
+// ScheduledMessage is a message or announcement waiting to be published at
+// SendAt, once or repeatedly following Recurrence
+type ScheduledMessage struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	GroupID  primitive.ObjectID `bson:"group_id" json:"group_id"`
+	SenderID primitive.ObjectID `bson:"sender_id" json:"sender_id"`
+	Type     string             `bson:"type" json:"type"` // regular, announcement
+	Content  string             `bson:"content" json:"content"`
+	Title    string             `bson:"title,omitempty" json:"title,omitempty"`
+
+	// Announcement options, as in AnnouncementRequest
+	AllowReplies           *bool `bson:"allow_replies,omitempty" json:"allow_replies,omitempty"`
+	RequireAcknowledgement bool  `bson:"require_acknowledgement,omitempty" json:"require_acknowledgement,omitempty"`
+	ReminderAfterHours     *int  `bson:"reminder_after_hours,omitempty" json:"reminder_after_hours,omitempty"`
+
+	Recurrence  string    `bson:"recurrence,omitempty" json:"recurrence,omitempty"` // daily, weekly or an RRULE subset
+	Timezone    string    `bson:"timezone" json:"timezone"`                         // IANA zone recurrences keep their wall-clock time in
+	FirstSendAt time.Time `bson:"first_send_at" json:"first_send_at"`               // Start of the recurrence
+	SendAt      time.Time `bson:"send_at" json:"send_at"`                           // Next time to publish
+	Status      string    `bson:"status" json:"status"`
+
+	SentCount     int                 `bson:"sent_count" json:"sent_count"`
+	LastSentAt    *time.Time          `bson:"last_sent_at,omitempty" json:"last_sent_at,omitempty"`
+	LastMessageID *primitive.ObjectID `bson:"last_message_id,omitempty" json:"last_message_id,omitempty"`
+	LastError     string              `bson:"last_error,omitempty" json:"last_error,omitempty"`
+
+	// Set while a scheduler instance is publishing; PendingMessageID is the
+	// ID the message is published under, so a retry after a crash can tell
+	// whether it was already sent
+	LeaseOwner       string              `bson:"lease_owner,omitempty" json:"-"`
+	LeaseUntil       *time.Time          `bson:"lease_until,omitempty" json:"-"`
+	PendingMessageID *primitive.ObjectID `bson:"pending_message_id,omitempty" json:"-"`
+
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// UpdateScheduledMessageRequest represents the changes an author can make to
+// a pending scheduled message. Changing send_at restarts the recurrence there.
+type UpdateScheduledMessageRequest struct {
+	Content    *string    `json:"content"`
+	Title      *string    `json:"title"`
+	SendAt     *time.Time `json:"send_at"`
+	Recurrence *string    `json:"recurrence"` // Empty to stop repeating
+	Timezone   *string    `json:"timezone"`
+
+	AllowReplies           *bool `json:"allow_replies"`
+	RequireAcknowledgement *bool `json:"require_acknowledgement"`
+	ReminderAfterHours     *int  `json:"reminder_after_hours"`
+}
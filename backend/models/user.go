@@ -17,6 +17,7 @@ type User struct {
 	OrganizationalUnit string            `bson:"organizational_unit" json:"organizational_unit"`
 	CreatedAt         time.Time          `bson:"created_at" json:"created_at"`
 	UpdatedAt         time.Time          `bson:"updated_at" json:"updated_at"`
+	LastSeenAt        *time.Time         `bson:"last_seen_at,omitempty" json:"last_seen_at,omitempty"`
 }
 
 // UserResponse is the user data returned to clients (without sensitive information)
@@ -29,6 +30,7 @@ type UserResponse struct {
 	OrganizationalUnit string   `json:"organizational_unit"`
 	CreatedAt         time.Time `json:"created_at"`
 	UpdatedAt         time.Time `json:"updated_at"`
+	LastSeenAt        *time.Time `json:"last_seen_at,omitempty"`
 }
 
 // ToResponse converts a User to a UserResponse
@@ -42,5 +44,6 @@ func (u *User) ToResponse() UserResponse {
 		OrganizationalUnit: u.OrganizationalUnit,
 		CreatedAt:         u.CreatedAt,
 		UpdatedAt:         u.UpdatedAt,
+		LastSeenAt:        u.LastSeenAt,
 	}
 }
@@ -0,0 +1,175 @@
+package notifications
+
+import (
+	"chatterbloom/backend/config"
+	"chatterbloom/backend/db"
+	"chatterbloom/backend/models"
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ackReminderPollInterval is how often due reminders are looked for
+const ackReminderPollInterval = time.Minute
+
+// ackReminderLease is how long an instance may take to send a reminder
+// before it is retried
+const ackReminderLease = 5 * time.Minute
+
+// AckReminders reminds the recipients of announcements that require an
+// acknowledgement, once, when the announcement's reminder is due
+type AckReminders struct {
+	DB       *mongo.Client
+	Config   *config.Config
+	Notifier *Notifier
+}
+
+// Run sends due reminders until ctx is done
+func (r *AckReminders) Run(ctx context.Context) {
+	ticker := time.NewTicker(ackReminderPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.SendDue(ctx, time.Now()); err != nil {
+			log.Printf("error: sending acknowledgement reminders: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// SendDue sends every reminder due by now and returns how many announcements
+// were reminded about. Each announcement is leased before its reminder is
+// sent, so several instances can run at once without sending duplicates; a
+// reminder that fails is retried once its lease expires.
+func (r *AckReminders) SendDue(ctx context.Context, now time.Time) (int, error) {
+	messagesColl := db.GetCollection(r.DB, r.Config.DatabaseName, "messages")
+
+	sent := 0
+	for {
+		// Lease the next due announcement
+		var message models.Message
+		err := messagesColl.FindOneAndUpdate(
+			ctx,
+			bson.M{
+				"type":             "announcement",
+				"requires_ack":     true,
+				"reminder_at":      bson.M{"$lte": now},
+				"reminder_sent_at": bson.M{"$exists": false},
+				"deleted_at":       bson.M{"$exists": false},
+				"$or": bson.A{
+					bson.M{"reminder_lease_until": bson.M{"$exists": false}},
+					bson.M{"reminder_lease_until": bson.M{"$lt": now}},
+				},
+			},
+			bson.M{"$set": bson.M{"reminder_lease_until": now.Add(ackReminderLease)}},
+			options.FindOneAndUpdate().SetSort(bson.M{"reminder_at": 1}),
+		).Decode(&message)
+		if err == mongo.ErrNoDocuments {
+			return sent, nil
+		}
+		if err != nil {
+			return sent, err
+		}
+
+		if err := r.remind(ctx, &message, now); err != nil {
+			// Leave the lease to expire so the reminder is retried later
+			log.Printf("error: sending acknowledgement reminder for %s: %v", message.ID.Hex(), err)
+			continue
+		}
+
+		_, err = messagesColl.UpdateOne(
+			ctx,
+			bson.M{"_id": message.ID},
+			bson.M{
+				"$set":   bson.M{"reminder_sent_at": now},
+				"$unset": bson.M{"reminder_lease_until": ""},
+			},
+		)
+		if err != nil {
+			return sent, err
+		}
+		sent++
+	}
+}
+
+// remind notifies every recipient of an announcement who hasn't acknowledged it
+func (r *AckReminders) remind(ctx context.Context, message *models.Message, now time.Time) error {
+	recipients, acknowledged, err := Recipients(ctx, r.DB, r.Config.DatabaseName, message)
+	if err != nil {
+		return err
+	}
+
+	title := "Please acknowledge: " + message.Title
+	var reminders []models.Notification
+	for _, userID := range recipients {
+		if _, ok := acknowledged[userID]; ok {
+			continue
+		}
+		reminders = append(reminders, models.Notification{
+			ID:        primitive.NewObjectID(),
+			UserID:    userID,
+			Type:      models.NotificationAckReminder,
+			GroupID:   &message.GroupID,
+			MessageID: &message.ID,
+			Title:     title,
+			CreatedAt: now,
+		})
+	}
+
+	return r.Notifier.Notify(ctx, reminders)
+}
+
+// Recipients returns who is asked to acknowledge an announcement, which is
+// every member of its group but the sender, and when each recipient who has
+// acknowledged it did so
+func Recipients(ctx context.Context, client *mongo.Client, dbName string, message *models.Message) ([]primitive.ObjectID, map[primitive.ObjectID]time.Time, error) {
+	// Get group members
+	membersColl := db.GetCollection(client, dbName, "group_members")
+	cursor, err := membersColl.Find(ctx, bson.M{
+		"group_id": message.GroupID,
+		"user_id":  bson.M{"$ne": message.SenderID},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var members []models.GroupMember
+	if err := cursor.All(ctx, &members); err != nil {
+		return nil, nil, err
+	}
+
+	recipients := make([]primitive.ObjectID, len(members))
+	for i, member := range members {
+		recipients[i] = member.UserID
+	}
+
+	// Get acknowledgements
+	acksColl := db.GetCollection(client, dbName, "acknowledgements")
+	ackCursor, err := acksColl.Find(ctx, bson.M{"message_id": message.ID})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer ackCursor.Close(ctx)
+
+	var acks []models.Acknowledgement
+	if err := ackCursor.All(ctx, &acks); err != nil {
+		return nil, nil, err
+	}
+
+	acknowledged := make(map[primitive.ObjectID]time.Time, len(acks))
+	for _, ack := range acks {
+		acknowledged[ack.UserID] = ack.AcknowledgedAt
+	}
+	return recipients, acknowledged, nil
+}
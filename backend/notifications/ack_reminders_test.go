@@ -0,0 +1,176 @@
+package notifications
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"chatterbloom/backend/config"
+	"chatterbloom/backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// TestSendDue checks that a reminder that fails to send neither stops the
+// others nor is marked sent, so it is retried once its lease expires
+func TestSendDue(t *testing.T) {
+	now := time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC)
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("failed reminder", func(mt *mtest.T) {
+		sender, member, acknowledger := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+		failing := announcementDoc(sender)
+		reminded := announcementDoc(sender)
+
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: failing}),
+			cursor("group_members", memberDoc(member)),
+			cursor("acknowledgements"),
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 2, Name: "BadValue", Message: "insert failed"}),
+
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: reminded}),
+			cursor("group_members", memberDoc(member), memberDoc(acknowledger)),
+			cursor("acknowledgements", bson.D{
+				{Key: "_id", Value: primitive.NewObjectID()},
+				{Key: "user_id", Value: acknowledger},
+				{Key: "acknowledged_at", Value: now.Add(-time.Hour)},
+			}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}),
+		)
+
+		cfg := &config.Config{DatabaseName: "chatterbloom"}
+		reminders := &AckReminders{DB: mt.Client, Config: cfg, Notifier: &Notifier{DB: mt.Client, Config: cfg}}
+		sent, err := reminders.SendDue(context.Background(), now)
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if sent != 1 {
+			mt.Errorf("got %d reminded, want 1", sent)
+		}
+
+		var leases, inserts, marks []bson.Raw
+		for _, started := range mt.GetAllStartedEvents() {
+			switch started.CommandName {
+			case "findAndModify":
+				leases = append(leases, started.Command)
+			case "insert":
+				inserts = append(inserts, started.Command)
+			case "update":
+				marks = append(marks, started.Command.Lookup("updates", "0").Document())
+			}
+		}
+
+		// Announcements are leased rather than marked sent up front
+		if len(leases) != 3 {
+			mt.Fatalf("got %d leases, want 3", len(leases))
+		}
+		lease := leases[0]
+		if _, err := lease.LookupErr("query", "$or"); err != nil {
+			mt.Errorf("leased announcements regardless of their lease: %s", lease.Lookup("query"))
+		}
+		if until := lease.Lookup("update", "$set", "reminder_lease_until").Time(); !until.Equal(now.Add(ackReminderLease)) {
+			mt.Errorf("leased until %s, want %s", until, now.Add(ackReminderLease))
+		}
+		if _, err := lease.LookupErr("update", "$set", "reminder_sent_at"); err == nil {
+			mt.Error("marked the reminder sent before sending it")
+		}
+
+		// Only the reminder that went out is marked sent
+		if len(marks) != 1 {
+			mt.Fatalf("got %d reminders marked sent, want 1", len(marks))
+		}
+		id := reminded[0].Value.(primitive.ObjectID)
+		if got := marks[0].Lookup("q", "_id").ObjectID(); got != id {
+			mt.Errorf("marked %s sent, want %s", got.Hex(), id.Hex())
+		}
+		if got := marks[0].Lookup("u", "$set", "reminder_sent_at").Time(); !got.Equal(now) {
+			mt.Errorf("marked sent at %s, want %s", got, now)
+		}
+		if _, err := marks[0].LookupErr("u", "$unset", "reminder_lease_until"); err != nil {
+			mt.Error("kept the lease of a sent reminder")
+		}
+
+		// Those who acknowledged aren't reminded
+		if len(inserts) != 2 {
+			mt.Fatalf("got %d inserts, want 2", len(inserts))
+		}
+		docs, _ := inserts[1].Lookup("documents").Array().Values()
+		if len(docs) != 1 || docs[0].Document().Lookup("user_id").ObjectID() != member {
+			mt.Errorf("reminded %v, want only the member who hasn't acknowledged", docs)
+		}
+		if kind := docs[0].Document().Lookup("type").StringValue(); kind != models.NotificationAckReminder {
+			mt.Errorf("got a %s notification", kind)
+		}
+	})
+}
+
+func TestRecipients(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("recipients", func(mt *mtest.T) {
+		sender, member, acknowledger := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+		acknowledgedAt := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+		var message models.Message
+		data, _ := bson.Marshal(announcementDoc(sender))
+		if err := bson.Unmarshal(data, &message); err != nil {
+			mt.Fatal(err)
+		}
+
+		mt.AddMockResponses(
+			cursor("group_members", memberDoc(member), memberDoc(acknowledger)),
+			cursor("acknowledgements", bson.D{
+				{Key: "_id", Value: primitive.NewObjectID()},
+				{Key: "user_id", Value: acknowledger},
+				{Key: "acknowledged_at", Value: acknowledgedAt},
+			}),
+		)
+
+		recipients, acknowledged, err := Recipients(context.Background(), mt.Client, "chatterbloom", &message)
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if len(recipients) != 2 || recipients[0] != member || recipients[1] != acknowledger {
+			mt.Errorf("got recipients %v", recipients)
+		}
+		if len(acknowledged) != 1 || !acknowledged[acknowledger].Equal(acknowledgedAt) {
+			mt.Errorf("got acknowledgements %v", acknowledged)
+		}
+
+		// The sender isn't asked to acknowledge their own announcement
+		started := mt.GetAllStartedEvents()
+		if excluded := started[0].Command.Lookup("filter", "user_id", "$ne").ObjectID(); excluded != sender {
+			mt.Errorf("excluded %s from the recipients, want the sender", excluded.Hex())
+		}
+		if id := started[1].Command.Lookup("filter", "message_id").ObjectID(); id != message.ID {
+			mt.Errorf("got acknowledgements of %s, want %s", id.Hex(), message.ID.Hex())
+		}
+	})
+}
+
+// cursor is a mock reply to a find on coll
+func cursor(coll string, docs ...bson.D) bson.D {
+	return mtest.CreateCursorResponse(0, "chatterbloom."+coll, mtest.FirstBatch, docs...)
+}
+
+func announcementDoc(sender primitive.ObjectID) bson.D {
+	return bson.D{
+		{Key: "_id", Value: primitive.NewObjectID()},
+		{Key: "group_id", Value: primitive.NewObjectID()},
+		{Key: "sender_id", Value: sender},
+		{Key: "type", Value: "announcement"},
+		{Key: "title", Value: "Field trip"},
+		{Key: "requires_ack", Value: true},
+	}
+}
+
+func memberDoc(userID primitive.ObjectID) bson.D {
+	return bson.D{
+		{Key: "_id", Value: primitive.NewObjectID()},
+		{Key: "user_id", Value: userID},
+		{Key: "role", Value: models.GroupRoleMember},
+	}
+}
@@ -0,0 +1,46 @@
+package notifications
+
+import (
+	"chatterbloom/backend/config"
+	"chatterbloom/backend/db"
+	"chatterbloom/backend/models"
+	"chatterbloom/backend/protocol"
+	"chatterbloom/backend/websocket"
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Notifier stores notifications and pushes them to their users' open
+// WebSocket connections
+type Notifier struct {
+	DB     *mongo.Client
+	Config *config.Config
+	Hub    *websocket.Hub
+}
+
+// Notify stores notifications and pushes each one to its user
+func (n *Notifier) Notify(ctx context.Context, notifications []models.Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	docs := make([]interface{}, len(notifications))
+	for i := range notifications {
+		docs[i] = notifications[i]
+	}
+
+	notificationsColl := db.GetCollection(n.DB, n.Config.DatabaseName, "notifications")
+	if _, err := notificationsColl.InsertMany(ctx, docs); err != nil {
+		return err
+	}
+
+	if n.Hub != nil {
+		for _, notification := range notifications {
+			n.Hub.SendToUser(notification.UserID.Hex(), protocol.TypeNotification, protocol.NotificationPayload{
+				Notification: notification,
+			})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+package policy
+
+import (
+	"chatterbloom/backend/constants"
+	"chatterbloom/backend/models"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Requirements a rule can place on a contact between two users
+const (
+	Always        = "always"
+	Never         = "never"
+	SameUnit      = "same_unit"      // Both users are in the same organizational unit
+	SharedGroup   = "shared_group"   // Both users are members of a group other than a direct conversation
+	Guardian      = "guardian"       // One user is a verified guardian of the other
+	SharedStudent = "shared_student" // One user is a verified guardian of a student who shares a group with the other
+)
+
+// Actions the policy governs
+const (
+	ActionContact   = "contact"    // Starting or messaging in a direct conversation
+	ActionAddMember = "add_member" // Adding a user to a group
+)
+
+// Reason codes of denials
+const (
+	ReasonRoleNotAllowed  = "role_not_allowed"
+	ReasonDifferentUnit   = "different_unit"
+	ReasonNoSharedGroup   = "no_shared_group"
+	ReasonNotGuardian     = "not_guardian"
+	ReasonNoSharedStudent = "no_shared_student"
+)
+
+// Rules maps a sender role to the requirement for each recipient role
+type Rules map[string]map[string]string
+
+// Tables holds the rules of each action
+type Tables struct {
+	Contact   Rules `json:"contact"`
+	AddMember Rules `json:"add_member"`
+}
+
+// Policy decides who may contact whom. Units override the default tables
+// for senders in an organizational unit, rule by rule.
+type Policy struct {
+	Tables
+	Units map[string]Tables `json:"units,omitempty"`
+}
+
+// Facts answers the questions rules ask about two users
+type Facts interface {
+	ShareGroup(a, b primitive.ObjectID) (bool, error)
+	IsGuardian(guardian, student primitive.ObjectID) (bool, error)
+	SharesStudent(guardian, other primitive.ObjectID) (bool, error)
+}
+
+// Denial explains why a contact is not allowed
+type Denial struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// Error implements error
+func (d *Denial) Error() string {
+	return d.Message
+}
+
+// MarshalJSON implements json.Marshaler. HTTP errors carrying a denial are
+// sent as the denial itself, reason code included, instead of its message.
+func (d *Denial) MarshalJSON() ([]byte, error) {
+	type denial Denial
+	return json.Marshal((*denial)(d))
+}
+
+// Default returns the school's default policy: staff may reach each other,
+// students only reach the teachers and principals they share a group with and
+// students of their unit and their guardians, parents reach the office, their
+// children and the parents they share a group with, and parents and teachers
+// reach each other through a student in one of the teacher's groups
+func Default() *Policy {
+	return &Policy{Tables: Tables{
+		Contact: Rules{
+			constants.RoleAdmin:     allRoles(Always),
+			constants.RolePrincipal: allRoles(Always),
+			constants.RoleTeacher: {
+				constants.RoleAdmin:     Always,
+				constants.RolePrincipal: Always,
+				constants.RoleTeacher:   Always,
+				constants.RoleStaff:     Always,
+				constants.RoleStudent:   SharedGroup,
+				constants.RoleParent:    SharedStudent,
+			},
+			constants.RoleStaff: {
+				constants.RoleAdmin:     Always,
+				constants.RolePrincipal: Always,
+				constants.RoleTeacher:   Always,
+				constants.RoleStaff:     Always,
+				constants.RoleStudent:   SharedGroup,
+				constants.RoleParent:    Always,
+			},
+			constants.RoleStudent: {
+				constants.RoleAdmin:     Never,
+				constants.RolePrincipal: SharedGroup,
+				constants.RoleTeacher:   SharedGroup,
+				constants.RoleStaff:     Never,
+				constants.RoleStudent:   SameUnit,
+				constants.RoleParent:    Guardian,
+			},
+			constants.RoleParent: {
+				constants.RoleAdmin:     Always,
+				constants.RolePrincipal: Always,
+				constants.RoleTeacher:   SharedStudent,
+				constants.RoleStaff:     Always,
+				constants.RoleStudent:   Guardian,
+				constants.RoleParent:    SharedGroup,
+			},
+		},
+		AddMember: Rules{
+			constants.RoleAdmin:     allRoles(Always),
+			constants.RolePrincipal: allRoles(Always),
+			constants.RoleTeacher:   allRoles(Always),
+			constants.RoleStaff:     allRoles(Always),
+			constants.RoleStudent: {
+				constants.RoleStudent: SameUnit,
+			},
+			constants.RoleParent: {
+				constants.RoleParent: SharedGroup,
+			},
+		},
+	}}
+}
+
+// Load returns the default policy with the rules in a JSON file laid over
+// it. An empty path gives the default policy.
+func Load(path string) (*Policy, error) {
+	p := Default()
+	if path == "" {
+		return p, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var overrides Policy
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("parsing contact policy %s: %w", path, err)
+	}
+	if err := overrides.validate(); err != nil {
+		return nil, fmt.Errorf("contact policy %s: %w", path, err)
+	}
+
+	p.Contact.merge(overrides.Contact)
+	p.AddMember.merge(overrides.AddMember)
+	p.Units = overrides.Units
+	return p, nil
+}
+
+// Requirement returns the rule for sender contacting recipient by action.
+// Pairs without a rule are never allowed.
+func (p *Policy) Requirement(action string, sender, recipient *models.User) string {
+	if unit, ok := p.Units[sender.OrganizationalUnit]; ok {
+		if requirement := unit.rules(action)[sender.Role][recipient.Role]; requirement != "" {
+			return requirement
+		}
+	}
+	if requirement := p.rules(action)[sender.Role][recipient.Role]; requirement != "" {
+		return requirement
+	}
+	return Never
+}
+
+// Check decides whether sender may contact recipient by action, returning
+// nil when allowed
+func (p *Policy) Check(action string, sender, recipient *models.User, facts Facts) (*Denial, error) {
+	switch p.Requirement(action, sender, recipient) {
+	case Always:
+		return nil, nil
+
+	case SameUnit:
+		if sender.OrganizationalUnit != "" && sender.OrganizationalUnit == recipient.OrganizationalUnit {
+			return nil, nil
+		}
+		return &Denial{
+			Reason:  ReasonDifferentUnit,
+			Message: fmt.Sprintf("Users with the %s role can only reach users with the %s role in their own organizational unit", sender.Role, recipient.Role),
+		}, nil
+
+	case SharedGroup:
+		shared, err := facts.ShareGroup(sender.ID, recipient.ID)
+		if err != nil {
+			return nil, err
+		}
+		if shared {
+			return nil, nil
+		}
+		return &Denial{
+			Reason:  ReasonNoSharedGroup,
+			Message: fmt.Sprintf("Users with the %s role can only reach users with the %s role they share a group with", sender.Role, recipient.Role),
+		}, nil
+
+	case Guardian:
+		guardian, err := facts.IsGuardian(sender.ID, recipient.ID)
+		if err == nil && !guardian {
+			guardian, err = facts.IsGuardian(recipient.ID, sender.ID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if guardian {
+			return nil, nil
+		}
+		return &Denial{
+			Reason:  ReasonNotGuardian,
+			Message: fmt.Sprintf("Users with the %s role can only reach users with the %s role they are linked to as guardian and student", sender.Role, recipient.Role),
+		}, nil
+
+	case SharedStudent:
+		shared, err := facts.SharesStudent(sender.ID, recipient.ID)
+		if err == nil && !shared {
+			shared, err = facts.SharesStudent(recipient.ID, sender.ID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if shared {
+			return nil, nil
+		}
+		return &Denial{
+			Reason:  ReasonNoSharedStudent,
+			Message: fmt.Sprintf("Users with the %s role can only reach users with the %s role through a student in one of their groups", sender.Role, recipient.Role),
+		}, nil
+	}
+
+	return &Denial{
+		Reason:  ReasonRoleNotAllowed,
+		Message: fmt.Sprintf("Users with the %s role can't reach users with the %s role", sender.Role, recipient.Role),
+	}, nil
+}
+
+// rules returns the table of an action
+func (t *Tables) rules(action string) Rules {
+	if action == ActionAddMember {
+		return t.AddMember
+	}
+	return t.Contact
+}
+
+// merge lays the rules in overrides over r
+func (r Rules) merge(overrides Rules) {
+	for senderRole, recipients := range overrides {
+		if r[senderRole] == nil {
+			r[senderRole] = make(map[string]string, len(recipients))
+		}
+		for recipientRole, requirement := range recipients {
+			r[senderRole][recipientRole] = requirement
+		}
+	}
+}
+
+// validate checks that every rule names a known requirement
+func (p *Policy) validate() error {
+	tables := []Tables{p.Tables}
+	for _, unit := range p.Units {
+		tables = append(tables, unit)
+	}
+	for _, t := range tables {
+		for _, rules := range []Rules{t.Contact, t.AddMember} {
+			for senderRole, recipients := range rules {
+				for recipientRole, requirement := range recipients {
+					switch requirement {
+					case Always, Never, SameUnit, SharedGroup, Guardian, SharedStudent:
+					default:
+						return fmt.Errorf("unknown requirement %q for %s to %s", requirement, senderRole, recipientRole)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// allRoles returns a rule giving every role the same requirement
+func allRoles(requirement string) map[string]string {
+	return map[string]string{
+		constants.RoleAdmin:     requirement,
+		constants.RolePrincipal: requirement,
+		constants.RoleTeacher:   requirement,
+		constants.RoleStaff:     requirement,
+		constants.RoleStudent:   requirement,
+		constants.RoleParent:    requirement,
+	}
+}
@@ -0,0 +1,226 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"chatterbloom/backend/constants"
+	"chatterbloom/backend/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var roles = []string{
+	constants.RoleAdmin,
+	constants.RolePrincipal,
+	constants.RoleTeacher,
+	constants.RoleStaff,
+	constants.RoleStudent,
+	constants.RoleParent,
+}
+
+// defaultContact is the contact table Default must give, by sender role and
+// then recipient role in the order of roles
+var defaultContact = map[string][]string{
+	constants.RoleAdmin:     {Always, Always, Always, Always, Always, Always},
+	constants.RolePrincipal: {Always, Always, Always, Always, Always, Always},
+	constants.RoleTeacher:   {Always, Always, Always, Always, SharedGroup, SharedStudent},
+	constants.RoleStaff:     {Always, Always, Always, Always, SharedGroup, Always},
+	constants.RoleStudent:   {Never, SharedGroup, SharedGroup, Never, SameUnit, Guardian},
+	constants.RoleParent:    {Always, Always, SharedStudent, Always, Guardian, SharedGroup},
+}
+
+// defaultAddMember is the add_member table Default must give
+var defaultAddMember = map[string][]string{
+	constants.RoleAdmin:     {Always, Always, Always, Always, Always, Always},
+	constants.RolePrincipal: {Always, Always, Always, Always, Always, Always},
+	constants.RoleTeacher:   {Always, Always, Always, Always, Always, Always},
+	constants.RoleStaff:     {Always, Always, Always, Always, Always, Always},
+	constants.RoleStudent:   {Never, Never, Never, Never, SameUnit, Never},
+	constants.RoleParent:    {Never, Never, Never, Never, Never, SharedGroup},
+}
+
+// reasons is the reason code of a denial under each requirement
+var reasons = map[string]string{
+	Never:         ReasonRoleNotAllowed,
+	SameUnit:      ReasonDifferentUnit,
+	SharedGroup:   ReasonNoSharedGroup,
+	Guardian:      ReasonNotGuardian,
+	SharedStudent: ReasonNoSharedStudent,
+}
+
+// fakeFacts answers every question with holds, except that only parents are
+// guardians, so checks must ask in the right direction
+type fakeFacts struct {
+	holds   bool
+	parents map[primitive.ObjectID]bool
+}
+
+func (f fakeFacts) ShareGroup(a, b primitive.ObjectID) (bool, error) {
+	return f.holds, nil
+}
+
+func (f fakeFacts) IsGuardian(guardian, student primitive.ObjectID) (bool, error) {
+	return f.holds && f.parents[guardian], nil
+}
+
+func (f fakeFacts) SharesStudent(guardian, other primitive.ObjectID) (bool, error) {
+	return f.holds && f.parents[guardian], nil
+}
+
+// TestDefaultCheck runs every pair of roles through the default policy, in
+// the same and in different units, with and without the facts rules ask for
+func TestDefaultCheck(t *testing.T) {
+	p := Default()
+	tables := map[string]map[string][]string{
+		ActionContact:   defaultContact,
+		ActionAddMember: defaultAddMember,
+	}
+
+	for action, table := range tables {
+		for _, senderRole := range roles {
+			for i, recipientRole := range roles {
+				requirement := table[senderRole][i]
+				for _, sameUnit := range []bool{true, false} {
+					for _, holds := range []bool{true, false} {
+						sender := &models.User{ID: primitive.NewObjectID(), Role: senderRole, OrganizationalUnit: "Grade 1"}
+						recipient := &models.User{ID: primitive.NewObjectID(), Role: recipientRole, OrganizationalUnit: "Grade 1"}
+						if !sameUnit {
+							recipient.OrganizationalUnit = "Grade 2"
+						}
+						facts := fakeFacts{holds: holds, parents: map[primitive.ObjectID]bool{}}
+						for _, user := range []*models.User{sender, recipient} {
+							facts.parents[user.ID] = user.Role == constants.RoleParent
+						}
+
+						allowed := requirement == Always ||
+							(requirement == SameUnit && sameUnit) ||
+							(holds && (requirement == SharedGroup || requirement == Guardian || requirement == SharedStudent))
+
+						denial, err := p.Check(action, sender, recipient, facts)
+						if err != nil {
+							t.Fatal(err)
+						}
+						name := action + " " + senderRole + " to " + recipientRole
+						switch {
+						case allowed && denial != nil:
+							t.Errorf("%s (same unit %v, facts hold %v): denied with %s, want allowed", name, sameUnit, holds, denial.Reason)
+						case !allowed && denial == nil:
+							t.Errorf("%s (same unit %v, facts hold %v): allowed, want denied", name, sameUnit, holds)
+						case !allowed && denial.Reason != reasons[requirement]:
+							t.Errorf("%s (same unit %v, facts hold %v): got reason %s, want %s", name, sameUnit, holds, denial.Reason, reasons[requirement])
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestUnitOverrides checks that a unit's rules apply to senders in that unit
+// only, and fall back to the default tables for pairs they don't name
+func TestUnitOverrides(t *testing.T) {
+	p := Default()
+	p.Units = map[string]Tables{
+		"Grade 12": {Contact: Rules{constants.RoleStudent: {constants.RoleStudent: Always}}},
+	}
+
+	cases := []struct {
+		senderUnit, senderRole, recipientRole string
+		want                                  string
+	}{
+		{"Grade 12", constants.RoleStudent, constants.RoleStudent, Always},
+		{"Grade 11", constants.RoleStudent, constants.RoleStudent, SameUnit},
+		{"", constants.RoleStudent, constants.RoleStudent, SameUnit},
+		{"Grade 12", constants.RoleStudent, constants.RoleTeacher, SharedGroup},
+		{"Grade 12", constants.RoleStudent, constants.RoleAdmin, Never},
+	}
+	for _, c := range cases {
+		sender := &models.User{Role: c.senderRole, OrganizationalUnit: c.senderUnit}
+		recipient := &models.User{Role: c.recipientRole, OrganizationalUnit: "Grade 12"}
+		if got := p.Requirement(ActionContact, sender, recipient); got != c.want {
+			t.Errorf("%s in %q to %s: got %s, want %s", c.senderRole, c.senderUnit, c.recipientRole, got, c.want)
+		}
+	}
+
+	// An unknown role has no rules
+	if got := p.Requirement(ActionContact, &models.User{Role: "visitor"}, &models.User{Role: constants.RoleAdmin}); got != Never {
+		t.Errorf("unknown role: got %s, want %s", got, Never)
+	}
+}
+
+// TestLoad checks that a policy file is laid over the default policy rule by
+// rule, and that bad files are rejected
+func TestLoad(t *testing.T) {
+	t.Run("no file", func(t *testing.T) {
+		p, err := Load("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Requirement(ActionContact, &models.User{Role: constants.RoleStudent}, &models.User{Role: constants.RoleTeacher}); got != SharedGroup {
+			t.Errorf("got %s, want the default %s", got, SharedGroup)
+		}
+	})
+
+	t.Run("overrides", func(t *testing.T) {
+		p, err := Load(writePolicy(t, `{
+			"contact": {"student": {"teacher": "always"}, "visitor": {"admin": "always"}},
+			"add_member": {"parent": {"parent": "never"}},
+			"units": {"Grade 1": {"contact": {"student": {"student": "never"}}}}
+		}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cases := []struct {
+			action, senderUnit, senderRole, recipientRole string
+			want                                          string
+		}{
+			{ActionContact, "", constants.RoleStudent, constants.RoleTeacher, Always},
+			{ActionContact, "", constants.RoleStudent, constants.RolePrincipal, SharedGroup},
+			{ActionContact, "", "visitor", constants.RoleAdmin, Always},
+			{ActionAddMember, "", constants.RoleParent, constants.RoleParent, Never},
+			{ActionAddMember, "", constants.RoleStudent, constants.RoleStudent, SameUnit},
+			{ActionContact, "Grade 1", constants.RoleStudent, constants.RoleStudent, Never},
+			{ActionContact, "Grade 2", constants.RoleStudent, constants.RoleStudent, SameUnit},
+		}
+		for _, c := range cases {
+			sender := &models.User{Role: c.senderRole, OrganizationalUnit: c.senderUnit}
+			if got := p.Requirement(c.action, sender, &models.User{Role: c.recipientRole}); got != c.want {
+				t.Errorf("%s %s in %q to %s: got %s, want %s", c.action, c.senderRole, c.senderUnit, c.recipientRole, got, c.want)
+			}
+		}
+
+		// The default policy is left alone
+		if got := Default().Requirement(ActionContact, &models.User{Role: constants.RoleStudent}, &models.User{Role: constants.RoleTeacher}); got != SharedGroup {
+			t.Errorf("Load changed the default policy: got %s, want %s", got, SharedGroup)
+		}
+	})
+
+	for name, contents := range map[string]string{
+		"invalid JSON":             `{"contact": `,
+		"unknown requirement":      `{"contact": {"student": {"teacher": "sometimes"}}}`,
+		"unknown unit requirement": `{"units": {"Grade 1": {"add_member": {"student": {"student": "maybe"}}}}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Load(writePolicy(t, contents)); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+			t.Error("got no error")
+		}
+	})
+}
+
+func writePolicy(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,212 @@
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+
+	"chatterbloom/backend/models"
+)
+
+// Version is the current version of the WebSocket protocol
+const Version = 1
+
+// Client-to-server event types
+const (
+	TypeSubscribe   = "subscribe"
+	TypeUnsubscribe = "unsubscribe"
+	TypeSendMessage = "send_message"
+	TypeTypingStart = "typing_start"
+	TypeTypingStop  = "typing_stop"
+	TypeMarkRead    = "mark_read"
+	TypeResume      = "resume"
+	TypeDelivered   = "delivered"
+	TypeSetPresence = "set_presence"
+)
+
+// Server-to-client event types
+const (
+	TypeAck             = "ack"
+	TypeError           = "error"
+	TypeSubscribed      = "subscribed"
+	TypeUnsubscribed    = "unsubscribed"
+	TypeNewMessage      = "new_message"
+	TypeAnnouncement    = "announcement"
+	TypeResumed         = "resumed"
+	TypeResync          = "resync"
+	TypePresence        = "presence"
+	TypeMessageUpdated  = "message_updated"
+	TypeMessageDeleted  = "message_deleted"
+	TypeThreadReply     = "thread_reply"
+	TypeReactionUpdated = "reaction_updated"
+	TypeAcknowledged    = "acknowledged"
+	TypeNotification    = "notification"
+
+	TypeConversationStarted = "conversation_started"
+)
+
+// Presence statuses
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceOffline = "offline"
+)
+
+// Error codes sent in ErrorPayload
+const (
+	ErrorInvalidFrame       = "invalid_frame"
+	ErrorUnsupportedVersion = "unsupported_version"
+	ErrorUnknownType        = "unknown_type"
+	ErrorForbidden          = "forbidden"
+	ErrorBadRequest         = "bad_request"
+	ErrorNotFound           = "not_found"
+	ErrorRateLimited        = "rate_limited"
+	ErrorInternal           = "internal_error"
+)
+
+// Envelope wraps every frame sent in either direction
+type Envelope struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"` // Set by the client, echoed back in ack/error
+	Version int             `json:"version"`
+	RoomID  string          `json:"room_id,omitempty"` // Set on persisted room events
+	Seq     int64           `json:"seq,omitempty"`     // Per-room sequence number of persisted room events
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// RoomPayload is the payload of subscribe, unsubscribe, subscribed and unsubscribed
+type RoomPayload struct {
+	RoomID string `json:"room_id"`
+}
+
+// SendMessagePayload is the payload of send_message
+type SendMessagePayload struct {
+	GroupID  string `json:"group_id"`
+	Content  string `json:"content"`
+	ParentID string `json:"parent_id,omitempty"` // Set to reply in a thread
+}
+
+// TypingPayload is the payload of typing_start and typing_stop, in both directions
+type TypingPayload struct {
+	GroupID string `json:"group_id"`
+	UserID  string `json:"user_id,omitempty"` // Filled in by the server
+}
+
+// MarkReadPayload is the payload of mark_read
+type MarkReadPayload struct {
+	MessageID string `json:"message_id"`
+}
+
+// ResumePayload is the payload of resume, mapping room IDs to the last
+// sequence number the client has seen in each
+type ResumePayload struct {
+	Rooms map[string]int64 `json:"rooms"`
+}
+
+// ResumedPayload is the payload of resumed, sent after a room's missed
+// events have been replayed. Complete is false when more events were missed
+// than can be replayed and the client should reload the room over REST.
+type ResumedPayload struct {
+	RoomID   string `json:"room_id"`
+	Replayed int    `json:"replayed"`
+	Complete bool   `json:"complete"`
+}
+
+// DeliveredPayload is the payload of delivered, sent by clients to
+// acknowledge the room events they have processed. While acks move Seq
+// forward, a client that falls behind is told to resync instead of dropped.
+type DeliveredPayload struct {
+	RoomID string `json:"room_id"`
+	Seq    int64  `json:"seq"`
+}
+
+// PresencePayload is the payload of set_presence and presence. Clients may
+// only set online or away; the server fills in the user and last seen time.
+type PresencePayload struct {
+	UserID     string     `json:"user_id,omitempty"`
+	Status     string     `json:"status"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+}
+
+// MessagePayload is the payload of new_message, announcement,
+// message_updated, message_deleted and send_message acks
+type MessagePayload struct {
+	Message models.MessageResponse `json:"message"`
+}
+
+// ThreadReplyPayload is the payload of thread_reply, carrying the reply and
+// the root's updated thread summary
+type ThreadReplyPayload struct {
+	Message      models.MessageResponse `json:"message"`
+	ThreadRootID string                 `json:"thread_root_id"`
+	ReplyCount   int                    `json:"reply_count"`
+	LastReplyAt  time.Time              `json:"last_reply_at"`
+}
+
+// ReactionPayload is the payload of reaction_updated
+type ReactionPayload struct {
+	MessageID string         `json:"message_id"`
+	UserID    string         `json:"user_id"`
+	Emoji     string         `json:"emoji"`
+	Added     bool           `json:"added"`
+	Reactions map[string]int `json:"reactions"` // Updated count per emoji
+}
+
+// AcknowledgedPayload is the payload of acknowledged
+type AcknowledgedPayload struct {
+	MessageID string `json:"message_id"`
+	UserID    string `json:"user_id"`
+	AckCount  int    `json:"ack_count"`
+}
+
+// NotificationPayload is the payload of notification, sent only to the
+// notified user
+type NotificationPayload struct {
+	Notification models.Notification `json:"notification"`
+}
+
+// ConversationPayload is the payload of conversation_started, sent to the
+// other participant when a direct conversation is created
+type ConversationPayload struct {
+	Conversation models.ChatGroupResponse `json:"conversation"`
+}
+
+// ErrorPayload is the payload of error
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Reason  string `json:"reason,omitempty"` // Contact policy reason code of forbidden errors
+}
+
+// NewEvent builds a server-to-client envelope around payload
+func NewEvent(eventType string, payload interface{}) (Envelope, error) {
+	env := Envelope{Type: eventType, Version: Version}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return Envelope{}, err
+		}
+		env.Payload = data
+	}
+	return env, nil
+}
+
+// Encode builds an envelope around payload and marshals it to JSON
+func Encode(eventType, id string, payload interface{}) ([]byte, error) {
+	env, err := NewEvent(eventType, payload)
+	if err != nil {
+		return nil, err
+	}
+	env.ID = id
+	return json.Marshal(env)
+}
+
+// EncodeRoomEvent builds a sequenced room event envelope and marshals it to JSON
+func EncodeRoomEvent(eventType, roomID string, seq int64, payload interface{}) ([]byte, error) {
+	env, err := NewEvent(eventType, payload)
+	if err != nil {
+		return nil, err
+	}
+	env.RoomID = roomID
+	env.Seq = seq
+	return json.Marshal(env)
+}
@@ -0,0 +1,187 @@
+package readstate
+
+import (
+	"context"
+	"sync"
+
+	"chatterbloom/backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore is a Store kept in memory, for tests. Messages keep their
+// read_by as raw values, so they can hold string IDs like old documents do.
+type MemoryStore struct {
+	mu       sync.Mutex
+	messages map[primitive.ObjectID]*memoryMessage
+	states   map[stateKey]Position
+}
+
+// memoryMessage is a stored message and its raw read_by; nil means missing
+type memoryMessage struct {
+	message models.Message
+	readBy  bson.A
+}
+
+// stateKey identifies a user's read position in a group
+type stateKey struct {
+	userID, groupID primitive.ObjectID
+}
+
+// NewMemoryStore creates an empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		messages: make(map[primitive.ObjectID]*memoryMessage),
+		states:   make(map[stateKey]Position),
+	}
+}
+
+// AddMessage stores a message. readBy holds ObjectIDs and hex strings, or is
+// nil for a message without read_by; message.ReadBy is ignored.
+func (s *MemoryStore) AddMessage(message models.Message, readBy bson.A) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages[message.ID] = &memoryMessage{message: message, readBy: readBy}
+}
+
+// ReadBy returns a message's read_by as the server decodes it
+func (s *MemoryStore) ReadBy(messageID primitive.ObjectID) (models.ObjectIDs, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	document := bson.M{}
+	if stored, ok := s.messages[messageID]; ok && stored.readBy != nil {
+		document["read_by"] = stored.readBy
+	}
+	data, err := bson.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	var decoded struct {
+		ReadBy models.ObjectIDs `bson:"read_by"`
+	}
+	err = bson.Unmarshal(data, &decoded)
+	return decoded.ReadBy, err
+}
+
+// lists reports whether a raw read_by holds a user in either form
+func lists(readBy bson.A, userID primitive.ObjectID) bool {
+	for _, value := range readBy {
+		if value == userID || value == userID.Hex() {
+			return true
+		}
+	}
+	return false
+}
+
+// Advance implements Store
+func (s *MemoryStore) Advance(ctx context.Context, userID primitive.ObjectID, message *models.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := stateKey{userID: userID, groupID: message.GroupID}
+	position := PositionOf(message)
+	previous, ok := s.states[key]
+	if ok && !previous.Before(position) {
+		return nil
+	}
+	s.states[key] = position
+
+	for _, stored := range s.messages {
+		m := stored.message
+		if m.GroupID != message.GroupID || m.Type != "announcement" || m.CreatedAt.After(position.CreatedAt) {
+			continue
+		}
+		if ok && m.CreatedAt.Before(previous.CreatedAt) {
+			continue
+		}
+		if !lists(stored.readBy, userID) {
+			stored.readBy = append(stored.readBy, userID)
+		}
+	}
+	return nil
+}
+
+// Positions implements Store
+func (s *MemoryStore) Positions(ctx context.Context, userID primitive.ObjectID, memberships []models.GroupMember) (map[primitive.ObjectID]Position, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	positions := make(map[primitive.ObjectID]Position, len(memberships))
+	for _, membership := range memberships {
+		position, ok := s.states[stateKey{userID: userID, groupID: membership.GroupID}]
+		if !ok {
+			position = Position{CreatedAt: membership.JoinedAt}
+		}
+		positions[membership.GroupID] = position
+	}
+	return positions, nil
+}
+
+// UnreadCounts implements Store
+func (s *MemoryStore) UnreadCounts(ctx context.Context, userID primitive.ObjectID, positions map[primitive.ObjectID]Position) (map[primitive.ObjectID]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := make(map[primitive.ObjectID]int, len(positions))
+	for _, stored := range s.messages {
+		m := &stored.message
+		position, ok := positions[m.GroupID]
+		if !ok || !position.Before(PositionOf(m)) {
+			continue
+		}
+		if m.SenderID == userID || m.ThreadRootID != nil || m.DeletedAt != nil {
+			continue
+		}
+		counts[m.GroupID]++
+	}
+	return counts, nil
+}
+
+// RepairReadBy implements Store
+func (s *MemoryStore) RepairReadBy(ctx context.Context, dryRun bool) (ReadByRepair, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var repair ReadByRepair
+	for _, stored := range s.messages {
+		if stored.readBy == nil {
+			repair.Missing++
+			if !dryRun {
+				stored.readBy = bson.A{}
+			}
+			continue
+		}
+
+		hasStrings := false
+		for _, value := range stored.readBy {
+			if _, ok := value.(string); ok {
+				hasStrings = true
+			}
+		}
+		if !hasStrings {
+			continue
+		}
+		repair.StringIDs++
+		if dryRun {
+			continue
+		}
+
+		repaired := bson.A{}
+		seen := make(map[primitive.ObjectID]bool, len(stored.readBy))
+		for _, value := range stored.readBy {
+			id, ok := value.(primitive.ObjectID)
+			if hex, isString := value.(string); isString {
+				parsed, err := primitive.ObjectIDFromHex(hex)
+				id, ok = parsed, err == nil
+			}
+			if ok && !seen[id] {
+				seen[id] = true
+				repaired = append(repaired, id)
+			}
+		}
+		stored.readBy = repaired
+	}
+	return repair, nil
+}
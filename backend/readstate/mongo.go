@@ -0,0 +1,220 @@
+package readstate
+
+import (
+	"context"
+	"time"
+
+	"chatterbloom/backend/db"
+	"chatterbloom/backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStore is a Store on the read_state and messages collections
+type MongoStore struct {
+	client *mongo.Client
+	dbName string
+}
+
+// NewMongoStore creates a store on a database. Collections are looked up on
+// use, so client may be connected later.
+func NewMongoStore(client *mongo.Client, dbName string) *MongoStore {
+	return &MongoStore{client: client, dbName: dbName}
+}
+
+// collection returns a handle to one of the store's collections
+func (s *MongoStore) collection(name string) *mongo.Collection {
+	return db.GetCollection(s.client, s.dbName, name)
+}
+
+// unreadBy matches read_by arrays that don't list a user in either form
+func unreadBy(userID primitive.ObjectID) bson.M {
+	return bson.M{"$nin": bson.A{userID, userID.Hex()}}
+}
+
+// Advance implements Store
+func (s *MongoStore) Advance(ctx context.Context, userID primitive.ObjectID, message *models.Message) error {
+	position := PositionOf(message)
+
+	// Only match states behind the new position; if the user's state is
+	// already ahead, the upsert collides with it and there is nothing to do
+	var previous models.ReadState
+	err := s.collection("read_state").FindOneAndUpdate(
+		ctx,
+		bson.M{
+			"user_id":  userID,
+			"group_id": message.GroupID,
+			"$or": bson.A{
+				bson.M{"last_read_at": bson.M{"$lt": position.CreatedAt}},
+				bson.M{"last_read_at": position.CreatedAt, "last_read_message_id": bson.M{"$lt": position.ID}},
+			},
+		},
+		bson.M{"$set": bson.M{
+			"last_read_message_id": position.ID,
+			"last_read_at":         position.CreatedAt,
+			"updated_at":           time.Now(),
+		}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before),
+	).Decode(&previous)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	// Record receipts on the announcements between the old and new position.
+	// Users already listed as a string aren't added again, and a null
+	// read_by, which $addToSet refuses, starts a new array.
+	createdAt := bson.M{"$lte": position.CreatedAt}
+	if err == nil {
+		createdAt["$gte"] = previous.LastReadAt
+	}
+	_, err = s.collection("messages").UpdateMany(
+		ctx,
+		bson.M{
+			"group_id":   message.GroupID,
+			"type":       "announcement",
+			"created_at": createdAt,
+			"read_by":    unreadBy(userID),
+		},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				"read_by": bson.M{"$concatArrays": bson.A{
+					bson.M{"$ifNull": bson.A{"$read_by", bson.A{}}},
+					bson.A{userID},
+				}},
+			}}},
+		},
+	)
+	return err
+}
+
+// Positions implements Store
+func (s *MongoStore) Positions(ctx context.Context, userID primitive.ObjectID, memberships []models.GroupMember) (map[primitive.ObjectID]Position, error) {
+	positions := make(map[primitive.ObjectID]Position, len(memberships))
+	groupIDs := make([]primitive.ObjectID, 0, len(memberships))
+	for _, membership := range memberships {
+		positions[membership.GroupID] = Position{CreatedAt: membership.JoinedAt}
+		groupIDs = append(groupIDs, membership.GroupID)
+	}
+
+	cursor, err := s.collection("read_state").Find(
+		ctx,
+		bson.M{"user_id": userID, "group_id": bson.M{"$in": groupIDs}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var states []models.ReadState
+	if err := cursor.All(ctx, &states); err != nil {
+		return nil, err
+	}
+
+	for _, state := range states {
+		positions[state.GroupID] = Position{CreatedAt: state.LastReadAt, ID: state.LastReadMessageID}
+	}
+	return positions, nil
+}
+
+// UnreadCounts implements Store in one aggregation
+func (s *MongoStore) UnreadCounts(ctx context.Context, userID primitive.ObjectID, positions map[primitive.ObjectID]Position) (map[primitive.ObjectID]int, error) {
+	counts := make(map[primitive.ObjectID]int, len(positions))
+	if len(positions) == 0 {
+		return counts, nil
+	}
+
+	unreadInGroup := make(bson.A, 0, len(positions))
+	for groupID, position := range positions {
+		unreadInGroup = append(unreadInGroup, bson.M{
+			"group_id": groupID,
+			"$or": bson.A{
+				bson.M{"created_at": bson.M{"$gt": position.CreatedAt}},
+				bson.M{"created_at": position.CreatedAt, "_id": bson.M{"$gt": position.ID}},
+			},
+		})
+	}
+
+	cursor, err := s.collection("messages").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"$or":            unreadInGroup,
+			"sender_id":      bson.M{"$ne": userID},
+			"thread_root_id": bson.M{"$exists": false},
+			"deleted_at":     bson.M{"$exists": false},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": "$group_id", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		GroupID primitive.ObjectID `bson:"_id"`
+		Count   int                `bson:"count"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		counts[result.GroupID] = result.Count
+	}
+	return counts, nil
+}
+
+// RepairReadBy implements Store
+func (s *MongoStore) RepairReadBy(ctx context.Context, dryRun bool) (ReadByRepair, error) {
+	messagesColl := s.collection("messages")
+
+	var repair ReadByRepair
+	stringIDs := bson.M{"read_by": bson.M{"$type": "string"}}
+	missing := bson.M{"read_by": nil}
+
+	if dryRun {
+		var err error
+		if repair.StringIDs, err = messagesColl.CountDocuments(ctx, stringIDs); err != nil {
+			return repair, err
+		}
+		repair.Missing, err = messagesColl.CountDocuments(ctx, missing)
+		return repair, err
+	}
+
+	// Convert every entry to an ObjectID, then drop the ones that failed
+	// and deduplicate the rest
+	result, err := messagesColl.UpdateMany(ctx, stringIDs, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"read_by": bson.M{"$setUnion": bson.A{
+				bson.M{"$filter": bson.M{
+					"input": bson.M{"$map": bson.M{
+						"input": "$read_by",
+						"in": bson.M{"$convert": bson.M{
+							"input":   "$$this",
+							"to":      "objectId",
+							"onError": nil,
+							"onNull":  nil,
+						}},
+					}},
+					"cond": bson.M{"$ne": bson.A{"$$this", nil}},
+				}},
+			}},
+		}}},
+	})
+	if err != nil {
+		return repair, err
+	}
+	repair.StringIDs = result.ModifiedCount
+
+	result, err = messagesColl.UpdateMany(ctx, missing, bson.M{"$set": bson.M{"read_by": bson.A{}}})
+	if err != nil {
+		return repair, err
+	}
+	repair.Missing = result.ModifiedCount
+
+	return repair, nil
+}
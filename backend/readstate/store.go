@@ -0,0 +1,61 @@
+package readstate
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"chatterbloom/backend/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Position is how far a user has read in a group. Messages are ordered by
+// creation time, with the ID breaking ties between messages created in the
+// same millisecond; a zero ID means nothing at CreatedAt has been read.
+type Position struct {
+	CreatedAt time.Time
+	ID        primitive.ObjectID
+}
+
+// PositionOf returns the position of a message
+func PositionOf(message *models.Message) Position {
+	return Position{CreatedAt: message.CreatedAt, ID: message.ID}
+}
+
+// Before reports whether p comes before other
+func (p Position) Before(other Position) bool {
+	if !p.CreatedAt.Equal(other.CreatedAt) {
+		return p.CreatedAt.Before(other.CreatedAt)
+	}
+	return bytes.Compare(p.ID[:], other.ID[:]) < 0
+}
+
+// ReadByRepair counts the messages changed by Store.RepairReadBy
+type ReadByRepair struct {
+	StringIDs int64 // Messages whose read_by held user IDs as strings
+	Missing   int64 // Messages with a missing or null read_by
+}
+
+// Store keeps users' read positions and the read_by receipts of
+// announcements. Older versions wrote read_by entries as hex strings, so
+// every method must cope with arrays mixing strings and ObjectIDs.
+type Store interface {
+	// Advance moves a user's read position in a message's group forward to
+	// it. Positions never move backwards, so marking an older message read
+	// is a no-op. Announcements passed over also record the user in read_by.
+	Advance(ctx context.Context, userID primitive.ObjectID, message *models.Message) error
+
+	// Positions returns the position each of the user's memberships has been
+	// read up to. Groups the user has never marked read start when they joined.
+	Positions(ctx context.Context, userID primitive.ObjectID, memberships []models.GroupMember) (map[primitive.ObjectID]Position, error)
+
+	// UnreadCounts returns how many top-level messages from other users are
+	// past each position. Groups with nothing unread are left out.
+	UnreadCounts(ctx context.Context, userID primitive.ObjectID, positions map[primitive.ObjectID]Position) (map[primitive.ObjectID]int, error)
+
+	// RepairReadBy rewrites string user IDs in read_by as ObjectIDs, dropping
+	// invalid entries and duplicates, and gives messages without read_by an
+	// empty one. With dryRun set, it only counts what would change.
+	RepairReadBy(ctx context.Context, dryRun bool) (ReadByRepair, error)
+}
@@ -0,0 +1,235 @@
+package readstate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"chatterbloom/backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The Mongo store must stay interchangeable with the one these tests use
+var _ Store = (*MongoStore)(nil)
+
+// fixture is a group whose announcements have read_by arrays as old and new
+// versions wrote them
+type fixture struct {
+	store                *MemoryStore
+	user, other, groupID primitive.ObjectID
+	joinedAt             time.Time
+	messages             []models.Message // Oldest first
+}
+
+// Indexes into fixture.messages
+const (
+	readAsString  = iota // Announcement listing the user as a string
+	readByOther          // Announcement listing another user both ways and an invalid ID
+	missingReadBy        // Announcement without read_by
+	fromUser             // Regular message sent by the user
+	reply                // Reply in a thread
+	deleted              // Deleted regular message
+	latest               // Announcement read by the user as an ObjectID
+)
+
+func newFixture() *fixture {
+	f := &fixture{
+		store:    NewMemoryStore(),
+		user:     primitive.NewObjectID(),
+		other:    primitive.NewObjectID(),
+		groupID:  primitive.NewObjectID(),
+		joinedAt: time.Date(2024, 9, 1, 8, 0, 0, 0, time.UTC),
+	}
+
+	readBy := []bson.A{
+		readAsString:  {f.user.Hex()},
+		readByOther:   {f.other, f.other.Hex(), "not-an-id"},
+		missingReadBy: nil,
+		fromUser:      {},
+		reply:         {},
+		deleted:       {},
+		latest:        {f.user},
+	}
+	for i := range readBy {
+		message := models.Message{
+			ID:        primitive.NewObjectID(),
+			GroupID:   f.groupID,
+			SenderID:  f.other,
+			Type:      "announcement",
+			CreatedAt: f.joinedAt.Add(time.Duration(i+1) * time.Minute),
+		}
+		switch i {
+		case fromUser:
+			message.Type = "regular"
+			message.SenderID = f.user
+		case reply:
+			message.Type = "regular"
+			message.ThreadRootID = &f.messages[readAsString].ID
+		case deleted:
+			message.Type = "regular"
+			message.DeletedAt = &message.CreatedAt
+		}
+		f.messages = append(f.messages, message)
+		f.store.AddMessage(message, readBy[i])
+	}
+	return f
+}
+
+// readBy returns a message's decoded read_by
+func (f *fixture) readBy(t *testing.T, i int) models.ObjectIDs {
+	t.Helper()
+	ids, err := f.store.ReadBy(f.messages[i].ID)
+	if err != nil {
+		t.Fatalf("decoding read_by of message %d: %v", i, err)
+	}
+	return ids
+}
+
+// unread returns the user's unread count in the group
+func (f *fixture) unread(t *testing.T) int {
+	t.Helper()
+	ctx := context.Background()
+	membership := models.GroupMember{GroupID: f.groupID, UserID: f.user, JoinedAt: f.joinedAt}
+	positions, err := f.store.Positions(ctx, f.user, []models.GroupMember{membership})
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts, err := f.store.UnreadCounts(ctx, f.user, positions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return counts[f.groupID]
+}
+
+// count returns how many times ids lists id
+func count(ids models.ObjectIDs, id primitive.ObjectID) int {
+	n := 0
+	for _, listed := range ids {
+		if listed == id {
+			n++
+		}
+	}
+	return n
+}
+
+// TestMarkReadOnMixedReadBy checks that marking a group read records the user
+// once on every announcement passed over, whatever form read_by is in
+func TestMarkReadOnMixedReadBy(t *testing.T) {
+	f := newFixture()
+	ctx := context.Background()
+
+	if err := f.store.Advance(ctx, f.user, &f.messages[latest]); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, i := range []int{readAsString, readByOther, missingReadBy, latest} {
+		if n := count(f.readBy(t, i), f.user); n != 1 {
+			t.Errorf("announcement %d lists the user %d times, want 1", i, n)
+		}
+	}
+	if ids := f.readBy(t, readByOther); count(ids, f.other) != 1 || len(ids) != 2 {
+		t.Errorf("announcement read by another user decoded as %v, want that user and ours", ids)
+	}
+	if n := len(f.readBy(t, fromUser)); n != 0 {
+		t.Errorf("regular message lists %d readers, want 0", n)
+	}
+
+	// Marking an older message read doesn't move the position back
+	if err := f.store.Advance(ctx, f.user, &f.messages[readAsString]); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.unread(t); n != 0 {
+		t.Errorf("got %d unread after moving back, want 0", n)
+	}
+}
+
+// TestUnreadCountsOnMixedReadBy checks that unread counts follow the read
+// position and skip the user's own messages, replies and deleted messages
+func TestUnreadCountsOnMixedReadBy(t *testing.T) {
+	f := newFixture()
+	ctx := context.Background()
+
+	// Everything since joining: three announcements before the user's own
+	// message and one after
+	if n := f.unread(t); n != 4 {
+		t.Errorf("got %d unread before reading, want 4", n)
+	}
+
+	if err := f.store.Advance(ctx, f.user, &f.messages[readByOther]); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.unread(t); n != 2 {
+		t.Errorf("got %d unread after reading two announcements, want 2", n)
+	}
+
+	if err := f.store.Advance(ctx, f.user, &f.messages[latest]); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.unread(t); n != 0 {
+		t.Errorf("got %d unread after reading everything, want 0", n)
+	}
+}
+
+// TestRepairReadBy checks that the repair counts and fixes string IDs and
+// missing arrays, keeps every valid reader, and leaves nothing to do after
+func TestRepairReadBy(t *testing.T) {
+	f := newFixture()
+	ctx := context.Background()
+	want := ReadByRepair{StringIDs: 2, Missing: 1}
+
+	repair, err := f.store.RepairReadBy(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repair != want {
+		t.Errorf("dry run got %+v, want %+v", repair, want)
+	}
+	if f.store.messages[f.messages[missingReadBy].ID].readBy != nil {
+		t.Error("dry run changed a message")
+	}
+
+	repair, err = f.store.RepairReadBy(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repair != want {
+		t.Errorf("repair got %+v, want %+v", repair, want)
+	}
+
+	wantReaders := map[int][]primitive.ObjectID{
+		readAsString:  {f.user},
+		readByOther:   {f.other},
+		missingReadBy: {},
+		latest:        {f.user},
+	}
+	for i, readers := range wantReaders {
+		raw := f.store.messages[f.messages[i].ID].readBy
+		if raw == nil || len(raw) != len(readers) {
+			t.Errorf("announcement %d has read_by %v after repair, want %v", i, raw, readers)
+			continue
+		}
+		for j, value := range raw {
+			if value != readers[j] {
+				t.Errorf("announcement %d has read_by %v after repair, want %v", i, raw, readers)
+			}
+		}
+	}
+
+	repair, err = f.store.RepairReadBy(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repair != (ReadByRepair{}) {
+		t.Errorf("second repair got %+v, want nothing to do", repair)
+	}
+
+	// Repaired arrays still work with mark-read
+	if err := f.store.Advance(ctx, f.user, &f.messages[latest]); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(f.readBy(t, missingReadBy), f.user); n != 1 {
+		t.Errorf("repaired announcement lists the user %d times, want 1", n)
+	}
+}
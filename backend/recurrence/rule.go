@@ -0,0 +1,207 @@
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequencies supported by Rule
+const (
+	Daily  = "DAILY"
+	Weekly = "WEEKLY"
+)
+
+// maxInterval bounds INTERVAL so finding the next occurrence stays cheap
+const maxInterval = 52
+
+var weekdays = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// Rule is a parsed recurrence rule. Occurrences happen at the wall-clock
+// time of the series' first occurrence, in that occurrence's location.
+type Rule struct {
+	Freq     string
+	Interval int            // Every Interval days or weeks
+	ByDay    []time.Weekday // Weekly only; defaults to the first occurrence's weekday
+	Count    int            // Total number of occurrences; 0 for no limit
+	Until    time.Time      // Last possible occurrence; zero for no limit
+
+	// UntilDate is set when UNTIL was a date, which ends the series after
+	// that day in the series' own location; Until is then midnight UTC
+	UntilDate bool
+}
+
+// Parse reads "daily", "weekly" or a subset of an RFC 5545 RRULE:
+// FREQ=DAILY or WEEKLY with optional INTERVAL, BYDAY, COUNT, UNTIL and
+// WKST=MO, e.g. "FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10"
+func Parse(rule string) (Rule, error) {
+	rule = strings.TrimPrefix(strings.TrimSpace(rule), "RRULE:")
+	switch strings.ToLower(rule) {
+	case "daily":
+		return Rule{Freq: Daily, Interval: 1}, nil
+	case "weekly":
+		return Rule{Freq: Weekly, Interval: 1}, nil
+	}
+
+	r := Rule{Interval: 1}
+	for _, part := range strings.Split(rule, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return Rule{}, fmt.Errorf("invalid recurrence part %q", part)
+		}
+
+		switch strings.ToUpper(name) {
+		case "FREQ":
+			r.Freq = strings.ToUpper(value)
+			if r.Freq != Daily && r.Freq != Weekly {
+				return Rule{}, fmt.Errorf("unsupported FREQ %q", value)
+			}
+		case "INTERVAL":
+			interval, err := strconv.Atoi(value)
+			if err != nil || interval < 1 || interval > maxInterval {
+				return Rule{}, fmt.Errorf("INTERVAL must be between 1 and %d", maxInterval)
+			}
+			r.Interval = interval
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				weekday, ok := weekdays[strings.ToUpper(day)]
+				if !ok {
+					return Rule{}, fmt.Errorf("invalid BYDAY %q", day)
+				}
+				r.ByDay = append(r.ByDay, weekday)
+			}
+		case "COUNT":
+			count, err := strconv.Atoi(value)
+			if err != nil || count < 1 {
+				return Rule{}, errors.New("COUNT must be a positive number")
+			}
+			r.Count = count
+		case "UNTIL":
+			if until, err := time.Parse("20060102T150405Z", value); err == nil {
+				r.Until = until
+			} else if until, err := time.Parse("20060102", value); err == nil {
+				r.Until, r.UntilDate = until, true
+			} else {
+				return Rule{}, fmt.Errorf("invalid UNTIL %q", value)
+			}
+		case "WKST":
+			// Weeks always start on Monday, which is RRULE's default
+			if strings.ToUpper(value) != "MO" {
+				return Rule{}, errors.New("only WKST=MO is supported")
+			}
+		default:
+			return Rule{}, fmt.Errorf("unsupported recurrence part %q", name)
+		}
+	}
+
+	if r.Freq == "" {
+		return Rule{}, errors.New("FREQ is required")
+	}
+	if len(r.ByDay) > 0 && r.Freq != Weekly {
+		return Rule{}, errors.New("BYDAY is only supported with FREQ=WEEKLY")
+	}
+	return r, nil
+}
+
+// Next returns the first occurrence strictly after after, for a series whose
+// first occurrence is start and of which done occurrences have happened. It
+// reports false once the series is over.
+func (r Rule) Next(start, after time.Time, done int) (time.Time, bool) {
+	if r.Count > 0 && done >= r.Count {
+		return time.Time{}, false
+	}
+	if after.Before(start) {
+		return start, !r.pastUntil(start)
+	}
+
+	// Walk forward a day at a time; every window of 7*Interval days holds
+	// at least one occurrence
+	after = after.In(start.Location())
+	startDay := civilDay(start)
+	first := daysBetween(startDay, civilDay(after))
+	for i := first; i <= first+7*r.Interval+1; i++ {
+		if !r.matches(start, i) {
+			continue
+		}
+
+		occurrence := wallClock(startDay.AddDate(0, 0, i), start)
+		if !occurrence.After(after) {
+			continue
+		}
+		if r.pastUntil(occurrence) {
+			return time.Time{}, false
+		}
+		return occurrence, true
+	}
+	return time.Time{}, false
+}
+
+// pastUntil reports whether an occurrence is after the end of the series
+func (r Rule) pastUntil(occurrence time.Time) bool {
+	if r.Until.IsZero() {
+		return false
+	}
+	if r.UntilDate {
+		return civilDay(occurrence).After(r.Until)
+	}
+	return occurrence.After(r.Until)
+}
+
+// matches reports whether the day offset days after the series' first day
+// has an occurrence
+func (r Rule) matches(start time.Time, offset int) bool {
+	if r.Freq == Daily {
+		return offset%r.Interval == 0
+	}
+
+	// Weeks start on Monday, as in RRULE's default WKST
+	sinceMonday := (int(start.Weekday()) + 6) % 7
+	if (sinceMonday+offset)/7%r.Interval != 0 {
+		return false
+	}
+
+	weekday := time.Weekday((int(start.Weekday()) + offset) % 7)
+	if len(r.ByDay) == 0 {
+		return weekday == start.Weekday()
+	}
+	for _, day := range r.ByDay {
+		if day == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// wallClock returns the time on day at start's wall-clock time in start's
+// location. A time skipped when clocks go forward is taken as being that
+// long after the change, as RFC 5545 has it, rather than before.
+func wallClock(day, start time.Time) time.Time {
+	occurrence := time.Date(day.Year(), day.Month(), day.Day(),
+		start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+
+	want := time.Date(day.Year(), day.Month(), day.Day(),
+		start.Hour(), start.Minute(), start.Second(), 0, time.UTC)
+	got := time.Date(occurrence.Year(), occurrence.Month(), occurrence.Day(),
+		occurrence.Hour(), occurrence.Minute(), occurrence.Second(), 0, time.UTC)
+	return occurrence.Add(want.Sub(got))
+}
+
+// civilDay returns t's calendar date in its own location, as midnight UTC
+func civilDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// daysBetween counts calendar days from a to b, both from civilDay
+func daysBetween(a, b time.Time) int {
+	return int(b.Sub(a).Hours() / 24)
+}
@@ -0,0 +1,266 @@
+package recurrence
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	valid := map[string]Rule{
+		"daily":  {Freq: Daily, Interval: 1},
+		"Weekly": {Freq: Weekly, Interval: 1},
+		"RRULE:FREQ=WEEKLY;BYDAY=mo,WE;COUNT=10": {
+			Freq: Weekly, Interval: 1, ByDay: []time.Weekday{time.Monday, time.Wednesday}, Count: 10,
+		},
+		"FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,TH;WKST=MO": {
+			Freq: Weekly, Interval: 2, ByDay: []time.Weekday{time.Tuesday, time.Thursday},
+		},
+		"freq=daily;interval=52": {Freq: Daily, Interval: 52},
+		"FREQ=DAILY;UNTIL=20240103T090000Z": {
+			Freq: Daily, Interval: 1, Until: time.Date(2024, 1, 3, 9, 0, 0, 0, time.UTC),
+		},
+		"FREQ=DAILY;UNTIL=20240103": {
+			Freq: Daily, Interval: 1, Until: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), UntilDate: true,
+		},
+	}
+	for rule, want := range valid {
+		got, err := Parse(rule)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", rule, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", rule, got, want)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"monthly",
+		"FREQ",
+		"INTERVAL=2",
+		"FREQ=MONTHLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;INTERVAL=53",
+		"FREQ=DAILY;INTERVAL=two",
+		"FREQ=DAILY;BYDAY=MO",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=WEEKLY;COUNT=0",
+		"FREQ=WEEKLY;UNTIL=2024-01-03",
+		"FREQ=WEEKLY;WKST=SU",
+		"FREQ=WEEKLY;BYMONTH=1",
+	}
+	for _, rule := range invalid {
+		if got, err := Parse(rule); err == nil {
+			t.Errorf("Parse(%q) = %+v, want an error", rule, got)
+		}
+	}
+}
+
+// TestNext walks series the way the scheduler does, from their first
+// occurrence until they end
+func TestNext(t *testing.T) {
+	newYork := location(t, "America/New_York")
+	tokyo := location(t, "Asia/Tokyo")
+	london := location(t, "Europe/London")
+
+	cases := []struct {
+		name, rule string
+		start      time.Time
+		want       []time.Time
+	}{
+		{
+			name:  "daily",
+			rule:  "daily",
+			start: utc(2024, 1, 1, 9),
+			want:  []time.Time{utc(2024, 1, 1, 9), utc(2024, 1, 2, 9), utc(2024, 1, 3, 9), utc(2024, 1, 4, 9)},
+		},
+		{
+			name:  "every third day",
+			rule:  "FREQ=DAILY;INTERVAL=3",
+			start: utc(2024, 1, 30, 9),
+			want:  []time.Time{utc(2024, 1, 30, 9), utc(2024, 2, 2, 9), utc(2024, 2, 5, 9), utc(2024, 2, 8, 9)},
+		},
+		{
+			name:  "weekly on the first occurrence's weekday",
+			rule:  "weekly",
+			start: utc(2024, 1, 3, 9),
+			want:  []time.Time{utc(2024, 1, 3, 9), utc(2024, 1, 10, 9), utc(2024, 1, 17, 9), utc(2024, 1, 24, 9)},
+		},
+		{
+			name:  "several days a week",
+			rule:  "FREQ=WEEKLY;BYDAY=MO,WE,FR;WKST=MO",
+			start: utc(2024, 1, 1, 9),
+			want:  []time.Time{utc(2024, 1, 1, 9), utc(2024, 1, 3, 9), utc(2024, 1, 5, 9), utc(2024, 1, 8, 9)},
+		},
+		{
+			// The first occurrence counts even off the listed days
+			name:  "every other week from midweek",
+			rule:  "FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,TH;WKST=MO",
+			start: utc(2024, 1, 3, 9),
+			want:  []time.Time{utc(2024, 1, 3, 9), utc(2024, 1, 4, 9), utc(2024, 1, 16, 9), utc(2024, 1, 18, 9), utc(2024, 1, 30, 9)},
+		},
+		{
+			// Sunday ends the week that starts on Monday the 1st, so the
+			// Monday right after it is in the skipped week
+			name:  "every other week from a Sunday",
+			rule:  "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,SU;WKST=MO",
+			start: utc(2024, 1, 7, 9),
+			want:  []time.Time{utc(2024, 1, 7, 9), utc(2024, 1, 15, 9), utc(2024, 1, 21, 9), utc(2024, 1, 29, 9), utc(2024, 2, 4, 9)},
+		},
+		{
+			name:  "count",
+			rule:  "FREQ=WEEKLY;BYDAY=TU,TH;COUNT=3",
+			start: utc(2024, 1, 2, 9),
+			want:  []time.Time{utc(2024, 1, 2, 9), utc(2024, 1, 4, 9), utc(2024, 1, 9, 9)},
+		},
+		{
+			name:  "until a time",
+			rule:  "FREQ=DAILY;UNTIL=20240103T090000Z",
+			start: utc(2024, 1, 1, 9),
+			want:  []time.Time{utc(2024, 1, 1, 9), utc(2024, 1, 2, 9), utc(2024, 1, 3, 9)},
+		},
+		{
+			name:  "until just before a time",
+			rule:  "FREQ=DAILY;UNTIL=20240103T085959Z",
+			start: utc(2024, 1, 1, 9),
+			want:  []time.Time{utc(2024, 1, 1, 9), utc(2024, 1, 2, 9)},
+		},
+		{
+			// 20:00 in New York is already the next day in UTC
+			name:  "until a date west of UTC",
+			rule:  "FREQ=DAILY;UNTIL=20240103",
+			start: time.Date(2024, 1, 1, 20, 0, 0, 0, newYork),
+			want: []time.Time{
+				time.Date(2024, 1, 1, 20, 0, 0, 0, newYork),
+				time.Date(2024, 1, 2, 20, 0, 0, 0, newYork),
+				time.Date(2024, 1, 3, 20, 0, 0, 0, newYork),
+			},
+		},
+		{
+			// 08:00 in Tokyo is still the previous day in UTC
+			name:  "until a date east of UTC",
+			rule:  "FREQ=DAILY;UNTIL=20240103",
+			start: time.Date(2024, 1, 1, 8, 0, 0, 0, tokyo),
+			want: []time.Time{
+				time.Date(2024, 1, 1, 8, 0, 0, 0, tokyo),
+				time.Date(2024, 1, 2, 8, 0, 0, 0, tokyo),
+				time.Date(2024, 1, 3, 8, 0, 0, 0, tokyo),
+			},
+		},
+		{
+			name:  "until before the first occurrence",
+			rule:  "FREQ=DAILY;UNTIL=20231231",
+			start: utc(2024, 1, 1, 9),
+		},
+		{
+			// Wall-clock time is kept when clocks spring forward
+			name:  "weekly across the start of daylight saving time",
+			rule:  "FREQ=WEEKLY;BYDAY=SU",
+			start: time.Date(2024, 3, 3, 9, 0, 0, 0, newYork),
+			want: []time.Time{
+				utc(2024, 3, 3, 14),
+				utc(2024, 3, 10, 13),
+				utc(2024, 3, 17, 13),
+			},
+		},
+		{
+			// and when they fall back
+			name:  "daily across the end of daylight saving time",
+			rule:  "daily",
+			start: time.Date(2024, 10, 26, 8, 30, 0, 0, london),
+			want: []time.Time{
+				time.Date(2024, 10, 26, 7, 30, 0, 0, time.UTC),
+				time.Date(2024, 10, 27, 8, 30, 0, 0, time.UTC),
+				time.Date(2024, 10, 28, 8, 30, 0, 0, time.UTC),
+			},
+		},
+		{
+			// A time skipped by the change happens an hour later that day
+			name:  "daily at a time skipped by daylight saving time",
+			rule:  "daily",
+			start: time.Date(2024, 3, 9, 2, 30, 0, 0, newYork),
+			want: []time.Time{
+				time.Date(2024, 3, 9, 2, 30, 0, 0, newYork),
+				time.Date(2024, 3, 10, 3, 30, 0, 0, newYork),
+				time.Date(2024, 3, 11, 2, 30, 0, 0, newYork),
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rule, err := Parse(c.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []time.Time
+			after := c.start.Add(-time.Minute)
+			for len(got) < len(c.want)+1 {
+				next, ok := rule.Next(c.start, after, len(got))
+				if !ok {
+					break
+				}
+				got = append(got, next)
+				after = next
+			}
+
+			// Series without an end go on past the expected occurrences
+			if rule.Count == 0 && rule.Until.IsZero() && len(got) > len(c.want) {
+				got = got[:len(c.want)]
+			}
+			if len(got) != len(c.want) {
+				t.Fatalf("got %d occurrences %v, want %v", len(got), got, c.want)
+			}
+			for i := range got {
+				if !got[i].Equal(c.want[i]) {
+					t.Errorf("occurrence %d: got %s, want %s", i, got[i], c.want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestNextSkipsMissed checks that a series resumed after a gap continues with
+// the first occurrence after the gap
+func TestNextSkipsMissed(t *testing.T) {
+	rule, err := Parse("FREQ=WEEKLY;BYDAY=MO,FR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := utc(2024, 1, 1, 9)
+
+	next, ok := rule.Next(start, utc(2024, 1, 17, 12), 1)
+	if !ok || !next.Equal(utc(2024, 1, 19, 9)) {
+		t.Errorf("got %s, %v, want %s", next, ok, utc(2024, 1, 19, 9))
+	}
+
+	// At an occurrence, the next one is returned
+	next, ok = rule.Next(start, utc(2024, 1, 19, 9), 2)
+	if !ok || !next.Equal(utc(2024, 1, 22, 9)) {
+		t.Errorf("got %s, %v, want %s", next, ok, utc(2024, 1, 22, 9))
+	}
+
+	// Occurrences are given in the series' location
+	newYork := location(t, "America/New_York")
+	next, _ = rule.Next(start.In(newYork), utc(2024, 1, 17, 12), 1)
+	if next.Location() != newYork {
+		t.Errorf("got an occurrence in %s, want %s", next.Location(), newYork)
+	}
+}
+
+func utc(year int, month time.Month, day, hour int) time.Time {
+	return time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+}
+
+func location(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+	return loc
+}
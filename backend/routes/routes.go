@@ -5,21 +5,33 @@ import (
 	"chatterbloom/backend/constants"
 	"chatterbloom/backend/controllers"
 	"chatterbloom/backend/middleware"
+	"chatterbloom/backend/policy"
+	"chatterbloom/backend/storage"
 	"chatterbloom/backend/websocket"
 
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-// RegisterRoutes registers all routes for the application
-func RegisterRoutes(e *echo.Echo, db *mongo.Client, hub *websocket.Hub) {
+// RegisterRoutes registers all routes for the application. Messages are
+// handled by messageController, which background jobs may share.
+func RegisterRoutes(e *echo.Echo, db *mongo.Client, hub *websocket.Hub, blobs storage.BlobStore, contacts *policy.Policy, messageController *controllers.MessageController) {
 	// Load configuration
 	cfg := config.LoadConfig()
 
 	// Initialize controllers
-	authController := &controllers.AuthController{DB: db, Config: cfg}
-	groupController := &controllers.GroupController{DB: db, Config: cfg}
-	messageController := &controllers.MessageController{DB: db, Config: cfg, Hub: hub}
+	authController := &controllers.AuthController{DB: db, Config: cfg, Hub: hub}
+	groupController := &controllers.GroupController{DB: db, Config: cfg, Hub: hub, Contacts: contacts}
+	reactionController := &controllers.ReactionController{DB: db, Config: cfg, Hub: hub}
+	searchController := &controllers.SearchController{DB: db, Config: cfg, Messages: messageController}
+	acknowledgementController := &controllers.AcknowledgementController{DB: db, Config: cfg, Hub: hub, Messages: messageController}
+	notificationController := &controllers.NotificationController{DB: db, Config: cfg}
+	conversationController := &controllers.ConversationController{DB: db, Config: cfg, Hub: hub, Contacts: contacts}
+	guardianshipController := &controllers.GuardianshipController{DB: db, Config: cfg, Hub: hub}
+	campaignController := &controllers.CampaignController{DB: db, Config: cfg, Messages: messageController}
+	scheduledMessageController := &controllers.ScheduledMessageController{DB: db, Config: cfg, Messages: messageController}
+	attachmentController := &controllers.AttachmentController{DB: db, Config: cfg, Blobs: blobs, Messages: messageController}
+	wsController := &controllers.WebSocketController{DB: db, Config: cfg, Hub: hub, Messages: messageController}
 
 	// Auth middleware
 	jwtMiddleware := middleware.JWTAuth(cfg)
@@ -28,10 +40,8 @@ func RegisterRoutes(e *echo.Echo, db *mongo.Client, hub *websocket.Hub) {
 	e.POST("/api/auth/login", authController.Login)
 	e.POST("/api/auth/register", authController.Register)
 
-	// WebSocket endpoint
-	e.GET("/ws", func(c echo.Context) error {
-		return websocket.ServeWs(hub, c)
-	})
+	// WebSocket endpoint - authenticates the handshake itself
+	e.GET("/ws", wsController.Connect)
 
 	// For development purposes, make some routes public
 	if cfg.Environment == "development" {
@@ -54,6 +64,9 @@ func RegisterRoutes(e *echo.Echo, db *mongo.Client, hub *websocket.Hub) {
 	api.GET("/user/profile", authController.GetProfile)
 	api.PUT("/user/profile", authController.UpdateProfile)
 
+	// WebSocket ticket for clients that can't send headers on the handshake
+	api.POST("/ws/ticket", wsController.IssueTicket)
+
 	// Group routes - protected in production
 	if cfg.Environment != "development" {
 		api.GET("/groups", groupController.GetGroups)
@@ -73,6 +86,10 @@ func RegisterRoutes(e *echo.Echo, db *mongo.Client, hub *websocket.Hub) {
 	adminRoutes.PUT("/users/:id", authController.UpdateUser)
 	adminRoutes.DELETE("/users/:id", authController.DeleteUser)
 	adminRoutes.GET("/groups/all", groupController.GetAllGroups)
+	adminRoutes.GET("/guardianships", guardianshipController.GetGuardianships)
+	adminRoutes.POST("/guardianships", guardianshipController.CreateGuardianship)
+	adminRoutes.PUT("/guardianships/:id", guardianshipController.UpdateGuardianship)
+	adminRoutes.DELETE("/guardianships/:id", guardianshipController.DeleteGuardianship)
 	
 	// Teacher routes
 	teacherRoutes := api.Group("/teacher")
@@ -80,10 +97,34 @@ func RegisterRoutes(e *echo.Echo, db *mongo.Client, hub *websocket.Hub) {
 	
 	// Class management routes
 	teacherRoutes.POST("/groups/:id/announcement", messageController.SendAnnouncement)
+	teacherRoutes.POST("/campaigns", campaignController.SendCampaign)
+	teacherRoutes.GET("/campaigns", campaignController.GetCampaigns)
+	teacherRoutes.GET("/campaigns/:id", campaignController.GetCampaignStats)
 	
 	// These routes are always protected
 	api.POST("/groups/:id/members", groupController.AddMemberToGroup)
 	api.DELETE("/groups/:id/members/:userId", groupController.RemoveMemberFromGroup)
+	api.PUT("/groups/:id/members/:userId/role", groupController.UpdateMemberRole)
+	api.GET("/groups/:id/presence", groupController.GetGroupPresence)
+	api.POST("/conversations/direct", conversationController.CreateDirectConversation)
+	api.PUT("/groups/:id/settings", groupController.UpdateGroupSettings)
+	api.PUT("/groups/:id/read", messageController.MarkGroupRead)
+	api.PUT("/messages/:id", messageController.UpdateMessage)
+	api.DELETE("/messages/:id", messageController.DeleteMessage)
 	api.PUT("/messages/:id/read", messageController.MarkMessageAsRead)
+	api.GET("/messages/:id/thread", messageController.GetThread)
+	api.POST("/messages/:id/reactions", reactionController.AddReaction)
+	api.DELETE("/messages/:id/reactions", reactionController.RemoveReaction)
+	api.POST("/messages/attachments", attachmentController.SendMessageWithAttachments)
+	api.GET("/messages/:id/attachments/:attachmentId", attachmentController.DownloadAttachment)
+	api.GET("/messages/:id/attachments/:attachmentId/thumbnail", attachmentController.DownloadThumbnail)
 	api.GET("/messages/unread", messageController.GetUnreadCount)
+	api.GET("/search/messages", searchController.SearchMessages)
+	api.POST("/messages/:id/acknowledge", acknowledgementController.Acknowledge)
+	api.GET("/messages/:id/acknowledgements", acknowledgementController.GetAcknowledgements)
+	api.GET("/notifications", notificationController.GetNotifications)
+	api.PUT("/notifications/:id/read", notificationController.MarkNotificationRead)
+	api.GET("/scheduled-messages", scheduledMessageController.GetScheduledMessages)
+	api.PUT("/scheduled-messages/:id", scheduledMessageController.UpdateScheduledMessage)
+	api.DELETE("/scheduled-messages/:id", scheduledMessageController.CancelScheduledMessage)
 }
@@ -0,0 +1,209 @@
+package scheduler
+
+import (
+	"chatterbloom/backend/config"
+	"chatterbloom/backend/db"
+	"chatterbloom/backend/models"
+	"chatterbloom/backend/recurrence"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// pollInterval is how often due scheduled messages are looked for
+const pollInterval = 15 * time.Second
+
+// leaseDuration is how long an instance may take to publish a scheduled
+// message before another instance may retry it
+const leaseDuration = 2 * time.Minute
+
+// ErrRejected is wrapped by publishers when a scheduled message can never be
+// published, e.g. because its author left the group. Other errors are retried.
+var ErrRejected = errors.New("scheduled message rejected")
+
+// Publisher publishes a due scheduled message under the given message ID
+type Publisher interface {
+	PublishScheduled(ctx context.Context, schedule *models.ScheduledMessage, messageID primitive.ObjectID) error
+}
+
+// Scheduler publishes scheduled messages when they are due
+type Scheduler struct {
+	DB        *mongo.Client
+	Config    *config.Config
+	Publisher Publisher
+	Instance  string // Identifies this instance's leases; defaults to host and process ID
+}
+
+// Run publishes due scheduled messages until ctx is done
+func (s *Scheduler) Run(ctx context.Context) {
+	if s.Instance == "" {
+		s.Instance = defaultInstance()
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.PublishDue(ctx, time.Now()); err != nil {
+			log.Printf("error: publishing scheduled messages: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// PublishDue publishes every scheduled message due by now and returns how
+// many were published. Each is leased before publishing, so several
+// instances can run at once; a lease left by a crashed instance expires and
+// the message is retried under the same message ID, which is never published
+// twice.
+func (s *Scheduler) PublishDue(ctx context.Context, now time.Time) (int, error) {
+	schedulesColl := db.GetCollection(s.DB, s.Config.DatabaseName, "scheduled_messages")
+
+	published := 0
+	for {
+		// Lease the next due message, picking its message ID on first lease
+		var schedule models.ScheduledMessage
+		err := schedulesColl.FindOneAndUpdate(
+			ctx,
+			bson.M{
+				"status":  models.ScheduleStatusPending,
+				"send_at": bson.M{"$lte": now},
+				"$or": bson.A{
+					bson.M{"lease_until": bson.M{"$exists": false}},
+					bson.M{"lease_until": bson.M{"$lt": now}},
+				},
+			},
+			mongo.Pipeline{{{Key: "$set", Value: bson.M{
+				"lease_owner":        s.Instance,
+				"lease_until":        now.Add(leaseDuration),
+				"pending_message_id": bson.M{"$ifNull": bson.A{"$pending_message_id", primitive.NewObjectID()}},
+			}}}},
+			options.FindOneAndUpdate().
+				SetSort(bson.M{"send_at": 1}).
+				SetReturnDocument(options.After),
+		).Decode(&schedule)
+		if err == mongo.ErrNoDocuments {
+			return published, nil
+		}
+		if err != nil {
+			return published, err
+		}
+
+		sent, err := s.publish(ctx, &schedule, now)
+		if err != nil {
+			// Leave the lease to expire so the message is retried later
+			log.Printf("error: publishing scheduled message %s: %v", schedule.ID.Hex(), err)
+			continue
+		}
+		if sent {
+			published++
+		}
+	}
+}
+
+// publish publishes a leased scheduled message, unless a previous attempt
+// already did, and moves it on to its next occurrence. It reports false for
+// messages the publisher rejected, which are marked failed.
+func (s *Scheduler) publish(ctx context.Context, schedule *models.ScheduledMessage, now time.Time) (bool, error) {
+	messageID := *schedule.PendingMessageID
+
+	// Check whether a previous attempt published the message
+	messagesColl := db.GetCollection(s.DB, s.Config.DatabaseName, "messages")
+	count, err := messagesColl.CountDocuments(ctx, bson.M{"_id": messageID})
+	if err != nil {
+		return false, err
+	}
+
+	if count == 0 {
+		err := s.Publisher.PublishScheduled(ctx, schedule, messageID)
+		if errors.Is(err, ErrRejected) {
+			return false, s.release(ctx, schedule, bson.M{
+				"status":     models.ScheduleStatusFailed,
+				"last_error": err.Error(),
+				"updated_at": now,
+			}, 0)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	update := bson.M{
+		"last_sent_at":    now,
+		"last_message_id": messageID,
+		"updated_at":      now,
+	}
+	if next, ok := nextOccurrence(schedule, now); ok {
+		update["send_at"] = next
+	} else {
+		update["status"] = models.ScheduleStatusCompleted
+	}
+	return true, s.release(ctx, schedule, update, 1)
+}
+
+// release applies update to a leased scheduled message and gives up the lease
+func (s *Scheduler) release(ctx context.Context, schedule *models.ScheduledMessage, update bson.M, sent int) error {
+	schedulesColl := db.GetCollection(s.DB, s.Config.DatabaseName, "scheduled_messages")
+	result, err := schedulesColl.UpdateOne(
+		ctx,
+		bson.M{"_id": schedule.ID, "lease_owner": s.Instance},
+		bson.M{
+			"$set":   update,
+			"$inc":   bson.M{"sent_count": sent},
+			"$unset": bson.M{"lease_owner": "", "lease_until": "", "pending_message_id": ""},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("lease on %s expired before it was released", schedule.ID.Hex())
+	}
+	return nil
+}
+
+// nextOccurrence returns when a scheduled message that was just sent is due
+// again, skipping occurrences missed while no scheduler was running
+func nextOccurrence(schedule *models.ScheduledMessage, now time.Time) (time.Time, bool) {
+	if schedule.Recurrence == "" {
+		return time.Time{}, false
+	}
+
+	rule, err := recurrence.Parse(schedule.Recurrence)
+	if err != nil {
+		log.Printf("error: scheduled message %s has an invalid recurrence: %v", schedule.ID.Hex(), err)
+		return time.Time{}, false
+	}
+	location, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+
+	after := schedule.SendAt
+	if now.After(after) {
+		after = now
+	}
+	return rule.Next(schedule.FirstSendAt.In(location), after, schedule.SentCount+1)
+}
+
+// defaultInstance names this process for leases
+func defaultInstance() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), primitive.NewObjectID().Hex())
+}
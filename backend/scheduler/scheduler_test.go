@@ -0,0 +1,304 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"chatterbloom/backend/config"
+	"chatterbloom/backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+const testInstance = "test-instance"
+
+// fakePublisher records the message IDs it is asked to publish under
+type fakePublisher struct {
+	err       error
+	published []primitive.ObjectID
+}
+
+func (p *fakePublisher) PublishScheduled(ctx context.Context, schedule *models.ScheduledMessage, messageID primitive.ObjectID) error {
+	p.published = append(p.published, messageID)
+	return p.err
+}
+
+// TestPublishDue checks how a leased scheduled message is published and
+// released, depending on whether a previous attempt already published it and
+// on how publishing goes
+func TestPublishDue(t *testing.T) {
+	now := time.Date(2024, 1, 10, 9, 0, 30, 0, time.UTC)
+
+	cases := []struct {
+		name       string
+		recurrence string
+		// Whether a previous attempt already published the message
+		alreadyPublished bool
+		publishErr       error
+		// Matched count of the release
+		leaseHeld bool
+
+		wantPublish   bool
+		wantPublished int
+		// Fields the release sets, and the increment of sent_count; no
+		// release is expected without wantSet
+		wantSet map[string]interface{}
+		wantInc int32
+	}{
+		{
+			name:          "one-off",
+			leaseHeld:     true,
+			wantPublish:   true,
+			wantPublished: 1,
+			wantSet:       map[string]interface{}{"status": models.ScheduleStatusCompleted},
+			wantInc:       1,
+		},
+		{
+			name:          "recurring",
+			recurrence:    "daily",
+			leaseHeld:     true,
+			wantPublish:   true,
+			wantPublished: 1,
+			wantSet:       map[string]interface{}{"send_at": time.Date(2024, 1, 11, 9, 0, 0, 0, time.UTC)},
+			wantInc:       1,
+		},
+		{
+			// A previous attempt crashed after publishing
+			name:             "already published",
+			alreadyPublished: true,
+			leaseHeld:        true,
+			wantPublished:    1,
+			wantSet:          map[string]interface{}{"status": models.ScheduleStatusCompleted},
+			wantInc:          1,
+		},
+		{
+			name:        "rejected",
+			publishErr:  fmt.Errorf("%w: author left the group", ErrRejected),
+			leaseHeld:   true,
+			wantPublish: true,
+			wantSet:     map[string]interface{}{"status": models.ScheduleStatusFailed},
+			wantInc:     0,
+		},
+		{
+			// The lease is left to expire so the message is retried
+			name:        "publishing fails",
+			publishErr:  errors.New("connection reset"),
+			wantPublish: true,
+		},
+		{
+			name:          "lease expired before release",
+			wantPublish:   true,
+			wantPublished: 0,
+			wantSet:       map[string]interface{}{"status": models.ScheduleStatusCompleted},
+			wantInc:       1,
+		},
+	}
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for _, c := range cases {
+		mt.Run(c.name, func(mt *mtest.T) {
+			scheduleID := primitive.NewObjectID()
+			messageID := primitive.NewObjectID()
+			sendAt := time.Date(2024, 1, 10, 9, 0, 0, 0, time.UTC)
+			schedule := scheduleDoc(mt, models.ScheduledMessage{
+				ID:               scheduleID,
+				Content:          "Reminder",
+				Recurrence:       c.recurrence,
+				Timezone:         "UTC",
+				FirstSendAt:      sendAt.AddDate(0, 0, -3),
+				SendAt:           sendAt,
+				Status:           models.ScheduleStatusPending,
+				SentCount:        3,
+				LeaseOwner:       testInstance,
+				PendingMessageID: &messageID,
+			})
+
+			count := cursor("messages")
+			if c.alreadyPublished {
+				count = cursor("messages", bson.D{{Key: "n", Value: 1}})
+			}
+			replies := []bson.D{
+				mtest.CreateSuccessResponse(bson.E{Key: "value", Value: schedule}),
+				count,
+			}
+			if c.wantSet != nil {
+				matched := 0
+				if c.leaseHeld {
+					matched = 1
+				}
+				replies = append(replies, mtest.CreateSuccessResponse(bson.E{Key: "n", Value: matched}))
+			}
+			replies = append(replies, mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}))
+			mt.AddMockResponses(replies...)
+
+			publisher := &fakePublisher{err: c.publishErr}
+			s := &Scheduler{DB: mt.Client, Config: &config.Config{DatabaseName: "chatterbloom"}, Publisher: publisher, Instance: testInstance}
+			published, err := s.PublishDue(context.Background(), now)
+			if err != nil {
+				mt.Fatal(err)
+			}
+			if published != c.wantPublished {
+				mt.Errorf("got %d published, want %d", published, c.wantPublished)
+			}
+
+			if c.wantPublish {
+				if len(publisher.published) != 1 || publisher.published[0] != messageID {
+					mt.Errorf("published under %v, want the leased message ID %s", publisher.published, messageID.Hex())
+				}
+			} else if len(publisher.published) != 0 {
+				mt.Errorf("published a message a previous attempt already published")
+			}
+
+			var release bson.Raw
+			for _, started := range mt.GetAllStartedEvents() {
+				if started.CommandName == "update" {
+					release = started.Command.Lookup("updates", "0").Document()
+				}
+			}
+			if c.wantSet == nil {
+				if release != nil {
+					mt.Errorf("released the lease: %s", release)
+				}
+				return
+			}
+			if release == nil {
+				mt.Fatal("didn't release the lease")
+			}
+
+			if owner := release.Lookup("q", "lease_owner").StringValue(); owner != testInstance {
+				mt.Errorf("released a lease owned by %q, want %q", owner, testInstance)
+			}
+			if id := release.Lookup("q", "_id").ObjectID(); id != scheduleID {
+				mt.Errorf("released %s, want %s", id.Hex(), scheduleID.Hex())
+			}
+			for field, want := range c.wantSet {
+				got := release.Lookup("u", "$set", field)
+				switch want := want.(type) {
+				case string:
+					if got.StringValue() != want {
+						mt.Errorf("set %s to %s, want %s", field, got, want)
+					}
+				case time.Time:
+					if !got.Time().Equal(want) {
+						mt.Errorf("set %s to %s, want %s", field, got.Time(), want)
+					}
+				}
+			}
+			if c.wantInc > 0 {
+				if id := release.Lookup("u", "$set", "last_message_id").ObjectID(); id != messageID {
+					mt.Errorf("set last_message_id to %s, want %s", id.Hex(), messageID.Hex())
+				}
+			}
+			if inc := release.Lookup("u", "$inc", "sent_count").Int32(); inc != c.wantInc {
+				mt.Errorf("incremented sent_count by %d, want %d", inc, c.wantInc)
+			}
+			for _, field := range []string{"lease_owner", "lease_until", "pending_message_id"} {
+				if _, err := release.LookupErr("u", "$unset", field); err != nil {
+					mt.Errorf("didn't unset %s", field)
+				}
+			}
+		})
+	}
+}
+
+// TestPublishDueLease checks which scheduled messages are leased, and that a
+// retried lease keeps the message ID of the first attempt
+func TestPublishDueLease(t *testing.T) {
+	now := time.Date(2024, 1, 10, 9, 0, 30, 0, time.UTC)
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("lease", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}))
+
+		s := &Scheduler{DB: mt.Client, Config: &config.Config{DatabaseName: "chatterbloom"}, Publisher: &fakePublisher{}, Instance: testInstance}
+		published, err := s.PublishDue(context.Background(), now)
+		if err != nil || published != 0 {
+			mt.Fatalf("got %d, %v with nothing due", published, err)
+		}
+
+		started := mt.GetStartedEvent()
+		if started == nil || started.CommandName != "findAndModify" {
+			mt.Fatalf("got %v, want a findAndModify", started)
+		}
+		query := started.Command.Lookup("query").Document()
+		if status := query.Lookup("status").StringValue(); status != models.ScheduleStatusPending {
+			mt.Errorf("leased messages with status %q", status)
+		}
+		if due := query.Lookup("send_at", "$lte").Time(); !due.Equal(now) {
+			mt.Errorf("leased messages due by %s, want %s", due, now)
+		}
+		// Only messages without a lease or with an expired one
+		or := query.Lookup("$or").Array()
+		if _, err := or.Index(0).Value().Document().LookupErr("lease_until", "$exists"); err != nil {
+			mt.Errorf("didn't lease messages without a lease: %s", or)
+		}
+		if expired := or.Index(1).Value().Document().Lookup("lease_until", "$lt").Time(); !expired.Equal(now) {
+			mt.Errorf("leased messages whose lease ends by %s, want %s", expired, now)
+		}
+
+		set := started.Command.Lookup("update", "0", "$set").Document()
+		if owner := set.Lookup("lease_owner").StringValue(); owner != testInstance {
+			mt.Errorf("leased to %q, want %q", owner, testInstance)
+		}
+		if until := set.Lookup("lease_until").Time(); !until.Equal(now.Add(leaseDuration)) {
+			mt.Errorf("leased until %s, want %s", until, now.Add(leaseDuration))
+		}
+		ifNull := set.Lookup("pending_message_id", "$ifNull").Array()
+		if field := ifNull.Index(0).Value().StringValue(); field != "$pending_message_id" {
+			mt.Errorf("got message ID %s, want the pending one kept", ifNull)
+		}
+		if sort := started.Command.Lookup("sort", "send_at").Int32(); sort != 1 {
+			mt.Errorf("leased in send_at order %d", sort)
+		}
+	})
+}
+
+// TestNextOccurrence checks that occurrences missed while no scheduler was
+// running are skipped
+func TestNextOccurrence(t *testing.T) {
+	first := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	schedule := &models.ScheduledMessage{
+		Recurrence:  "daily",
+		Timezone:    "UTC",
+		FirstSendAt: first,
+		SendAt:      first.AddDate(0, 0, 1),
+		SentCount:   1,
+	}
+
+	next, ok := nextOccurrence(schedule, time.Date(2024, 1, 5, 12, 0, 0, 0, time.UTC))
+	if want := time.Date(2024, 1, 6, 9, 0, 0, 0, time.UTC); !ok || !next.Equal(want) {
+		t.Errorf("got %s, %v, want %s", next, ok, want)
+	}
+
+	schedule.Recurrence = "FREQ=DAILY;COUNT=2"
+	if next, ok := nextOccurrence(schedule, schedule.SendAt); ok {
+		t.Errorf("got %s after the last occurrence", next)
+	}
+
+	schedule.Recurrence = ""
+	if next, ok := nextOccurrence(schedule, schedule.SendAt); ok {
+		t.Errorf("got %s for a one-off message", next)
+	}
+}
+
+// cursor is a mock reply to a find on coll
+func cursor(coll string, docs ...bson.D) bson.D {
+	return mtest.CreateCursorResponse(0, "chatterbloom."+coll, mtest.FirstBatch, docs...)
+}
+
+func scheduleDoc(mt *mtest.T, schedule models.ScheduledMessage) bson.D {
+	data, err := bson.Marshal(schedule)
+	if err != nil {
+		mt.Fatal(err)
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		mt.Fatal(err)
+	}
+	return doc
+}
@@ -0,0 +1,142 @@
+package search
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+const (
+	// MinTermLength is the shortest term that is searched for
+	MinTermLength = 2
+
+	// maxTerms bounds how many terms of a query are used
+	maxTerms = 10
+)
+
+// Terms splits a query into lowercase words, dropping duplicates and words
+// shorter than MinTermLength
+func Terms(query string) []string {
+	words := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := make(map[string]bool, len(words))
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		if len([]rune(word)) < MinTermLength || seen[word] {
+			continue
+		}
+		seen[word] = true
+		terms = append(terms, word)
+		if len(terms) == maxTerms {
+			break
+		}
+	}
+	return terms
+}
+
+// TextQuery builds a MongoDB $text search string that requires every term,
+// by quoting each one as a phrase
+func TextQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + term + `"`
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Matches reports whether text contains every term, ignoring case. It is the
+// in-process equivalent of a TextQuery search.
+func Matches(text string, terms []string) bool {
+	lower := strings.ToLower(text)
+	for _, term := range terms {
+		if !strings.Contains(lower, term) {
+			return false
+		}
+	}
+	return len(terms) > 0
+}
+
+// Snippet returns an HTML-escaped excerpt of text of about width runes around
+// the first match, with every match wrapped in <mark> tags
+func Snippet(text string, terms []string, width int) string {
+	// Find every match, merging overlapping ones. If lowercasing changed
+	// byte offsets, matches can't be mapped back and the excerpt is left
+	// unhighlighted.
+	type span struct{ start, end int }
+	var spans []span
+	if lower := strings.ToLower(text); len(lower) == len(text) {
+		for i := 0; i < len(lower); i++ {
+			end := -1
+			for _, term := range terms {
+				if strings.HasPrefix(lower[i:], term) && i+len(term) > end {
+					end = i + len(term)
+				}
+			}
+			if end < 0 {
+				continue
+			}
+			if n := len(spans); n > 0 && spans[n-1].end >= i {
+				if end > spans[n-1].end {
+					spans[n-1].end = end
+				}
+			} else {
+				spans = append(spans, span{i, end})
+			}
+		}
+	}
+
+	// Centre the excerpt on the first match
+	start := 0
+	if len(spans) > 0 {
+		start = spans[0].start
+		for back := width / 3; back > 0 && start > 0; back-- {
+			start--
+			for start > 0 && !isRuneStart(text[start]) {
+				start--
+			}
+		}
+	}
+	excerpt := truncate(text, start, width)
+	end := start + len(excerpt)
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for _, s := range spans {
+		if s.end <= start || s.start >= end {
+			continue
+		}
+		from, to := max(s.start, start), min(s.end, end)
+		b.WriteString(html.EscapeString(text[pos:from]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[from:to]))
+		b.WriteString("</mark>")
+		pos = to
+	}
+	b.WriteString(html.EscapeString(text[pos:end]))
+	if end < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// truncate returns up to width runes of text starting at byte offset start
+func truncate(text string, start, width int) string {
+	end := start
+	for n := 0; n < width && end < len(text); n++ {
+		end++
+		for end < len(text) && !isRuneStart(text[end]) {
+			end++
+		}
+	}
+	return text[start:end]
+}
+
+// isRuneStart reports whether b begins a UTF-8 encoded rune
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
@@ -0,0 +1,134 @@
+package search
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTerms(t *testing.T) {
+	cases := map[string][]string{
+		"Field trip":                         {"field", "trip"},
+		"  field, FIELD;trip!":               {"field", "trip"},
+		"a trip to the zoo":                  {"trip", "to", "the", "zoo"},
+		"Grade 5B's e-mail":                  {"grade", "5b", "mail"},
+		"Éléves à l'école":                   {"éléves", "école"},
+		"x y z":                              {},
+		"":                                   {},
+		"<script>alert(1)</script>":          {"script", "alert"},
+		"a1 b2 c3 d4 e5 f6 g7 h8 i9 j10 k11": {"a1", "b2", "c3", "d4", "e5", "f6", "g7", "h8", "i9", "j10"},
+	}
+	for query, want := range cases {
+		if got := Terms(query); !reflect.DeepEqual(got, want) {
+			t.Errorf("Terms(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
+
+func TestTextQuery(t *testing.T) {
+	if got := TextQuery([]string{"field", "trip"}); got != `"field" "trip"` {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	cases := []struct {
+		text  string
+		terms []string
+		want  bool
+	}{
+		{"Field Trip on Friday", []string{"field", "trip"}, true},
+		{"Field day on Friday", []string{"field", "trip"}, false},
+		{"Trips: field day", []string{"field", "trip"}, true},
+		{"ÉCOLE", []string{"école"}, true},
+		{"anything", nil, false},
+	}
+	for _, c := range cases {
+		if got := Matches(c.text, c.terms); got != c.want {
+			t.Errorf("Matches(%q, %q) = %v, want %v", c.text, c.terms, got, c.want)
+		}
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	long := strings.Repeat("word ", 100) + "Field" + strings.Repeat(" more", 100)
+
+	cases := []struct {
+		name, text string
+		terms      []string
+		width      int
+		want       string
+	}{
+		{
+			name:  "highlights every match",
+			text:  "Field trip: bring a raincoat for the field",
+			terms: []string{"field", "trip"},
+			width: 160,
+			want:  "<mark>Field</mark> <mark>trip</mark>: bring a raincoat for the <mark>field</mark>",
+		},
+		{
+			name:  "merges overlapping matches",
+			text:  "abcd",
+			terms: []string{"ab", "bc"},
+			width: 160,
+			want:  "<mark>abc</mark>d",
+		},
+		{
+			name:  "merges adjacent matches",
+			text:  "fieldtrip",
+			terms: []string{"field", "trip"},
+			width: 160,
+			want:  "<mark>fieldtrip</mark>",
+		},
+		{
+			name:  "escapes HTML",
+			text:  "<b>field</b> & trip",
+			terms: []string{"field"},
+			width: 160,
+			want:  "&lt;b&gt;<mark>field</mark>&lt;/b&gt; &amp; trip",
+		},
+		{
+			name:  "centres on the first match",
+			text:  long,
+			terms: []string{"field"},
+			width: 30,
+			want:  "…word word <mark>Field</mark> more more more…",
+		},
+		{
+			name:  "multibyte text",
+			text:  "Café trip",
+			terms: []string{"café"},
+			width: 160,
+			want:  "<mark>Café</mark> trip",
+		},
+		{
+			name:  "cut mid-match",
+			text:  "Bring raincoats",
+			terms: []string{"raincoats"},
+			width: 9,
+			want:  "…ng <mark>rainco</mark>…",
+		},
+		{
+			name:  "no match",
+			text:  "Hello there",
+			terms: []string{"zz"},
+			width: 5,
+			want:  "Hello…",
+		},
+		{
+			// Lowercasing İ adds a combining dot, so matches can't be mapped back
+			name:  "lowercasing changes length",
+			text:  "İstanbul <trip>",
+			terms: []string{"trip"},
+			width: 160,
+			want:  "İstanbul &lt;trip&gt;",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := Snippet(c.text, c.terms, c.width); got != c.want {
+				t.Errorf("got  %s\nwant %s", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalBlobStoreKeys(t *testing.T) {
+	root := t.TempDir()
+	store := NewLocalBlobStore(root)
+
+	for _, key := range []string{"a", "attachments/g1/file", "attachments/g1/f_thumb.jpg", "a/..b/c"} {
+		path, err := store.path(key)
+		if err != nil {
+			t.Errorf("path(%q): %v", key, err)
+			continue
+		}
+		if path != filepath.Join(root, filepath.FromSlash(key)) {
+			t.Errorf("path(%q) = %s", key, path)
+		}
+	}
+
+	for _, key := range []string{"", "/", "/etc/passwd", "a//b", "a/", "..", "../x", "a/../../x", "a/./b", "."} {
+		if path, err := store.path(key); err == nil {
+			t.Errorf("path(%q) = %s, want an error", key, path)
+		}
+	}
+}
+
+func TestLocalBlobStore(t *testing.T) {
+	root := t.TempDir()
+	store := NewLocalBlobStore(root)
+	ctx := context.Background()
+	key := "attachments/g1/file"
+
+	if _, err := store.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get before put: got %v, want ErrNotFound", err)
+	}
+
+	if err := store.Put(ctx, key, strings.NewReader("first"), 5, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(ctx, key, strings.NewReader("second"), 6, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readBlob(t, store, key); got != "second" {
+		t.Errorf("got %q, want the replaced blob", got)
+	}
+
+	// A short or long body is an error and leaves the stored blob alone
+	if err := store.Put(ctx, key, strings.NewReader("abc"), 4, "text/plain"); err == nil {
+		t.Error("put with a short body: got no error")
+	}
+	if got := readBlob(t, store, key); got != "second" {
+		t.Errorf("got %q after a failed put", got)
+	}
+	entries, err := os.ReadDir(filepath.Join(root, "attachments", "g1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d files, want only the blob without temporary files", len(entries))
+	}
+
+	if err := store.Put(ctx, "../escape", strings.NewReader("x"), 1, "text/plain"); err == nil {
+		t.Error("put with an escaping key: got no error")
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("get after delete: got %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("deleting a missing blob: %v", err)
+	}
+}
+
+func readBlob(t *testing.T, store BlobStore, key string) string {
+	t.Helper()
+	blob, err := store.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blob.Close()
+	data, err := io.ReadAll(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,107 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+)
+
+// RoomEvent is an encoded event addressed to every client in a room
+type RoomEvent struct {
+	RoomID  string
+	Message []byte
+
+	// Connections of this user are skipped, e.g. for their own typing indicator
+	ExceptUserID string
+
+	// Set instead of Message to unsubscribe every connection of this user
+	// from the room
+	EvictUserID string
+}
+
+// Backplane fans room events out to every hub instance, so clients connected
+// to one backend replica see events published through another. A hub
+// publishes every room event to its backplane and only delivers events it
+// receives back from it, including its own.
+type Backplane interface {
+	// Publish sends a room event to every subscribed hub
+	Publish(ctx context.Context, event RoomEvent) error
+
+	// Subscribe registers handler to receive every published event until ctx
+	// is done. It must not block; handler is called from another goroutine.
+	Subscribe(ctx context.Context, handler func(event RoomEvent)) error
+}
+
+// memorySubscriberBuffer is how many events a slow in-memory subscriber may lag behind
+const memorySubscriberBuffer = 256
+
+// MemoryBackplane is an in-process Backplane. It is the default for a single
+// instance and lets several hubs in one process share events.
+type MemoryBackplane struct {
+	mu          sync.RWMutex
+	subscribers map[*memorySubscriber]bool
+}
+
+// memorySubscriber is one hub's queue on a MemoryBackplane
+type memorySubscriber struct {
+	events chan RoomEvent
+	done   <-chan struct{}
+}
+
+// NewMemoryBackplane creates an in-process backplane
+func NewMemoryBackplane() *MemoryBackplane {
+	return &MemoryBackplane{
+		subscribers: make(map[*memorySubscriber]bool),
+	}
+}
+
+// Publish implements Backplane
+func (b *MemoryBackplane) Publish(ctx context.Context, event RoomEvent) error {
+	b.mu.RLock()
+	subscribers := make([]*memorySubscriber, 0, len(b.subscribers))
+	for sub := range b.subscribers {
+		subscribers = append(subscribers, sub)
+	}
+	b.mu.RUnlock()
+
+	for _, sub := range subscribers {
+		select {
+		case sub.events <- event:
+		case <-sub.done:
+			// Subscriber went away while we were publishing
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Subscribe implements Backplane
+func (b *MemoryBackplane) Subscribe(ctx context.Context, handler func(event RoomEvent)) error {
+	sub := &memorySubscriber{
+		events: make(chan RoomEvent, memorySubscriberBuffer),
+		done:   ctx.Done(),
+	}
+
+	b.mu.Lock()
+	b.subscribers[sub] = true
+	b.mu.Unlock()
+
+	go func() {
+		defer func() {
+			b.mu.Lock()
+			delete(b.subscribers, sub)
+			b.mu.Unlock()
+		}()
+
+		for {
+			select {
+			case event := <-sub.events:
+				handler(event)
+			case <-sub.done:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// How long published events are kept before MongoDB expires them
+	mongoEventTTL = time.Hour
+
+	// Delay before re-opening a change stream that failed
+	mongoRetryDelay = 2 * time.Second
+)
+
+// hubEvent is a room event stored in the backplane collection
+type hubEvent struct {
+	RoomID       string    `bson:"room_id"`
+	Message      string    `bson:"message"`
+	ExceptUserID string    `bson:"except_user_id,omitempty"`
+	EvictUserID  string    `bson:"evict_user_id,omitempty"`
+	CreatedAt    time.Time `bson:"created_at"`
+}
+
+// MongoBackplane is a Backplane backed by a MongoDB collection and change
+// stream. Every instance inserts the events it publishes and watches the
+// collection for events from all instances. Change streams need a replica
+// set or sharded cluster.
+type MongoBackplane struct {
+	coll *mongo.Collection
+}
+
+// NewMongoBackplane creates a backplane on coll and ensures its TTL index
+func NewMongoBackplane(coll *mongo.Collection) (*MongoBackplane, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(mongoEventTTL.Seconds())),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MongoBackplane{coll: coll}, nil
+}
+
+// Publish implements Backplane
+func (b *MongoBackplane) Publish(ctx context.Context, event RoomEvent) error {
+	_, err := b.coll.InsertOne(ctx, hubEvent{
+		RoomID:       event.RoomID,
+		Message:      string(event.Message),
+		ExceptUserID: event.ExceptUserID,
+		EvictUserID:  event.EvictUserID,
+		CreatedAt:    time.Now(),
+	})
+	return err
+}
+
+// Subscribe implements Backplane
+func (b *MongoBackplane) Subscribe(ctx context.Context, handler func(event RoomEvent)) error {
+	go b.watch(ctx, handler)
+	return nil
+}
+
+// watch follows the change stream until ctx is done, reconnecting from the
+// last seen resume token whenever the stream fails
+func (b *MongoBackplane) watch(ctx context.Context, handler func(event RoomEvent)) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": "insert"}}},
+	}
+
+	var resumeToken bson.Raw
+	for {
+		opts := options.ChangeStream()
+		if resumeToken != nil {
+			opts.SetResumeAfter(resumeToken)
+		}
+
+		stream, err := b.coll.Watch(ctx, pipeline, opts)
+		if err == nil {
+			for stream.Next(ctx) {
+				var change struct {
+					FullDocument hubEvent `bson:"fullDocument"`
+				}
+				if err := stream.Decode(&change); err != nil {
+					log.Printf("error: decoding backplane event: %v", err)
+					continue
+				}
+				handler(RoomEvent{
+					RoomID:       change.FullDocument.RoomID,
+					Message:      []byte(change.FullDocument.Message),
+					ExceptUserID: change.FullDocument.ExceptUserID,
+					EvictUserID:  change.FullDocument.EvictUserID,
+				})
+				resumeToken = stream.ResumeToken()
+			}
+			err = stream.Err()
+			stream.Close(context.Background())
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("error: backplane change stream: %v; retrying", err)
+
+		select {
+		case <-time.After(mongoRetryDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
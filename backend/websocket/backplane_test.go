@@ -0,0 +1,87 @@
+package websocket
+
+import (
+	"testing"
+
+	"chatterbloom/backend/protocol"
+)
+
+// flush publishes a marker event to a room through hub and waits until conn
+// received it. The backplane delivers in order, so every event published
+// before it has been delivered too.
+func flush(t *testing.T, hub *Hub, conn *fakeConn, roomID string) {
+	t.Helper()
+	before := len(conn.received("flush"))
+	message, err := protocol.Encode("flush", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.BroadcastToRoom(roomID, message)
+	waitFor(t, "the flush marker", func() bool {
+		return len(conn.received("flush")) > before
+	})
+}
+
+// TestBackplaneSharesEventsBetweenHubs checks that an event sent through one
+// hub reaches clients of another hub on the same backplane exactly once
+func TestBackplaneSharesEventsBetweenHubs(t *testing.T) {
+	backplane := NewMemoryBackplane()
+	hubA := NewHub(backplane, newMemoryEventLog())
+	hubB := NewHub(backplane, newMemoryEventLog())
+	go hubA.Run()
+	go hubB.Run()
+
+	// Registering waits for Run, which subscribes to the backplane first
+	_, connA := connect(hubA, "user-a", "group-1")
+	_, connB := connect(hubB, "user-b", "group-1")
+
+	hubA.SendToGroup("group-1", protocol.TypeNewMessage, map[string]string{"content": "hello"})
+	flush(t, hubA, connB, "group-1")
+
+	frames := connB.received(protocol.TypeNewMessage)
+	if len(frames) != 1 {
+		t.Fatalf("client on hub B got %d new_message events, want 1", len(frames))
+	}
+	if frames[0].RoomID != "group-1" || frames[0].Seq != 1 {
+		t.Errorf("got room %q seq %d, want group-1 seq 1", frames[0].RoomID, frames[0].Seq)
+	}
+
+	flush(t, hubB, connA, "group-1")
+	if n := len(connA.received(protocol.TypeNewMessage)); n != 1 {
+		t.Errorf("client on hub A got %d new_message events, want 1", n)
+	}
+}
+
+// TestBackplaneHonoursExceptUserID checks that an event skipping a user is
+// skipped on every hub, but still reaches the rest of the room
+func TestBackplaneHonoursExceptUserID(t *testing.T) {
+	backplane := NewMemoryBackplane()
+	hubA := NewHub(backplane, nil)
+	hubB := NewHub(backplane, nil)
+	go hubA.Run()
+	go hubB.Run()
+
+	_, typistOnA := connect(hubA, "typist", "group-1")
+	_, typistOnB := connect(hubB, "typist", "group-1")
+	_, other := connect(hubB, "other", "group-1")
+
+	message, err := protocol.Encode(protocol.TypeTypingStart, "", protocol.TypingPayload{GroupID: "group-1", UserID: "typist"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hubA.publish(RoomEvent{RoomID: "group-1", Message: message, ExceptUserID: "typist"})
+
+	flush(t, hubA, typistOnA, "group-1")
+	flush(t, hubA, typistOnB, "group-1")
+	flush(t, hubA, other, "group-1")
+
+	if n := len(typistOnA.received(protocol.TypeTypingStart)); n != 0 {
+		t.Errorf("typist on hub A got %d typing events, want 0", n)
+	}
+	if n := len(typistOnB.received(protocol.TypeTypingStart)); n != 0 {
+		t.Errorf("typist on hub B got %d typing events, want 0", n)
+	}
+	if n := len(other.received(protocol.TypeTypingStart)); n != 1 {
+		t.Errorf("other user got %d typing events, want 1", n)
+	}
+}
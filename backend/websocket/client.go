@@ -1,11 +1,20 @@
 package websocket
 
 import (
-	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"log"
+	"net/http"
 	"time"
 
+	"chatterbloom/backend/models"
+	"chatterbloom/backend/policy"
+	"chatterbloom/backend/protocol"
+
 	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/time/rate"
 )
 
 const (
@@ -19,12 +28,13 @@ const (
 	pingPeriod = (pongWait * 9) / 10
 
 	// Maximum message size allowed from peer
-	maxMessageSize = 512
-)
+	maxMessageSize = 4096
+
+	// Number of outbound events buffered per client
+	sendBufferSize = 256
 
-var (
-	newline = []byte{'\n'}
-	space   = []byte{' '}
+	// Maximum number of missed events replayed per room on resume
+	maxReplayEvents = 200
 )
 
 // Client is a middleman between the websocket connection and the hub
@@ -32,7 +42,7 @@ type Client struct {
 	hub *Hub
 
 	// The websocket connection
-	conn *websocket.Conn
+	conn wsConn
 
 	// Buffered channel of outbound messages
 	send chan []byte
@@ -40,8 +50,48 @@ type Client struct {
 	// User ID for this client
 	userID string
 
-	// Room ID (group ID) this client is in
-	roomID string
+	// Room IDs (group IDs) this client is subscribed to, owned by the hub
+	rooms map[string]bool
+
+	// Authorizes subscriptions and handles frames that change application state
+	handler Handler
+
+	// Last time the client acked a newer room event (or connected), owned by the hub
+	lastProgress time.Time
+
+	// Highest sequence number the client acked per room, owned by the hub
+	delivered map[string]int64
+
+	// Whether events are being skipped because the send buffer filled up, owned by the hub
+	lagging bool
+
+	// Whether the client reported the user as away, owned by the hub
+	away bool
+
+	// Limits how often the client may send typing frames, owned by readPump
+	typingLimiter *rate.Limiter
+}
+
+// wsConn is the part of *websocket.Conn the pumps use, so tests can swap in
+// a fake connection
+type wsConn interface {
+	SetReadLimit(limit int64)
+	SetReadDeadline(t time.Time) error
+	SetPongHandler(h func(appData string) error)
+	ReadMessage() (messageType int, p []byte, err error)
+	SetWriteDeadline(t time.Time) error
+	WriteMessage(messageType int, data []byte) error
+	Close() error
+}
+
+// Handler is implemented by the application to authorize room subscriptions
+// and to validate and persist client frames. Returned errors are expected to
+// be *echo.HTTPError so they can be mapped to protocol error codes.
+type Handler interface {
+	CanJoinRoom(userID, roomID string) (bool, error)
+	SendMessage(userID string, payload protocol.SendMessagePayload) (*models.MessageResponse, error)
+	MarkRead(userID string, payload protocol.MarkReadPayload) error
+	UpdateLastSeen(userID string, at time.Time) error
 }
 
 // readPump pumps messages from the websocket connection to the hub
@@ -55,6 +105,7 @@ func (c *Client) readPump() {
 	c.conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.conn.SetPongHandler(func(string) error {
 		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		c.hub.heartbeat <- c
 		return nil
 	})
 
@@ -66,18 +117,225 @@ func (c *Client) readPump() {
 			}
 			break
 		}
-		message = bytes.TrimSpace(bytes.Replace(message, newline, space, -1))
-		
-		// If this client is in a room, broadcast to that room only
-		if c.roomID != "" {
-			c.hub.BroadcastToRoom(c.roomID, message)
-		} else {
-			// Otherwise broadcast to all clients
-			c.hub.broadcast <- message
+
+		c.dispatch(message)
+	}
+}
+
+// dispatch decodes a client frame and routes it by event type
+func (c *Client) dispatch(message []byte) {
+	var env protocol.Envelope
+	if err := json.Unmarshal(message, &env); err != nil || env.Type == "" {
+		c.replyError("", protocol.ErrorInvalidFrame, "Frame is not a valid envelope")
+		return
+	}
+
+	if env.Version != protocol.Version {
+		c.replyError(env.ID, protocol.ErrorUnsupportedVersion, fmt.Sprintf("Unsupported protocol version %d", env.Version))
+		return
+	}
+
+	switch env.Type {
+	case protocol.TypeSubscribe:
+		var payload protocol.RoomPayload
+		if !c.decodePayload(env, &payload) {
+			return
+		}
+		c.subscribe(env.ID, payload.RoomID)
+
+	case protocol.TypeUnsubscribe:
+		var payload protocol.RoomPayload
+		if !c.decodePayload(env, &payload) {
+			return
+		}
+		c.hub.unsubscribe <- subscription{client: c, roomID: payload.RoomID, frameID: env.ID}
+
+	case protocol.TypeTypingStart, protocol.TypeTypingStop:
+		var payload protocol.TypingPayload
+		if !c.decodePayload(env, &payload) {
+			return
+		}
+		if !c.typingLimiter.Allow() {
+			c.replyError(env.ID, protocol.ErrorRateLimited, "Too many typing updates")
+			return
+		}
+		c.hub.typingUpdates <- typingUpdate{
+			client: c,
+			roomID: payload.GroupID,
+			typing: env.Type == protocol.TypeTypingStart,
 		}
+
+	case protocol.TypeSendMessage:
+		var payload protocol.SendMessagePayload
+		if !c.decodePayload(env, &payload) {
+			return
+		}
+		message, err := c.handler.SendMessage(c.userID, payload)
+		if err != nil {
+			c.replyHandlerError(env.ID, err)
+			return
+		}
+		c.reply(protocol.TypeAck, env.ID, protocol.MessagePayload{Message: *message})
+
+	case protocol.TypeMarkRead:
+		var payload protocol.MarkReadPayload
+		if !c.decodePayload(env, &payload) {
+			return
+		}
+		if err := c.handler.MarkRead(c.userID, payload); err != nil {
+			c.replyHandlerError(env.ID, err)
+			return
+		}
+		c.reply(protocol.TypeAck, env.ID, nil)
+
+	case protocol.TypeResume:
+		var payload protocol.ResumePayload
+		if !c.decodePayload(env, &payload) {
+			return
+		}
+		c.resume(env.ID, payload)
+
+	case protocol.TypeSetPresence:
+		var payload protocol.PresencePayload
+		if !c.decodePayload(env, &payload) {
+			return
+		}
+		if payload.Status != protocol.PresenceOnline && payload.Status != protocol.PresenceAway {
+			c.replyError(env.ID, protocol.ErrorBadRequest, "Status must be online or away")
+			return
+		}
+		c.hub.presenceUpdates <- presenceUpdate{client: c, away: payload.Status == protocol.PresenceAway}
+		c.reply(protocol.TypeAck, env.ID, nil)
+
+	case protocol.TypeDelivered:
+		// Delivery acks tell the hub the client is still consuming events,
+		// even if it is slow
+		var payload protocol.DeliveredPayload
+		if !c.decodePayload(env, &payload) {
+			return
+		}
+		c.hub.deliveries <- delivery{client: c, roomID: payload.RoomID, seq: payload.Seq}
+
+	default:
+		c.replyError(env.ID, protocol.ErrorUnknownType, "Unknown event type "+env.Type)
 	}
 }
 
+// decodePayload unmarshals the envelope payload, replying with an error on failure
+func (c *Client) decodePayload(env protocol.Envelope, payload interface{}) bool {
+	if len(env.Payload) == 0 {
+		c.replyError(env.ID, protocol.ErrorInvalidFrame, "Missing payload")
+		return false
+	}
+	if err := json.Unmarshal(env.Payload, payload); err != nil {
+		c.replyError(env.ID, protocol.ErrorInvalidFrame, "Invalid payload for "+env.Type)
+		return false
+	}
+	return true
+}
+
+// subscribe asks the hub to add this client to a room once authorized
+func (c *Client) subscribe(frameID, roomID string) {
+	allowed, err := c.handler.CanJoinRoom(c.userID, roomID)
+	if err != nil {
+		c.replyHandlerError(frameID, err)
+		return
+	}
+	if !allowed {
+		c.replyError(frameID, protocol.ErrorForbidden, "You are not a member of this group")
+		return
+	}
+
+	c.hub.subscribe <- subscription{client: c, roomID: roomID, frameID: frameID}
+}
+
+// resume replays the room events the client missed after the given
+// sequence numbers, then sends a resumed event for each room
+func (c *Client) resume(frameID string, payload protocol.ResumePayload) {
+	if c.hub.eventLog == nil {
+		c.replyError(frameID, protocol.ErrorBadRequest, "Resume is not supported")
+		return
+	}
+
+	for roomID, lastSeq := range payload.Rooms {
+		allowed, err := c.handler.CanJoinRoom(c.userID, roomID)
+		if err != nil {
+			c.replyHandlerError(frameID, err)
+			continue
+		}
+		if !allowed {
+			c.replyError(frameID, protocol.ErrorForbidden, "You are not a member of this group")
+			continue
+		}
+
+		// Fetch one extra event to find out whether the replay is complete
+		events, err := c.hub.eventLog.Since(context.Background(), roomID, lastSeq, maxReplayEvents+1)
+		if err != nil {
+			log.Printf("error: loading events for room %s: %v", roomID, err)
+			c.replyError(frameID, protocol.ErrorInternal, "Failed to load missed events")
+			continue
+		}
+
+		complete := len(events) <= maxReplayEvents
+		if !complete {
+			events = events[:maxReplayEvents]
+		}
+		for _, event := range events {
+			c.hub.direct <- directMessage{client: c, message: event}
+		}
+
+		c.reply(protocol.TypeResumed, frameID, protocol.ResumedPayload{
+			RoomID:   roomID,
+			Replayed: len(events),
+			Complete: complete,
+		})
+	}
+}
+
+// reply sends an event to this client only
+func (c *Client) reply(eventType, frameID string, payload interface{}) {
+	frame, err := protocol.Encode(eventType, frameID, payload)
+	if err != nil {
+		log.Printf("error: encoding %s frame: %v", eventType, err)
+		return
+	}
+	c.hub.direct <- directMessage{client: c, message: frame}
+}
+
+// replyError sends an error event to this client only
+func (c *Client) replyError(frameID, code, message string) {
+	c.reply(protocol.TypeError, frameID, protocol.ErrorPayload{Code: code, Message: message})
+}
+
+// replyHandlerError maps an error returned by the Handler to an error event
+func (c *Client) replyHandlerError(frameID string, err error) {
+	he, ok := err.(*echo.HTTPError)
+	if !ok {
+		log.Printf("error: handling frame for user %s: %v", c.userID, err)
+		c.replyError(frameID, protocol.ErrorInternal, "Internal error")
+		return
+	}
+
+	code := protocol.ErrorInternal
+	switch he.Code {
+	case http.StatusBadRequest:
+		code = protocol.ErrorBadRequest
+	case http.StatusForbidden:
+		code = protocol.ErrorForbidden
+	case http.StatusNotFound:
+		code = protocol.ErrorNotFound
+	case http.StatusTooManyRequests:
+		code = protocol.ErrorRateLimited
+	}
+
+	// Pass on why the contact policy denied the frame
+	if denial, ok := he.Message.(*policy.Denial); ok {
+		c.reply(protocol.TypeError, frameID, protocol.ErrorPayload{Code: code, Message: denial.Message, Reason: denial.Reason})
+		return
+	}
+	c.replyError(frameID, code, fmt.Sprint(he.Message))
+}
+
 // writePump pumps messages from the hub to the websocket connection
 func (c *Client) writePump() {
 	ticker := time.NewTicker(pingPeriod)
@@ -96,20 +354,8 @@ func (c *Client) writePump() {
 				return
 			}
 
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			w.Write(message)
-
-			// Add queued chat messages to the current websocket message
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write(newline)
-				w.Write(<-c.send)
-			}
-
-			if err := w.Close(); err != nil {
+			// Each event goes in its own frame so clients can parse it as one JSON document
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
 				return
 			}
 		case <-ticker.C:
@@ -0,0 +1,115 @@
+package websocket
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EventLog assigns per-room sequence numbers to room events and keeps them
+// so reconnecting clients can replay what they missed
+type EventLog interface {
+	// NextSeq reserves the next sequence number for a room
+	NextSeq(ctx context.Context, roomID string) (int64, error)
+
+	// Store keeps an encoded event under its room and sequence number
+	Store(ctx context.Context, roomID string, seq int64, message []byte) error
+
+	// Since returns up to limit encoded events of a room after afterSeq, oldest first
+	Since(ctx context.Context, roomID string, afterSeq int64, limit int) ([][]byte, error)
+}
+
+// How long room events are kept for replay
+const roomEventTTL = 7 * 24 * time.Hour
+
+// roomEvent is a stored room event
+type roomEvent struct {
+	RoomID    string    `bson:"room_id"`
+	Seq       int64     `bson:"seq"`
+	Message   string    `bson:"message"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+// MongoEventLog is an EventLog backed by MongoDB. Sequence numbers come from
+// atomic increments in one collection so they stay monotonic across instances.
+type MongoEventLog struct {
+	sequences *mongo.Collection
+	events    *mongo.Collection
+}
+
+// NewMongoEventLog creates an event log and ensures its indexes
+func NewMongoEventLog(sequences, events *mongo.Collection) (*MongoEventLog, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := events.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "room_id", Value: 1}, {Key: "seq", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(roomEventTTL.Seconds())),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MongoEventLog{sequences: sequences, events: events}, nil
+}
+
+// NextSeq implements EventLog
+func (l *MongoEventLog) NextSeq(ctx context.Context, roomID string) (int64, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := l.sequences.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": roomID},
+		bson.M{"$inc": bson.M{"seq": 1}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return 0, err
+	}
+	return counter.Seq, nil
+}
+
+// Store implements EventLog
+func (l *MongoEventLog) Store(ctx context.Context, roomID string, seq int64, message []byte) error {
+	_, err := l.events.InsertOne(ctx, roomEvent{
+		RoomID:    roomID,
+		Seq:       seq,
+		Message:   string(message),
+		CreatedAt: time.Now(),
+	})
+	return err
+}
+
+// Since implements EventLog
+func (l *MongoEventLog) Since(ctx context.Context, roomID string, afterSeq int64, limit int) ([][]byte, error) {
+	cursor, err := l.events.Find(
+		ctx,
+		bson.M{"room_id": roomID, "seq": bson.M{"$gt": afterSeq}},
+		options.Find().SetSort(bson.M{"seq": 1}).SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var events []roomEvent
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+
+	messages := make([][]byte, len(events))
+	for i, event := range events {
+		messages[i] = []byte(event.Message)
+	}
+	return messages, nil
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
+	"golang.org/x/time/rate"
 )
 
 var upgrader = websocket.Upgrader{
@@ -18,32 +19,17 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-// ServeWs handles websocket requests from clients
-func ServeWs(hub *Hub, c echo.Context) error {
-	conn, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+// ServeWs upgrades an already authenticated request and registers the client
+// with the hub, subscribed to roomIDs. Callers are responsible for verifying
+// that userID may join those rooms; later frames go through handler.
+func ServeWs(hub *Hub, c echo.Context, userID string, roomIDs []string, handler Handler, responseHeader http.Header) error {
+	conn, err := upgrader.Upgrade(c.Response(), c.Request(), responseHeader)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
 
-	// Get user ID and room ID from query parameters
-	userID := c.QueryParam("userId")
-	roomID := c.QueryParam("roomId")
-
-	if userID == "" {
-		log.Println("User ID is required")
-		conn.Close()
-		return echo.NewHTTPError(http.StatusBadRequest, "User ID is required")
-	}
-
-	client := &Client{
-		hub:    hub,
-		conn:   conn,
-		send:   make(chan []byte, 256),
-		userID: userID,
-		roomID: roomID,
-	}
-
+	client := newClient(hub, conn, userID, roomIDs, handler)
 	client.hub.register <- client
 
 	// Allow collection of memory referenced by the caller by doing all work in
@@ -53,3 +39,24 @@ func ServeWs(hub *Hub, c echo.Context) error {
 
 	return nil
 }
+
+// newClient creates a client for conn that is not yet registered with hub
+func newClient(hub *Hub, conn wsConn, userID string, roomIDs []string, handler Handler) *Client {
+	rooms := make(map[string]bool, len(roomIDs))
+	for _, roomID := range roomIDs {
+		rooms[roomID] = true
+	}
+
+	return &Client{
+		hub:     hub,
+		conn:    conn,
+		send:    make(chan []byte, sendBufferSize),
+		userID:  userID,
+		rooms:   rooms,
+		handler: handler,
+
+		delivered: make(map[string]int64),
+
+		typingLimiter: rate.NewLimiter(typingRate, typingBurst),
+	}
+}
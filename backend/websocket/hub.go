@@ -1,120 +1,490 @@
 package websocket
 
 import (
-	"encoding/json"
+	"context"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+
+	"chatterbloom/backend/protocol"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// Hub maintains the set of active clients and broadcasts messages to them
+// subscription is a request to add a client to or remove it from a room
+type subscription struct {
+	client  *Client
+	roomID  string
+	frameID string
+}
+
+// directMessage is a frame addressed to a single client
+type directMessage struct {
+	client  *Client
+	message []byte
+}
+
+// delivery is a client acking the room events it has processed
+type delivery struct {
+	client *Client
+	roomID string
+	seq    int64
+}
+
+// roomLockStripes is how many locks SendToGroup spreads rooms over
+const roomLockStripes = 64
+
+// outboxSize is how many events the Run goroutine may queue for publishing
+const outboxSize = 1024
+
+// Hub maintains the set of active clients and broadcasts messages to them.
+// All client and room state is owned by the Run goroutine; every other
+// goroutine talks to the hub through its channels.
 type Hub struct {
 	// Registered clients
 	clients map[*Client]bool
 
-	// Inbound messages from the clients
-	broadcast chan []byte
-
 	// Register requests from the clients
 	register chan *Client
 
 	// Unregister requests from clients
 	unregister chan *Client
 
+	// Room subscribe requests from clients
+	subscribe chan subscription
+
+	// Room unsubscribe requests from clients
+	unsubscribe chan subscription
+
+	// Replies to a single client
+	direct chan directMessage
+
+	// Room events delivered by the backplane
+	roomBroadcast chan RoomEvent
+
+	// Pongs from clients, a chance for lagging clients to catch up
+	heartbeat chan *Client
+
+	// Delivery acks from clients
+	deliveries chan delivery
+
+	// How long a client with a full buffer may go without acking newer
+	// events before it is dropped rather than marked as lagging
+	progressTimeout time.Duration
+
+	// Serialize SendToGroup per room, see roomLock
+	roomLocks [roomLockStripes]sync.Mutex
+
+	// Fans room events out to every hub instance
+	backplane Backplane
+
+	// Events raised by the Run goroutine, published in order by drainOutbox
+	outbox chan RoomEvent
+
+	// Sequences and keeps room events for replay; nil disables sequencing
+	eventLog EventLog
+
 	// Group-specific rooms
 	rooms map[string]map[*Client]bool
+
+	// Open connections per user ID, for presence
+	users map[string]map[*Client]bool
+
+	// Online/away changes from clients
+	presenceUpdates chan presenceUpdate
+
+	// Presence lookups from other goroutines
+	presenceQueries chan presenceQuery
+
+	// Identifies this hub in the presence it shares with the other hubs
+	instanceID string
+
+	// Presence other hubs shared, by instance ID
+	remotePresence map[string]*remotePresence
+
+	// Typing start/stop requests from clients
+	typingUpdates chan typingUpdate
+
+	// Expiry of active typing indicators
+	typing map[typingKey]time.Time
 }
 
-// NewHub creates a new hub instance
-func NewHub() *Hub {
+// NewHub creates a new hub instance that shares room events through
+// backplane and, if eventLog is not nil, sequences them for replay
+func NewHub(backplane Backplane, eventLog EventLog) *Hub {
 	return &Hub{
-		broadcast:  make(chan []byte),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		clients:    make(map[*Client]bool),
-		rooms:      make(map[string]map[*Client]bool),
+		register:        make(chan *Client),
+		unregister:      make(chan *Client),
+		subscribe:       make(chan subscription),
+		unsubscribe:     make(chan subscription),
+		direct:          make(chan directMessage),
+		roomBroadcast:   make(chan RoomEvent),
+		heartbeat:       make(chan *Client),
+		deliveries:      make(chan delivery),
+		progressTimeout: pongWait,
+		presenceUpdates: make(chan presenceUpdate),
+		presenceQueries: make(chan presenceQuery),
+		typingUpdates:   make(chan typingUpdate),
+		clients:         make(map[*Client]bool),
+		rooms:           make(map[string]map[*Client]bool),
+		users:           make(map[string]map[*Client]bool),
+		typing:          make(map[typingKey]time.Time),
+		instanceID:      primitive.NewObjectID().Hex(),
+		remotePresence:  make(map[string]*remotePresence),
+		backplane:       backplane,
+		outbox:          make(chan RoomEvent, outboxSize),
+		eventLog:        eventLog,
 	}
 }
 
 // Run starts the hub and handles client connections and messages
 func (h *Hub) Run() {
+	if err := h.backplane.Subscribe(context.Background(), h.deliver); err != nil {
+		log.Printf("error: subscribing hub to backplane: %v", err)
+	}
+	go h.drainOutbox()
+
+	typingSweep := time.NewTicker(typingSweepInterval)
+	defer typingSweep.Stop()
+
+	// Introduce this hub to the others and keep its presence fresh
+	presenceShare := time.NewTicker(presenceSyncInterval)
+	defer presenceShare.Stop()
+	h.shareAllPresence()
+
 	for {
 		select {
 		case client := <-h.register:
 			h.clients[client] = true
-			
-			// Add client to room if specified
-			if client.roomID != "" {
-				if _, ok := h.rooms[client.roomID]; !ok {
-					h.rooms[client.roomID] = make(map[*Client]bool)
-				}
-				h.rooms[client.roomID][client] = true
+			client.lastProgress = time.Now()
+
+			// Add client to every room it connected with, and to its user's
+			// personal room
+			for roomID := range client.rooms {
+				h.joinRoom(client, roomID)
 			}
-			
+			h.joinRoom(client, userRoomID(client.userID))
+
+			h.trackPresence(client, func() { h.addConnection(client) })
+
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-				
-				// Remove from room if in one
-				if client.roomID != "" {
-					if room, ok := h.rooms[client.roomID]; ok {
-						delete(room, client)
-						// Clean up empty rooms
-						if len(room) == 0 {
-							delete(h.rooms, client.roomID)
-						}
-					}
-				}
+				h.removeClient(client)
+			}
+
+		case sub := <-h.subscribe:
+			if _, ok := h.clients[sub.client]; ok {
+				sub.client.rooms[sub.roomID] = true
+				h.joinRoom(sub.client, sub.roomID)
+				h.sendControl(sub, protocol.TypeSubscribed)
+			}
+
+		case sub := <-h.unsubscribe:
+			if _, ok := h.clients[sub.client]; ok {
+				delete(sub.client.rooms, sub.roomID)
+				delete(sub.client.delivered, sub.roomID)
+				h.leaveRoom(sub.client, sub.roomID)
+				h.sendControl(sub, protocol.TypeUnsubscribed)
+			}
+
+		case event := <-h.roomBroadcast:
+			h.broadcastRoom(event)
+
+		case msg := <-h.direct:
+			if _, ok := h.clients[msg.client]; ok {
+				h.sendTo(msg.client, msg.message)
+			}
+
+		case update := <-h.presenceUpdates:
+			if _, ok := h.clients[update.client]; ok {
+				h.trackPresence(update.client, func() { update.client.away = update.away })
+			}
+
+		case query := <-h.presenceQueries:
+			statuses := make(map[string]string, len(query.userIDs))
+			for _, userID := range query.userIDs {
+				statuses[userID] = h.mergedStatus(userID)
+			}
+			query.reply <- statuses
+
+		case client := <-h.heartbeat:
+			if _, ok := h.clients[client]; ok {
+				h.recoverLagging(client)
+			}
+
+		case ack := <-h.deliveries:
+			if _, ok := h.clients[ack.client]; ok {
+				h.acknowledge(ack)
 			}
-			
-		case message := <-h.broadcast:
-			// Broadcast to all clients
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
-					
-					// Remove from room if in one
-					if client.roomID != "" {
-						if room, ok := h.rooms[client.roomID]; ok {
-							delete(room, client)
-							if len(room) == 0 {
-								delete(h.rooms, client.roomID)
-							}
-						}
-					}
-				}
+
+		case update := <-h.typingUpdates:
+			// Clients may only signal typing in rooms they are subscribed to
+			if _, ok := h.clients[update.client]; ok && update.client.rooms[update.roomID] {
+				h.updateTyping(update)
 			}
+
+		case now := <-typingSweep.C:
+			h.expireTyping(now)
+
+		case now := <-presenceShare.C:
+			h.expirePresence(now)
+			h.shareAllPresence()
 		}
 	}
 }
 
-// BroadcastToRoom sends a message to all clients in a specific room
-func (h *Hub) BroadcastToRoom(roomID string, message []byte) {
+// joinRoom adds a client to a room, creating the room if needed
+func (h *Hub) joinRoom(client *Client, roomID string) {
+	if _, ok := h.rooms[roomID]; !ok {
+		h.rooms[roomID] = make(map[*Client]bool)
+	}
+	h.rooms[roomID][client] = true
+}
+
+// leaveRoom removes a client from a room and cleans up empty rooms
+func (h *Hub) leaveRoom(client *Client, roomID string) {
 	if room, ok := h.rooms[roomID]; ok {
-		for client := range room {
-			select {
-			case client.send <- message:
-			default:
-				close(client.send)
-				delete(h.clients, client)
-				delete(room, client)
-				if len(room) == 0 {
-					delete(h.rooms, roomID)
-				}
-			}
+		delete(room, client)
+		if len(room) == 0 {
+			delete(h.rooms, roomID)
 		}
 	}
 }
 
-// SendToGroup sends a JSON message to all clients in a specific group
-func (h *Hub) SendToGroup(groupID string, data interface{}) {
-	// Convert data to JSON
-	jsonData, err := json.Marshal(data)
+// removeClient drops a client from the hub and every room it is in. It must
+// only be called for registered clients so send is closed exactly once.
+func (h *Hub) removeClient(client *Client) {
+	delete(h.clients, client)
+	close(client.send)
+
+	for roomID := range client.rooms {
+		h.leaveRoom(client, roomID)
+	}
+	h.leaveRoom(client, userRoomID(client.userID))
+
+	h.trackPresence(client, func() { h.removeConnection(client) })
+	h.clearTyping(client)
+}
+
+// sendControl notifies a client about a change to its subscriptions
+func (h *Hub) sendControl(sub subscription, eventType string) {
+	message, err := protocol.Encode(eventType, sub.frameID, protocol.RoomPayload{RoomID: sub.roomID})
 	if err != nil {
-		return // Silently fail if JSON marshaling fails
+		log.Printf("error: encoding %s frame: %v", eventType, err)
+		return
 	}
-	
-	// Use the existing BroadcastToRoom method
-	h.BroadcastToRoom(groupID, jsonData)
+	h.sendTo(sub.client, message)
+}
+
+// sendTo queues a message for a client. A client whose buffer is full is
+// marked as lagging if it is still acking newer events, and dropped if it
+// stopped making progress; lagging clients skip events until they catch up
+// and are then told to resync.
+func (h *Hub) sendTo(client *Client, message []byte) {
+	if client.lagging && !h.recoverLagging(client) {
+		if !h.consuming(client) {
+			h.removeClient(client)
+		}
+		return
+	}
+
+	select {
+	case client.send <- message:
+	default:
+		if h.consuming(client) {
+			client.lagging = true
+			return
+		}
+		h.removeClient(client)
+	}
+}
+
+// consuming reports whether a client acked newer events recently, which
+// tells a slow consumer from one that stopped reading. Pongs don't count;
+// the browser answers them even when the page is stuck.
+func (h *Hub) consuming(client *Client) bool {
+	return time.Since(client.lastProgress) < h.progressTimeout
+}
+
+// acknowledge records a delivery ack. Only an ack past the client's previous
+// one in the room counts as progress; repeating an old ack does not.
+func (h *Hub) acknowledge(ack delivery) {
+	client := ack.client
+	if ack.seq > client.delivered[ack.roomID] {
+		client.delivered[ack.roomID] = ack.seq
+		client.lastProgress = time.Now()
+	}
+	h.recoverLagging(client)
+}
+
+// recoverLagging sends a resync event to a lagging client once it has drained
+// enough of its buffer, and reports whether the client is caught up
+func (h *Hub) recoverLagging(client *Client) bool {
+	if !client.lagging {
+		return true
+	}
+	if len(client.send) > cap(client.send)/2 {
+		return false
+	}
+
+	message, err := protocol.Encode(protocol.TypeResync, "", nil)
+	if err != nil {
+		log.Printf("error: encoding %s frame: %v", protocol.TypeResync, err)
+		return false
+	}
+	client.lagging = false
+	client.send <- message
+	return true
+}
+
+// broadcastRoom queues an event for every client in its room. Presence
+// shared by other hubs is merged and evictions are applied instead.
+func (h *Hub) broadcastRoom(event RoomEvent) {
+	if event.RoomID == presenceRoomID {
+		h.mergePresence(event)
+		return
+	}
+	if event.EvictUserID != "" {
+		h.evictUser(event.EvictUserID, event.RoomID)
+		return
+	}
+
+	for client := range h.rooms[event.RoomID] {
+		if event.ExceptUserID != "" && client.userID == event.ExceptUserID {
+			continue
+		}
+		h.sendTo(client, event.Message)
+	}
+}
+
+// evictUser unsubscribes every local connection of a user from a room,
+// telling each one it was unsubscribed, and stops the user's typing
+// indicator there
+func (h *Hub) evictUser(userID, roomID string) {
+	for client := range h.users[userID] {
+		if !client.rooms[roomID] {
+			continue
+		}
+		delete(client.rooms, roomID)
+		delete(client.delivered, roomID)
+		h.leaveRoom(client, roomID)
+		h.sendControl(subscription{client: client, roomID: roomID}, protocol.TypeUnsubscribed)
+	}
+
+	key := typingKey{roomID: roomID, userID: userID}
+	if _, active := h.typing[key]; active {
+		delete(h.typing, key)
+		h.announceTyping(key, protocol.TypeTypingStop)
+	}
+}
+
+// RemoveUserFromRoom unsubscribes every connection of a user from a room on
+// every hub sharing the backplane, e.g. after they left or were removed from
+// the group. It must not be called from the Run goroutine.
+func (h *Hub) RemoveUserFromRoom(userID, roomID string) {
+	h.publish(RoomEvent{RoomID: roomID, EvictUserID: userID})
+}
+
+// BroadcastToRoom sends a message to all clients in a specific room on every
+// hub sharing the backplane. It must not be called from the Run goroutine.
+func (h *Hub) BroadcastToRoom(roomID string, message []byte) {
+	h.publish(RoomEvent{RoomID: roomID, Message: message})
+}
+
+// publish hands a room event to the backplane
+func (h *Hub) publish(event RoomEvent) {
+	if err := h.backplane.Publish(context.Background(), event); err != nil {
+		log.Printf("error: publishing to room %s: %v", event.RoomID, err)
+	}
+}
+
+// enqueue queues an event raised by the Run goroutine for publishing. The
+// backplane may be waiting on Run to deliver, so the event is dropped rather
+// than blocking if the outbox is full.
+func (h *Hub) enqueue(event RoomEvent) {
+	select {
+	case h.outbox <- event:
+	default:
+		log.Printf("error: outbox full, dropping event for room %s", event.RoomID)
+	}
+}
+
+// drainOutbox publishes queued events one at a time, so they reach the
+// backplane in the order Run raised them
+func (h *Hub) drainOutbox() {
+	for event := range h.outbox {
+		h.publish(event)
+	}
+}
+
+// deliver is the backplane handler that queues an event for local clients
+func (h *Hub) deliver(event RoomEvent) {
+	h.roomBroadcast <- event
+}
+
+// userRoomID names the personal room every connection of a user is in
+func userRoomID(userID string) string {
+	return "user:" + userID
+}
+
+// SendToUser sends a typed event to every connection of a user on every hub
+// sharing the backplane. Unlike group events it is not sequenced, so users
+// who are offline never see it.
+func (h *Hub) SendToUser(userID, eventType string, payload interface{}) {
+	message, err := protocol.Encode(eventType, "", payload)
+	if err != nil {
+		log.Printf("error: encoding %s event: %v", eventType, err)
+		return
+	}
+	h.publish(RoomEvent{RoomID: userRoomID(userID), Message: message})
+}
+
+// SendToGroup sends a typed event to all clients in a specific group. With
+// an event log the event is sequenced and stored so it can be replayed.
+func (h *Hub) SendToGroup(groupID, eventType string, payload interface{}) {
+	if h.eventLog == nil {
+		message, err := protocol.Encode(eventType, "", payload)
+		if err != nil {
+			log.Printf("error: encoding %s event: %v", eventType, err)
+			return
+		}
+		h.BroadcastToRoom(groupID, message)
+		return
+	}
+
+	// Hold the room's lock from allocating the sequence number until the
+	// event is published, so this instance publishes a room's events in
+	// sequence order
+	lock := h.roomLock(groupID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	ctx := context.Background()
+	seq, err := h.eventLog.NextSeq(ctx, groupID)
+	if err != nil {
+		log.Printf("error: sequencing %s event for room %s: %v", eventType, groupID, err)
+		return
+	}
+
+	message, err := protocol.EncodeRoomEvent(eventType, groupID, seq, payload)
+	if err != nil {
+		log.Printf("error: encoding %s event: %v", eventType, err)
+		return
+	}
+
+	if err := h.eventLog.Store(ctx, groupID, seq, message); err != nil {
+		log.Printf("error: storing %s event for room %s: %v", eventType, groupID, err)
+	}
+
+	h.BroadcastToRoom(groupID, message)
+}
+
+// roomLock returns the lock that serializes SendToGroup for a room. Rooms
+// share a fixed set of locks rather than each getting one that would have to
+// be cleaned up.
+func (h *Hub) roomLock(roomID string) *sync.Mutex {
+	hash := fnv.New32a()
+	hash.Write([]byte(roomID))
+	return &h.roomLocks[hash.Sum32()%roomLockStripes]
 }
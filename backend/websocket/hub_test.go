@@ -0,0 +1,382 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"chatterbloom/backend/models"
+	"chatterbloom/backend/protocol"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeConn is an in-memory wsConn. Frames pushed to incoming are read by
+// readPump; writes are counted by message type and text frames are kept.
+type fakeConn struct {
+	incoming  chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu     sync.Mutex
+	writes map[int]int
+	frames []protocol.Envelope
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{
+		incoming: make(chan []byte, 16),
+		closed:   make(chan struct{}),
+		writes:   make(map[int]int),
+	}
+}
+
+func (f *fakeConn) SetReadLimit(int64)                {}
+func (f *fakeConn) SetReadDeadline(time.Time) error   { return nil }
+func (f *fakeConn) SetPongHandler(func(string) error) {}
+func (f *fakeConn) SetWriteDeadline(time.Time) error  { return nil }
+
+func (f *fakeConn) ReadMessage() (int, []byte, error) {
+	select {
+	case frame := <-f.incoming:
+		return websocket.TextMessage, frame, nil
+	case <-f.closed:
+		return 0, nil, &websocket.CloseError{Code: websocket.CloseGoingAway}
+	}
+}
+
+func (f *fakeConn) WriteMessage(messageType int, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writes[messageType]++
+	if messageType == websocket.TextMessage {
+		var env protocol.Envelope
+		if err := json.Unmarshal(data, &env); err != nil {
+			return err
+		}
+		f.frames = append(f.frames, env)
+	}
+	return nil
+}
+
+func (f *fakeConn) Close() error {
+	f.closeOnce.Do(func() { close(f.closed) })
+	return nil
+}
+
+// written returns how many frames of a type were written
+func (f *fakeConn) written(messageType int) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.writes[messageType]
+}
+
+// received returns the text frames written so far with any of the given
+// event types, in the order they were written
+func (f *fakeConn) received(eventTypes ...string) []protocol.Envelope {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var frames []protocol.Envelope
+	for _, env := range f.frames {
+		for _, eventType := range eventTypes {
+			if env.Type == eventType {
+				frames = append(frames, env)
+				break
+			}
+		}
+	}
+	return frames
+}
+
+// fakeHandler lets every user join every room
+type fakeHandler struct{}
+
+func (fakeHandler) CanJoinRoom(userID, roomID string) (bool, error) { return true, nil }
+
+func (fakeHandler) SendMessage(userID string, payload protocol.SendMessagePayload) (*models.MessageResponse, error) {
+	return &models.MessageResponse{Content: payload.Content}, nil
+}
+
+func (fakeHandler) MarkRead(userID string, payload protocol.MarkReadPayload) error { return nil }
+
+func (fakeHandler) UpdateLastSeen(userID string, at time.Time) error { return nil }
+
+// memoryEventLog is an in-memory EventLog
+type memoryEventLog struct {
+	mu     sync.Mutex
+	seqs   map[string]int64
+	events map[string][][]byte
+}
+
+func newMemoryEventLog() *memoryEventLog {
+	return &memoryEventLog{seqs: make(map[string]int64), events: make(map[string][][]byte)}
+}
+
+func (l *memoryEventLog) NextSeq(ctx context.Context, roomID string) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seqs[roomID]++
+	return l.seqs[roomID], nil
+}
+
+func (l *memoryEventLog) Store(ctx context.Context, roomID string, seq int64, message []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events[roomID] = append(l.events[roomID], message)
+	return nil
+}
+
+func (l *memoryEventLog) Since(ctx context.Context, roomID string, afterSeq int64, limit int) ([][]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	events := l.events[roomID]
+	if int(afterSeq) >= len(events) {
+		return nil, nil
+	}
+	events = events[afterSeq:]
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+// connect registers a client on a fake connection and starts its pumps, as
+// ServeWs does
+func connect(hub *Hub, userID string, roomIDs ...string) (*Client, *fakeConn) {
+	conn := newFakeConn()
+	client := newClient(hub, conn, userID, roomIDs, fakeHandler{})
+	hub.register <- client
+	go client.writePump()
+	go client.readPump()
+	return client, conn
+}
+
+// waitFor polls condition until it holds or the test times out
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestHubConcurrentUse connects and disconnects clients while others
+// broadcast to their rooms. Run with -race; closing a send channel twice
+// panics, and each connection must get exactly one close frame.
+func TestHubConcurrentUse(t *testing.T) {
+	hub := NewHub(NewMemoryBackplane(), newMemoryEventLog())
+	go hub.Run()
+
+	const (
+		workers     = 16
+		connections = 20
+	)
+	rooms := []string{"room-a", "room-b", "room-c"}
+
+	var (
+		mu    sync.Mutex
+		conns []*fakeConn
+		users []string
+	)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < connections; i++ {
+				userID := fmt.Sprintf("user-%d", w%4)
+				room := rooms[(w+i)%len(rooms)]
+
+				client, conn := connect(hub, userID, room)
+				mu.Lock()
+				conns = append(conns, conn)
+				users = append(users, userID)
+				mu.Unlock()
+
+				subscribe, err := protocol.Encode(protocol.TypeSubscribe, "sub", protocol.RoomPayload{RoomID: rooms[i%len(rooms)]})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				conn.incoming <- subscribe
+
+				hub.BroadcastToRoom(room, []byte(`{"type":"test","version":1}`))
+				hub.SendToGroup(room, protocol.TypeNewMessage, map[string]int{"worker": w, "i": i})
+
+				// Unregister some clients directly as well as by closing
+				// the connection, so both paths race
+				if i%3 == 0 {
+					hub.unregister <- client
+				}
+				conn.Close()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// Every client unregisters once its read pump sees the closed connection
+	waitFor(t, "all users to go offline", func() bool {
+		for _, status := range hub.Presence(users) {
+			if status != protocol.PresenceOffline {
+				return false
+			}
+		}
+		return true
+	})
+
+	for i, conn := range conns {
+		waitFor(t, fmt.Sprintf("connection %d to get a close frame", i), func() bool {
+			return conn.written(websocket.CloseMessage) > 0
+		})
+		if n := conn.written(websocket.CloseMessage); n != 1 {
+			t.Errorf("connection %d got %d close frames, want 1", i, n)
+		}
+	}
+}
+
+// slowEventLog takes a random moment to store each event, so concurrent
+// sends overtake each other unless something keeps them in order
+type slowEventLog struct {
+	*memoryEventLog
+}
+
+func (l slowEventLog) Store(ctx context.Context, roomID string, seq int64, message []byte) error {
+	time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+	return l.memoryEventLog.Store(ctx, roomID, seq, message)
+}
+
+// TestSendToGroupPublishesInSequenceOrder sends to one room from many
+// goroutines and checks that events arrive in sequence number order
+func TestSendToGroupPublishesInSequenceOrder(t *testing.T) {
+	hub := NewHub(NewMemoryBackplane(), slowEventLog{newMemoryEventLog()})
+	go hub.Run()
+
+	_, conn := connect(hub, "reader", "group-1")
+
+	const senders, events = 8, 20
+	var wg sync.WaitGroup
+	for s := 0; s < senders; s++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < events; i++ {
+				hub.SendToGroup("group-1", protocol.TypeNewMessage, nil)
+			}
+		}()
+	}
+	wg.Wait()
+
+	waitFor(t, "every event", func() bool {
+		return len(conn.received(protocol.TypeNewMessage)) == senders*events
+	})
+	for i, env := range conn.received(protocol.TypeNewMessage) {
+		if env.Seq != int64(i+1) {
+			t.Fatalf("event %d has seq %d, want %d", i, env.Seq, i+1)
+		}
+	}
+}
+
+// TestFullBufferDropsOnlyClientsThatStopAcking fills the buffers of two
+// clients that never drain them. The one still acking newer events is kept
+// and told to resync once it catches up; the one repeating an old ack is
+// dropped.
+func TestFullBufferDropsOnlyClientsThatStopAcking(t *testing.T) {
+	hub := NewHub(NewMemoryBackplane(), nil)
+	hub.progressTimeout = 500 * time.Millisecond
+	go hub.Run()
+
+	// Registered without pumps, so nothing drains their buffers
+	slow := newClient(hub, newFakeConn(), "slow", []string{"group-1"}, fakeHandler{})
+	stuck := newClient(hub, newFakeConn(), "stuck", []string{"group-1"}, fakeHandler{})
+	hub.register <- slow
+	hub.register <- stuck
+
+	// The backplane keeps events in order across rooms, so once a client in
+	// another room gets a marker every earlier broadcast was handled
+	_, barrier := connect(hub, "barrier", "barrier")
+
+	hub.deliveries <- delivery{client: stuck, roomID: "group-1", seq: 1}
+	time.Sleep(2 * hub.progressTimeout)
+	hub.deliveries <- delivery{client: slow, roomID: "group-1", seq: 1}
+	hub.deliveries <- delivery{client: stuck, roomID: "group-1", seq: 1}
+
+	for i := 0; i <= sendBufferSize; i++ {
+		hub.BroadcastToRoom("group-1", []byte(`{"type":"test","version":1}`))
+	}
+	flush(t, hub, barrier, "barrier")
+
+	// The stuck client's channel was closed after what it had buffered
+	for open := true; open; {
+		select {
+		case _, open = <-stuck.send:
+		case <-time.After(time.Second):
+			t.Fatal("stuck client was not dropped")
+		}
+	}
+
+	// The slow client is still connected; once it drains its buffer and
+	// acks again it is told to resync
+	for len(slow.send) > 0 {
+		if _, open := <-slow.send; !open {
+			t.Fatal("slow client was dropped")
+		}
+	}
+	hub.deliveries <- delivery{client: slow, roomID: "group-1", seq: 2}
+	select {
+	case message, open := <-slow.send:
+		if !open {
+			t.Fatal("slow client was dropped")
+		}
+		var env protocol.Envelope
+		if err := json.Unmarshal(message, &env); err != nil || env.Type != protocol.TypeResync {
+			t.Fatalf("slow client got %s, want a resync event", message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("slow client was not told to resync")
+	}
+}
+
+// TestRemoveUserFromRoomEvictsEveryConnection removes a user from a room
+// through one hub and checks that their connections on another hub stop
+// getting the room's events
+func TestRemoveUserFromRoomEvictsEveryConnection(t *testing.T) {
+	backplane := NewMemoryBackplane()
+	hubA := NewHub(backplane, nil)
+	hubB := NewHub(backplane, nil)
+	go hubA.Run()
+	go hubB.Run()
+
+	_, first := connect(hubB, "removed", "group-1", "group-2")
+	_, second := connect(hubB, "removed", "group-1")
+	_, other := connect(hubB, "other", "group-1")
+	connect(hubA, "someone", "group-1")
+
+	hubA.RemoveUserFromRoom("removed", "group-1")
+	hubA.BroadcastToRoom("group-1", []byte(`{"type":"after_removal","version":1}`))
+	flush(t, hubA, other, "group-1")
+
+	for i, conn := range []*fakeConn{first, second} {
+		unsubscribed := conn.received(protocol.TypeUnsubscribed)
+		if len(unsubscribed) != 1 {
+			t.Fatalf("connection %d got %d unsubscribed events, want 1", i, len(unsubscribed))
+		}
+		if n := len(conn.received("after_removal")); n != 0 {
+			t.Errorf("connection %d got %d events after the removal, want 0", i, n)
+		}
+	}
+	if n := len(other.received("after_removal")); n != 1 {
+		t.Errorf("other user got %d events after the removal, want 1", n)
+	}
+
+	// Other rooms are untouched
+	flush(t, hubA, first, "group-2")
+}
@@ -0,0 +1,219 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"chatterbloom/backend/protocol"
+)
+
+// presenceUpdate is a client switching between online and away
+type presenceUpdate struct {
+	client *Client
+	away   bool
+}
+
+// presenceQuery asks the hub for the status of a set of users
+type presenceQuery struct {
+	userIDs []string
+	reply   chan map[string]string
+}
+
+// Presence returns the status of each user across every hub sharing the
+// backplane. Other instances share their users' presence as it changes, so a
+// user connected elsewhere shows up here within one backplane round trip.
+func (h *Hub) Presence(userIDs []string) map[string]string {
+	reply := make(chan map[string]string, 1)
+	h.presenceQueries <- presenceQuery{userIDs: userIDs, reply: reply}
+	return <-reply
+}
+
+// userStatus aggregates the status of every connection a user has open on
+// this instance: online if any is active, away if all are away, offline if
+// there are none
+func (h *Hub) userStatus(userID string) string {
+	connections := h.users[userID]
+	if len(connections) == 0 {
+		return protocol.PresenceOffline
+	}
+	for client := range connections {
+		if !client.away {
+			return protocol.PresenceOnline
+		}
+	}
+	return protocol.PresenceAway
+}
+
+// mergedStatus combines a user's status on this instance with what the other
+// instances reported, the same way userStatus combines connections
+func (h *Hub) mergedStatus(userID string) string {
+	status := h.userStatus(userID)
+	for _, remote := range h.remotePresence {
+		switch remote.users[userID] {
+		case protocol.PresenceOnline:
+			return protocol.PresenceOnline
+		case protocol.PresenceAway:
+			status = protocol.PresenceAway
+		}
+	}
+	return status
+}
+
+// trackPresence runs change against the user's connections, shares the
+// user's new status on this instance with the other instances and announces
+// the user's overall status if it changed
+func (h *Hub) trackPresence(client *Client, change func()) {
+	localBefore, before := h.userStatus(client.userID), h.mergedStatus(client.userID)
+	change()
+	localAfter, after := h.userStatus(client.userID), h.mergedStatus(client.userID)
+
+	if localBefore != localAfter {
+		h.sharePresence(map[string]string{client.userID: localAfter}, false)
+	}
+	if before != after {
+		h.announcePresence(client, after)
+	}
+}
+
+// addConnection records a new connection for the client's user
+func (h *Hub) addConnection(client *Client) {
+	if _, ok := h.users[client.userID]; !ok {
+		h.users[client.userID] = make(map[*Client]bool)
+	}
+	h.users[client.userID][client] = true
+}
+
+// removeConnection forgets a connection of the client's user
+func (h *Hub) removeConnection(client *Client) {
+	if connections, ok := h.users[client.userID]; ok {
+		delete(connections, client)
+		if len(connections) == 0 {
+			delete(h.users, client.userID)
+		}
+	}
+}
+
+// announcePresence broadcasts a user's status to every room any of their
+// connections is in and records when they were last seen
+func (h *Hub) announcePresence(client *Client, status string) {
+	now := time.Now()
+
+	rooms := make(map[string]bool)
+	for roomID := range client.rooms {
+		rooms[roomID] = true
+	}
+	for connection := range h.users[client.userID] {
+		for roomID := range connection.rooms {
+			rooms[roomID] = true
+		}
+	}
+
+	message, err := protocol.Encode(protocol.TypePresence, "", protocol.PresencePayload{
+		UserID:     client.userID,
+		Status:     status,
+		LastSeenAt: &now,
+	})
+	if err != nil {
+		log.Printf("error: encoding %s event: %v", protocol.TypePresence, err)
+		return
+	}
+
+	// Publish and persist off the Run goroutine; the outbox keeps a user's
+	// status changes in order
+	for roomID := range rooms {
+		h.enqueue(RoomEvent{RoomID: roomID, Message: message})
+	}
+	go func() {
+		if err := client.handler.UpdateLastSeen(client.userID, now); err != nil {
+			log.Printf("error: updating last seen for user %s: %v", client.userID, err)
+		}
+	}()
+}
+
+const (
+	// Internal backplane room hubs share their users' presence through
+	presenceRoomID = "hub:presence"
+
+	// How often each hub shares the presence of all its users
+	presenceSyncInterval = 30 * time.Second
+
+	// How long another hub's presence is trusted without hearing from it
+	presenceExpiry = 3 * presenceSyncInterval
+)
+
+// presenceSync is one hub's users' presence, shared with the other hubs
+type presenceSync struct {
+	Instance string            `json:"instance"`
+	Users    map[string]string `json:"users"`
+	Full     bool              `json:"full"` // Users lists every user connected to the instance
+}
+
+// remotePresence is what another hub last reported about its users
+type remotePresence struct {
+	users     map[string]string
+	heardFrom time.Time
+}
+
+// sharePresence publishes the status of users on this instance to the other
+// hubs. With full set, users lists everyone connected here.
+func (h *Hub) sharePresence(users map[string]string, full bool) {
+	message, err := json.Marshal(presenceSync{Instance: h.instanceID, Users: users, Full: full})
+	if err != nil {
+		log.Printf("error: encoding presence sync: %v", err)
+		return
+	}
+	h.enqueue(RoomEvent{RoomID: presenceRoomID, Message: message})
+}
+
+// shareAllPresence publishes the status of every user connected to this
+// instance, which also tells the other hubs this one is still alive
+func (h *Hub) shareAllPresence() {
+	users := make(map[string]string, len(h.users))
+	for userID := range h.users {
+		users[userID] = h.userStatus(userID)
+	}
+	h.sharePresence(users, true)
+}
+
+// mergePresence records the presence another hub shared. A hub heard from
+// for the first time is sent this hub's full presence so it doesn't have to
+// wait for the next periodic sync.
+func (h *Hub) mergePresence(event RoomEvent) {
+	var shared presenceSync
+	if err := json.Unmarshal(event.Message, &shared); err != nil {
+		log.Printf("error: decoding presence sync: %v", err)
+		return
+	}
+	if shared.Instance == h.instanceID {
+		return
+	}
+
+	remote, known := h.remotePresence[shared.Instance]
+	if !known || shared.Full {
+		remote = &remotePresence{users: make(map[string]string)}
+		h.remotePresence[shared.Instance] = remote
+	}
+	remote.heardFrom = time.Now()
+	for userID, status := range shared.Users {
+		if status == protocol.PresenceOffline {
+			delete(remote.users, userID)
+			continue
+		}
+		remote.users[userID] = status
+	}
+
+	if !known {
+		h.shareAllPresence()
+	}
+}
+
+// expirePresence forgets hubs that stopped sharing their presence, e.g.
+// because they crashed
+func (h *Hub) expirePresence(now time.Time) {
+	for instance, remote := range h.remotePresence {
+		if now.Sub(remote.heardFrom) > presenceExpiry {
+			delete(h.remotePresence, instance)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+
+	"chatterbloom/backend/protocol"
+
+	"github.com/gorilla/websocket"
+)
+
+// waitForStatus waits until hub reports a user's status
+func waitForStatus(t *testing.T, hub *Hub, userID, status string) {
+	t.Helper()
+	waitFor(t, userID+" to be "+status, func() bool {
+		return hub.Presence([]string{userID})[userID] == status
+	})
+}
+
+// TestPresenceIsSharedBetweenHubs checks that a user connected to one hub
+// is reported with the right status by another
+func TestPresenceIsSharedBetweenHubs(t *testing.T) {
+	backplane := NewMemoryBackplane()
+	hubA := NewHub(backplane, nil)
+	hubB := NewHub(backplane, nil)
+	go hubA.Run()
+	go hubB.Run()
+
+	_, conn := connect(hubA, "user-1", "group-1")
+	waitForStatus(t, hubB, "user-1", protocol.PresenceOnline)
+
+	away, err := protocol.Encode(protocol.TypeSetPresence, "", protocol.PresencePayload{Status: protocol.PresenceAway})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.incoming <- away
+	waitForStatus(t, hubB, "user-1", protocol.PresenceAway)
+
+	conn.Close()
+	waitForStatus(t, hubB, "user-1", protocol.PresenceOffline)
+}
+
+// TestPresenceReachesHubsStartedLater checks that a new hub learns who is
+// connected elsewhere without waiting for the periodic sync
+func TestPresenceReachesHubsStartedLater(t *testing.T) {
+	backplane := NewMemoryBackplane()
+	hubA := NewHub(backplane, nil)
+	go hubA.Run()
+	connect(hubA, "user-1", "group-1")
+
+	hubB := NewHub(backplane, nil)
+	go hubB.Run()
+	waitForStatus(t, hubB, "user-1", protocol.PresenceOnline)
+}
+
+// TestPresenceAnnouncesOverallStatus checks that a user connected to two hubs
+// isn't announced offline when only one of the connections closes
+func TestPresenceAnnouncesOverallStatus(t *testing.T) {
+	backplane := NewMemoryBackplane()
+	hubA := NewHub(backplane, nil)
+	hubB := NewHub(backplane, nil)
+	go hubA.Run()
+	go hubB.Run()
+
+	_, watcher := connect(hubA, "watcher", "group-1")
+	connect(hubA, "user-1", "group-1")
+	waitForStatus(t, hubB, "user-1", protocol.PresenceOnline)
+
+	_, second := connect(hubB, "user-1", "group-1")
+	second.Close()
+	waitFor(t, "hub B to drop the second connection", func() bool {
+		return second.written(websocket.CloseMessage) > 0
+	})
+
+	// Announcements leave hub B in order, so once the watcher hears about
+	// another user joining there, anything hub B said about user-1 arrived
+	connect(hubB, "marker", "group-1")
+	waitFor(t, "the marker to be announced", func() bool {
+		for _, payload := range presenceEvents(t, watcher) {
+			if payload.UserID == "marker" {
+				return true
+			}
+		}
+		return false
+	})
+
+	for _, payload := range presenceEvents(t, watcher) {
+		if payload.UserID == "user-1" && payload.Status != protocol.PresenceOnline {
+			t.Errorf("watcher was told user-1 is %s", payload.Status)
+		}
+	}
+}
+
+// presenceEvents returns the payloads of the presence events conn received
+func presenceEvents(t *testing.T, conn *fakeConn) []protocol.PresencePayload {
+	t.Helper()
+	var payloads []protocol.PresencePayload
+	for _, env := range conn.received(protocol.TypePresence) {
+		var payload protocol.PresencePayload
+		if err := json.Unmarshal(env.Payload, &payload); err != nil {
+			t.Fatal(err)
+		}
+		payloads = append(payloads, payload)
+	}
+	return payloads
+}
@@ -0,0 +1,104 @@
+package websocket
+
+import (
+	"log"
+	"time"
+
+	"chatterbloom/backend/protocol"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// How long a typing indicator lasts without another typing_start
+	typingTimeout = 6 * time.Second
+
+	// How often expired typing indicators are swept
+	typingSweepInterval = time.Second
+
+	// Sustained rate and burst of typing frames a client may send
+	typingRate  = rate.Limit(2)
+	typingBurst = 5
+)
+
+// typingKey identifies one user typing in one room
+type typingKey struct {
+	roomID string
+	userID string
+}
+
+// typingUpdate is a client starting or stopping typing in a room
+type typingUpdate struct {
+	client *Client
+	roomID string
+	typing bool
+}
+
+// updateTyping debounces typing frames: repeated typing_start frames only
+// extend the indicator, and typing_stop only matters for an active one
+func (h *Hub) updateTyping(update typingUpdate) {
+	key := typingKey{roomID: update.roomID, userID: update.client.userID}
+	_, active := h.typing[key]
+
+	if update.typing {
+		h.typing[key] = time.Now().Add(typingTimeout)
+		if !active {
+			h.announceTyping(key, protocol.TypeTypingStart)
+		}
+		return
+	}
+
+	if active {
+		delete(h.typing, key)
+		h.announceTyping(key, protocol.TypeTypingStop)
+	}
+}
+
+// expireTyping stops indicators whose user went quiet without typing_stop
+func (h *Hub) expireTyping(now time.Time) {
+	for key, expiresAt := range h.typing {
+		if now.After(expiresAt) {
+			delete(h.typing, key)
+			h.announceTyping(key, protocol.TypeTypingStop)
+		}
+	}
+}
+
+// clearTyping stops the indicators of a disconnecting client's user in rooms
+// where the user has no other connection left
+func (h *Hub) clearTyping(client *Client) {
+	for roomID := range client.rooms {
+		key := typingKey{roomID: roomID, userID: client.userID}
+		if _, active := h.typing[key]; !active {
+			continue
+		}
+
+		stillConnected := false
+		for connection := range h.users[client.userID] {
+			if connection.rooms[roomID] {
+				stillConnected = true
+				break
+			}
+		}
+		if !stillConnected {
+			delete(h.typing, key)
+			h.announceTyping(key, protocol.TypeTypingStop)
+		}
+	}
+}
+
+// announceTyping sends a typing event to everyone in the room but the typist.
+// Typing events are never sequenced or persisted.
+func (h *Hub) announceTyping(key typingKey, eventType string) {
+	message, err := protocol.Encode(eventType, "", protocol.TypingPayload{
+		GroupID: key.roomID,
+		UserID:  key.userID,
+	})
+	if err != nil {
+		log.Printf("error: encoding %s event: %v", eventType, err)
+		return
+	}
+
+	// Publish off the Run goroutine, in order so a stop never overtakes its start
+	h.enqueue(RoomEvent{RoomID: key.roomID, Message: message, ExceptUserID: key.userID})
+}
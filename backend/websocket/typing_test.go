@@ -0,0 +1,39 @@
+package websocket
+
+import (
+	"testing"
+
+	"chatterbloom/backend/protocol"
+)
+
+// TestTypingEventsStayInOrder checks that quick start/stop toggles reach the
+// rest of the room in the order they were sent
+func TestTypingEventsStayInOrder(t *testing.T) {
+	hub := NewHub(NewMemoryBackplane(), nil)
+	go hub.Run()
+
+	_, typist := connect(hub, "typist", "group-1")
+	_, watcher := connect(hub, "watcher", "group-1")
+
+	// Stay within the typing burst so no frame is rate limited
+	want := []string{protocol.TypeTypingStart, protocol.TypeTypingStop, protocol.TypeTypingStart, protocol.TypeTypingStop}
+	for _, eventType := range want {
+		frame, err := protocol.Encode(eventType, "", protocol.TypingPayload{GroupID: "group-1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		typist.incoming <- frame
+	}
+
+	waitFor(t, "every typing event", func() bool {
+		return len(watcher.received(protocol.TypeTypingStart, protocol.TypeTypingStop)) == len(want)
+	})
+	for i, env := range watcher.received(protocol.TypeTypingStart, protocol.TypeTypingStop) {
+		if env.Type != want[i] {
+			t.Fatalf("typing event %d is %s, want %s", i, env.Type, want[i])
+		}
+	}
+	if n := len(typist.received(protocol.TypeTypingStart, protocol.TypeTypingStop)); n != 0 {
+		t.Errorf("typist got %d of their own typing events, want 0", n)
+	}
+}
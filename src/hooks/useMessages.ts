@@ -83,10 +83,18 @@ export function useMessages(groupId: string) {
         addMessage(message);
       }
     });
+
+    // Reload when the server says events for this group were lost
+    const unsubscribeResync = websocketService.onResync((roomId) => {
+      if (!roomId || roomId === groupId) {
+        fetchMessages();
+      }
+    });
     
     // Clean up on unmount
     return () => {
       unsubscribe();
+      unsubscribeResync();
       websocketService.disconnect();
     };
   }, [groupId, fetchMessages, addMessage]);
@@ -122,7 +122,12 @@ class ApiService {
 
   // Group methods
   async getGroups(): Promise<ChatGroup[]> {
-    return this.get('/groups');
+    // Direct conversations come back apart from groups
+    const { groups, direct_conversations } = await this.get<{
+      groups: ChatGroup[];
+      direct_conversations: ChatGroup[];
+    }>('/groups');
+    return [...groups, ...direct_conversations];
   }
 
   async createGroup(groupData: {
@@ -140,7 +145,7 @@ class ApiService {
     return this.get(`/groups/${groupId}`);
   }
 
-  async addMemberToGroup(groupId: string, userId: string, role: 'admin' | 'member' = 'member') {
+  async addMemberToGroup(groupId: string, userId: string, role: 'admin' | 'moderator' | 'member' = 'member') {
     return this.post(`/groups/${groupId}/members`, {
       user_id: userId,
       role,
@@ -151,6 +156,10 @@ class ApiService {
     return this.delete(`/groups/${groupId}/members/${userId}`);
   }
 
+  async updateMemberRole(groupId: string, userId: string, role: 'admin' | 'moderator' | 'member') {
+    return this.put(`/groups/${groupId}/members/${userId}/role`, { role });
+  }
+
   // Message methods
   async getMessages(groupId: string, limit = 50, offset = 0): Promise<ChatMessage[]> {
     return this.get(`/groups/${groupId}/messages?limit=${limit}&offset=${offset}`);
@@ -169,6 +178,12 @@ class ApiService {
     return this.get(url);
   }
 
+  // WebSocket ticket, valid for a few seconds, to pass as the `ticket`
+  // query parameter of /ws
+  async getWebSocketTicket(): Promise<{ ticket: string; expires_at: string }> {
+    return this.post('/ws/ticket');
+  }
+
 }
 
 // Create and export API service instance
@@ -197,6 +212,9 @@ export const createGroup = (groupData: {
 }): Promise<ChatGroup> => 
   apiService.createGroup(groupData);
 
+export const getWebSocketTicket = (): Promise<{ ticket: string; expires_at: string }> =>
+  apiService.getWebSocketTicket();
+
 export const getCurrentUser = (): Promise<Profile> => 
   apiService.getCurrentUser();
 
@@ -1,5 +1,4 @@
 import { sendMessage as apiSendMessage } from "@/services/apiService";
-import websocketService from "@/services/websocketService";
 
 export async function sendMessage(content: string, groupId: string) {
   try {
@@ -10,13 +9,10 @@ export async function sendMessage(content: string, groupId: string) {
       throw new Error("User not authenticated");
     }
     
-    // Send message through API
+    // Send message through API; the server delivers it to the group's
+    // WebSocket clients, so it must not be sent over the socket as well
     const message = await apiSendMessage(content, groupId);
     
-    // Also send through WebSocket for real-time updates
-    // This is a backup in case the WebSocket connection is not established yet
-    websocketService.sendMessage(content, groupId, userId);
-    
     return { success: true, message };
   } catch (error) {
     console.error("Error sending message:", error);
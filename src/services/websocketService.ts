@@ -1,112 +1,156 @@
-import { ChatMessage, Profile } from "@/types/chat";
+import { ChatMessage } from "@/types/chat";
+import { getWebSocketTicket } from "@/services/apiService";
 
-// Define WebSocket message types
-export type WebSocketMessage = {
+// Version of the WebSocket protocol this client speaks
+const PROTOCOL_VERSION = 1;
+
+// Envelope wraps every frame sent in either direction
+export type WebSocketEnvelope<T = any> = {
   type: string;
-  message?: ChatMessage;
-  data?: any;
+  id?: string; // Set by the client, echoed back in ack/error
+  version: number;
+  room_id?: string; // Set on persisted room events
+  seq?: number; // Per-room sequence number of persisted room events
+  payload?: T;
 };
 
 class WebSocketService {
   private socket: WebSocket | null = null;
   private messageHandlers: ((message: ChatMessage) => void)[] = [];
   private connectionHandlers: ((connected: boolean) => void)[] = [];
+  private resyncHandlers: ((roomId?: string) => void)[] = [];
   private reconnectTimer: NodeJS.Timeout | null = null;
   private userId: string | null = null;
-  private groupId: string | null = null;
+  private rooms = new Set<string>(); // Rooms to subscribe to on every connection
+  private lastSeq = new Map<string, number>(); // Last sequence number seen per room
+  private nextFrameId = 0;
+  private attempt = 0; // Bumped on every connect and disconnect to drop stale attempts
 
-  // Connect to WebSocket server
-  connect(userId: string, groupId?: string): void {
+  // Connect to WebSocket server, subscribing to groupId if given
+  async connect(userId: string, groupId?: string): Promise<void> {
     if (this.socket) {
-      this.disconnect();
+      this.close();
     }
 
     this.userId = userId;
-    this.groupId = groupId || null;
+    if (groupId) {
+      this.rooms.add(groupId);
+    }
+    const attempt = ++this.attempt;
+
+    // The handshake can't carry the auth header, so trade it for a ticket
+    let ticket: string;
+    try {
+      ({ ticket } = await getWebSocketTicket());
+    } catch (error) {
+      console.error('Failed to get WebSocket ticket:', error);
+      if (attempt === this.attempt) {
+        this.scheduleReconnect();
+      }
+      return;
+    }
+    if (attempt !== this.attempt) {
+      return;
+    }
 
     const wsProtocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
     const wsHost = import.meta.env.VITE_WS_HOST || 'localhost:8090';
-    const wsUrl = `${wsProtocol}//${wsHost}/ws?userId=${userId}${groupId ? `&roomId=${groupId}` : ''}`;
+    const wsUrl = `${wsProtocol}//${wsHost}/ws?ticket=${encodeURIComponent(ticket)}`;
 
-    this.socket = new WebSocket(wsUrl);
+    const socket = new WebSocket(wsUrl);
+    this.socket = socket;
 
-    this.socket.onopen = () => {
+    socket.onopen = () => {
       console.log('WebSocket connection established');
-      this.notifyConnectionHandlers(true);
       if (this.reconnectTimer) {
         clearTimeout(this.reconnectTimer);
         this.reconnectTimer = null;
       }
+
+      // Replay what was missed while disconnected, then join the rooms
+      if (this.lastSeq.size > 0) {
+        this.send('resume', { rooms: Object.fromEntries(this.lastSeq) });
+      }
+      this.rooms.forEach(roomId => this.send('subscribe', { room_id: roomId }));
+
+      this.notifyConnectionHandlers(true);
     };
 
-    this.socket.onmessage = (event) => {
+    socket.onmessage = (event) => {
+      let envelope: WebSocketEnvelope;
       try {
-        const data: WebSocketMessage = JSON.parse(event.data);
-        
-        if (data.type === 'new_message' && data.message) {
-          this.notifyMessageHandlers(data.message);
-        }
+        envelope = JSON.parse(event.data);
       } catch (error) {
         console.error('Error parsing WebSocket message:', error);
+        return;
       }
+      this.handleEnvelope(envelope);
     };
 
-    this.socket.onclose = (event) => {
+    socket.onclose = (event) => {
       console.log('WebSocket connection closed:', event.code, event.reason);
-      this.notifyConnectionHandlers(false);
+      if (this.socket !== socket) {
+        return;
+      }
       this.socket = null;
+      this.notifyConnectionHandlers(false);
 
       // Attempt to reconnect after 5 seconds
       if (!event.wasClean) {
-        this.reconnectTimer = setTimeout(() => {
-          if (this.userId) {
-            this.connect(this.userId, this.groupId || undefined);
-          }
-        }, 5000);
+        this.scheduleReconnect();
       }
     };
 
-    this.socket.onerror = (error) => {
+    socket.onerror = (error) => {
       console.error('WebSocket error:', error);
     };
   }
 
-  // Disconnect from WebSocket server
+  // Disconnect from WebSocket server and forget its rooms
   disconnect(): void {
-    if (this.socket) {
-      this.socket.close();
-      this.socket = null;
-    }
-    
+    this.attempt++;
+    this.close();
+    this.rooms.clear();
+    this.lastSeq.clear();
+
     if (this.reconnectTimer) {
       clearTimeout(this.reconnectTimer);
       this.reconnectTimer = null;
     }
   }
 
-  // Send a message through WebSocket
-  sendMessage(content: string, groupId: string, senderId: string): void {
-    if (!this.socket || this.socket.readyState !== WebSocket.OPEN) {
+  // Subscribe to a group's room, now and after every reconnect
+  subscribe(groupId: string): void {
+    this.rooms.add(groupId);
+    if (this.isConnected()) {
+      this.send('subscribe', { room_id: groupId });
+    }
+  }
+
+  // Unsubscribe from a group's room
+  unsubscribe(groupId: string): void {
+    this.rooms.delete(groupId);
+    this.lastSeq.delete(groupId);
+    if (this.isConnected()) {
+      this.send('unsubscribe', { room_id: groupId });
+    }
+  }
+
+  // Send a message through WebSocket. The server answers with an ack frame
+  // carrying the stored message, and sends it to the group as new_message.
+  sendMessage(content: string, groupId: string): void {
+    if (!this.isConnected()) {
       console.error('WebSocket not connected');
       return;
     }
 
-    const message = {
-      type: 'send_message',
-      message: {
-        content,
-        group_id: groupId,
-        sender_id: senderId,
-      }
-    };
-
-    this.socket.send(JSON.stringify(message));
+    this.send('send_message', { group_id: groupId, content });
   }
 
   // Register a handler for new messages
   onMessage(handler: (message: ChatMessage) => void): () => void {
     this.messageHandlers.push(handler);
-    
+
     // Return unsubscribe function
     return () => {
       this.messageHandlers = this.messageHandlers.filter(h => h !== handler);
@@ -116,13 +160,103 @@ class WebSocketService {
   // Register a handler for connection status changes
   onConnectionChange(handler: (connected: boolean) => void): () => void {
     this.connectionHandlers.push(handler);
-    
+
     // Return unsubscribe function
     return () => {
       this.connectionHandlers = this.connectionHandlers.filter(h => h !== handler);
     };
   }
 
+  // Register a handler called when events were lost and a room (or every
+  // room, if roomId is undefined) must be reloaded over REST
+  onResync(handler: (roomId?: string) => void): () => void {
+    this.resyncHandlers.push(handler);
+
+    // Return unsubscribe function
+    return () => {
+      this.resyncHandlers = this.resyncHandlers.filter(h => h !== handler);
+    };
+  }
+
+  // Dispatch a frame from the server
+  private handleEnvelope(envelope: WebSocketEnvelope): void {
+    const { room_id: roomId, seq } = envelope;
+
+    // Room events can be replayed after a resume; skip ones already seen
+    if (roomId && seq) {
+      if (seq <= (this.lastSeq.get(roomId) ?? 0)) {
+        return;
+      }
+      this.lastSeq.set(roomId, seq);
+    }
+
+    switch (envelope.type) {
+      case 'new_message':
+      case 'announcement':
+        if (envelope.payload?.message) {
+          this.notifyMessageHandlers(envelope.payload.message);
+        }
+        break;
+
+      case 'resync':
+        // The server dropped events because this client fell behind
+        this.lastSeq.clear();
+        this.notifyResyncHandlers();
+        break;
+
+      case 'resumed':
+        if (envelope.payload && !envelope.payload.complete) {
+          this.notifyResyncHandlers(envelope.payload.room_id);
+        }
+        break;
+
+      case 'error':
+        console.error('WebSocket error frame:', envelope.payload);
+        break;
+    }
+
+    // Tell the server the event was processed, so a slow client is asked
+    // to resync instead of being disconnected
+    if (roomId && seq) {
+      this.send('delivered', { room_id: roomId, seq });
+    }
+  }
+
+  // Send a frame, returning its ID
+  private send(type: string, payload?: unknown): string | null {
+    if (!this.socket || this.socket.readyState !== WebSocket.OPEN) {
+      return null;
+    }
+
+    const id = String(++this.nextFrameId);
+    const envelope: WebSocketEnvelope = { type, id, version: PROTOCOL_VERSION, payload };
+    this.socket.send(JSON.stringify(envelope));
+    return id;
+  }
+
+  // Close the current socket without reconnecting
+  private close(): void {
+    if (this.socket) {
+      const socket = this.socket;
+      this.socket = null;
+      socket.close();
+      this.notifyConnectionHandlers(false);
+    }
+  }
+
+  // Reconnect after 5 seconds with a new ticket
+  private scheduleReconnect(): void {
+    if (this.reconnectTimer) {
+      clearTimeout(this.reconnectTimer);
+    }
+    this.reconnectTimer = setTimeout(() => {
+      this.reconnectTimer = null;
+      if (this.userId) {
+        this.connect(this.userId);
+      }
+    }, 5000);
+  }
+
   // Notify all message handlers
   private notifyMessageHandlers(message: ChatMessage): void {
     this.messageHandlers.forEach(handler => {
@@ -145,6 +279,17 @@ class WebSocketService {
     });
   }
 
+  // Notify all resync handlers
+  private notifyResyncHandlers(roomId?: string): void {
+    this.resyncHandlers.forEach(handler => {
+      try {
+        handler(roomId);
+      } catch (error) {
+        console.error('Error in resync handler:', error);
+      }
+    });
+  }
+
   // Check if WebSocket is connected
   isConnected(): boolean {
     return this.socket !== null && this.socket.readyState === WebSocket.OPEN;